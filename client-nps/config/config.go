@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 )
 
 // Config 应用配置
@@ -20,6 +21,8 @@ type Config struct {
 	Server      ServerConfig    `json:"server"`
 	Database    DatabaseConfig  `json:"database"`
 	Auth        AuthConfig      `json:"auth"`
+	// SpeedTestServer 本机测速服务端（供另一台盒子/局域网主机/浏览器测量吞吐）
+	SpeedTestServer SpeedTestServerConfig `json:"speedtest_server"`
 }
 
 // DeviceConfig 设备配置
@@ -92,6 +95,15 @@ type ScannerConfig struct {
 	ScanInterval int  `json:"scan_interval"` // 秒
 	Timeout      int  `json:"timeout"`       // 秒
 	Concurrency  int  `json:"concurrency"`   // 并发数
+
+	// 端口清点：quick(默认)/standard/full/custom；custom 时使用 ports（如 "22,80,8000-8100"）
+	PortProfile string `json:"port_profile,omitempty"`
+	Ports       string `json:"ports,omitempty"`
+	UDPPorts    string `json:"udp_ports,omitempty"` // 为空时 standard/full 扫描 DNS/NTP/SNMP 等带探测包的端口，"none" 不扫
+
+	// 被动发现：监听 ARP/DHCP/mDNS 报文实时更新设备（需要抓包权限）
+	Passive           bool     `json:"passive"`
+	PassiveInterfaces []string `json:"passive_interfaces,omitempty"` // 为空时监听所有扫描接口
 }
 
 // ServerConfig 服务器配置
@@ -100,6 +112,15 @@ type ServerConfig struct {
 	Host string `json:"host"`
 }
 
+// SpeedTestServerConfig 测速服务端配置
+type SpeedTestServerConfig struct {
+	Disabled bool `json:"disabled"` // 关闭 /api/v1/speedtest/* 测速接口
+	// Port >0 时另外在该端口提供测速接口（不受主服务 15 秒读写超时限制，可单独映射 NPS 隧道）
+	Port int `json:"port,omitempty"`
+	// Token 对端（另一台盒子/脚本）访问测速接口的共享口令；为空时只接受登录后的 JWT
+	Token string `json:"token,omitempty"`
+}
+
 // DatabaseConfig 数据库配置
 type DatabaseConfig struct {
 	Path string `json:"path"`
@@ -381,6 +402,46 @@ func (c *Config) MaxWiFiPriority() int {
 	return max
 }
 
+// mu 保护运行中会被 API 修改的配置：定时扫描、被动监听等后台 goroutine 通过 ScannerSettings 读取
+var mu sync.RWMutex
+
+// ScannerSettings 加锁复制扫描配置
+func (c *Config) ScannerSettings() ScannerConfig {
+	mu.RLock()
+	defer mu.RUnlock()
+	s := c.Scanner
+	s.PassiveInterfaces = append([]string(nil), c.Scanner.PassiveInterfaces...)
+	return s
+}
+
+// SetScanner 加锁修改扫描配置
+func (c *Config) SetScanner(s ScannerConfig) {
+	mu.Lock()
+	c.Scanner = s
+	mu.Unlock()
+}
+
+// SpeedTestSettings 加锁复制测速服务端配置（鉴权中间件每次请求都会读取）
+func (c *Config) SpeedTestSettings() SpeedTestServerConfig {
+	mu.RLock()
+	defer mu.RUnlock()
+	return c.SpeedTestServer
+}
+
+// SetSpeedTestServer 加锁修改测速服务端配置
+func (c *Config) SetSpeedTestServer(s SpeedTestServerConfig) {
+	mu.Lock()
+	c.SpeedTestServer = s
+	mu.Unlock()
+}
+
+// Replace 加锁整体替换配置（导入配置）
+func (c *Config) Replace(n *Config) {
+	mu.Lock()
+	*c = *n
+	mu.Unlock()
+}
+
 // Save 保存配置
 func (c *Config) Save() error {
 	configPath := GetConfigPath()
@@ -391,7 +452,9 @@ func (c *Config) Save() error {
 		return err
 	}
 
+	mu.RLock()
 	data, err := json.MarshalIndent(c, "", "  ")
+	mu.RUnlock()
 	if err != nil {
 		return err
 	}
@@ -409,5 +472,9 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("数据库路径不能为空")
 	}
 
+	if p := c.SpeedTestServer.Port; p < 0 || p > 65535 || (p != 0 && p == c.Server.Port) {
+		return fmt.Errorf("测速服务端口无效: %d", p)
+	}
+
 	return nil
 }
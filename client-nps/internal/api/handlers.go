@@ -3,14 +3,21 @@ package api
 import (
 	"bufio"
 	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io"
+	"math"
 	"net"
 	"net/http"
 	"nwct/client-nps/config"
 	"nwct/client-nps/internal/database"
+	"nwct/client-nps/internal/jobs"
 	"nwct/client-nps/internal/logger"
 	"nwct/client-nps/internal/network"
 	"nwct/client-nps/internal/nps"
+	"nwct/client-nps/internal/probe"
+	"nwct/client-nps/internal/realtime"
 	"nwct/client-nps/internal/scanner"
 	"nwct/client-nps/internal/toolkit"
 	"nwct/client-nps/internal/version"
@@ -22,9 +29,11 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
 	"github.com/shirou/gopsutil/v3/host"
@@ -35,6 +44,13 @@ func nowRFC3339() string {
 	return time.Now().Format(time.RFC3339)
 }
 
+func formatTimeOrEmpty(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
 func normalizeSSID(v string) string {
 	return strings.TrimSpace(v)
 }
@@ -618,6 +634,13 @@ func (s *Server) handleDevicesList(c *gin.Context) {
 		status = "online"
 	}
 	deviceType := c.Query("type")
+	subnet := c.Query("subnet")
+	iface := c.Query("interface")
+	// 标注过滤：tag 可逗号分隔（需全部包含），alias/owner/location 不区分大小写包含匹配
+	tags := splitQueryList(c.Query("tag"))
+	alias := strings.ToLower(strings.TrimSpace(c.Query("alias")))
+	owner := strings.ToLower(strings.TrimSpace(c.Query("owner")))
+	location := strings.ToLower(strings.TrimSpace(c.Query("location")))
 	filtered := []scanner.Device{}
 	for _, d := range devices {
 		if status != "" && status != "all" && d.Status != status {
@@ -626,6 +649,24 @@ func (s *Server) handleDevicesList(c *gin.Context) {
 		if deviceType != "" && d.Type != deviceType {
 			continue
 		}
+		if subnet != "" && d.Subnet != subnet {
+			continue
+		}
+		if iface != "" && d.Interface != iface {
+			continue
+		}
+		if !hasAllTags(d.Tags, tags) {
+			continue
+		}
+		if alias != "" && !strings.Contains(strings.ToLower(d.Alias), alias) {
+			continue
+		}
+		if owner != "" && !strings.Contains(strings.ToLower(d.Owner), owner) {
+			continue
+		}
+		if location != "" && !strings.Contains(strings.ToLower(d.Location), location) {
+			continue
+		}
 		filtered = append(filtered, d)
 	}
 
@@ -654,6 +695,7 @@ func (s *Server) handleDevicesList(c *gin.Context) {
 	deviceList := make([]gin.H, len(pagedDevices))
 	for i, d := range pagedDevices {
 		deviceList[i] = gin.H{
+			"id":         d.ID,
 			"ip":         d.IP,
 			"mac":        d.MAC,
 			"name":       d.Name,
@@ -661,10 +703,18 @@ func (s *Server) handleDevicesList(c *gin.Context) {
 			"model":      d.Model,
 			"type":       d.Type,
 			"os":         d.OS,
+			"interface":  d.Interface,
+			"subnet":     d.Subnet,
+			"addresses":  d.Addresses,
 			"status":     d.Status,
 			"open_ports": d.OpenPorts,
 			"last_seen":  d.LastSeen,
 			"first_seen": d.FirstSeen,
+			"alias":      d.Alias,
+			"owner":      d.Owner,
+			"location":   d.Location,
+			"notes":      d.Notes,
+			"tags":       d.Tags,
 		}
 	}
 
@@ -676,6 +726,84 @@ func (s *Server) handleDevicesList(c *gin.Context) {
 	}))
 }
 
+// handleDevicesSearch 设备检索：全文关键词 + 结构化条件，支持任意列排序
+func (s *Server) handleDevicesSearch(c *gin.Context) {
+	q := database.DeviceSearch{
+		Query:    strings.TrimSpace(c.Query("q")),
+		Status:   c.Query("status"),
+		Type:     c.Query("type"),
+		Vendor:   c.Query("vendor"),
+		Protocol: c.Query("protocol"),
+		Tags:     splitQueryList(c.Query("tag")),
+		CIDR:     c.Query("cidr"),
+		Sort:     c.Query("sort"),
+		Order:    c.Query("order"),
+	}
+	if v := strings.TrimSpace(c.Query("port")); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil || port <= 0 || port > 65535 {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "无效的端口: "+v))
+			return
+		}
+		q.Port = port
+	}
+	for _, r := range []struct {
+		key string
+		dst *time.Time
+	}{
+		{"first_seen_from", &q.FirstSeenFrom},
+		{"first_seen_to", &q.FirstSeenTo},
+		{"last_seen_from", &q.LastSeenFrom},
+		{"last_seen_to", &q.LastSeenTo},
+	} {
+		v := strings.TrimSpace(c.Query(r.key))
+		if v == "" {
+			continue
+		}
+		t, err := parseQueryTime(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "无效的时间 "+r.key+": "+v))
+			return
+		}
+		*r.dst = t
+	}
+
+	page, pageSize := 1, 20
+	if p := c.Query("page"); p != "" {
+		fmt.Sscanf(p, "%d", &page)
+	}
+	if ps := c.Query("page_size"); ps != "" {
+		fmt.Sscanf(ps, "%d", &pageSize)
+	}
+	if page < 1 {
+		page = 1
+	}
+	if pageSize <= 0 || pageSize > 500 {
+		pageSize = 20
+	}
+	q.Limit, q.Offset = pageSize, (page-1)*pageSize
+
+	devices, total, err := s.scanner.SearchDevices(q)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
+		"devices":   devices,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	}))
+}
+
+// parseQueryTime 查询参数中的时间：RFC3339 或 2006-01-02（本地时区当天零点）
+func parseQueryTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", v, time.Local)
+}
+
 // handleDevicesActivity 最近活动（设备上线/离线历史）
 func (s *Server) handleDevicesActivity(c *gin.Context) {
 	limit := 20
@@ -693,6 +821,7 @@ func (s *Server) handleDevicesActivity(c *gin.Context) {
 	for _, a := range list {
 		out = append(out, gin.H{
 			"timestamp": a.Timestamp.Format(time.RFC3339),
+			"device_id": a.DeviceID,
 			"ip":        a.IP,
 			"status":    a.Status,
 			"name":      a.Name,
@@ -704,6 +833,7 @@ func (s *Server) handleDevicesActivity(c *gin.Context) {
 }
 
 // handleDeviceDetail 处理获取设备详情请求
+// 路径参数可以是当前 IP、历史地址或设备 ID；IP 被 DHCP 重新分配时返回最近使用该地址的设备
 func (s *Server) handleDeviceDetail(c *gin.Context) {
 	ip := c.Param("ip")
 
@@ -722,312 +852,2539 @@ func (s *Server) handleDeviceDetail(c *gin.Context) {
 			"service":  p.Service,
 			"version":  p.Version,
 			"status":   p.Status,
+			"banner":   p.Banner,
 		}
 	}
 
 	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
-		"ip":         detail.IP,
-		"mac":        detail.MAC,
-		"name":       detail.Name,
-		"vendor":     detail.Vendor,
-		"model":      detail.Model,
-		"type":       detail.Type,
-		"os":         detail.OS,
-		"status":     detail.Status,
-		"open_ports": portList,
-		"extra":      detail.Extra,
-		"last_seen":  detail.LastSeen,
-		"first_seen": detail.FirstSeen,
-		"history":    detail.History,
+		"id":              detail.ID,
+		"ip":              detail.IP,
+		"mac":             detail.MAC,
+		"name":            detail.Name,
+		"vendor":          detail.Vendor,
+		"model":           detail.Model,
+		"type":            detail.Type,
+		"os":              detail.OS,
+		"interface":       detail.Interface,
+		"subnet":          detail.Subnet,
+		"addresses":       detail.Addresses,
+		"status":          detail.Status,
+		"open_ports":      portList,
+		"extra":           detail.Extra,
+		"last_seen":       detail.LastSeen,
+		"first_seen":      detail.FirstSeen,
+		"history":         detail.History,
+		"address_history": detail.AddressHistory,
+		"alias":           detail.Alias,
+		"owner":           detail.Owner,
+		"location":        detail.Location,
+		"notes":           detail.Notes,
+		"tags":            detail.Tags,
 	}))
 }
 
-// handleScanStart 处理启动扫描请求
-func (s *Server) handleScanStart(c *gin.Context) {
+// handleDeviceAnnotate 修改设备的用户标注（别名/负责人/位置/备注/标签），扫描不会覆盖这些字段
+// tags 整体替换；add_tags/remove_tags 增量修改
+func (s *Server) handleDeviceAnnotate(c *gin.Context) {
 	var req struct {
-		Subnet  string `json:"subnet"`
-		Timeout int    `json:"timeout"`
+		Alias      *string   `json:"alias"`
+		Owner      *string   `json:"owner"`
+		Location   *string   `json:"location"`
+		Notes      *string   `json:"notes"`
+		Tags       *[]string `json:"tags"`
+		AddTags    []string  `json:"add_tags"`
+		RemoveTags []string  `json:"remove_tags"`
 	}
-
 	if err := c.ShouldBindJSON(&req); err != nil {
-		// 允许空请求体，使用默认值
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "参数错误: "+err.Error()))
+		return
 	}
 
-	// 如果没有指定网段，尝试自动检测
-	if req.Subnet == "" {
-		netStatus, err := s.netManager.GetNetworkStatus()
-		if err == nil && netStatus.IP != "" {
-			// 从IP计算网段（简化：假设/24）
-			req.Subnet = netStatus.IP + "/24"
-		} else {
-			req.Subnet = "192.168.1.0/24" // 默认网段
-		}
+	d, err := database.ResolveDevice(s.db, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	if d == nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse(404, "设备不存在"))
+		return
 	}
 
-	if err := s.scanner.StartScan(req.Subnet); err != nil {
-		// 扫描已在进行中：返回当前状态（避免前端报错/重复点击导致 500）
-		if strings.Contains(err.Error(), "扫描已在进行中") || strings.Contains(err.Error(), "进行中") {
-			status := s.scanner.GetScanStatus()
-			c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
-				"scan_id":       "scan_001",
-				"status":        status.Status,
-				"progress":      status.Progress,
-				"scanned_count": status.ScannedCount,
-				"found_count":   status.FoundCount,
-				"start_time":    status.StartTime.Format(time.RFC3339),
-			}))
-			return
-		}
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+	ann, err := database.UpdateDeviceAnnotation(s.db, d.ID, database.DeviceAnnotationPatch{
+		Alias:      req.Alias,
+		Owner:      req.Owner,
+		Location:   req.Location,
+		Notes:      req.Notes,
+		Tags:       req.Tags,
+		AddTags:    req.AddTags,
+		RemoveTags: req.RemoveTags,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, "保存失败: "+err.Error()))
 		return
 	}
 
-	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
-		"scan_id": "scan_001",
-		"status":  "running",
-	}))
+	realtime.Default().Broadcast("device_upsert", map[string]interface{}{
+		"id":        d.ID,
+		"ip":        d.IP,
+		"mac":       d.MAC,
+		"name":      d.Name,
+		"vendor":    d.Vendor,
+		"type":      d.Type,
+		"os":        d.OS,
+		"interface": d.Interface,
+		"subnet":    d.Subnet,
+		"status":    d.Status,
+		"alias":     ann.Alias,
+		"owner":     ann.Owner,
+		"location":  ann.Location,
+		"notes":     ann.Notes,
+		"tags":      ann.Tags,
+		"source":    "annotation",
+		"last_seen": d.LastSeen.Format(time.RFC3339),
+	})
+	c.JSON(http.StatusOK, models.SuccessResponse(ann))
 }
 
-// handleScanStop 处理停止扫描请求
-func (s *Server) handleScanStop(c *gin.Context) {
-	if err := s.scanner.StopScan(); err != nil {
+// handleDeviceTags 全部标签及使用次数（用于过滤下拉框）
+func (s *Server) handleDeviceTags(c *gin.Context) {
+	tags, err := database.GetAllTags(s.db)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
 		return
 	}
-
-	c.JSON(http.StatusOK, models.SuccessResponse(nil))
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{"tags": tags}))
 }
 
-// handleScanStatus 处理获取扫描状态请求
-func (s *Server) handleScanStatus(c *gin.Context) {
-	status := s.scanner.GetScanStatus()
+// groupConcurrency 分组批量操作的并发上限
+const groupConcurrency = 8
 
-	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
-		"status":        status.Status,
-		"progress":      status.Progress,
-		"scanned_count": status.ScannedCount,
-		"found_count":   status.FoundCount,
-		"start_time":    status.StartTime.Format(time.RFC3339),
-	}))
+// handleGroupsList 设备分组列表（含在线统计）
+func (s *Server) handleGroupsList(c *gin.Context) {
+	groups, err := database.GetDeviceGroups(s.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	summaries, err := database.SummarizeGroups(s.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	byID := map[int64]database.GroupSummary{}
+	for _, sum := range summaries {
+		byID[sum.ID] = sum
+	}
+	out := make([]gin.H, 0, len(groups))
+	for _, g := range groups {
+		sum := byID[g.ID]
+		out = append(out, gin.H{
+			"id":          g.ID,
+			"name":        g.Name,
+			"description": g.Description,
+			"kind":        g.Kind,
+			"query":       g.Query,
+			"members":     g.Members,
+			"total":       sum.Total,
+			"online":      sum.Online,
+			"offline":     sum.Offline,
+			"updated_at":  g.UpdatedAt.Format(time.RFC3339),
+		})
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{"groups": out}))
 }
 
-// handlePing 处理Ping测试请求
-func (s *Server) handlePing(c *gin.Context) {
-	var req struct {
-		Target  string `json:"target" binding:"required"`
-		Count   int    `json:"count"`
-		Timeout int    `json:"timeout"`
+// groupRequest 创建/更新分组的请求体；members 可填设备 ID 或 IP
+type groupRequest struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Kind        string              `json:"kind"` // static（默认）/ dynamic
+	Query       database.GroupQuery `json:"query"`
+	Members     []string            `json:"members"`
+}
+
+// resolveMemberIDs 把设备 ID/IP 解析为设备 ID，返回无法识别的项
+func (s *Server) resolveMemberIDs(keys []string) ([]string, []string) {
+	ids, unknown := []string{}, []string{}
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key == "" {
+			continue
+		}
+		d, err := database.ResolveDevice(s.db, key)
+		if err != nil || d == nil {
+			unknown = append(unknown, key)
+			continue
+		}
+		ids = append(ids, d.ID)
 	}
+	return ids, unknown
+}
 
+// handleGroupCreate 创建分组
+func (s *Server) handleGroupCreate(c *gin.Context) {
+	var req groupRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "参数错误: "+err.Error()))
 		return
 	}
-
-	if req.Count <= 0 {
-		req.Count = 4
+	members, unknown := s.resolveMemberIDs(req.Members)
+	if len(unknown) > 0 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "设备不存在: "+strings.Join(unknown, ",")))
+		return
 	}
-	if req.Timeout <= 0 {
-		req.Timeout = 5
+	g := &database.DeviceGroup{
+		Name:        req.Name,
+		Description: strings.TrimSpace(req.Description),
+		Kind:        strings.TrimSpace(req.Kind),
+		Query:       req.Query,
+		Members:     members,
 	}
-
-	// 使用toolkit的Ping实现
-	result, err := toolkit.Ping(req.Target, req.Count, time.Duration(req.Timeout)*time.Second)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+	if err := database.SaveDeviceGroup(s.db, g); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, err.Error()))
 		return
 	}
-
-	c.JSON(http.StatusOK, models.SuccessResponse(result))
+	c.JSON(http.StatusOK, models.SuccessResponse(g))
 }
 
-// handleTraceroute 处理Traceroute请求
-func (s *Server) handleTraceroute(c *gin.Context) {
-	var req struct {
-		Target  string `json:"target"`
-		MaxHops int    `json:"max_hops"`
-		Timeout int    `json:"timeout"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		// 允许空请求体（将自动使用网关作为目标）
+// loadGroup 读取路径参数中的分组；失败时已写入响应并返回 nil
+func (s *Server) loadGroup(c *gin.Context) *database.DeviceGroup {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "无效的分组ID"))
+		return nil
 	}
-
-	if req.MaxHops <= 0 {
-		req.MaxHops = 30
+	g, err := database.GetDeviceGroup(s.db, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return nil
 	}
-	if req.Timeout <= 0 {
-		req.Timeout = 5
+	if g == nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse(404, "分组不存在"))
+		return nil
 	}
+	return g
+}
 
-	target := strings.TrimSpace(req.Target)
-	if target == "" {
-		st, err := s.netManager.GetNetworkStatus()
-		if err == nil && st != nil && strings.TrimSpace(st.Gateway) != "" {
-			target = strings.TrimSpace(st.Gateway)
-		}
-	}
-	if target == "" {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "未指定target，且无法获取默认网关"))
+// handleGroupDetail 分组详情（含当前成员设备）
+func (s *Server) handleGroupDetail(c *gin.Context) {
+	g := s.loadGroup(c)
+	if g == nil {
 		return
 	}
-
-	// 使用toolkit的Traceroute实现
-	result, err := toolkit.Traceroute(target, req.MaxHops, time.Duration(req.Timeout)*time.Second)
+	devices, err := database.GroupDevices(s.db, g)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
 		return
 	}
-
-	c.JSON(http.StatusOK, models.SuccessResponse(result))
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
+		"group":   g,
+		"devices": devices,
+	}))
 }
 
-// handleSpeedTest 处理网速测试请求
-func (s *Server) handleSpeedTest(c *gin.Context) {
-	var req struct {
-		// mode:
-		// - web: 访问网站测速（DNS/TCP/TLS/TTFB/Total），默认
-		// - download: 下载带宽测速（旧逻辑）
-		Mode          string `json:"mode"`
-		URL           string `json:"url"`
-		Method        string `json:"method"` // GET(默认)/HEAD
-		Count         int    `json:"count"`
-		Timeout       int    `json:"timeout"` // 秒
-		DownloadBytes int64  `json:"download_bytes"`
-
-		// 旧字段兼容（download 模式使用）
-		Server   string `json:"server"`
-		TestType string `json:"test_type"`
+// handleGroupUpdate 更新分组（整体替换名称/描述/类型/条件/成员）
+func (s *Server) handleGroupUpdate(c *gin.Context) {
+	g := s.loadGroup(c)
+	if g == nil {
+		return
 	}
-
+	var req groupRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		// 允许空请求体
-		req.Mode = "web"
-	}
-
-	mode := strings.TrimSpace(req.Mode)
-	if mode == "" {
-		mode = "web"
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "参数错误: "+err.Error()))
+		return
 	}
-
-	switch mode {
-	case "download":
-		// 使用toolkit的下载测速（兼容旧面板/脚本）
-		if req.TestType == "" {
-			req.TestType = "download"
-		}
-		if req.Server == "" {
-			req.Server = "default"
-		}
-		result, err := toolkit.SpeedTest(req.Server, req.TestType)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
-			return
-		}
-		c.JSON(http.StatusOK, models.SuccessResponse(result))
+	members, unknown := s.resolveMemberIDs(req.Members)
+	if len(unknown) > 0 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "设备不存在: "+strings.Join(unknown, ",")))
 		return
-	case "web":
-		fallthrough
-	default:
-		if req.Count <= 0 {
-			req.Count = 3
-		}
-		if req.Timeout <= 0 {
-			req.Timeout = 8
-		}
-		// 兼容：如果 url 为空但 server 有值，把 server 当作 url
-		targetURL := strings.TrimSpace(req.URL)
-		if targetURL == "" {
-			targetURL = strings.TrimSpace(req.Server)
-		}
-		method := strings.TrimSpace(req.Method)
-		if method == "" {
-			method = "GET"
-		}
-		result, err := toolkit.WebSpeedTestWithOptions(targetURL, method, req.Count, time.Duration(req.Timeout)*time.Second, req.DownloadBytes)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
-			return
-		}
-		c.JSON(http.StatusOK, models.SuccessResponse(result))
+	}
+	g.Name = req.Name
+	g.Description = strings.TrimSpace(req.Description)
+	g.Kind = strings.TrimSpace(req.Kind)
+	g.Query = req.Query
+	g.Members = members
+	if err := database.SaveDeviceGroup(s.db, g); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, err.Error()))
 		return
 	}
+	c.JSON(http.StatusOK, models.SuccessResponse(g))
 }
 
-// handlePortScan 处理端口扫描请求
-func (s *Server) handlePortScan(c *gin.Context) {
+// handleGroupMembers 增删静态分组成员
+func (s *Server) handleGroupMembers(c *gin.Context) {
+	g := s.loadGroup(c)
+	if g == nil {
+		return
+	}
+	if g.Kind != database.GroupKindStatic {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "动态分组的成员由条件决定"))
+		return
+	}
 	var req struct {
-		Target   string      `json:"target" binding:"required"`
-		Ports    interface{} `json:"ports"`
-		Timeout  int         `json:"timeout"`
-		ScanType string      `json:"scan_type"`
+		Add    []string `json:"add"`
+		Remove []string `json:"remove"`
 	}
-
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "参数错误: "+err.Error()))
 		return
 	}
-
-	if req.Timeout <= 0 {
-		req.Timeout = 5
+	add, unknown := s.resolveMemberIDs(req.Add)
+	if len(unknown) > 0 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "设备不存在: "+strings.Join(unknown, ",")))
+		return
 	}
-	if req.ScanType == "" {
-		req.ScanType = "tcp"
+	// 删除时允许直接给已不存在设备的 ID
+	remove := map[string]bool{}
+	for _, key := range req.Remove {
+		remove[strings.TrimSpace(key)] = true
 	}
-
-	// 使用toolkit的端口扫描
-	result, err := toolkit.PortScan(req.Target, req.Ports, time.Duration(req.Timeout)*time.Second, req.ScanType)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
-		return
+	removeIDs, _ := s.resolveMemberIDs(req.Remove)
+	for _, id := range removeIDs {
+		remove[id] = true
 	}
-
-	c.JSON(http.StatusOK, models.SuccessResponse(result))
-}
-
-// handleDNS 处理DNS查询请求
-func (s *Server) handleDNS(c *gin.Context) {
-	var req struct {
-		Query  string `json:"query" binding:"required"`
-		Type   string `json:"type"`
-		Server string `json:"server"`
+	members := []string{}
+	for _, id := range append(g.Members, add...) {
+		if !remove[id] {
+			members = append(members, id)
+		}
 	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "参数错误: "+err.Error()))
+	g.Members = members
+	if err := database.SaveDeviceGroup(s.db, g); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, err.Error()))
 		return
 	}
+	c.JSON(http.StatusOK, models.SuccessResponse(g))
+}
 
-	if req.Type == "" {
-		req.Type = "A"
+// handleGroupDelete 删除分组（不影响设备本身）
+func (s *Server) handleGroupDelete(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "无效的分组ID"))
+		return
 	}
-
-	// 使用toolkit的DNS查询
-	records, err := toolkit.DNSQuery(req.Query, req.Type, req.Server)
+	found, err := database.DeleteDeviceGroup(s.db, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
 		return
 	}
-
-	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
-		"query":   req.Query,
-		"type":    req.Type,
-		"records": records,
-	}))
+	if !found {
+		c.JSON(http.StatusNotFound, models.ErrorResponse(404, "分组不存在"))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{"message": "删除成功"}))
 }
 
-// handleNPSStatus 处理获取NPS状态请求
-func (s *Server) handleNPSStatus(c *gin.Context) {
-	status, err := s.npsClient.GetStatus()
+// handleGroupStatus 分组在线/离线统计及各成员状态
+func (s *Server) handleGroupStatus(c *gin.Context) {
+	g := s.loadGroup(c)
+	if g == nil {
+		return
+	}
+	devices, err := database.GroupDevices(s.db, g)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
 		return
 	}
-
-	c.JSON(http.StatusOK, models.SuccessResponse(status))
+	sum := database.GroupSummary{ID: g.ID, Name: g.Name, Kind: g.Kind, Total: len(devices)}
+	members := make([]gin.H, 0, len(devices))
+	for _, d := range devices {
+		if d.Status == "online" {
+			sum.Online++
+		} else {
+			sum.Offline++
+		}
+		members = append(members, gin.H{
+			"id":        d.ID,
+			"ip":        d.IP,
+			"name":      d.Name,
+			"alias":     d.Alias,
+			"status":    d.Status,
+			"last_seen": d.LastSeen.Format(time.RFC3339),
+		})
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
+		"summary": sum,
+		"members": members,
+	}))
 }
 
-// handleNPCInstall 一键下载并安装 npc（用于设备端集成 NPS 客户端）
+// forEachGroupDevice 对分组成员并发执行 fn（并发上限 groupConcurrency），结果按成员顺序返回；
+// 每完成一台调用 report，ctx 取消后不再启动新的成员（未执行的标记为已取消）
+func forEachGroupDevice(ctx context.Context, devices []database.Device, report func(done int, r gin.H), fn func(d database.Device) gin.H) []gin.H {
+	results := make([]gin.H, len(devices))
+	sem := make(chan struct{}, groupConcurrency)
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		done int
+	)
+	for i, d := range devices {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			results[i] = gin.H{"id": d.ID, "ip": d.IP, "name": d.Name, "error": "已取消"}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, d database.Device) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			r := fn(d)
+			r["id"] = d.ID
+			r["ip"] = d.IP
+			r["name"] = d.Name
+			results[i] = r
+			mu.Lock()
+			done++
+			report(done, r)
+			mu.Unlock()
+		}(i, d)
+	}
+	wg.Wait()
+	return results
+}
+
+// 分组批量操作的参数上限（以后台任务执行，仍需避免单次任务过大）
+const (
+	maxGroupPingCount = 20
+	maxGroupScanPorts = 1024 // 每台设备扫描的端口数
+)
+
+type groupPingRequest struct {
+	GroupID int64 `json:"group_id"`
+	Count   int   `json:"count"`
+	Timeout int   `json:"timeout"`
+}
+
+// groupPingResult 分组 Ping 汇总结果
+type groupPingResult struct {
+	GroupID     int64   `json:"group_id"`
+	Total       int     `json:"total"`
+	Reachable   int     `json:"reachable"`
+	Unreachable int     `json:"unreachable"`
+	Results     []gin.H `json:"results"`
+}
+
+// handleGroupPing 对分组内每个设备执行 Ping（后台任务，立即返回任务信息，结果见 /jobs/:id）
+func (s *Server) handleGroupPing(c *gin.Context) {
+	g := s.loadGroup(c)
+	if g == nil {
+		return
+	}
+	var req groupPingRequest
+	_ = c.ShouldBindJSON(&req) // 允许空请求体
+	req.GroupID = g.ID
+	if req.Count <= 0 {
+		req.Count = 2
+	}
+	if req.Count > maxGroupPingCount {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, fmt.Sprintf("参数错误: count 最大 %d", maxGroupPingCount)))
+		return
+	}
+	if req.Timeout <= 0 {
+		req.Timeout = 2
+	}
+
+	devices, err := database.GroupDevices(s.db, g)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	s.submitToolJob(c, "group_ping", database.DiagnosticSourceUI, req, s.recordDiagnostic("group_ping", database.DiagnosticSourceUI, req, func(ctx context.Context, report func(int, interface{})) (interface{}, error) {
+		reachable := 0
+		results := forEachGroupDevice(ctx, devices, func(done int, r gin.H) {
+			if r["reachable"] == true {
+				reachable++
+			}
+			report(done*100/len(devices), r)
+		}, func(d database.Device) gin.H {
+			result, err := toolkit.PingWithOptions(ctx, d.IP, toolkit.PingOptions{
+				Count:   req.Count,
+				Timeout: time.Duration(req.Timeout) * time.Second,
+			})
+			if err != nil {
+				return gin.H{"reachable": false, "error": err.Error()}
+			}
+			return gin.H{"reachable": result.PacketsReceived > 0, "result": result}
+		})
+		return &groupPingResult{
+			GroupID:     g.ID,
+			Total:       len(devices),
+			Reachable:   reachable,
+			Unreachable: len(devices) - reachable,
+			Results:     results,
+		}, nil
+	}))
+}
+
+type groupPortScanRequest struct {
+	GroupID  int64       `json:"group_id"`
+	Ports    interface{} `json:"ports"`
+	Timeout  int         `json:"timeout"`
+	ScanType string      `json:"scan_type"`
+}
+
+// groupPortScanResult 分组端口扫描汇总结果
+type groupPortScanResult struct {
+	GroupID   int64   `json:"group_id"`
+	Total     int     `json:"total"`
+	OpenPorts int     `json:"open_ports"` // 全部设备的开放端口数之和
+	Results   []gin.H `json:"results"`
+}
+
+// handleGroupPortScan 对分组内每个设备执行端口扫描（后台任务，每台设备最多 maxGroupScanPorts 个端口）
+func (s *Server) handleGroupPortScan(c *gin.Context) {
+	g := s.loadGroup(c)
+	if g == nil {
+		return
+	}
+	var req groupPortScanRequest
+	_ = c.ShouldBindJSON(&req) // 允许空请求体（使用常用端口）
+	req.GroupID = g.ID
+	if req.Ports == nil {
+		req.Ports = "22,23,53,80,443,445,554,3389,8080,8443"
+	}
+	if req.Timeout <= 0 {
+		req.Timeout = 2
+	}
+	if req.ScanType == "" {
+		req.ScanType = "tcp"
+	}
+	ports, err := toolkit.ParsePorts(req.Ports)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "参数错误: "+err.Error()))
+		return
+	}
+	if len(ports) > maxGroupScanPorts {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, fmt.Sprintf("参数错误: 分组扫描每台设备最多 %d 个端口（当前 %d）", maxGroupScanPorts, len(ports))))
+		return
+	}
+
+	devices, err := database.GroupDevices(s.db, g)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	s.submitToolJob(c, "group_portscan", database.DiagnosticSourceUI, req, s.recordDiagnostic("group_portscan", database.DiagnosticSourceUI, req, func(ctx context.Context, report func(int, interface{})) (interface{}, error) {
+		open := 0
+		results := forEachGroupDevice(ctx, devices, func(done int, r gin.H) {
+			if result, ok := r["result"].(*toolkit.PortScanResult); ok {
+				open += len(result.OpenPorts)
+			}
+			report(done*100/len(devices), r)
+		}, func(d database.Device) gin.H {
+			result, err := toolkit.PortScanContext(ctx, d.IP, ports, time.Duration(req.Timeout)*time.Second, req.ScanType)
+			if err != nil {
+				return gin.H{"error": err.Error()}
+			}
+			return gin.H{"result": result}
+		})
+		return &groupPortScanResult{
+			GroupID:   g.ID,
+			Total:     len(devices),
+			OpenPorts: open,
+			Results:   results,
+		}, nil
+	}))
+}
+
+// splitQueryList 逗号分隔的查询参数
+func splitQueryList(v string) []string {
+	out := []string{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// hasAllTags 设备标签是否包含全部 want（不区分大小写）
+func hasAllTags(have, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if strings.EqualFold(h, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// handleScanStart 处理启动扫描请求
+func (s *Server) handleScanStart(c *gin.Context) {
+	var req struct {
+		Subnet     string   `json:"subnet"`     // 兼容旧参数：单个网段
+		Subnets    []string `json:"subnets"`    // 多个网段（CIDR）
+		Interfaces []string `json:"interfaces"` // 按接口扫描其所在网段
+		Timeout    int      `json:"timeout"`
+		// mode: incremental（默认，保留设备历史）/ reset（扫描前清空）
+		Mode string `json:"mode"`
+		// 端口清点配置档（quick/standard/full/custom）及 custom 的端口表达式，未指定时使用 scanner 配置
+		PortProfile string `json:"port_profile"`
+		Ports       string `json:"ports"`
+		UDPPorts    string `json:"udp_ports"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		// 允许空请求体，使用默认值
+	}
+
+	// 未指定网段/接口时，自动使用所有已启动的物理接口所在网段
+	subnets := req.Subnets
+	if strings.TrimSpace(req.Subnet) != "" {
+		subnets = append([]string{req.Subnet}, subnets...)
+	}
+	targets, err := scanner.ResolveTargets(s.netManager, subnets, req.Interfaces)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, err.Error()))
+		return
+	}
+
+	// 超时/并发：请求未指定时使用 scanner 配置
+	cfg := s.config.ScannerSettings()
+	timeout := req.Timeout
+	if timeout <= 0 {
+		timeout = cfg.Timeout
+	}
+	opts := scanner.ScanOptions{
+		Targets:     targets,
+		Mode:        req.Mode,
+		Timeout:     time.Duration(timeout) * time.Second,
+		Concurrency: cfg.Concurrency,
+		Source:      scanner.ScanSourceAPI,
+		PortProfile: cfg.PortProfile,
+		Ports:       cfg.Ports,
+		UDPPorts:    cfg.UDPPorts,
+	}
+	if strings.TrimSpace(req.PortProfile) != "" {
+		opts.PortProfile, opts.Ports, opts.UDPPorts = req.PortProfile, req.Ports, req.UDPPorts
+	} else if strings.TrimSpace(req.UDPPorts) != "" {
+		opts.UDPPorts = req.UDPPorts
+	}
+
+	if err := s.scanner.StartScanWithOptions(opts); err != nil {
+		// 扫描已在进行中：返回当前状态（避免前端报错/重复点击导致 500）
+		if strings.Contains(err.Error(), "扫描已在进行中") || strings.Contains(err.Error(), "进行中") {
+			status := s.scanner.GetScanStatus()
+			c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
+				"scan_id":       status.ScanID,
+				"mode":          status.Mode,
+				"status":        status.Status,
+				"progress":      status.Progress,
+				"scanned_count": status.ScannedCount,
+				"found_count":   status.FoundCount,
+				"start_time":    status.StartTime.Format(time.RFC3339),
+			}))
+			return
+		}
+		if strings.Contains(err.Error(), "无效") || strings.Contains(err.Error(), "不支持") {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse(400, err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+
+	status := s.scanner.GetScanStatus()
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
+		"scan_id": status.ScanID,
+		"mode":    status.Mode,
+		"subnets": status.Subnets,
+		"targets": targets,
+		"status":  "running",
+	}))
+}
+
+// handleScanStop 处理停止扫描请求
+func (s *Server) handleScanStop(c *gin.Context) {
+	if err := s.scanner.StopScan(); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse(nil))
+}
+
+// handleScanStatus 处理获取扫描状态请求
+func (s *Server) handleScanStatus(c *gin.Context) {
+	status := s.scanner.GetScanStatus()
+
+	// 定时扫描信息（auto_scan 关闭或调度器未启动时 enabled=false）
+	schedule := gin.H{"enabled": false}
+	if sc := scanner.DefaultScheduler(); sc != nil {
+		st := sc.Status()
+		schedule = gin.H{
+			"enabled":     st.Enabled,
+			"interval":    st.Interval,
+			"last_run":    formatTimeOrEmpty(st.LastRun),
+			"next_run":    formatTimeOrEmpty(st.NextRun),
+			"last_result": st.LastResult,
+			"last_error":  st.LastError,
+			"subnets":     st.Subnets,
+		}
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
+		"scan_id":       status.ScanID,
+		"mode":          status.Mode,
+		"source":        status.Source,
+		"subnets":       status.Subnets,
+		"status":        status.Status,
+		"progress":      status.Progress,
+		"scanned_count": status.ScannedCount,
+		"found_count":   status.FoundCount,
+		"new_count":     status.NewCount,
+		"gone_count":    status.GoneCount,
+		"start_time":    status.StartTime.Format(time.RFC3339),
+		"schedule":      schedule,
+	}))
+}
+
+// handlePassiveStatus 被动发现（ARP/DHCP/mDNS 监听）状态
+func (s *Server) handlePassiveStatus(c *gin.Context) {
+	pl := scanner.DefaultPassiveListener()
+	if pl == nil {
+		c.JSON(http.StatusOK, models.SuccessResponse(gin.H{"enabled": false, "running": false}))
+		return
+	}
+	st := pl.Status()
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
+		"enabled":     st.Enabled,
+		"running":     st.Running,
+		"interfaces":  st.Interfaces,
+		"packets":     st.Packets,
+		"observed":    st.Observed,
+		"new_devices": st.NewDevices,
+		"last_event":  formatTimeOrEmpty(st.LastEvent),
+		"last_error":  st.LastError,
+	}))
+}
+
+// handleRulesList 自定义识别规则列表
+func (s *Server) handleRulesList(c *gin.Context) {
+	engine := scanner.DefaultRuleEngine()
+	lastErr := ""
+	if err := engine.LastError(); err != nil {
+		lastErr = err.Error()
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
+		"rules":      engine.Rules(),
+		"path":       engine.Path(),
+		"last_error": lastErr,
+	}))
+}
+
+// handleRulesUpsert 新增/更新识别规则（按 id），只影响之后的扫描
+func (s *Server) handleRulesUpsert(c *gin.Context) {
+	var req scanner.IdentifyRule
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "参数错误: "+err.Error()))
+		return
+	}
+	rule, err := scanner.DefaultRuleEngine().Upsert(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{"rule": rule}))
+}
+
+// handleRulesDelete 删除识别规则
+func (s *Server) handleRulesDelete(c *gin.Context) {
+	found, err := scanner.DefaultRuleEngine().Delete(c.Param("id"))
+	if !found {
+		c.JSON(http.StatusNotFound, models.ErrorResponse(404, "规则不存在"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, "保存失败: "+err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{"message": "删除成功"}))
+}
+
+// handleRulesReload 从文件重新加载识别规则（手工编辑规则文件后调用）
+func (s *Server) handleRulesReload(c *gin.Context) {
+	engine := scanner.DefaultRuleEngine()
+	if err := engine.Reload(); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{"rules": engine.Rules()}))
+}
+
+// handleRulesTest 规则试运行：对已入库设备匹配，返回会产生的变化，不写库
+// 传 rule 时只测试该规则（可以是尚未保存的规则），否则测试当前全部规则
+func (s *Server) handleRulesTest(c *gin.Context) {
+	var req struct {
+		Rule      *scanner.IdentifyRule `json:"rule"`
+		DeviceIDs []string              `json:"device_ids"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "参数错误: "+err.Error()))
+		return
+	}
+
+	var devices []database.Device
+	if len(req.DeviceIDs) > 0 {
+		for _, key := range req.DeviceIDs {
+			if d, err := database.ResolveDevice(s.db, strings.TrimSpace(key)); err == nil && d != nil {
+				devices = append(devices, *d)
+			}
+		}
+	} else {
+		list, _, err := database.GetDevices(s.db, "", "", 5000, 0)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+			return
+		}
+		devices = list
+	}
+
+	results := []gin.H{}
+	for i := range devices {
+		d := &devices[i]
+		in := scanner.StoredRuleInput(s.db, d)
+		var res *scanner.RuleResult
+		if req.Rule != nil {
+			r, err := scanner.EvaluateRule(*req.Rule, in)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, models.ErrorResponse(400, err.Error()))
+				return
+			}
+			res = r
+		} else {
+			res = scanner.DefaultRuleEngine().Evaluate(in)
+		}
+		if len(res.Matched) == 0 {
+			continue
+		}
+		changes := gin.H{}
+		for _, f := range []struct{ field, from, to string }{
+			{"vendor", d.Vendor, res.Vendor},
+			{"model", d.Model, res.Model},
+			{"type", d.Type, res.Type},
+			{"os", d.OS, res.OS},
+			{"name", d.Name, res.Name},
+		} {
+			if f.to != "" && f.to != f.from {
+				changes[f.field] = gin.H{"from": f.from, "to": f.to}
+			}
+		}
+		results = append(results, gin.H{
+			"id":      d.ID,
+			"ip":      d.IP,
+			"mac":     d.MAC,
+			"name":    d.Name,
+			"matched": res.Matched,
+			"set":     res.RuleAction,
+			"changes": changes,
+		})
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
+		"total":   len(devices),
+		"matched": len(results),
+		"results": results,
+	}))
+}
+
+// handleScanEvents 扫描差异事件（新设备/设备消失）
+func (s *Server) handleScanEvents(c *gin.Context) {
+	limit := 50
+	if v := strings.TrimSpace(c.Query("limit")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			limit = n
+		}
+	}
+	list, err := database.GetScanEvents(s.db, strings.TrimSpace(c.Query("scan_id")), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	out := make([]gin.H, 0, len(list))
+	for _, ev := range list {
+		out = append(out, gin.H{
+			"id":        ev.ID,
+			"scan_id":   ev.ScanID,
+			"event":     ev.Event,
+			"device_id": ev.DeviceID,
+			"ip":        ev.DeviceIP,
+			"mac":       ev.MAC,
+			"name":      ev.Name,
+			"vendor":    ev.Vendor,
+			"timestamp": ev.Timestamp.Format(time.RFC3339),
+		})
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{"events": out}))
+}
+
+// toolJobBuilder 解析工具箱请求参数，返回保存到任务记录的参数和执行函数；
+// 同一个函数既用于同步请求，也用于提交后台任务。source 为发起方（ui / mqtt / scheduler）
+type toolJobBuilder func(s *Server, source string, raw []byte) (params interface{}, fn jobs.Func, err error)
+
+// toolJobs 支持后台执行的工具（任务 kind → 参数解析）
+var toolJobs = map[string]toolJobBuilder{
+	"ping":       buildPingJob,
+	"traceroute": buildTracerouteJob,
+	"speedtest":  buildSpeedTestJob,
+	"portscan":   buildPortScanJob,
+	"dns":        buildDNSJob,
+	"mtr":        buildMtrJob,
+}
+
+// asyncTools 默认作为后台任务执行的工具：耗时通常超过 HTTP 写超时（15 秒）
+var asyncTools = map[string]bool{
+	"traceroute": true,
+	"speedtest":  true,
+	"portscan":   true,
+	"mtr":        true,
+}
+
+// syncToolBudget 同步执行允许的最长预计耗时，低于 HTTP 写超时（15 秒）并留出余量
+const syncToolBudget = 10 * time.Second
+
+// toolEstimator 能预估最长耗时的工具参数；预计超过 syncToolBudget 时默认改为后台任务
+type toolEstimator interface {
+	expectedDuration() time.Duration
+}
+
+func exceedsSyncBudget(params interface{}) bool {
+	e, ok := params.(toolEstimator)
+	return ok && e.expectedDuration() > syncToolBudget
+}
+
+// jobOnlyTools 只能作为后台任务运行的工具（忽略 "async": false）：
+// MTR 按轮持续探测，默认 10 轮即超过写超时，cycles 为 0 时直到取消
+var jobOnlyTools = map[string]bool{
+	"mtr": true,
+}
+
+// runTool 执行工具箱请求：Ping / DNS 默认同步返回结果（客户端断开时终止），
+// asyncTools 中的工具以及预计耗时超过 syncToolBudget 的请求默认提交为后台任务，返回 202 和任务信息；
+// 请求体的 "async" 可覆盖默认行为（jobOnlyTools 除外），但预计超时的请求不能强制同步
+func (s *Server) runTool(c *gin.Context, kind string) {
+	raw, _ := c.GetRawData()
+	source := database.DiagnosticSourceUI
+	params, fn, err := s.buildTool(kind, source, raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, err.Error()))
+		return
+	}
+
+	var opt struct {
+		Async *bool `json:"async"`
+	}
+	_ = json.Unmarshal(raw, &opt)
+	long := exceedsSyncBudget(params)
+	async := asyncTools[kind] || long
+	if opt.Async != nil && !jobOnlyTools[kind] {
+		if !*opt.Async && long {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse(400, fmt.Sprintf("参数错误: 预计耗时超过 %d 秒，请以后台任务方式运行（async: true）", int(syncToolBudget/time.Second))))
+			return
+		}
+		async = *opt.Async
+	}
+	if async {
+		s.submitToolJob(c, kind, source, params, fn)
+		return
+	}
+
+	result, err := fn(c.Request.Context(), func(int, interface{}) {})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(result))
+}
+
+// buildTool 解析工具参数，并在每次运行结束后把参数、结果和耗时写入诊断历史（source: ui / mqtt / scheduler）
+func (s *Server) buildTool(kind, source string, raw []byte) (interface{}, jobs.Func, error) {
+	build, ok := toolJobs[kind]
+	if !ok {
+		return nil, nil, fmt.Errorf("不支持的任务类型: %s", kind)
+	}
+	params, fn, err := build(s, source, raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	return params, s.recordDiagnostic(diagnosticKind(kind, params), source, params, fn), nil
+}
+
+// SubmitTool 以后台任务方式运行工具（供 MQTT 命令等非 HTTP 入口使用）
+func (s *Server) SubmitTool(kind, source string, raw []byte) (*jobs.Job, error) {
+	params, fn, err := s.buildTool(kind, source, raw)
+	if err != nil {
+		return nil, err
+	}
+	return jobs.Default().Submit(kind, source, params, fn)
+}
+
+// submitToolJob 提交后台任务，返回 202 和任务信息（结果通过 /jobs/:id 或 job_progress 获取）
+func (s *Server) submitToolJob(c *gin.Context, kind, source string, params interface{}, fn jobs.Func) {
+	job, err := jobs.Default().Submit(kind, source, params, fn)
+	if err != nil {
+		c.JSON(http.StatusTooManyRequests, models.ErrorResponse(429, err.Error()))
+		return
+	}
+	c.JSON(http.StatusAccepted, models.SuccessResponse(job))
+}
+
+// handlePing 处理Ping测试请求
+func (s *Server) handlePing(c *gin.Context) {
+	s.runTool(c, "ping")
+}
+
+type pingRequest struct {
+	Target    string  `json:"target" binding:"required"`
+	Count     int     `json:"count"`
+	Timeout   int     `json:"timeout"`
+	Interval  float64 `json:"interval"`   // 发包间隔（秒），默认 1，最小 0.2
+	Size      int     `json:"size"`       // ICMP 数据长度，默认 56
+	IPVersion int     `json:"ip_version"` // 4 / 6，默认按解析结果（优先 IPv4）
+}
+
+const maxPingCount = 1000
+
+// expectedDuration 按间隔发完所有包后，再等待最后一个包的超时
+func (req pingRequest) expectedDuration() time.Duration {
+	return time.Duration(float64(req.Count-1)*req.Interval*float64(time.Second)) + time.Duration(req.Timeout)*time.Second
+}
+
+func buildPingJob(s *Server, source string, raw []byte) (interface{}, jobs.Func, error) {
+	var req pingRequest
+	if err := binding.JSON.BindBody(raw, &req); err != nil {
+		return nil, nil, fmt.Errorf("参数错误: %v", err)
+	}
+
+	if req.Count <= 0 {
+		req.Count = 4
+	}
+	if req.Count > maxPingCount {
+		return nil, nil, fmt.Errorf("参数错误: count 最大 %d", maxPingCount)
+	}
+	if req.Timeout <= 0 {
+		req.Timeout = 5
+	}
+	if req.Interval <= 0 {
+		req.Interval = 1
+	}
+	if req.Interval < 0.2 {
+		req.Interval = 0.2
+	}
+	if req.IPVersion != 0 && req.IPVersion != 4 && req.IPVersion != 6 {
+		return nil, nil, fmt.Errorf("参数错误: ip_version 只能为 4 或 6")
+	}
+
+	return req, func(ctx context.Context, report func(int, interface{})) (interface{}, error) {
+		// 使用toolkit的原生ICMP Ping，逐包上报（回复可能乱序到达，进度按已出结果的包数计）
+		done := 0
+		return toolkit.PingWithOptions(ctx, req.Target, toolkit.PingOptions{
+			Count:     req.Count,
+			Timeout:   time.Duration(req.Timeout) * time.Second,
+			Interval:  time.Duration(req.Interval * float64(time.Second)),
+			Size:      req.Size,
+			IPVersion: req.IPVersion,
+			OnPacket: func(p toolkit.PingPacket) {
+				done++
+				report(done*100/req.Count, p)
+			},
+		})
+	}, nil
+}
+
+// handleTraceroute 处理Traceroute请求
+func (s *Server) handleTraceroute(c *gin.Context) {
+	s.runTool(c, "traceroute")
+}
+
+type tracerouteRequest struct {
+	Target    string `json:"target"`
+	MaxHops   int    `json:"max_hops"`
+	Timeout   int    `json:"timeout"`    // 每跳等待时间（秒）
+	Protocol  string `json:"protocol"`   // icmp（默认）/ udp / tcp
+	Port      int    `json:"port"`       // udp 默认 33434，tcp 默认 80
+	Probes    int    `json:"probes"`     // 每跳探测次数，默认 3
+	FirstHop  int    `json:"first_hop"`  // 起始 TTL，默认 1
+	Paris     *bool  `json:"paris"`      // 固定流标识，默认 true
+	NoResolve bool   `json:"no_resolve"` // 不做反向解析
+	IPVersion int    `json:"ip_version"` // 4 / 6
+}
+
+func buildTracerouteJob(s *Server, source string, raw []byte) (interface{}, jobs.Func, error) {
+	var req tracerouteRequest
+	// 允许空请求体（将自动使用网关作为目标）
+	_ = json.Unmarshal(raw, &req)
+
+	if req.MaxHops <= 0 {
+		req.MaxHops = 30
+	}
+	if req.Timeout <= 0 {
+		req.Timeout = 3
+	}
+	if req.Paris == nil {
+		paris := true
+		req.Paris = &paris
+	}
+	req.Protocol = strings.ToLower(strings.TrimSpace(req.Protocol))
+	switch req.Protocol {
+	case "", "icmp", "udp", "tcp":
+	default:
+		return nil, nil, fmt.Errorf("参数错误: protocol 只能为 icmp、udp 或 tcp")
+	}
+	if req.IPVersion != 0 && req.IPVersion != 4 && req.IPVersion != 6 {
+		return nil, nil, fmt.Errorf("参数错误: ip_version 只能为 4 或 6")
+	}
+
+	req.Target = strings.TrimSpace(req.Target)
+	if req.Target == "" {
+		st, err := s.netManager.GetNetworkStatus()
+		if err == nil && st != nil && strings.TrimSpace(st.Gateway) != "" {
+			req.Target = strings.TrimSpace(st.Gateway)
+		}
+	}
+	if req.Target == "" {
+		return nil, nil, fmt.Errorf("未指定target，且无法获取默认网关")
+	}
+
+	return req, func(ctx context.Context, report func(int, interface{})) (interface{}, error) {
+		// 使用toolkit的原生Traceroute，每跳完成即推送
+		return toolkit.TracerouteWithOptions(ctx, req.Target, toolkit.TracerouteOptions{
+			Protocol:  req.Protocol,
+			Port:      req.Port,
+			Probes:    req.Probes,
+			FirstHop:  req.FirstHop,
+			MaxHops:   req.MaxHops,
+			Timeout:   time.Duration(req.Timeout) * time.Second,
+			Paris:     *req.Paris,
+			NoResolve: req.NoResolve,
+			IPVersion: req.IPVersion,
+			OnHop: func(h toolkit.Hop) {
+				realtime.Default().Broadcast("traceroute_hop", map[string]interface{}{
+					"target": req.Target,
+					"hop":    h,
+				})
+				report(h.Hop*100/req.MaxHops, h)
+			},
+		})
+	}, nil
+}
+
+// handleSpeedTest 处理网速测试请求
+func (s *Server) handleSpeedTest(c *gin.Context) {
+	s.runTool(c, "speedtest")
+}
+
+type speedTestRequest struct {
+	// mode:
+	// - web: 访问网站测速（DNS/TCP/TLS/TTFB/Total），默认
+	// - download: 下载带宽测速（旧逻辑）
+	// - peer: 与另一台盒子/局域网主机的测速服务端测双向吞吐
+	// - upload: 上传带宽测速（upload_url 任意 POST/PUT 端点；或 server 为盒子测速服务端；都为空时用 Cloudflare）
+	Mode          string `json:"mode"`
+	URL           string `json:"url"`
+	Method        string `json:"method"` // GET(默认)/HEAD
+	Count         int    `json:"count"`
+	Timeout       int    `json:"timeout"` // 秒
+	DownloadBytes int64  `json:"download_bytes"`
+
+	// 旧字段兼容（download 模式使用）
+	Server   string `json:"server"`
+	TestType string `json:"test_type"`
+
+	// peer 模式：server 为对端地址
+	Token     string `json:"token"`     // 对端 speedtest_server.token
+	Direction string `json:"direction"` // download / upload / both（默认）
+	Duration  int    `json:"duration"`  // 每个方向的秒数，默认 10
+	Streams   int    `json:"streams"`   // 并发连接数，默认 4
+
+	// upload 模式（download 模式 test_type=all/upload 时同样生效）；method 为 POST（默认）/PUT
+	UploadURL  string            `json:"upload_url"`
+	Headers    map[string]string `json:"headers"`
+	WarmUp     float64           `json:"warmup"`      // 预热秒数，不计入结果，默认 2；<0 不预热
+	ChunkSize  int64             `json:"chunk_size"`  // 每个请求的字节数，0 为单个 chunked 请求持续到结束
+	IntervalMs int               `json:"interval_ms"` // 采样窗口，默认 500
+}
+
+// uploadOptions 由请求参数生成上传测速参数
+func (req *speedTestRequest) uploadOptions(onSample func(toolkit.SpeedSample)) toolkit.UploadTestOptions {
+	opts := toolkit.UploadTestOptions{
+		URL:       strings.TrimSpace(req.UploadURL),
+		Headers:   map[string]string{},
+		Streams:   req.Streams,
+		Duration:  time.Duration(req.Duration) * time.Second,
+		WarmUp:    time.Duration(req.WarmUp * float64(time.Second)),
+		Interval:  time.Duration(req.IntervalMs) * time.Millisecond,
+		ChunkSize: req.ChunkSize,
+		OnSample:  onSample,
+	}
+	if m := strings.ToUpper(strings.TrimSpace(req.Method)); m == http.MethodPost || m == http.MethodPut {
+		opts.Method = m
+	}
+	for k, v := range req.Headers {
+		opts.Headers[k] = v
+	}
+	if req.Token != "" {
+		opts.Headers[toolkit.SpeedTestTokenHeader] = req.Token
+	}
+	return opts
+}
+
+func buildSpeedTestJob(s *Server, source string, raw []byte) (interface{}, jobs.Func, error) {
+	var req speedTestRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		// 允许空请求体
+		req.Mode = "web"
+	}
+
+	req.Mode = strings.TrimSpace(req.Mode)
+	if req.Mode == "" {
+		req.Mode = "web"
+	}
+
+	switch req.Mode {
+	case "download":
+		// 使用toolkit的下载测速（兼容旧面板/脚本）
+		if req.TestType == "" {
+			req.TestType = "download"
+		}
+		if req.Server == "" {
+			req.Server = "default"
+		}
+		return req, func(ctx context.Context, report func(int, interface{})) (interface{}, error) {
+			r, err := toolkit.SpeedTestWithUpload(ctx, req.Server, req.TestType, req.uploadOptions(nil))
+			if err != nil {
+				return nil, err
+			}
+			rec := &database.SpeedTestRecord{
+				Mode:         "internet",
+				Server:       r.Server,
+				Direction:    req.TestType,
+				DownloadMbps: r.DownloadSpeed,
+				UploadMbps:   r.UploadSpeed,
+				LatencyMs:    float64(r.Latency),
+				Duration:     float64(r.Duration),
+				Source:       source,
+			}
+			if r.Upload != nil {
+				rec.UploadBytes = r.Upload.Bytes
+			}
+			s.saveSpeedTestRecord(rec)
+			return r, nil
+		}, nil
+	case "upload":
+		if req.Duration <= 0 {
+			req.Duration = 10
+		}
+		return req, func(ctx context.Context, report func(int, interface{})) (interface{}, error) {
+			r, err := toolkit.SpeedTestWithUpload(ctx, req.Server, "upload", req.uploadOptions(func(sm toolkit.SpeedSample) {
+				report(int(sm.T*100)/req.Duration, sm)
+			}))
+			if err != nil {
+				return nil, err
+			}
+			mode := "internet"
+			if strings.Contains(r.Upload.URL, "/api/v1/speedtest/") {
+				mode = "peer"
+			}
+			s.saveSpeedTestRecord(&database.SpeedTestRecord{
+				Mode:        mode,
+				Server:      r.Upload.URL,
+				Direction:   "upload",
+				UploadMbps:  r.UploadSpeed,
+				UploadBytes: r.Upload.Bytes,
+				Streams:     r.Upload.Streams,
+				Duration:    r.Upload.Duration,
+				Source:      source,
+			})
+			return r, nil
+		}, nil
+	case "peer":
+		if strings.TrimSpace(req.Server) == "" {
+			return nil, nil, fmt.Errorf("参数错误: peer 模式需要 server（对端地址）")
+		}
+		if req.Duration <= 0 {
+			req.Duration = 10
+		}
+		return req, func(ctx context.Context, report func(int, interface{})) (interface{}, error) {
+			// 两个方向各占一半进度
+			phases := 1
+			if req.Direction == "" || req.Direction == "both" {
+				phases = 2
+			}
+			r, err := toolkit.PeerSpeedTest(ctx, toolkit.PeerSpeedOptions{
+				Server:    req.Server,
+				Token:     req.Token,
+				Direction: req.Direction,
+				Duration:  time.Duration(req.Duration) * time.Second,
+				Streams:   req.Streams,
+				OnProgress: func(p toolkit.PeerSpeedProgress) {
+					done := p.Elapsed / float64(req.Duration)
+					if phases == 2 && p.Direction == "upload" {
+						done++
+					}
+					report(int(done*100)/phases, p)
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			s.saveSpeedTestRecord(&database.SpeedTestRecord{
+				Mode:          "peer",
+				Server:        r.Server,
+				Direction:     r.Direction,
+				DownloadMbps:  r.DownloadSpeed,
+				UploadMbps:    r.UploadSpeed,
+				LatencyMs:     r.Latency,
+				DownloadBytes: r.DownloadBytes,
+				UploadBytes:   r.UploadBytes,
+				Streams:       r.Streams,
+				Duration:      r.Duration,
+				Source:        source,
+			})
+			return r, nil
+		}, nil
+	case "web":
+		fallthrough
+	default:
+		if req.Count <= 0 {
+			req.Count = 3
+		}
+		if req.Timeout <= 0 {
+			req.Timeout = 8
+		}
+		// 兼容：如果 url 为空但 server 有值，把 server 当作 url
+		targetURL := strings.TrimSpace(req.URL)
+		if targetURL == "" {
+			targetURL = strings.TrimSpace(req.Server)
+		}
+		method := strings.TrimSpace(req.Method)
+		if method == "" {
+			method = "GET"
+		}
+		return req, func(ctx context.Context, report func(int, interface{})) (interface{}, error) {
+			return toolkit.WebSpeedTestContext(ctx, targetURL, method, req.Count, time.Duration(req.Timeout)*time.Second, req.DownloadBytes,
+				func(i int, a toolkit.WebSpeedAttempt) {
+					report((i+1)*100/req.Count, a)
+				})
+		}, nil
+	}
+}
+
+func (s *Server) saveSpeedTestRecord(r *database.SpeedTestRecord) {
+	if err := database.SaveSpeedTestResult(s.db, r); err != nil {
+		logger.Warn("保存测速记录失败: %v", err)
+	}
+}
+
+// handleSpeedTestPing 测速服务端：延迟测量
+func (s *Server) handleSpeedTestPing(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{"time": time.Now().UnixMilli()}))
+}
+
+// handleSpeedTestDownload 测速服务端：持续下发随机数据（?bytes= 总字节数，?duration= 最长秒数，默认 15）
+func (s *Server) handleSpeedTestDownload(c *gin.Context) {
+	limit, _ := strconv.ParseInt(c.Query("bytes"), 10, 64)
+	secs, _ := strconv.Atoi(c.Query("duration"))
+	d := time.Duration(secs) * time.Second
+	if d <= 0 {
+		d = 15 * time.Second
+	}
+	if d > toolkit.SpeedTestMaxDuration {
+		d = toolkit.SpeedTestMaxDuration
+	}
+	deadline := time.Now().Add(d)
+	// 主服务的写超时为 15 秒，测速连接单独放宽
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(deadline.Add(10 * time.Second))
+
+	// 到达 duration 时可能未发满 bytes，因此不设 Content-Length（分块传输），提前结束也是完整的响应
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+	_, _ = toolkit.WriteSpeedStream(c.Request.Context(), c.Writer, limit, deadline)
+}
+
+// handleSpeedTestUpload 测速服务端：接收并丢弃请求体，返回收到的字节数和速率
+func (s *Server) handleSpeedTestUpload(c *gin.Context) {
+	rc := http.NewResponseController(c.Writer)
+	_ = rc.SetReadDeadline(time.Now().Add(toolkit.SpeedTestMaxDuration + 10*time.Second))
+	_ = rc.SetWriteDeadline(time.Now().Add(toolkit.SpeedTestMaxDuration + 15*time.Second))
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, c.Request.Body)
+	secs := time.Since(start).Seconds()
+	if err != nil && n == 0 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "读取上传数据失败: "+err.Error()))
+		return
+	}
+	speed := 0.0
+	if secs > 0 {
+		speed = float64(n) * 8 / secs / 1e6
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
+		"bytes":    n,
+		"duration": secs,
+		"speed":    speed,
+	}))
+}
+
+// handleSpeedTestResultSubmit 保存浏览器端测速结果（Web 面板用本机测速接口测出的上下行）
+func (s *Server) handleSpeedTestResultSubmit(c *gin.Context) {
+	var req struct {
+		DownloadMbps  float64 `json:"download_mbps"`
+		UploadMbps    float64 `json:"upload_mbps"`
+		LatencyMs     float64 `json:"latency_ms"`
+		DownloadBytes int64   `json:"download_bytes"`
+		UploadBytes   int64   `json:"upload_bytes"`
+		Streams       int     `json:"streams"`
+		Duration      float64 `json:"duration"`
+		Direction     string  `json:"direction"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "参数错误: "+err.Error()))
+		return
+	}
+	if req.DownloadMbps < 0 || req.UploadMbps < 0 || (req.DownloadMbps == 0 && req.UploadMbps == 0) {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "参数错误: 缺少测速结果"))
+		return
+	}
+	rec := &database.SpeedTestRecord{
+		Mode:          "browser",
+		Server:        c.Request.Host,
+		Direction:     req.Direction,
+		DownloadMbps:  req.DownloadMbps,
+		UploadMbps:    req.UploadMbps,
+		LatencyMs:     req.LatencyMs,
+		DownloadBytes: req.DownloadBytes,
+		UploadBytes:   req.UploadBytes,
+		Streams:       req.Streams,
+		Duration:      req.Duration,
+		Source:        "browser",
+		Client:        c.ClientIP(),
+	}
+	if err := database.SaveSpeedTestResult(s.db, rec); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(rec))
+}
+
+// handleSpeedTestHistory 吞吐测速历史（?mode=peer|browser|internet，?limit= 默认 50）
+func (s *Server) handleSpeedTestHistory(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	list, err := database.ListSpeedTestResults(s.db, c.Query("mode"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
+		"results": list,
+	}))
+}
+
+// handleSpeedTestHistoryDelete 删除一条测速历史
+func (s *Server) handleSpeedTestHistoryDelete(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "无效的ID"))
+		return
+	}
+	if err := database.DeleteSpeedTestResult(s.db, id); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(nil))
+}
+
+// handlePortScan 处理端口扫描请求
+func (s *Server) handlePortScan(c *gin.Context) {
+	s.runTool(c, "portscan")
+}
+
+// portScanRequest 端口扫描参数
+type portScanRequest struct {
+	Target      string      `json:"target" binding:"required"`
+	Ports       interface{} `json:"ports"`
+	Timeout     int         `json:"timeout"`     // 单端口超时（秒），默认 5
+	ScanType    string      `json:"scan_type"`   // tcp/udp/both，默认 tcp
+	Concurrency int         `json:"concurrency"` // 并发数，默认 64，最多 256
+	Rate        int         `json:"rate"`        // 每秒最多探测数，0 不限速，最多 10000
+}
+
+func buildPortScanJob(s *Server, source string, raw []byte) (interface{}, jobs.Func, error) {
+	var req portScanRequest
+	if err := binding.JSON.BindBody(raw, &req); err != nil {
+		return nil, nil, fmt.Errorf("参数错误: %v", err)
+	}
+	if req.Timeout <= 0 {
+		req.Timeout = 5
+	}
+	if req.ScanType == "" {
+		req.ScanType = "tcp"
+	}
+	if req.Concurrency > toolkit.MaxPortScanConcurrency {
+		req.Concurrency = toolkit.MaxPortScanConcurrency
+	}
+	if req.Rate > toolkit.MaxPortScanRate {
+		req.Rate = toolkit.MaxPortScanRate
+	}
+	if _, err := toolkit.ParsePorts(req.Ports); err != nil {
+		return nil, nil, err
+	}
+
+	return req, func(ctx context.Context, report func(int, interface{})) (interface{}, error) {
+		events := &portScanEvents{jobID: jobs.IDFromContext(ctx), start: time.Now()}
+		events.started(req)
+		// 逐端口上报进度，只把 open / open|filtered 作为增量输出
+		result, err := toolkit.PortScanWithOptions(ctx, req.Target, toolkit.PortScanOptions{
+			Ports:       req.Ports,
+			Timeout:     time.Duration(req.Timeout) * time.Second,
+			ScanType:    req.ScanType,
+			Concurrency: req.Concurrency,
+			Rate:        req.Rate,
+			OnResult: func(info toolkit.PortInfo, done, total int) {
+				events.result(info, done)
+				if info.Status == toolkit.PortStatusClosed {
+					report(done*100/total, nil)
+					return
+				}
+				report(done*100/total, info)
+			},
+		})
+		events.finished(ctx, result, err)
+		return result, err
+	}, nil
+}
+
+// portScanTotal 待探测数（端口数 × 协议数）
+func portScanTotal(req portScanRequest) int {
+	ports, err := toolkit.ParsePorts(req.Ports)
+	if err != nil {
+		return 0
+	}
+	if strings.EqualFold(strings.TrimSpace(req.ScanType), "both") {
+		return len(ports) * 2
+	}
+	return len(ports)
+}
+
+// portScanProgressInterval portscan_progress 推送间隔，避免大范围扫描时刷爆 WebSocket 缓冲
+const portScanProgressInterval = 500 * time.Millisecond
+
+// portScanEvents 后台端口扫描的 portscan_started / portscan_result / portscan_progress / portscan_done 推送，
+// 兼容订阅旧事件的客户端（与 job_progress 同时发送）；同步执行时不推送
+type portScanEvents struct {
+	jobID        string
+	start        time.Time
+	total, done  int
+	open         int
+	lastProgress time.Time
+}
+
+func (e *portScanEvents) started(req portScanRequest) {
+	if e.jobID == "" {
+		return
+	}
+	e.total = portScanTotal(req)
+	realtime.Default().Broadcast("portscan_started", map[string]interface{}{
+		"job_id":    e.jobID,
+		"target":    req.Target,
+		"scan_type": req.ScanType,
+		"total":     e.total,
+	})
+}
+
+// result 每个端口探测完成时调用（OnResult 是串行的）
+func (e *portScanEvents) result(info toolkit.PortInfo, done int) {
+	if e.jobID == "" {
+		return
+	}
+	e.done = done
+	if info.Status != toolkit.PortStatusClosed {
+		if info.Status == toolkit.PortStatusOpen {
+			e.open++
+		}
+		realtime.Default().Broadcast("portscan_result", map[string]interface{}{
+			"job_id": e.jobID,
+			"port":   info,
+		})
+	}
+	if time.Since(e.lastProgress) >= portScanProgressInterval {
+		e.lastProgress = time.Now()
+		realtime.Default().Broadcast("portscan_progress", map[string]interface{}{
+			"job_id":   e.jobID,
+			"done":     done,
+			"total":    e.total,
+			"progress": done * 100 / e.total,
+			"open":     e.open,
+		})
+	}
+}
+
+func (e *portScanEvents) finished(ctx context.Context, result *toolkit.PortScanResult, err error) {
+	if e.jobID == "" {
+		return
+	}
+	data := map[string]interface{}{
+		"job_id":      e.jobID,
+		"status":      jobs.StatusCompleted,
+		"done":        e.done,
+		"total":       e.total,
+		"open_ports":  e.open,
+		"duration_ms": time.Since(e.start).Milliseconds(),
+	}
+	switch {
+	case err != nil:
+		data["status"] = jobs.StatusFailed
+		data["error"] = err.Error()
+	case ctx.Err() != nil:
+		data["status"] = jobs.StatusCancelled
+	}
+	if result != nil {
+		data["open_ports"] = len(result.OpenPorts)
+	}
+	realtime.Default().Broadcast("portscan_done", data)
+}
+
+// portScanJobView /tools/portscan/jobs 接口的任务格式（由通用后台任务转换，兼容旧客户端）
+type portScanJobView struct {
+	ID          string      `json:"id"`
+	Target      string      `json:"target"`
+	ScanType    string      `json:"scan_type"`
+	Concurrency int         `json:"concurrency"`
+	Rate        int         `json:"rate"`
+	Status      string      `json:"status"`
+	Total       int         `json:"total"` // 待探测数（端口数 × 协议数）
+	Done        int         `json:"done"`
+	Progress    int         `json:"progress"` // 0-100
+	Error       string      `json:"error,omitempty"`
+	StartedAt   time.Time   `json:"started_at"`
+	FinishedAt  *time.Time  `json:"finished_at,omitempty"`
+	Result      interface{} `json:"result"` // 运行中为已发现的开放端口，结束后为完整结果
+}
+
+func newPortScanJobView(j *jobs.Job, withResult bool) portScanJobView {
+	req, ok := j.Params.(portScanRequest)
+	if raw, isRaw := j.Params.(json.RawMessage); !ok && isRaw {
+		_ = json.Unmarshal(raw, &req)
+	}
+	v := portScanJobView{
+		ID:          j.ID,
+		Target:      req.Target,
+		ScanType:    req.ScanType,
+		Concurrency: req.Concurrency,
+		Rate:        req.Rate,
+		Status:      j.Status,
+		Total:       portScanTotal(req),
+		Progress:    j.Progress,
+		Error:       j.Error,
+		StartedAt:   j.CreatedAt,
+		FinishedAt:  j.FinishedAt,
+	}
+	if j.StartedAt != nil {
+		v.StartedAt = *j.StartedAt
+	}
+	v.Done = v.Total * v.Progress / 100
+	if !j.Finished() {
+		// 旧接口没有排队状态
+		v.Status = jobs.StatusRunning
+	}
+	if !withResult {
+		return v
+	}
+	if j.Finished() {
+		v.Result = j.Result
+		return v
+	}
+	partial := &toolkit.PortScanResult{
+		Target:        req.Target,
+		OpenPorts:     []toolkit.PortInfo{},
+		FilteredPorts: []toolkit.PortInfo{},
+		ClosedPorts:   []int{},
+		ScanTime:      v.StartedAt,
+	}
+	if ports, err := toolkit.ParsePorts(req.Ports); err == nil {
+		partial.ScannedPorts = len(ports)
+	}
+	for _, out := range j.Output {
+		info, ok := out.(toolkit.PortInfo)
+		if !ok {
+			continue
+		}
+		if info.Status == toolkit.PortStatusOpen {
+			partial.OpenPorts = append(partial.OpenPorts, info)
+		} else {
+			partial.FilteredPorts = append(partial.FilteredPorts, info)
+		}
+	}
+	v.Result = partial
+	return v
+}
+
+// portScanJob 按 ID 取端口扫描任务，不存在或不是端口扫描时返回 nil
+func portScanJob(c *gin.Context) *jobs.Job {
+	job, err := jobs.Default().Get(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return nil
+	}
+	if job == nil || job.Kind != "portscan" {
+		c.JSON(http.StatusNotFound, models.ErrorResponse(404, "扫描任务不存在"))
+		return nil
+	}
+	return job
+}
+
+// handlePortScanJobStart 启动后台端口扫描（等同于 POST /jobs 的 portscan 任务）
+func (s *Server) handlePortScanJobStart(c *gin.Context) {
+	raw, _ := c.GetRawData()
+	params, fn, err := s.buildTool("portscan", database.DiagnosticSourceUI, raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, err.Error()))
+		return
+	}
+	job, err := jobs.Default().Submit("portscan", database.DiagnosticSourceUI, params, fn)
+	if err != nil {
+		c.JSON(http.StatusTooManyRequests, models.ErrorResponse(429, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(newPortScanJobView(job, true)))
+}
+
+// handlePortScanJobs 端口扫描任务列表（不含结果）
+func (s *Server) handlePortScanJobs(c *gin.Context) {
+	list, err := jobs.Default().List("portscan", 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	views := make([]portScanJobView, 0, len(list))
+	for i := range list {
+		views = append(views, newPortScanJobView(&list[i], false))
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
+		"jobs": views,
+	}))
+}
+
+// handlePortScanJob 端口扫描任务详情（运行中返回已发现的开放端口）
+func (s *Server) handlePortScanJob(c *gin.Context) {
+	job := portScanJob(c)
+	if job == nil {
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(newPortScanJobView(job, true)))
+}
+
+// handlePortScanJobCancel 取消端口扫描任务
+func (s *Server) handlePortScanJobCancel(c *gin.Context) {
+	if portScanJob(c) == nil {
+		return
+	}
+	job, err := jobs.Default().Cancel(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	if job == nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse(404, "扫描任务不存在"))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(newPortScanJobView(job, true)))
+}
+
+// handleDNS 处理DNS查询请求
+func (s *Server) handleDNS(c *gin.Context) {
+	s.runTool(c, "dns")
+}
+
+type dnsRequest struct {
+	Query     string   `json:"query" binding:"required"`
+	Type      string   `json:"type"`
+	Server    string   `json:"server"`     // 为空使用系统 DNS；支持 tls://、https://、tcp:// 前缀
+	Transport string   `json:"transport"`  // udp（默认）/ tcp / dot / doh
+	Timeout   int      `json:"timeout"`    // 秒，默认 5
+	NoRecurse bool     `json:"no_recurse"` // 不请求递归
+	Compare   bool     `json:"compare"`    // 对比模式：同时查询 servers（为空时用系统 DNS 和常用公共 DNS）
+	Servers   []string `json:"servers"`
+}
+
+func buildDNSJob(s *Server, source string, raw []byte) (interface{}, jobs.Func, error) {
+	var req dnsRequest
+	if err := binding.JSON.BindBody(raw, &req); err != nil {
+		return nil, nil, fmt.Errorf("参数错误: %v", err)
+	}
+
+	if req.Type == "" {
+		req.Type = "A"
+	}
+	if req.Timeout <= 0 {
+		req.Timeout = 5
+	}
+	if len(req.Servers) > 10 {
+		return nil, nil, fmt.Errorf("参数错误: servers 最多 10 个")
+	}
+
+	return req, func(ctx context.Context, report func(int, interface{})) (interface{}, error) {
+		opts := toolkit.DNSOptions{
+			Type:      req.Type,
+			Server:    req.Server,
+			Transport: req.Transport,
+			Timeout:   time.Duration(req.Timeout) * time.Second,
+			NoRecurse: req.NoRecurse,
+		}
+		if req.Compare || len(req.Servers) > 0 {
+			return toolkit.DNSCompare(ctx, req.Query, req.Servers, opts), nil
+		}
+		// 使用toolkit的DNS客户端直接查询指定服务器
+		return toolkit.DNSLookup(ctx, req.Query, opts)
+	}, nil
+}
+
+// handleMtr 处理MTR请求（持续探测路径上每一跳，建议 "async": true）
+func (s *Server) handleMtr(c *gin.Context) {
+	s.runTool(c, "mtr")
+}
+
+type mtrRequest struct {
+	Target    string  `json:"target" binding:"required"`
+	Cycles    *int    `json:"cycles"`   // 探测轮数，默认 10；0 表示直到取消
+	Interval  float64 `json:"interval"` // 每轮间隔（秒），默认 1，最小 0.2
+	Timeout   int     `json:"timeout"`  // 每轮等待回复的时间（秒），默认 2
+	MaxHops   int     `json:"max_hops"`
+	Protocol  string  `json:"protocol"` // icmp（默认）/ udp / tcp
+	Port      int     `json:"port"`
+	Paris     *bool   `json:"paris"` // 默认 true
+	NoResolve bool    `json:"no_resolve"`
+	IPVersion int     `json:"ip_version"`
+	Save      bool    `json:"save"` // 结束后保存报告
+	Note      string  `json:"note"` // 保存报告时的备注
+}
+
+func buildMtrJob(s *Server, source string, raw []byte) (interface{}, jobs.Func, error) {
+	var req mtrRequest
+	if err := binding.JSON.BindBody(raw, &req); err != nil {
+		return nil, nil, fmt.Errorf("参数错误: %v", err)
+	}
+
+	if req.Cycles == nil {
+		cycles := 10
+		req.Cycles = &cycles
+	}
+	if *req.Cycles < 0 || *req.Cycles > 10000 {
+		return nil, nil, fmt.Errorf("参数错误: cycles 范围为 0-10000")
+	}
+	if req.Interval <= 0 {
+		req.Interval = 1
+	}
+	if req.Interval < 0.2 {
+		req.Interval = 0.2
+	}
+	if req.Timeout <= 0 {
+		req.Timeout = 2
+	}
+	if req.Paris == nil {
+		paris := true
+		req.Paris = &paris
+	}
+	if req.IPVersion != 0 && req.IPVersion != 4 && req.IPVersion != 6 {
+		return nil, nil, fmt.Errorf("参数错误: ip_version 只能为 4 或 6")
+	}
+
+	return req, func(ctx context.Context, report func(int, interface{})) (interface{}, error) {
+		result, err := toolkit.Mtr(ctx, req.Target, toolkit.MtrOptions{
+			Protocol:  req.Protocol,
+			Port:      req.Port,
+			Paris:     *req.Paris,
+			MaxHops:   req.MaxHops,
+			Cycles:    *req.Cycles,
+			Interval:  time.Duration(req.Interval * float64(time.Second)),
+			Timeout:   time.Duration(req.Timeout) * time.Second,
+			NoResolve: req.NoResolve,
+			IPVersion: req.IPVersion,
+			OnCycle: func(r *toolkit.MtrReport) {
+				realtime.Default().Broadcast("mtr_update", r)
+				progress := -1
+				if *req.Cycles > 0 {
+					progress = r.Cycles * 100 / *req.Cycles
+				}
+				report(progress, gin.H{"cycle": r.Cycles, "hops": r.Hops})
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if req.Save {
+			if err := saveMtrReport(s, result, req.Note); err != nil {
+				logger.Warn("保存MTR报告失败: %v", err)
+			}
+		}
+		return result, nil
+	}, nil
+}
+
+func saveMtrReport(s *Server, r *toolkit.MtrReport, note string) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return database.SaveMtrReport(s.db, &database.MtrReportRecord{
+		ID:        r.ID,
+		Target:    r.Target,
+		IP:        r.IP,
+		Protocol:  r.Protocol,
+		Cycles:    r.Cycles,
+		Note:      note,
+		Report:    data,
+		CreatedAt: r.StartedAt,
+	})
+}
+
+// handleMtrReports 已保存的MTR报告（?target= 按目标过滤，?limit= 默认 50）
+func (s *Server) handleMtrReports(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	list, err := database.ListMtrReports(s.db, strings.TrimSpace(c.Query("target")), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
+		"reports": list,
+	}))
+}
+
+// handleMtrReportSave 保存已结束（或已取消）的MTR任务的报告：{"job_id": "...", "note": "..."}
+func (s *Server) handleMtrReportSave(c *gin.Context) {
+	var req struct {
+		JobID string `json:"job_id" binding:"required"`
+		Note  string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "参数错误: "+err.Error()))
+		return
+	}
+	job, err := jobs.Default().Get(req.JobID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	if job == nil || job.Kind != "mtr" {
+		c.JSON(http.StatusNotFound, models.ErrorResponse(404, "MTR任务不存在"))
+		return
+	}
+	if !job.Finished() || job.Result == nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "任务尚未结束或没有结果"))
+		return
+	}
+	// 内存中的结果为 *toolkit.MtrReport，数据库中的为 JSON，统一转换
+	data, err := json.Marshal(job.Result)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	var r toolkit.MtrReport
+	if err := json.Unmarshal(data, &r); err != nil || r.ID == "" {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, "任务结果不是有效的MTR报告"))
+		return
+	}
+	if err := saveMtrReport(s, &r, req.Note); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	rec, err := database.GetMtrReport(s.db, r.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(rec))
+}
+
+// handleMtrReportDetail 获取保存的MTR报告
+func (s *Server) handleMtrReportDetail(c *gin.Context) {
+	rec, err := database.GetMtrReport(s.db, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	if rec == nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse(404, "报告不存在"))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(rec))
+}
+
+// handleMtrReportDelete 删除保存的MTR报告
+func (s *Server) handleMtrReportDelete(c *gin.Context) {
+	if err := database.DeleteMtrReport(s.db, c.Param("id")); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(nil))
+}
+
+// handleJobSubmit 提交后台任务：{"kind": "portscan", "params": {...}}，params 与对应工具接口的请求体相同
+func (s *Server) handleJobSubmit(c *gin.Context) {
+	var req struct {
+		Kind   string          `json:"kind" binding:"required"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "参数错误: "+err.Error()))
+		return
+	}
+	params, fn, err := s.buildTool(req.Kind, database.DiagnosticSourceUI, req.Params)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, err.Error()))
+		return
+	}
+	s.submitToolJob(c, req.Kind, database.DiagnosticSourceUI, params, fn)
+}
+
+// handleJobs 最近的后台任务（?kind= 按类型过滤，?limit= 默认 50）
+func (s *Server) handleJobs(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	list, err := jobs.Default().List(c.Query("kind"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
+		"jobs": list,
+	}))
+}
+
+// handleJobDetail 任务详情：运行中返回进度和增量输出，结束后返回结果
+func (s *Server) handleJobDetail(c *gin.Context) {
+	job, err := jobs.Default().Get(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	if job == nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse(404, "任务不存在"))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(job))
+}
+
+// handleJobCancel 取消排队或运行中的任务
+func (s *Server) handleJobCancel(c *gin.Context) {
+	job, err := jobs.Default().Cancel(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	if job == nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse(404, "任务不存在"))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(job))
+}
+
+// recordDiagnostic 包装工具执行函数：结束后（成功、失败或取消）保存一条诊断运行记录
+func (s *Server) recordDiagnostic(kind, source string, params interface{}, fn jobs.Func) jobs.Func {
+	return func(ctx context.Context, report func(int, interface{})) (interface{}, error) {
+		start := time.Now()
+		result, err := fn(ctx, report)
+
+		run := &database.DiagnosticRun{
+			Kind:      kind,
+			Target:    diagnosticTarget(params),
+			Source:    source,
+			Status:    "ok",
+			JobID:     jobs.IDFromContext(ctx),
+			Duration:  math.Round(time.Since(start).Seconds()*1000) / 1000,
+			CreatedAt: start,
+		}
+		switch {
+		case ctx.Err() != nil:
+			run.Status = "cancelled"
+		case err != nil:
+			run.Status = "failed"
+			run.Error = err.Error()
+		default:
+			run.Metrics = diagnosticMetrics(result)
+		}
+		run.Params, _ = json.Marshal(params)
+		if result != nil {
+			run.Result, _ = json.Marshal(result)
+		}
+		if dbErr := database.SaveDiagnosticRun(s.db, run); dbErr != nil {
+			logger.Warn("保存诊断记录失败: %v", dbErr)
+		}
+		return result, err
+	}
+}
+
+// diagnosticKind 诊断历史中的类型：网站测速（speedtest 的 web 模式）单独记为 webspeed
+func diagnosticKind(kind string, params interface{}) string {
+	if req, ok := params.(speedTestRequest); ok {
+		switch req.Mode {
+		case "download", "upload", "peer":
+		default:
+			return "webspeed"
+		}
+	}
+	return kind
+}
+
+// diagnosticTarget 诊断目标（用于按目标过滤和统计趋势）
+func diagnosticTarget(params interface{}) string {
+	switch req := params.(type) {
+	case pingRequest:
+		return req.Target
+	case tracerouteRequest:
+		return req.Target
+	case mtrRequest:
+		return req.Target
+	case portScanRequest:
+		return req.Target
+	case dnsRequest:
+		return req.Query
+	case groupPingRequest:
+		return fmt.Sprintf("group:%d", req.GroupID)
+	case groupPortScanRequest:
+		return fmt.Sprintf("group:%d", req.GroupID)
+	case speedTestRequest:
+		for _, v := range []string{req.UploadURL, req.URL, req.Server} {
+			if v = strings.TrimSpace(v); v != "" {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// diagnosticMetrics 从工具结果中提取用于趋势统计的数值指标
+func diagnosticMetrics(result interface{}) map[string]float64 {
+	m := map[string]float64{}
+	boolMetric := func(b bool) float64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	switch r := result.(type) {
+	case *toolkit.PingResult:
+		m["loss"] = r.PacketLoss
+		if r.PacketsReceived > 0 {
+			m["avg_ms"] = r.AvgLatency
+			m["min_ms"] = r.MinLatency
+			m["max_ms"] = r.MaxLatency
+			m["jitter_ms"] = r.Jitter
+		}
+	case *toolkit.TracerouteResult:
+		m["hops"] = float64(len(r.Hops))
+		m["reached"] = boolMetric(r.Reached)
+		if r.Reached && len(r.Hops) > 0 {
+			m["latency_ms"] = r.Hops[len(r.Hops)-1].AvgLatency
+		}
+	case *toolkit.MtrReport:
+		m["hops"] = float64(len(r.Hops))
+		m["reached"] = boolMetric(r.Reached)
+		if len(r.Hops) > 0 {
+			last := r.Hops[len(r.Hops)-1]
+			m["loss"] = last.Loss
+			m["avg_ms"] = last.Avg
+		}
+	case *toolkit.SpeedResult:
+		if r.DownloadSpeed > 0 {
+			m["download_mbps"] = r.DownloadSpeed
+		}
+		if r.UploadSpeed > 0 {
+			m["upload_mbps"] = r.UploadSpeed
+		}
+		if r.Latency > 0 {
+			m["latency_ms"] = float64(r.Latency)
+		}
+	case *toolkit.PeerSpeedResult:
+		if r.DownloadSpeed > 0 {
+			m["download_mbps"] = r.DownloadSpeed
+		}
+		if r.UploadSpeed > 0 {
+			m["upload_mbps"] = r.UploadSpeed
+		}
+		m["latency_ms"] = r.Latency
+	case *toolkit.WebSpeedResult:
+		for k, v := range r.Summary {
+			switch n := v.(type) {
+			case int:
+				m[k] = float64(n)
+			case float64:
+				m[k] = n
+			}
+		}
+	case *toolkit.PortScanResult:
+		m["scanned_ports"] = float64(r.ScannedPorts)
+		m["open_ports"] = float64(len(r.OpenPorts))
+		m["filtered_ports"] = float64(len(r.FilteredPorts))
+	case *toolkit.DNSResult:
+		m["time_ms"] = r.Time
+		m["answers"] = float64(len(r.Records))
+	case *toolkit.DNSCompareResult:
+		m["consistent"] = boolMetric(r.Consistent)
+		m["servers"] = float64(len(r.Results))
+	case *groupPingResult:
+		m["total"] = float64(r.Total)
+		m["reachable"] = float64(r.Reachable)
+		m["unreachable"] = float64(r.Unreachable)
+	case *groupPortScanResult:
+		m["total"] = float64(r.Total)
+		m["open_ports"] = float64(r.OpenPorts)
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+// diagnosticFilter 由查询参数生成诊断历史过滤条件（kind/target/source/status/since/until/days）
+func diagnosticFilter(c *gin.Context) (database.DiagnosticFilter, error) {
+	f := database.DiagnosticFilter{
+		Kind:   strings.TrimSpace(c.Query("kind")),
+		Target: strings.TrimSpace(c.Query("target")),
+		Source: strings.TrimSpace(c.Query("source")),
+		Status: strings.TrimSpace(c.Query("status")),
+	}
+	if v := c.Query("since"); v != "" {
+		t, err := parseQueryTime(v)
+		if err != nil {
+			return f, fmt.Errorf("参数错误: since 格式应为 RFC3339 或 2006-01-02")
+		}
+		f.Since = t
+	} else if days, _ := strconv.Atoi(c.Query("days")); days > 0 {
+		f.Since = time.Now().AddDate(0, 0, -days)
+	}
+	if v := c.Query("until"); v != "" {
+		t, err := parseQueryTime(v)
+		if err != nil {
+			return f, fmt.Errorf("参数错误: until 格式应为 RFC3339 或 2006-01-02")
+		}
+		f.Until = t
+	}
+	return f, nil
+}
+
+// handleDiagnosticRuns 诊断运行历史（?kind=&target=&source=&status=&since=&until=&days=&limit=&offset=）
+func (s *Server) handleDiagnosticRuns(c *gin.Context) {
+	f, err := diagnosticFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, err.Error()))
+		return
+	}
+	f.Limit, _ = strconv.Atoi(c.Query("limit"))
+	f.Offset, _ = strconv.Atoi(c.Query("offset"))
+	list, total, err := database.ListDiagnosticRuns(s.db, f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
+		"runs":  list,
+		"total": total,
+	}))
+}
+
+// handleDiagnosticRunDetail 诊断运行详情（含完整结果）
+func (s *Server) handleDiagnosticRunDetail(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "无效的记录ID"))
+		return
+	}
+	run, err := database.GetDiagnosticRun(s.db, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	if run == nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse(404, "记录不存在"))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(run))
+}
+
+// handleDiagnosticRunDelete 删除一条诊断运行
+func (s *Server) handleDiagnosticRunDelete(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "无效的记录ID"))
+		return
+	}
+	if err := database.DeleteDiagnosticRun(s.db, id); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(nil))
+}
+
+// handleDiagnosticRunsDelete 按条件批量删除诊断运行（过滤参数同列表）；不带任何条件时需要 ?all=true
+func (s *Server) handleDiagnosticRunsDelete(c *gin.Context) {
+	f, err := diagnosticFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, err.Error()))
+		return
+	}
+	if f == (database.DiagnosticFilter{}) && c.Query("all") != "true" {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "未指定过滤条件；清空全部记录请加 all=true"))
+		return
+	}
+	n, err := database.DeleteDiagnosticRuns(s.db, f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{"deleted": n}))
+}
+
+// handleDiagnosticTrend 指标按天趋势（?kind=speedtest&metric=download_mbps&target=&days=30）
+func (s *Server) handleDiagnosticTrend(c *gin.Context) {
+	kind := strings.TrimSpace(c.Query("kind"))
+	metric := strings.TrimSpace(c.Query("metric"))
+	if kind == "" || metric == "" {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "参数错误: 需要 kind 和 metric"))
+		return
+	}
+	days, _ := strconv.Atoi(c.Query("days"))
+	if days <= 0 {
+		days = 30
+	}
+	if days > 365 {
+		days = 365
+	}
+	target := strings.TrimSpace(c.Query("target"))
+	points, err := database.DiagnosticTrend(s.db, kind, metric, target, days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
+		"kind":   kind,
+		"metric": metric,
+		"target": target,
+		"days":   days,
+		"points": points,
+	}))
+}
+
+// checkRequest 定时检查定义（新建/修改/试运行）
+type checkRequest struct {
+	Name       string                      `json:"name"`
+	Type       string                      `json:"type"`
+	Target     string                      `json:"target"`
+	Enabled    *bool                       `json:"enabled"` // 默认 true
+	Interval   int                         `json:"interval"`
+	Timeout    int                         `json:"timeout"`
+	Params     database.MonitorCheckParams `json:"params"`
+	Thresholds database.MonitorThresholds  `json:"thresholds"`
+}
+
+func (r checkRequest) check() *database.MonitorCheck {
+	enabled := r.Enabled == nil || *r.Enabled
+	return &database.MonitorCheck{
+		Name:       r.Name,
+		Type:       r.Type,
+		Target:     r.Target,
+		Enabled:    enabled,
+		Interval:   r.Interval,
+		Timeout:    r.Timeout,
+		Params:     r.Params,
+		Thresholds: r.Thresholds,
+	}
+}
+
+// handleMonitorChecks 定时检查列表（含当前状态）
+func (s *Server) handleMonitorChecks(c *gin.Context) {
+	list, err := database.ListMonitorChecks(s.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(list))
+}
+
+// handleMonitorCheckCreate 新建定时检查（保存后立即执行一次）
+func (s *Server) handleMonitorCheckCreate(c *gin.Context) {
+	var req checkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "参数错误: "+err.Error()))
+		return
+	}
+	check := req.check()
+	if err := database.SaveMonitorCheck(s.db, check); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, err.Error()))
+		return
+	}
+	if err := probe.DefaultChecks().Reload(); err != nil {
+		logger.Warn("重新加载定时检查失败: %v", err)
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(check))
+}
+
+// handleMonitorCheckDetail 定时检查详情
+func (s *Server) handleMonitorCheckDetail(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "无效的检查ID"))
+		return
+	}
+	check, err := database.GetMonitorCheck(s.db, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	if check == nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse(404, "检查不存在"))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(check))
+}
+
+// handleMonitorCheckUpdate 修改定时检查（整体替换定义，保留当前状态）
+func (s *Server) handleMonitorCheckUpdate(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "无效的检查ID"))
+		return
+	}
+	var req checkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "参数错误: "+err.Error()))
+		return
+	}
+	check := req.check()
+	check.ID = id
+	if err := database.SaveMonitorCheck(s.db, check); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, models.ErrorResponse(404, "检查不存在"))
+			return
+		}
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, err.Error()))
+		return
+	}
+	if err := probe.DefaultChecks().Reload(); err != nil {
+		logger.Warn("重新加载定时检查失败: %v", err)
+	}
+	check, err = database.GetMonitorCheck(s.db, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(check))
+}
+
+// handleMonitorCheckDelete 删除定时检查及其结果
+func (s *Server) handleMonitorCheckDelete(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "无效的检查ID"))
+		return
+	}
+	ok, err := database.DeleteMonitorCheck(s.db, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, models.ErrorResponse(404, "检查不存在"))
+		return
+	}
+	if err := probe.DefaultChecks().Reload(); err != nil {
+		logger.Warn("重新加载定时检查失败: %v", err)
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(nil))
+}
+
+// handleMonitorCheckRun 立即执行一次检查（结果入库，状态变化照常推送）
+func (s *Server) handleMonitorCheckRun(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "无效的检查ID"))
+		return
+	}
+	r, err := probe.DefaultChecks().RunNow(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	if r == nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse(404, "检查不存在"))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(r))
+}
+
+// handleMonitorCheckTest 试运行一个未保存的检查定义（不入库、不推送）
+func (s *Server) handleMonitorCheckTest(c *gin.Context) {
+	var req checkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "参数错误: "+err.Error()))
+		return
+	}
+	check := req.check()
+	if err := check.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(probe.RunCheck(c.Request.Context(), check)))
+}
+
+// handleMonitorCheckResults 检查结果时间序列（?since=&until=&limit=，按时间升序）
+func (s *Server) handleMonitorCheckResults(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "无效的检查ID"))
+		return
+	}
+	var since, until time.Time
+	if v := c.Query("since"); v != "" {
+		if since, err = parseQueryTime(v); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "参数错误: since 格式应为 RFC3339 或 2006-01-02"))
+			return
+		}
+	} else {
+		since = time.Now().Add(-24 * time.Hour)
+	}
+	if v := c.Query("until"); v != "" {
+		if until, err = parseQueryTime(v); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse(400, "参数错误: until 格式应为 RFC3339 或 2006-01-02"))
+			return
+		}
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	list, err := database.ListMonitorCheckResults(s.db, id, since, until, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{
+		"check_id": id,
+		"results":  list,
+	}))
+}
+
+// handleNPSStatus 处理获取NPS状态请求
+func (s *Server) handleNPSStatus(c *gin.Context) {
+	status, err := s.npsClient.GetStatus()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse(status))
+}
+
+// handleNPCInstall 一键下载并安装 npc（用于设备端集成 NPS 客户端）
 func (s *Server) handleNPCInstall(c *gin.Context) {
 	var req struct {
 		Version    string `json:"version"`
@@ -1379,7 +3736,8 @@ func (s *Server) handleConfigGet(c *gin.Context) {
 			"tls":       s.config.MQTT.TLS,
 			"auto_connect": s.config.MQTT.AutoConnect,
 		},
-		"scanner": s.config.Scanner,
+		"scanner":          s.config.ScannerSettings(),
+		"speedtest_server": maskSpeedTestToken(s.config.SpeedTestSettings()),
 	}
 
 	c.JSON(http.StatusOK, models.SuccessResponse(config))
@@ -1393,15 +3751,16 @@ func (s *Server) handleConfigUpdate(c *gin.Context) {
 		return
 	}
 
-	// 允许更新的字段：device/network/nps_server/mqtt/scanner/server/database/initialized
+	// 允许更新的字段：device/network/nps_server/mqtt/scanner/server/database/speedtest_server/initialized
 	// 安全：不允许通过该接口直接写入 password_hash
 	s.config.Device = req.Device
 	s.config.Network = req.Network
 	s.config.NPSServer = req.NPSServer
 	s.config.MQTT = req.MQTT
-	s.config.Scanner = req.Scanner
+	s.config.SetScanner(req.Scanner)
 	s.config.Server = req.Server
 	s.config.Database = req.Database
+	s.config.SetSpeedTestServer(keepSpeedTestToken(req.SpeedTestServer, s.config.SpeedTestSettings()))
 	s.config.Initialized = req.Initialized
 
 	if err := s.config.Validate(); err != nil {
@@ -1416,6 +3775,26 @@ func (s *Server) handleConfigUpdate(c *gin.Context) {
 	c.JSON(http.StatusOK, models.SuccessResponse(gin.H{"message": "更新成功"}))
 }
 
+// maskedToken 配置读取接口中代替口令返回的占位符
+const maskedToken = "***"
+
+// maskSpeedTestToken 读取配置时隐藏测速口令（未设置时返回空，便于区分）
+func maskSpeedTestToken(st config.SpeedTestServerConfig) config.SpeedTestServerConfig {
+	if st.Token != "" {
+		st.Token = maskedToken
+	}
+	return st
+}
+
+// keepSpeedTestToken 回写配置时收到占位符或空口令则保留原口令，
+// 避免“读取-修改-写回”把口令清空
+func keepSpeedTestToken(st, cur config.SpeedTestServerConfig) config.SpeedTestServerConfig {
+	if st.Token == "" || st.Token == maskedToken {
+		st.Token = cur.Token
+	}
+	return st
+}
+
 // handleConfigInit 处理初始化配置请求
 func (s *Server) handleConfigInit(c *gin.Context) {
 	var req struct {
@@ -1446,7 +3825,7 @@ func (s *Server) handleConfigInit(c *gin.Context) {
 		s.config.MQTT = *req.MQTT
 	}
 	if req.Scanner != nil {
-		s.config.Scanner = *req.Scanner
+		s.config.SetScanner(*req.Scanner)
 	}
 
 	// 设置管理员密码
@@ -1500,7 +3879,7 @@ func (s *Server) handleConfigImport(c *gin.Context) {
 		return
 	}
 
-	*s.config = req
+	s.config.Replace(&req)
 	if err := s.config.Save(); err != nil {
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse(500, "保存失败: "+err.Error()))
 		return
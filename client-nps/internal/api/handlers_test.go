@@ -0,0 +1,84 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"nwct/client-nps/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 读取配置再原样写回时，测速服务端的开关、端口和口令都不能丢
+func TestSpeedTestConfigRoundTrip(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("NWCT_CONFIG_PATH", filepath.Join(t.TempDir(), "config.json"))
+	cfg := config.DefaultConfig()
+	cfg.SpeedTestServer = config.SpeedTestServerConfig{Disabled: true, Port: 18081, Token: "s3cret"}
+	s := &Server{config: cfg}
+	r := gin.New()
+	r.GET("/config", s.handleConfigGet)
+	r.PUT("/config", s.handleConfigUpdate)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/config", nil))
+	var got struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	var st config.SpeedTestServerConfig
+	if err := json.Unmarshal(got.Data["speedtest_server"], &st); err != nil {
+		t.Fatalf("读取配置缺少 speedtest_server: %s", w.Body.String())
+	}
+	if st.Token != "***" || !st.Disabled || st.Port != 18081 {
+		t.Fatalf("speedtest_server = %+v，期望口令脱敏且保留开关和端口", st)
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"server":           cfg.Server,
+		"database":         cfg.Database,
+		"initialized":      true,
+		"speedtest_server": st,
+	})
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("写回配置失败: %d %s", w.Code, w.Body.String())
+	}
+	if cur := cfg.SpeedTestSettings(); cur != (config.SpeedTestServerConfig{Disabled: true, Port: 18081, Token: "s3cret"}) {
+		t.Fatalf("写回后 speedtest_server = %+v", cur)
+	}
+}
+
+// 在 duration 内发不完 bytes 时应提前结束并给出完整响应，客户端不能读到 unexpected EOF
+func TestSpeedTestDownloadStopsAtDeadline(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Server{}
+	r := gin.New()
+	r.GET("/download", s.handleSpeedTestDownload)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/download?bytes=1000000000000&duration=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ContentLength != -1 {
+		t.Fatalf("Content-Length = %d，期望不设置", resp.ContentLength)
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		t.Fatalf("读取 %d 字节后出错: %v", n, err)
+	}
+	if n == 0 {
+		t.Fatal("没有收到测速数据")
+	}
+}
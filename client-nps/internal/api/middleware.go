@@ -1,7 +1,10 @@
 package api
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"nwct/client-nps/internal/toolkit"
+	"nwct/client-nps/models"
 	"nwct/client-nps/utils"
 	"strings"
 
@@ -13,8 +16,8 @@ func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Speedtest-Token")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -81,3 +84,24 @@ func (s *Server) authMiddleware() gin.HandlerFunc {
 	}
 }
 
+// speedTestAuth 测速服务端鉴权：配置了 speedtest_server.token 时接受 X-Speedtest-Token 头
+// （不接受 URL 参数，避免口令出现在访问日志和代理日志中），否则（或口令不符）按 JWT 校验；
+// 关闭测速服务端时返回 404
+func (s *Server) speedTestAuth() gin.HandlerFunc {
+	jwt := s.authMiddleware()
+	return func(c *gin.Context) {
+		st := s.config.SpeedTestSettings()
+		if st.Disabled {
+			c.AbortWithStatusJSON(http.StatusNotFound, models.ErrorResponse(404, "测速服务端未开启"))
+			return
+		}
+		if want := st.Token; want != "" {
+			got := c.GetHeader(toolkit.SpeedTestTokenHeader)
+			if subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1 {
+				c.Next()
+				return
+			}
+		}
+		jwt(c)
+	}
+}
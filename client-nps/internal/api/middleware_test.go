@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"nwct/client-nps/config"
+	"nwct/client-nps/internal/toolkit"
+	"nwct/client-nps/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSpeedTestAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	jwt, err := utils.GenerateJWT("test-device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const token = "s3cret"
+
+	tests := []struct {
+		name     string
+		cfg      config.SpeedTestServerConfig
+		query    string
+		header   string // X-Speedtest-Token
+		bearer   string
+		wantCode int
+	}{
+		{name: "口令正确", cfg: config.SpeedTestServerConfig{Token: token}, header: token, wantCode: http.StatusOK},
+		{name: "口令错误", cfg: config.SpeedTestServerConfig{Token: token}, header: "wrong", wantCode: http.StatusUnauthorized},
+		{name: "口令前缀不算匹配", cfg: config.SpeedTestServerConfig{Token: token}, header: "s3cre", wantCode: http.StatusUnauthorized},
+		{name: "不接受 URL 参数中的口令", cfg: config.SpeedTestServerConfig{Token: token}, query: "?token=" + token, wantCode: http.StatusUnauthorized},
+		{name: "未配置口令且没有 JWT", wantCode: http.StatusUnauthorized},
+		{name: "未配置口令时使用 JWT", bearer: jwt, wantCode: http.StatusOK},
+		{name: "口令错误但 JWT 有效", cfg: config.SpeedTestServerConfig{Token: token}, header: "wrong", bearer: jwt, wantCode: http.StatusOK},
+		{name: "JWT 无效", cfg: config.SpeedTestServerConfig{Token: token}, bearer: "invalid", wantCode: http.StatusUnauthorized},
+		{name: "测速服务端关闭时即使口令正确也返回 404", cfg: config.SpeedTestServerConfig{Disabled: true, Token: token}, header: token, wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{config: &config.Config{Initialized: true, SpeedTestServer: tt.cfg}}
+			r := gin.New()
+			r.GET("/api/v1/speedtest/ping", s.speedTestAuth(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/speedtest/ping"+tt.query, nil)
+			if tt.header != "" {
+				req.Header.Set(toolkit.SpeedTestTokenHeader, tt.header)
+			}
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("状态码 %d，期望 %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -56,6 +56,21 @@ func (s *Server) Router() *gin.Engine {
 	return s.router
 }
 
+// SpeedTestRouter 只包含测速服务端接口的路由，用于 speedtest_server.port 独立端口
+func (s *Server) SpeedTestRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.Use(corsMiddleware())
+	s.registerSpeedTestRoutes(r.Group("/api/v1"))
+	return r
+}
+
+func (s *Server) registerSpeedTestRoutes(g *gin.RouterGroup) {
+	g.GET("/speedtest/ping", s.speedTestAuth(), s.handleSpeedTestPing)
+	g.GET("/speedtest/download", s.speedTestAuth(), s.handleSpeedTestDownload)
+	g.POST("/speedtest/upload", s.speedTestAuth(), s.handleSpeedTestUpload)
+}
+
 // initRouter 初始化路由
 func (s *Server) initRouter() {
 	gin.SetMode(gin.ReleaseMode)
@@ -103,17 +118,72 @@ func (s *Server) initRouter() {
 		// 设备扫描
 		api.GET("/devices", s.authMiddleware(), s.handleDevicesList)
 		api.GET("/devices/activity", s.authMiddleware(), s.handleDevicesActivity)
+		api.GET("/devices/search", s.authMiddleware(), s.handleDevicesSearch)
 		api.GET("/devices/:ip", s.authMiddleware(), s.handleDeviceDetail)
+		api.PATCH("/devices/:id", s.authMiddleware(), s.handleDeviceAnnotate)
+		api.GET("/devices/tags", s.authMiddleware(), s.handleDeviceTags)
+		api.GET("/devices/groups", s.authMiddleware(), s.handleGroupsList)
+		api.POST("/devices/groups", s.authMiddleware(), s.handleGroupCreate)
+		api.GET("/devices/groups/:id", s.authMiddleware(), s.handleGroupDetail)
+		api.PUT("/devices/groups/:id", s.authMiddleware(), s.handleGroupUpdate)
+		api.DELETE("/devices/groups/:id", s.authMiddleware(), s.handleGroupDelete)
+		api.POST("/devices/groups/:id/members", s.authMiddleware(), s.handleGroupMembers)
+		api.GET("/devices/groups/:id/status", s.authMiddleware(), s.handleGroupStatus)
+		api.POST("/devices/groups/:id/ping", s.authMiddleware(), s.handleGroupPing)
+		api.POST("/devices/groups/:id/portscan", s.authMiddleware(), s.handleGroupPortScan)
 		api.POST("/devices/scan/start", s.authMiddleware(), s.handleScanStart)
 		api.POST("/devices/scan/stop", s.authMiddleware(), s.handleScanStop)
 		api.GET("/devices/scan/status", s.authMiddleware(), s.handleScanStatus)
+		api.GET("/devices/scan/events", s.authMiddleware(), s.handleScanEvents)
+		api.GET("/devices/passive/status", s.authMiddleware(), s.handlePassiveStatus)
+		api.GET("/devices/rules", s.authMiddleware(), s.handleRulesList)
+		api.POST("/devices/rules", s.authMiddleware(), s.handleRulesUpsert)
+		api.DELETE("/devices/rules/:id", s.authMiddleware(), s.handleRulesDelete)
+		api.POST("/devices/rules/reload", s.authMiddleware(), s.handleRulesReload)
+		api.POST("/devices/rules/test", s.authMiddleware(), s.handleRulesTest)
 
 		// 网络工具箱
 		api.POST("/tools/ping", s.authMiddleware(), s.handlePing)
 		api.POST("/tools/traceroute", s.authMiddleware(), s.handleTraceroute)
 		api.POST("/tools/speedtest", s.authMiddleware(), s.handleSpeedTest)
 		api.POST("/tools/portscan", s.authMiddleware(), s.handlePortScan)
+		api.POST("/tools/portscan/jobs", s.authMiddleware(), s.handlePortScanJobStart)
+		api.GET("/tools/portscan/jobs", s.authMiddleware(), s.handlePortScanJobs)
+		api.GET("/tools/portscan/jobs/:id", s.authMiddleware(), s.handlePortScanJob)
+		api.POST("/tools/portscan/jobs/:id/cancel", s.authMiddleware(), s.handlePortScanJobCancel)
 		api.POST("/tools/dns", s.authMiddleware(), s.handleDNS)
+		api.POST("/tools/mtr", s.authMiddleware(), s.handleMtr)
+		api.GET("/tools/mtr/reports", s.authMiddleware(), s.handleMtrReports)
+		api.POST("/tools/mtr/reports", s.authMiddleware(), s.handleMtrReportSave)
+		api.GET("/tools/mtr/reports/:id", s.authMiddleware(), s.handleMtrReportDetail)
+		api.DELETE("/tools/mtr/reports/:id", s.authMiddleware(), s.handleMtrReportDelete)
+
+		// 测速服务端（对端盒子/浏览器测吞吐）与测速历史
+		s.registerSpeedTestRoutes(api)
+		api.POST("/speedtest/results", s.authMiddleware(), s.handleSpeedTestResultSubmit)
+		api.GET("/speedtest/history", s.authMiddleware(), s.handleSpeedTestHistory)
+		api.DELETE("/speedtest/history/:id", s.authMiddleware(), s.handleSpeedTestHistoryDelete)
+
+		// 后台任务（工具箱长时间操作）
+		api.POST("/jobs", s.authMiddleware(), s.handleJobSubmit)
+		api.GET("/jobs", s.authMiddleware(), s.handleJobs)
+		api.GET("/jobs/:id", s.authMiddleware(), s.handleJobDetail)
+		api.POST("/jobs/:id/cancel", s.authMiddleware(), s.handleJobCancel)
+
+		// 诊断历史与趋势
+		api.GET("/diagnostics/runs", s.authMiddleware(), s.handleDiagnosticRuns)
+		api.DELETE("/diagnostics/runs", s.authMiddleware(), s.handleDiagnosticRunsDelete)
+		api.GET("/diagnostics/runs/:id", s.authMiddleware(), s.handleDiagnosticRunDetail)
+		api.DELETE("/diagnostics/runs/:id", s.authMiddleware(), s.handleDiagnosticRunDelete)
+		api.GET("/diagnostics/trends", s.authMiddleware(), s.handleDiagnosticTrend)
+		api.GET("/monitor/checks", s.authMiddleware(), s.handleMonitorChecks)
+		api.POST("/monitor/checks", s.authMiddleware(), s.handleMonitorCheckCreate)
+		api.POST("/monitor/checks/test", s.authMiddleware(), s.handleMonitorCheckTest)
+		api.GET("/monitor/checks/:id", s.authMiddleware(), s.handleMonitorCheckDetail)
+		api.PUT("/monitor/checks/:id", s.authMiddleware(), s.handleMonitorCheckUpdate)
+		api.DELETE("/monitor/checks/:id", s.authMiddleware(), s.handleMonitorCheckDelete)
+		api.POST("/monitor/checks/:id/run", s.authMiddleware(), s.handleMonitorCheckRun)
+		api.GET("/monitor/checks/:id/results", s.authMiddleware(), s.handleMonitorCheckResults)
 
 		// NPS管理
 		api.GET("/nps/status", s.authMiddleware(), s.handleNPSStatus)
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// AddressFamily 返回地址族（ipv4/ipv6），无法解析返回空
+func AddressFamily(addr string) string {
+	ip := net.ParseIP(stripZone(addr))
+	if ip == nil {
+		return ""
+	}
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// AddressScope 返回地址范围：link-local / ula / global
+func AddressScope(addr string) string {
+	ip := net.ParseIP(stripZone(addr))
+	if ip == nil {
+		return ""
+	}
+	switch {
+	case ip.IsLinkLocalUnicast():
+		return "link-local"
+	case ip.To4() == nil && len(ip) == net.IPv6len && ip[0]&0xfe == 0xfc:
+		return "ula"
+	default:
+		return "global"
+	}
+}
+
+func stripZone(addr string) string {
+	if i := strings.IndexByte(addr, '%'); i >= 0 {
+		return addr[:i]
+	}
+	return addr
+}
+
+// SaveDeviceAddress 记录设备的一个地址（已存在则刷新 last_seen），返回是否为新地址
+func SaveDeviceAddress(db *sql.DB, a *DeviceAddress) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("数据库未初始化")
+	}
+	a.Address = stripZone(a.Address)
+	if a.Family == "" {
+		a.Family = AddressFamily(a.Address)
+	}
+	if a.Scope == "" {
+		a.Scope = AddressScope(a.Address)
+	}
+	now := time.Now()
+
+	res, err := db.Exec(`
+		UPDATE device_addresses
+		SET last_seen = ?, interface = COALESCE(NULLIF(?, ''), interface)
+		WHERE device_id = ? AND address = ?
+	`, now, a.Interface, a.DeviceID, a.Address)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return false, nil
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO device_addresses (device_id, address, family, scope, interface, first_seen, last_seen)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, a.DeviceID, a.Address, a.Family, a.Scope, a.Interface, now, now)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetDeviceAddresses 获取设备的地址历史（IPv4 在前，同族按最近出现排序）
+func GetDeviceAddresses(db *sql.DB, deviceID string) ([]DeviceAddress, error) {
+	if db == nil {
+		return nil, fmt.Errorf("数据库未初始化")
+	}
+	rows, err := db.Query(`
+		SELECT id, device_id, address, family, COALESCE(scope,''), COALESCE(interface,''), first_seen, last_seen
+		FROM device_addresses
+		WHERE device_id = ?
+		ORDER BY family, last_seen DESC
+	`, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []DeviceAddress{}
+	for rows.Next() {
+		var a DeviceAddress
+		if err := rows.Scan(&a.ID, &a.DeviceID, &a.Address, &a.Family, &a.Scope, &a.Interface, &a.FirstSeen, &a.LastSeen); err != nil {
+			continue
+		}
+		if a.Scope == "" {
+			a.Scope = AddressScope(a.Address) // 迁移来的旧地址未记录 scope
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
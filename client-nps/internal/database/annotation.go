@@ -0,0 +1,192 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DeviceAnnotationPatch 标注的部分更新：nil 表示不修改；Tags 整体替换，AddTags/RemoveTags 增量修改
+type DeviceAnnotationPatch struct {
+	Alias      *string
+	Owner      *string
+	Location   *string
+	Notes      *string
+	Tags       *[]string
+	AddTags    []string
+	RemoveTags []string
+}
+
+// GetDeviceAnnotation 获取设备标注（没有标注时返回空标注）
+func GetDeviceAnnotation(db *sql.DB, deviceID string) (*DeviceAnnotation, error) {
+	a := &DeviceAnnotation{DeviceID: deviceID, Tags: []string{}}
+	if db == nil {
+		return a, fmt.Errorf("数据库未初始化")
+	}
+	var updatedAt sql.NullTime
+	err := db.QueryRow(`
+		SELECT COALESCE(alias, ''), COALESCE(owner, ''), COALESCE(location, ''), COALESCE(notes, ''), updated_at
+		FROM device_annotations
+		WHERE device_id = ?
+	`, deviceID).Scan(&a.Alias, &a.Owner, &a.Location, &a.Notes, &updatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return a, err
+	}
+	a.UpdatedAt = updatedAt.Time
+
+	tags, err := getDeviceTags(db, deviceID)
+	if err != nil {
+		return a, err
+	}
+	a.Tags = tags
+	return a, nil
+}
+
+// UpdateDeviceAnnotation 更新设备标注，返回更新后的结果
+func UpdateDeviceAnnotation(db *sql.DB, deviceID string, patch DeviceAnnotationPatch) (*DeviceAnnotation, error) {
+	if db == nil {
+		return nil, fmt.Errorf("数据库未初始化")
+	}
+	cur, err := GetDeviceAnnotation(db, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range []struct {
+		dst *string
+		v   *string
+	}{
+		{&cur.Alias, patch.Alias},
+		{&cur.Owner, patch.Owner},
+		{&cur.Location, patch.Location},
+		{&cur.Notes, patch.Notes},
+	} {
+		if f.v != nil {
+			*f.dst = strings.TrimSpace(*f.v)
+		}
+	}
+
+	tags := cur.Tags
+	if patch.Tags != nil {
+		tags = *patch.Tags
+	}
+	tags = append(append([]string{}, tags...), patch.AddTags...)
+	remove := map[string]bool{}
+	for _, t := range patch.RemoveTags {
+		remove[strings.ToLower(normalizeTag(t))] = true
+	}
+	cur.Tags = []string{}
+	seen := map[string]bool{}
+	for _, t := range tags {
+		t = normalizeTag(t)
+		key := strings.ToLower(t)
+		if t == "" || seen[key] || remove[key] {
+			continue
+		}
+		seen[key] = true
+		cur.Tags = append(cur.Tags, t)
+	}
+	cur.UpdatedAt = time.Now()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO device_annotations (device_id, alias, owner, location, notes, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET
+			alias = excluded.alias, owner = excluded.owner, location = excluded.location,
+			notes = excluded.notes, updated_at = excluded.updated_at
+	`, deviceID, cur.Alias, cur.Owner, cur.Location, cur.Notes, cur.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM device_tags WHERE device_id = ?`, deviceID); err != nil {
+		return nil, err
+	}
+	for _, t := range cur.Tags {
+		if _, err := tx.Exec(`INSERT INTO device_tags (device_id, tag) VALUES (?, ?)`, deviceID, t); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return cur, nil
+}
+
+// GetAllTags 全部标签及使用次数
+func GetAllTags(db *sql.DB) (map[string]int, error) {
+	if db == nil {
+		return nil, fmt.Errorf("数据库未初始化")
+	}
+	rows, err := db.Query(`SELECT tag, COUNT(*) FROM device_tags GROUP BY tag ORDER BY tag`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]int{}
+	for rows.Next() {
+		var tag string
+		var n int
+		if err := rows.Scan(&tag, &n); err != nil {
+			continue
+		}
+		out[tag] = n
+	}
+	return out, nil
+}
+
+func getDeviceTags(db *sql.DB, deviceID string) ([]string, error) {
+	rows, err := db.Query(`SELECT tag FROM device_tags WHERE device_id = ? ORDER BY tag`, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []string{}
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err == nil {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+// rekeyAnnotations 设备 ID 变化（ip_ -> dev_）时迁移标注和分组成员；新 ID 已有的记录保留新 ID 的，旧 ID 剩下的冲突行一并删除
+func rekeyAnnotations(db *sql.DB, oldID, newID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"device_annotations", "device_tags", "device_group_members"} {
+		if _, err := tx.Exec(`UPDATE OR IGNORE `+table+` SET device_id = ? WHERE device_id = ?`, newID, oldID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE device_id = ?`, oldID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// normalizeTag 标签去首尾空白；逗号用于查询时拼接，替换为空格
+func normalizeTag(t string) string {
+	return strings.Join(strings.Fields(strings.ReplaceAll(t, ",", " ")), " ")
+}
+
+func splitTags(s string) []string {
+	out := []string{}
+	for _, t := range strings.Split(s, ",") {
+		if t != "" {
+			out = append(out, t)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
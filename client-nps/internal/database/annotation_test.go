@@ -0,0 +1,78 @@
+package database
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 设备拿到 MAC 后 ip_ 改为 dev_：标注、标签、分组成员迁到新 ID，新 ID 已有的保留，旧 ID 不留残余
+func TestRekeyAnnotationsOnConflict(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("初始化数据库失败: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	d := &Device{IP: "192.168.1.30", Status: "online", FirstSeen: time.Now(), LastSeen: time.Now()}
+	if err := SaveDevice(db, d); err != nil {
+		t.Fatalf("保存设备失败: %v", err)
+	}
+	oldID, newID := d.ID, DeviceIDFor("02:00:00:00:00:30", d.IP)
+
+	oldAlias, newAlias := "旧名字", "新名字"
+	if _, err := UpdateDeviceAnnotation(db, oldID, DeviceAnnotationPatch{Alias: &oldAlias, AddTags: []string{"lab", "printer"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UpdateDeviceAnnotation(db, newID, DeviceAnnotationPatch{Alias: &newAlias, AddTags: []string{"lab"}}); err != nil {
+		t.Fatal(err)
+	}
+	g := &DeviceGroup{Name: "固定", Kind: GroupKindStatic, Members: []string{oldID, newID}}
+	if err := SaveDeviceGroup(db, g); err != nil {
+		t.Fatal(err)
+	}
+
+	d.MAC = "02:00:00:00:00:30"
+	if err := SaveDevice(db, d); err != nil {
+		t.Fatalf("保存设备失败: %v", err)
+	}
+	if d.ID != newID {
+		t.Fatalf("设备 ID = %s，期望 %s", d.ID, newID)
+	}
+
+	for _, table := range []string{"device_annotations", "device_tags", "device_group_members"} {
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE device_id = ?`, oldID).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Fatalf("%s 仍有 %d 条旧 ID 记录", table, n)
+		}
+	}
+
+	a, err := GetDeviceAnnotation(db, newID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Alias != newAlias {
+		t.Fatalf("别名 = %q，期望保留新 ID 的 %q", a.Alias, newAlias)
+	}
+	if want := []string{"lab", "printer"}; !reflect.DeepEqual(a.Tags, want) {
+		t.Fatalf("标签 = %v，期望 %v", a.Tags, want)
+	}
+	tags, err := GetAllTags(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"lab": 1, "printer": 1}; !reflect.DeepEqual(tags, want) {
+		t.Fatalf("标签统计 = %v，期望 %v", tags, want)
+	}
+	members, err := groupMemberIDs(db, g.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{newID}; !reflect.DeepEqual(members, want) {
+		t.Fatalf("分组成员 = %v，期望 %v", members, want)
+	}
+}
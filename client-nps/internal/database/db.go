@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
@@ -66,50 +67,212 @@ func InitDB(dbPath string) (*sql.DB, error) {
 	return db, nil
 }
 
-// createTables 创建数据库表
-func createTables() error {
-	// 设备表
-	devicesTable := `
-	CREATE TABLE IF NOT EXISTS devices (
-		ip TEXT PRIMARY KEY,
+// 设备相关表结构（%s 为表名，迁移时先建到临时表再改名）
+// 设备以 id 标识（由 MAC 推导，见 DeviceIDFor），ip 只是当前地址，
+// 地址变化记录在 device_addresses 中
+const (
+	devicesSchema = `
+	CREATE TABLE IF NOT EXISTS %s (
+		id TEXT PRIMARY KEY,
 		mac TEXT NOT NULL,
+		ip TEXT NOT NULL,
 		name TEXT,
 		vendor TEXT,
 		model TEXT,
 		type TEXT,
 		os TEXT,
 		extra TEXT,
+		interface TEXT,
+		subnet TEXT,
 		status TEXT DEFAULT 'offline',
 		first_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
 		last_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
 
-	// 设备端口表
-	devicePortsTable := `
-	CREATE TABLE IF NOT EXISTS device_ports (
+	devicePortsSchema = `
+	CREATE TABLE IF NOT EXISTS %s (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		device_ip TEXT NOT NULL,
+		device_id TEXT NOT NULL,
 		port INTEGER NOT NULL,
 		protocol TEXT NOT NULL,
 		service TEXT,
 		version TEXT,
 		status TEXT DEFAULT 'open',
+		banner TEXT,
 		scanned_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (device_ip) REFERENCES devices(ip) ON DELETE CASCADE,
-		UNIQUE(device_ip, port, protocol)
+		FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		UNIQUE(device_id, port, protocol)
 	);`
 
-	// 设备历史表
-	deviceHistoryTable := `
-	CREATE TABLE IF NOT EXISTS device_history (
+	// ip 为记录时设备所用的地址
+	deviceHistorySchema = `
+	CREATE TABLE IF NOT EXISTS %s (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		device_ip TEXT NOT NULL,
+		device_id TEXT NOT NULL,
+		ip TEXT,
 		status TEXT NOT NULL,
 		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (device_ip) REFERENCES devices(ip) ON DELETE CASCADE
+		FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE ON UPDATE CASCADE
+	);`
+
+	// 地址历史：一个设备可有多个地址（IPv4 + IPv6 链路本地/ULA/全局），
+	// 每个地址记录首次/最后一次出现时间，DHCP 换地址后旧地址仍可追溯
+	deviceAddressesSchema = `
+	CREATE TABLE IF NOT EXISTS %s (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device_id TEXT NOT NULL,
+		address TEXT NOT NULL,
+		family TEXT NOT NULL,
+		scope TEXT,
+		interface TEXT,
+		first_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		UNIQUE(device_id, address)
+	);`
+
+	// 用户标注与设备表分开存放：扫描只写 devices，不会覆盖这里的内容。
+	// 不设外键：重置扫描清空设备后，同一 MAC 的设备再次出现时标注仍然有效
+	deviceAnnotationsSchema = `
+	CREATE TABLE IF NOT EXISTS device_annotations (
+		device_id TEXT PRIMARY KEY,
+		alias TEXT,
+		owner TEXT,
+		location TEXT,
+		notes TEXT,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// 分组成员同样不对 devices 设外键（理由同上），分组删除时成员关系级联删除
+	deviceGroupsSchema = `
+	CREATE TABLE IF NOT EXISTS device_groups (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		description TEXT,
+		kind TEXT NOT NULL DEFAULT 'static',
+		query TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	deviceGroupMembersSchema = `
+	CREATE TABLE IF NOT EXISTS device_group_members (
+		group_id INTEGER NOT NULL,
+		device_id TEXT NOT NULL,
+		PRIMARY KEY (group_id, device_id),
+		FOREIGN KEY (group_id) REFERENCES device_groups(id) ON DELETE CASCADE
+	);`
+
+	deviceTagsSchema = `
+	CREATE TABLE IF NOT EXISTS device_tags (
+		device_id TEXT NOT NULL,
+		tag TEXT NOT NULL COLLATE NOCASE,
+		PRIMARY KEY (device_id, tag)
+	);`
+
+	// 工具箱后台任务（ping/traceroute/测速/端口扫描/DNS），params/result 为 JSON
+	jobsSchema = `
+	CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT PRIMARY KEY,
+		kind TEXT NOT NULL,
+		status TEXT NOT NULL,
+		source TEXT,
+		params TEXT,
+		result TEXT,
+		error TEXT,
+		progress INTEGER DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		started_at DATETIME,
+		finished_at DATETIME
+	);`
+
+	// 保存的 MTR 报告，report 为完整报告 JSON
+	mtrReportsSchema = `
+	CREATE TABLE IF NOT EXISTS mtr_reports (
+		id TEXT PRIMARY KEY,
+		target TEXT NOT NULL,
+		ip TEXT,
+		protocol TEXT,
+		cycles INTEGER DEFAULT 0,
+		note TEXT,
+		report TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// 吞吐测速历史：peer（盒子→对端）/ browser（浏览器→本机）/ internet（公网下载测速）
+	speedTestResultsSchema = `
+	CREATE TABLE IF NOT EXISTS speedtest_results (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		mode TEXT NOT NULL,
+		server TEXT,
+		direction TEXT,
+		download_mbps REAL DEFAULT 0,
+		upload_mbps REAL DEFAULT 0,
+		latency_ms REAL DEFAULT 0,
+		download_bytes INTEGER DEFAULT 0,
+		upload_bytes INTEGER DEFAULT 0,
+		streams INTEGER DEFAULT 0,
+		duration REAL DEFAULT 0,
+		source TEXT,
+		client TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// 诊断运行历史：每次 ping/traceroute/测速/端口扫描/DNS 的参数、结果、耗时和触发来源；
+	// metrics 为从结果中提取的数值指标（JSON 对象），用于趋势统计
+	diagnosticsRunsSchema = `
+	CREATE TABLE IF NOT EXISTS diagnostics_runs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		target TEXT,
+		source TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT,
+		job_id TEXT,
+		params TEXT,
+		result TEXT,
+		metrics TEXT,
+		duration REAL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
 
+	// 定时检查定义；params / thresholds 为 JSON，state 为当前状态（unknown/ok/warn/crit）
+	monitorChecksSchema = `
+	CREATE TABLE IF NOT EXISTS monitor_checks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		target TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		interval INTEGER NOT NULL DEFAULT 60,
+		timeout INTEGER NOT NULL DEFAULT 5,
+		params TEXT,
+		thresholds TEXT,
+		state TEXT NOT NULL DEFAULT 'unknown',
+		state_since DATETIME,
+		last_run_at DATETIME,
+		last_message TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// 定时检查结果时间序列
+	monitorCheckResultsSchema = `
+	CREATE TABLE IF NOT EXISTS monitor_check_results (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		check_id INTEGER NOT NULL,
+		state TEXT NOT NULL,
+		latency_ms REAL DEFAULT 0,
+		loss REAL DEFAULT 0,
+		value REAL DEFAULT 0,
+		message TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+)
+
+// createTables 创建数据库表
+func createTables() error {
 	// MQTT日志表
 	mqttLogsTable := `
 	CREATE TABLE IF NOT EXISTS mqtt_logs (
@@ -122,11 +285,54 @@ func createTables() error {
 		status TEXT DEFAULT 'success'
 	);`
 
+	// 扫描差异事件表（新设备/设备消失），不随设备删除而级联，便于回溯
+	scanEventsTable := `
+	CREATE TABLE IF NOT EXISTS scan_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		scan_id TEXT NOT NULL,
+		event TEXT NOT NULL,
+		device_ip TEXT NOT NULL,
+		mac TEXT,
+		name TEXT,
+		vendor TEXT,
+		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// 旧库（devices 以 ip 为主键）先迁移到以设备 ID 为主键的结构
+	legacy, err := tableExists("devices")
+	if err != nil {
+		return err
+	}
+	if legacy {
+		hasID, err := hasColumn("devices", "id")
+		if err != nil {
+			return err
+		}
+		legacy = !hasID
+	}
+	if legacy {
+		if err := migrateDeviceIdentity(); err != nil {
+			return fmt.Errorf("迁移设备表失败: %v", err)
+		}
+	}
+
 	tables := []string{
-		devicesTable,
-		devicePortsTable,
-		deviceHistoryTable,
+		fmt.Sprintf(devicesSchema, "devices"),
+		fmt.Sprintf(devicePortsSchema, "device_ports"),
+		fmt.Sprintf(deviceHistorySchema, "device_history"),
 		mqttLogsTable,
+		scanEventsTable,
+		fmt.Sprintf(deviceAddressesSchema, "device_addresses"),
+		deviceAnnotationsSchema,
+		deviceTagsSchema,
+		deviceGroupsSchema,
+		deviceGroupMembersSchema,
+		jobsSchema,
+		mtrReportsSchema,
+		speedTestResultsSchema,
+		diagnosticsRunsSchema,
+		monitorChecksSchema,
+		monitorCheckResultsSchema,
 	}
 
 	for _, table := range tables {
@@ -136,22 +342,189 @@ func createTables() error {
 	}
 
 	// 轻量迁移：旧库补字段
-	if err := ensureColumn("devices", "model", "TEXT"); err != nil {
+	if err := ensureColumn("scan_events", "device_id", "TEXT"); err != nil {
 		return err
 	}
-	if err := ensureColumn("devices", "extra", "TEXT"); err != nil {
+	if err := ensureColumn("device_ports", "banner", "TEXT"); err != nil {
 		return err
 	}
 
+	indexes := []string{
+		`CREATE INDEX IF NOT EXISTS idx_devices_ip ON devices(ip)`,
+		`CREATE INDEX IF NOT EXISTS idx_devices_mac ON devices(mac)`,
+		`CREATE INDEX IF NOT EXISTS idx_device_history_device ON device_history(device_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_device_addresses_address ON device_addresses(address)`,
+		`CREATE INDEX IF NOT EXISTS idx_device_tags_tag ON device_tags(tag)`,
+		// 设备检索的结构化条件与排序
+		`CREATE INDEX IF NOT EXISTS idx_devices_last_seen ON devices(last_seen)`,
+		`CREATE INDEX IF NOT EXISTS idx_devices_first_seen ON devices(first_seen)`,
+		`CREATE INDEX IF NOT EXISTS idx_devices_status_type ON devices(status, type)`,
+		`CREATE INDEX IF NOT EXISTS idx_device_ports_port ON device_ports(port, protocol)`,
+		`CREATE INDEX IF NOT EXISTS idx_jobs_kind_created ON jobs(kind, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_mtr_reports_created ON mtr_reports(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_speedtest_results_created ON speedtest_results(mode, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_diagnostics_runs_kind_created ON diagnostics_runs(kind, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_monitor_check_results_check ON monitor_check_results(check_id, created_at)`,
+	}
+	for _, idx := range indexes {
+		if _, err := db.Exec(idx); err != nil {
+			return fmt.Errorf("创建索引失败: %v", err)
+		}
+	}
+
+	if err := createSearchIndex(); err != nil {
+		return fmt.Errorf("创建检索索引失败: %v", err)
+	}
+
 	return nil
 }
 
-func ensureColumn(table, col, colType string) error {
-	// 检查是否存在该列
-	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+// migrateDeviceIdentity 把以 ip 为主键的旧表迁移为以设备 ID（MAC）为主键：
+// 同一 MAC 的多条记录合并为一个设备（属性取最近一次，first_seen 取最早），
+// 各条记录的 IP 写入地址历史；端口/历史按新的设备 ID 关联。
+// 外键开关只对单个连接生效，因此整个迁移固定在同一连接上执行。
+func migrateDeviceIdentity() error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// 旧库可能缺少这些列（更早版本）
+	for _, col := range []string{"model", "extra", "interface", "subnet"} {
+		if err := ensureColumn("devices", col, "TEXT"); err != nil {
+			return err
+		}
+	}
+	hasAddresses, err := tableExists("device_addresses")
+	if err != nil {
+		return err
+	}
+	hasEvents, err := tableExists("scan_events")
+	if err != nil {
+		return err
+	}
+	if hasEvents {
+		if err := ensureColumn("scan_events", "device_id", "TEXT"); err != nil {
+			return err
+		}
+	}
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `ALTER TABLE devices ADD COLUMN id TEXT`); err != nil {
+		return err
+	}
+	if err := assignLegacyDeviceIDs(ctx, tx); err != nil {
+		return err
+	}
+
+	stmts := []string{
+		fmt.Sprintf(devicesSchema, "devices_new"),
+		`INSERT INTO devices_new (id, mac, ip, name, vendor, model, type, os, extra, interface, subnet, status, first_seen, last_seen, updated_at)
+		SELECT d.id, d.mac, d.ip, d.name, d.vendor, d.model, d.type, d.os, d.extra, d.interface, d.subnet, d.status,
+			(SELECT MIN(x.first_seen) FROM devices x WHERE x.id = d.id), d.last_seen, d.updated_at
+		FROM devices d
+		WHERE d.rowid = (SELECT x.rowid FROM devices x WHERE x.id = d.id ORDER BY x.last_seen DESC LIMIT 1)`,
+		fmt.Sprintf(devicePortsSchema, "device_ports_new"),
+		`INSERT OR IGNORE INTO device_ports_new (device_id, port, protocol, service, version, status, scanned_at)
+		SELECT d.id, p.port, p.protocol, p.service, p.version, p.status, p.scanned_at
+		FROM device_ports p JOIN devices d ON d.ip = p.device_ip
+		ORDER BY p.scanned_at DESC`,
+		fmt.Sprintf(deviceHistorySchema, "device_history_new"),
+		`INSERT INTO device_history_new (device_id, ip, status, timestamp)
+		SELECT d.id, h.device_ip, h.status, h.timestamp
+		FROM device_history h JOIN devices d ON d.ip = h.device_ip
+		ORDER BY h.timestamp, h.id`,
+		fmt.Sprintf(deviceAddressesSchema, "device_addresses_new"),
+		`INSERT OR IGNORE INTO device_addresses_new (device_id, address, family, interface, first_seen, last_seen)
+		SELECT id, ip, CASE WHEN instr(ip, ':') > 0 THEN 'ipv6' ELSE 'ipv4' END, interface, first_seen, last_seen
+		FROM devices`,
+	}
+	if hasAddresses {
+		stmts = append(stmts,
+			`INSERT OR IGNORE INTO device_addresses_new (device_id, address, family, scope, interface, first_seen, last_seen)
+			SELECT d.id, a.address, a.family, a.scope, a.interface, a.first_seen, a.last_seen
+			FROM device_addresses a JOIN devices d ON d.ip = a.device_ip`,
+			`DROP TABLE device_addresses`,
+		)
+	}
+	if hasEvents {
+		stmts = append(stmts,
+			`UPDATE scan_events SET device_id = (SELECT d.id FROM devices d WHERE d.ip = scan_events.device_ip)`,
+		)
+	}
+	stmts = append(stmts,
+		`DROP TABLE device_ports`,
+		`DROP TABLE device_history`,
+		`DROP TABLE devices`,
+		`ALTER TABLE devices_new RENAME TO devices`,
+		`ALTER TABLE device_ports_new RENAME TO device_ports`,
+		`ALTER TABLE device_history_new RENAME TO device_history`,
+		`ALTER TABLE device_addresses_new RENAME TO device_addresses`,
+	)
+
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%v: %s", err, strings.SplitN(strings.TrimSpace(stmt), "\n", 2)[0])
+		}
+	}
+	return tx.Commit()
+}
+
+// assignLegacyDeviceIDs 用 DeviceIDFor 为旧记录生成设备 ID，保证与运行时完全一致
+// （旧数据的 MAC 可能是小写、带 - 分隔或前后有空白）
+func assignLegacyDeviceIDs(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT rowid, COALESCE(mac, ''), ip FROM devices`)
 	if err != nil {
 		return err
 	}
+	ids := map[int64]string{}
+	for rows.Next() {
+		var rowid int64
+		var mac, ip string
+		if err := rows.Scan(&rowid, &mac, &ip); err != nil {
+			rows.Close()
+			return err
+		}
+		ids[rowid] = DeviceIDFor(mac, ip)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for rowid, id := range ids {
+		if _, err := tx.ExecContext(ctx, `UPDATE devices SET id = ? WHERE rowid = ?`, id, rowid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tableExists(table string) (bool, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func hasColumn(table, col string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
 	defer rows.Close()
 	for rows.Next() {
 		var cid int
@@ -163,9 +536,21 @@ func ensureColumn(table, col, colType string) error {
 			continue
 		}
 		if strings.EqualFold(name, col) {
-			return nil
+			return true, nil
 		}
 	}
+	return false, rows.Err()
+}
+
+func ensureColumn(table, col, colType string) error {
+	// 检查是否存在该列
+	ok, err := hasColumn(table, col)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
 	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, col, colType))
 	if err != nil {
 		return fmt.Errorf("迁移失败: %s.%s: %v", table, col, err)
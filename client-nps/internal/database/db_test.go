@@ -0,0 +1,170 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// 以 ip 为主键的旧版本表结构
+const legacySchema = `
+CREATE TABLE devices (
+	ip TEXT PRIMARY KEY,
+	mac TEXT NOT NULL,
+	name TEXT,
+	vendor TEXT,
+	model TEXT,
+	type TEXT,
+	os TEXT,
+	extra TEXT,
+	status TEXT DEFAULT 'offline',
+	first_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+	last_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE device_ports (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	device_ip TEXT NOT NULL,
+	port INTEGER NOT NULL,
+	protocol TEXT NOT NULL,
+	service TEXT,
+	version TEXT,
+	status TEXT DEFAULT 'open',
+	scanned_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (device_ip) REFERENCES devices(ip) ON DELETE CASCADE,
+	UNIQUE(device_ip, port, protocol)
+);
+CREATE TABLE device_history (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	device_ip TEXT NOT NULL,
+	status TEXT NOT NULL,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (device_ip) REFERENCES devices(ip) ON DELETE CASCADE
+);
+CREATE TABLE mqtt_logs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	direction TEXT NOT NULL,
+	topic TEXT NOT NULL,
+	qos INTEGER DEFAULT 0,
+	payload TEXT,
+	status TEXT DEFAULT 'success'
+);
+INSERT INTO devices (ip, mac, name, type, status, first_seen, last_seen) VALUES
+	('192.168.1.10', 'AA:BB:CC:00:00:01', 'nas-old', 'nas', 'offline', '2024-01-01 08:00:00', '2024-01-02 08:00:00'),
+	('192.168.1.20', 'AA:BB:CC:00:00:01', 'nas', 'nas', 'online', '2024-01-03 08:00:00', '2024-01-05 08:00:00'),
+	('192.168.1.30', '', 'printer', 'printer', 'online', '2024-01-04 08:00:00', '2024-01-05 08:00:00'),
+	('192.168.1.40', ' aa-bb-cc-00-00-01 ', 'nas-wifi', 'nas', 'offline', '2024-01-02 08:00:00', '2024-01-02 09:00:00'),
+	('192.168.1.50', 'ff:ff:ff:ff:ff:ff', 'bogus', 'unknown', 'offline', '2024-01-04 08:00:00', '2024-01-04 09:00:00');
+INSERT INTO device_ports (device_ip, port, protocol, service, scanned_at) VALUES
+	('192.168.1.10', 22, 'tcp', 'ssh', '2024-01-02 08:00:00'),
+	('192.168.1.20', 22, 'tcp', 'ssh', '2024-01-05 08:00:00'),
+	('192.168.1.20', 445, 'tcp', 'smb', '2024-01-05 08:00:00'),
+	('192.168.1.30', 631, 'tcp', 'ipp', '2024-01-05 08:00:00'),
+	('192.168.1.40', 8080, 'tcp', 'http', '2024-01-02 09:00:00');
+INSERT INTO device_history (device_ip, status, timestamp) VALUES
+	('192.168.1.10', 'online', '2024-01-01 08:00:00'),
+	('192.168.1.10', 'offline', '2024-01-02 08:00:00'),
+	('192.168.1.20', 'online', '2024-01-03 08:00:00'),
+	('192.168.1.30', 'online', '2024-01-04 08:00:00');
+`
+
+// 旧库（ip 主键）升级后按 MAC 合并设备，端口/历史/地址都挂到新的设备 ID 上
+func TestMigrateDeviceIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	legacy, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacy.Exec(legacySchema); err != nil {
+		t.Fatalf("创建旧表失败: %v", err)
+	}
+	legacy.Close()
+
+	db, err := InitDB(path)
+	if err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	devices, total, err := GetDevices(db, "", "", 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 {
+		t.Fatalf("迁移后有 %d 个设备，期望 3（同一 MAC 的不同写法合并）", total)
+	}
+	byID := map[string]Device{}
+	for _, d := range devices {
+		byID[d.ID] = d
+	}
+
+	nas, ok := byID["dev_aabbcc000001"]
+	if !ok {
+		t.Fatalf("缺少按 MAC 生成的设备 ID，实际: %v", byID)
+	}
+	if nas.IP != "192.168.1.20" || nas.Name != "nas" || nas.Status != "online" {
+		t.Fatalf("合并后的属性应取最近一次记录: %+v", nas)
+	}
+	if got := nas.FirstSeen.Format("2006-01-02"); got != "2024-01-01" {
+		t.Fatalf("first_seen = %s，期望取最早的 2024-01-01", got)
+	}
+	for _, id := range []string{"ip_192.168.1.30", "ip_192.168.1.50"} {
+		if _, ok := byID[id]; !ok {
+			t.Fatalf("无有效 MAC 的设备应使用 %s，实际: %v", id, byID)
+		}
+	}
+
+	addrs, err := GetDeviceAddresses(db, "dev_aabbcc000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range addrs {
+		got = append(got, a.Address)
+	}
+	sort.Strings(got)
+	if want := []string{"192.168.1.10", "192.168.1.20", "192.168.1.40"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("地址历史 = %v，期望 %v", got, want)
+	}
+
+	ports, err := GetDevicePorts(db, "dev_aabbcc000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 3 {
+		t.Fatalf("合并设备有 %d 个端口，期望 3（22 去重 + 445 + 8080）", len(ports))
+	}
+	if ports, _ := GetDevicePorts(db, "ip_192.168.1.30"); len(ports) != 1 || ports[0].Port != 631 {
+		t.Fatalf("ip_ 设备端口 = %+v，期望 631", ports)
+	}
+
+	var history int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM device_history WHERE device_id = 'dev_aabbcc000001'`).Scan(&history); err != nil {
+		t.Fatal(err)
+	}
+	if history != 3 {
+		t.Fatalf("合并设备有 %d 条历史，期望 3", history)
+	}
+
+	for _, table := range []string{"device_ports", "device_history", "device_addresses"} {
+		var orphans int
+		q := `SELECT COUNT(*) FROM ` + table + ` WHERE device_id NOT IN (SELECT id FROM devices)`
+		if err := db.QueryRow(q).Scan(&orphans); err != nil {
+			t.Fatal(err)
+		}
+		if orphans != 0 {
+			t.Fatalf("%s 有 %d 条孤立记录", table, orphans)
+		}
+	}
+	rows, err := db.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if rows.Next() {
+		t.Fatal("迁移后外键检查不通过")
+	}
+}
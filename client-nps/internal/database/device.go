@@ -3,11 +3,13 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 )
 
 type DeviceActivity struct {
 	Timestamp time.Time `json:"timestamp"`
+	DeviceID  string    `json:"device_id"`
 	IP        string    `json:"ip"`
 	Status    string    `json:"status"`
 	Name      string    `json:"name"`
@@ -15,17 +17,45 @@ type DeviceActivity struct {
 	Model     string    `json:"model"`
 }
 
-// SaveDevice 保存或更新设备
+// DeviceIDFor 由 MAC 生成设备 ID（dev_ + 小写无分隔 MAC）；
+// 没有有效 MAC 时退化为按地址标识（ip_ + IP），之后拿到 MAC 会自动改为 MAC 标识
+func DeviceIDFor(mac, ip string) string {
+	m := strings.ToUpper(strings.TrimSpace(mac))
+	if m == "" || m == "00:00:00:00:00:00" || m == "FF:FF:FF:FF:FF:FF" {
+		return "ip_" + stripZone(ip)
+	}
+	return "dev_" + strings.ToLower(strings.NewReplacer(":", "", "-", "").Replace(m))
+}
+
+// SaveDevice 保存或更新设备（按 MAC 归并为同一设备，IP 变化只更新当前地址）
+// 已存在的设备做合并：保留 first_seen；本次未识别出的 name/model/extra 不覆盖旧值；
+// 历史记录只在新设备或状态变化时写入，避免增量扫描把时间线刷满。
+// 当前地址同时写入地址历史。
 func SaveDevice(db *sql.DB, device *Device) error {
 	if db == nil {
 		return fmt.Errorf("数据库未初始化")
 	}
 	now := time.Now()
-	
+	device.ID = DeviceIDFor(device.MAC, device.IP)
+
+	// 之前只按地址标识的设备，拿到 MAC 后改为 MAC 标识（端口/历史/地址随外键级联，标注无外键需单独改）
+	if legacyID := DeviceIDFor("", device.IP); legacyID != device.ID {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM devices WHERE id = ?", device.ID).Scan(&n); err == nil && n == 0 {
+			if _, err := db.Exec("UPDATE devices SET id = ? WHERE id = ?", device.ID, legacyID); err != nil {
+				return err
+			}
+			if err := rekeyAnnotations(db, legacyID, device.ID); err != nil {
+				return err
+			}
+		}
+	}
+
 	// 检查设备是否存在
-	var exists bool
-	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM devices WHERE ip = ?)", device.IP).Scan(&exists)
-	if err != nil {
+	var prevStatus sql.NullString
+	err := db.QueryRow("SELECT status FROM devices WHERE id = ?", device.ID).Scan(&prevStatus)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
 		return err
 	}
 
@@ -33,32 +63,49 @@ func SaveDevice(db *sql.DB, device *Device) error {
 		// 更新设备
 		_, err = db.Exec(`
 			UPDATE devices 
-			SET mac = ?, name = ?, vendor = ?, model = ?, type = ?, os = ?, extra = ?, status = ?, last_seen = ?, updated_at = ?
-			WHERE ip = ?
-		`, device.MAC, device.Name, device.Vendor, device.Model, device.Type, device.OS, device.Extra, device.Status, now, now, device.IP)
+			SET mac = ?, ip = ?, name = COALESCE(NULLIF(?, ''), name), vendor = ?, model = COALESCE(NULLIF(?, ''), model),
+				type = ?, os = ?, extra = COALESCE(NULLIF(?, ''), extra),
+				interface = COALESCE(NULLIF(?, ''), interface), subnet = COALESCE(NULLIF(?, ''), subnet),
+				status = ?, last_seen = ?, updated_at = ?
+			WHERE id = ?
+		`, device.MAC, device.IP, device.Name, device.Vendor, device.Model, device.Type, device.OS, device.Extra,
+			device.Interface, device.Subnet, device.Status, now, now, device.ID)
 	} else {
 		// 插入新设备
 		_, err = db.Exec(`
-			INSERT INTO devices (ip, mac, name, vendor, model, type, os, extra, status, first_seen, last_seen, updated_at)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`, device.IP, device.MAC, device.Name, device.Vendor, device.Model, device.Type, device.OS, device.Extra, device.Status, now, now, now)
+			INSERT INTO devices (id, mac, ip, name, vendor, model, type, os, extra, interface, subnet, status, first_seen, last_seen, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, device.ID, device.MAC, device.IP, device.Name, device.Vendor, device.Model, device.Type, device.OS, device.Extra,
+			device.Interface, device.Subnet, device.Status, now, now, now)
 	}
 
 	if err != nil {
 		return err
 	}
 
+	if _, err := SaveDeviceAddress(db, &DeviceAddress{
+		DeviceID:  device.ID,
+		Address:   device.IP,
+		Interface: device.Interface,
+	}); err != nil {
+		return err
+	}
+
+	if exists && prevStatus.String == device.Status {
+		return nil
+	}
+
 	// 记录历史
 	_, err = db.Exec(`
-		INSERT INTO device_history (device_ip, status, timestamp)
-		VALUES (?, ?, ?)
-	`, device.IP, device.Status, now)
+		INSERT INTO device_history (device_id, ip, status, timestamp)
+		VALUES (?, ?, ?, ?)
+	`, device.ID, device.IP, device.Status, now)
 
 	return err
 }
 
 // UpdateDeviceStatus 更新设备在线状态，并写入历史
-func UpdateDeviceStatus(db *sql.DB, ip string, status string) error {
+func UpdateDeviceStatus(db *sql.DB, id string, status string) error {
 	if db == nil {
 		return fmt.Errorf("数据库未初始化")
 	}
@@ -66,20 +113,41 @@ func UpdateDeviceStatus(db *sql.DB, ip string, status string) error {
 	_, err := db.Exec(`
 		UPDATE devices
 		SET status = ?, last_seen = ?, updated_at = ?
-		WHERE ip = ?
-	`, status, now, now, ip)
+		WHERE id = ?
+	`, status, now, now, id)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		INSERT INTO device_history (device_id, ip, status, timestamp)
+		SELECT id, ip, ?, ? FROM devices WHERE id = ?
+	`, status, now, id)
+	return err
+}
+
+// MarkDeviceOffline 将设备标记为离线（不刷新 last_seen，保留“最后一次见到”的时间），并写入历史
+func MarkDeviceOffline(db *sql.DB, id string) error {
+	if db == nil {
+		return fmt.Errorf("数据库未初始化")
+	}
+	now := time.Now()
+	_, err := db.Exec(`
+		UPDATE devices
+		SET status = 'offline', updated_at = ?
+		WHERE id = ?
+	`, now, id)
 	if err != nil {
 		return err
 	}
 	_, err = db.Exec(`
-		INSERT INTO device_history (device_ip, status, timestamp)
-		VALUES (?, ?, ?)
-	`, ip, status, now)
+		INSERT INTO device_history (device_id, ip, status, timestamp)
+		SELECT id, ip, 'offline', ? FROM devices WHERE id = ?
+	`, now, id)
 	return err
 }
 
 // TouchDeviceLastSeen 仅刷新 last_seen（用于设备仍在线时的心跳刷新）
-func TouchDeviceLastSeen(db *sql.DB, ip string) error {
+func TouchDeviceLastSeen(db *sql.DB, id string) error {
 	if db == nil {
 		return fmt.Errorf("数据库未初始化")
 	}
@@ -87,22 +155,35 @@ func TouchDeviceLastSeen(db *sql.DB, ip string) error {
 	_, err := db.Exec(`
 		UPDATE devices
 		SET last_seen = ?, updated_at = ?
-		WHERE ip = ?
-	`, now, now, ip)
+		WHERE id = ?
+	`, now, now, id)
 	return err
 }
 
-// GetDevice 获取设备
-func GetDevice(db *sql.DB, ip string) (*Device, error) {
-	device := &Device{}
-	err := db.QueryRow(`
-		SELECT ip, mac, name, vendor, model, type, os, extra, status, first_seen, last_seen
-		FROM devices
-		WHERE ip = ?
-	`, ip).Scan(
-		&device.IP, &device.MAC, &device.Name, &device.Vendor, &device.Model,
-		&device.Type, &device.OS, &device.Extra, &device.Status, &device.FirstSeen, &device.LastSeen,
+// 设备查询统一带上用户标注（标签以逗号拼接，见 normalizeTag）
+const (
+	deviceColumns = `id, ip, mac, COALESCE(name, ''), COALESCE(vendor, ''), COALESCE(model, ''), COALESCE(type, ''), COALESCE(os, ''),
+	COALESCE(extra, ''), COALESCE(interface, ''), COALESCE(subnet, ''), status, first_seen, last_seen,
+	COALESCE(a.alias, ''), COALESCE(a.owner, ''), COALESCE(a.location, ''), COALESCE(a.notes, ''),
+	COALESCE((SELECT group_concat(t.tag, ',') FROM device_tags t WHERE t.device_id = devices.id), '')`
+	deviceFrom = `devices LEFT JOIN device_annotations a ON a.device_id = devices.id`
+)
+
+func scanDevice(row interface{ Scan(...any) error }, device *Device) error {
+	var tags string
+	err := row.Scan(
+		&device.ID, &device.IP, &device.MAC, &device.Name, &device.Vendor, &device.Model,
+		&device.Type, &device.OS, &device.Extra, &device.Interface, &device.Subnet, &device.Status, &device.FirstSeen, &device.LastSeen,
+		&device.Alias, &device.Owner, &device.Location, &device.Notes, &tags,
 	)
+	device.Tags = splitTags(tags)
+	return err
+}
+
+// GetDevice 按设备 ID 获取设备
+func GetDevice(db *sql.DB, id string) (*Device, error) {
+	device := &Device{}
+	err := scanDevice(db.QueryRow(`SELECT `+deviceColumns+` FROM `+deviceFrom+` WHERE id = ?`, id), device)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -114,9 +195,45 @@ func GetDevice(db *sql.DB, ip string) (*Device, error) {
 	return device, nil
 }
 
+// ResolveDevice 按设备 ID、当前 IP 或历史地址查找设备：
+// 多个设备用过同一地址时（DHCP 重新分配），取最近见到的那个
+func ResolveDevice(db *sql.DB, key string) (*Device, error) {
+	if db == nil {
+		return nil, fmt.Errorf("数据库未初始化")
+	}
+	key = strings.TrimSpace(key)
+	if d, err := GetDevice(db, key); d != nil || err != nil {
+		return d, err
+	}
+
+	device := &Device{}
+	err := scanDevice(db.QueryRow(`SELECT `+deviceColumns+` FROM `+deviceFrom+` WHERE ip = ? ORDER BY last_seen DESC LIMIT 1`, key), device)
+	if err == nil {
+		return device, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	var id string
+	err = db.QueryRow(`
+		SELECT device_id FROM device_addresses
+		WHERE address = ?
+		ORDER BY last_seen DESC
+		LIMIT 1
+	`, stripZone(key)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return GetDevice(db, id)
+}
+
 // GetDevices 获取设备列表
 func GetDevices(db *sql.DB, status, deviceType string, limit, offset int) ([]Device, int, error) {
-	query := "SELECT ip, mac, name, vendor, model, type, os, status, first_seen, last_seen FROM devices WHERE 1=1"
+	query := "SELECT " + deviceColumns + " FROM " + deviceFrom + " WHERE 1=1"
 	args := []interface{}{}
 	// 过滤无意义的广播/占位 MAC（避免 UI 出现 192.168.x.255 / FF:FF:FF:FF:FF:FF 等记录）
 	query += " AND mac != ?"
@@ -144,11 +261,7 @@ func GetDevices(db *sql.DB, status, deviceType string, limit, offset int) ([]Dev
 	devices := []Device{}
 	for rows.Next() {
 		var device Device
-		err := rows.Scan(
-			&device.IP, &device.MAC, &device.Name, &device.Vendor, &device.Model,
-			&device.Type, &device.OS, &device.Status, &device.FirstSeen, &device.LastSeen,
-		)
-		if err != nil {
+		if err := scanDevice(rows, &device); err != nil {
 			continue
 		}
 		devices = append(devices, device)
@@ -189,9 +302,9 @@ func GetRecentActivity(db *sql.DB, limit int) ([]DeviceActivity, error) {
 		limit = 200
 	}
 	rows, err := db.Query(`
-		SELECT h.timestamp, h.status, d.ip, d.name, d.vendor, d.model
+		SELECT h.timestamp, h.status, d.id, COALESCE(h.ip, d.ip), COALESCE(d.name, ''), COALESCE(d.vendor, ''), COALESCE(d.model, '')
 		FROM device_history h
-		JOIN devices d ON d.ip = h.device_ip
+		JOIN devices d ON d.id = h.device_id
 		ORDER BY h.timestamp DESC
 		LIMIT ?
 	`, limit)
@@ -202,7 +315,7 @@ func GetRecentActivity(db *sql.DB, limit int) ([]DeviceActivity, error) {
 	out := []DeviceActivity{}
 	for rows.Next() {
 		var a DeviceActivity
-		if err := rows.Scan(&a.Timestamp, &a.Status, &a.IP, &a.Name, &a.Vendor, &a.Model); err != nil {
+		if err := rows.Scan(&a.Timestamp, &a.Status, &a.DeviceID, &a.IP, &a.Name, &a.Vendor, &a.Model); err != nil {
 			continue
 		}
 		out = append(out, a)
@@ -210,42 +323,50 @@ func GetRecentActivity(db *sql.DB, limit int) ([]DeviceActivity, error) {
 	return out, nil
 }
 
-// ClearAllDeviceData 清空本次扫描前的历史设备数据（设备、端口、历史）
-// 需求：每次扫描都丢弃历史数据，避免 UI 混入旧网段/旧结果
+// ClearAllDeviceData 清空历史设备数据（设备、端口、历史）
+// 仅在“重置扫描”（mode=reset）时使用；默认的增量扫描会保留历史
 func ClearAllDeviceData(db *sql.DB) error {
 	if db == nil {
 		return fmt.Errorf("数据库未初始化")
 	}
-	// 顺序：先删子表，再删主表
+	// 顺序：先删子表，再删主表；用户标注保留（设备再次出现时按 ID 关联回来）
 	if _, err := db.Exec(`DELETE FROM device_ports`); err != nil {
 		return err
 	}
 	if _, err := db.Exec(`DELETE FROM device_history`); err != nil {
 		return err
 	}
+	if _, err := db.Exec(`DELETE FROM device_addresses`); err != nil {
+		return err
+	}
 	if _, err := db.Exec(`DELETE FROM devices`); err != nil {
 		return err
 	}
 	return nil
 }
 
-// SaveDevicePort 保存设备端口
-func SaveDevicePort(db *sql.DB, deviceIP string, port *DevicePort) error {
+// SaveDevicePort 保存设备端口；本次没探测到服务/版本/banner 时保留上次的结果
+func SaveDevicePort(db *sql.DB, deviceID string, port *DevicePort) error {
 	_, err := db.Exec(`
-		INSERT OR REPLACE INTO device_ports (device_ip, port, protocol, service, version, status, scanned_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, deviceIP, port.Port, port.Protocol, port.Service, port.Version, port.Status, time.Now())
+		INSERT INTO device_ports (device_id, port, protocol, service, version, status, banner, scanned_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(device_id, port, protocol) DO UPDATE SET
+			service = CASE WHEN excluded.service IN ('', 'unknown') AND service != '' THEN service ELSE excluded.service END,
+			version = COALESCE(NULLIF(excluded.version, ''), version),
+			banner = COALESCE(NULLIF(excluded.banner, ''), banner),
+			status = excluded.status, scanned_at = excluded.scanned_at
+	`, deviceID, port.Port, port.Protocol, port.Service, port.Version, port.Status, port.Banner, time.Now())
 	return err
 }
 
 // GetDevicePorts 获取设备端口列表
-func GetDevicePorts(db *sql.DB, deviceIP string) ([]DevicePort, error) {
+func GetDevicePorts(db *sql.DB, deviceID string) ([]DevicePort, error) {
 	rows, err := db.Query(`
-		SELECT id, device_ip, port, protocol, service, version, status, scanned_at
+		SELECT id, device_id, port, protocol, COALESCE(service, ''), COALESCE(version, ''), status, COALESCE(banner, ''), scanned_at
 		FROM device_ports
-		WHERE device_ip = ?
+		WHERE device_id = ?
 		ORDER BY port
-	`, deviceIP)
+	`, deviceID)
 	if err != nil {
 		return nil, err
 	}
@@ -255,8 +376,8 @@ func GetDevicePorts(db *sql.DB, deviceIP string) ([]DevicePort, error) {
 	for rows.Next() {
 		var port DevicePort
 		err := rows.Scan(
-			&port.ID, &port.DeviceIP, &port.Port, &port.Protocol,
-			&port.Service, &port.Version, &port.Status, &port.ScannedAt,
+			&port.ID, &port.DeviceID, &port.Port, &port.Protocol,
+			&port.Service, &port.Version, &port.Status, &port.Banner, &port.ScannedAt,
 		)
 		if err != nil {
 			continue
@@ -0,0 +1,291 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 诊断运行的触发来源
+const (
+	DiagnosticSourceUI        = "ui"
+	DiagnosticSourceMQTT      = "mqtt"
+	DiagnosticSourceScheduler = "scheduler"
+)
+
+// DiagnosticRun 一次诊断运行（ping/traceroute/speedtest/webspeed/portscan/dns/mtr/group_ping/group_portscan/check）
+type DiagnosticRun struct {
+	ID       int64              `json:"id"`
+	Kind     string             `json:"kind"`
+	Target   string             `json:"target"`
+	Source   string             `json:"source"` // ui / mqtt / scheduler
+	Status   string             `json:"status"` // ok / failed / cancelled
+	Error    string             `json:"error,omitempty"`
+	JobID    string             `json:"job_id,omitempty"`
+	Params   json.RawMessage    `json:"params,omitempty"`
+	Result   json.RawMessage    `json:"result,omitempty"`
+	Metrics  map[string]float64 `json:"metrics,omitempty"`
+	Duration float64            `json:"duration"` // 秒
+	// CreatedAt 开始时间
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DiagnosticFilter 诊断历史过滤条件，零值字段不过滤
+type DiagnosticFilter struct {
+	Kind   string
+	Target string
+	Source string
+	Status string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+	Offset int
+}
+
+// DiagnosticTrendPoint 某一天某个指标的统计
+type DiagnosticTrendPoint struct {
+	Day    string  `json:"day"` // 本地日期 2006-01-02
+	Count  int     `json:"count"`
+	Median float64 `json:"median"`
+	Avg    float64 `json:"avg"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+}
+
+// diagnosticsKeep 保留的诊断运行条数
+const diagnosticsKeep = 20000
+
+// SaveDiagnosticRun 保存一次诊断运行，并清理过旧的记录
+func SaveDiagnosticRun(db *sql.DB, r *DiagnosticRun) error {
+	if db == nil {
+		return fmt.Errorf("数据库未初始化")
+	}
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	var metrics interface{}
+	if len(r.Metrics) > 0 {
+		b, err := json.Marshal(r.Metrics)
+		if err != nil {
+			return err
+		}
+		metrics = string(b)
+	}
+	res, err := db.Exec(`
+		INSERT INTO diagnostics_runs (kind, target, source, status, error, job_id, params, result, metrics, duration, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, r.Kind, r.Target, r.Source, r.Status, r.Error, r.JobID, nullJSON(r.Params), nullJSON(r.Result), metrics, r.Duration, r.CreatedAt)
+	if err != nil {
+		return err
+	}
+	r.ID, _ = res.LastInsertId()
+	// id 自增，按主键范围删除，避免每次写入都全表扫描
+	if r.ID > diagnosticsKeep {
+		_, err = db.Exec(`DELETE FROM diagnostics_runs WHERE id <= ?`, r.ID-diagnosticsKeep)
+	}
+	return err
+}
+
+// GetDiagnosticRun 获取诊断运行详情（含结果，不存在返回 nil）
+func GetDiagnosticRun(db *sql.DB, id int64) (*DiagnosticRun, error) {
+	if db == nil {
+		return nil, fmt.Errorf("数据库未初始化")
+	}
+	var r DiagnosticRun
+	var params, result, metrics string
+	err := db.QueryRow(`
+		SELECT id, kind, COALESCE(target, ''), source, status, COALESCE(error, ''), COALESCE(job_id, ''),
+			COALESCE(params, ''), COALESCE(result, ''), COALESCE(metrics, ''), duration, created_at
+		FROM diagnostics_runs WHERE id = ?`, id).
+		Scan(&r.ID, &r.Kind, &r.Target, &r.Source, &r.Status, &r.Error, &r.JobID,
+			&params, &result, &metrics, &r.Duration, &r.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if params != "" {
+		r.Params = json.RawMessage(params)
+	}
+	if result != "" {
+		r.Result = json.RawMessage(result)
+	}
+	r.Metrics = decodeMetrics(metrics)
+	return &r, nil
+}
+
+// ListDiagnosticRuns 按条件列出诊断运行（不含结果），同时返回符合条件的总数
+func ListDiagnosticRuns(db *sql.DB, f DiagnosticFilter) ([]DiagnosticRun, int, error) {
+	if db == nil {
+		return nil, 0, fmt.Errorf("数据库未初始化")
+	}
+	if f.Limit <= 0 {
+		f.Limit = 50
+	}
+	if f.Limit > 1000 {
+		f.Limit = 1000
+	}
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+	where, args := f.where()
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM diagnostics_runs`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := db.Query(`
+		SELECT id, kind, COALESCE(target, ''), source, status, COALESCE(error, ''), COALESCE(job_id, ''),
+			COALESCE(params, ''), COALESCE(metrics, ''), duration, created_at
+		FROM diagnostics_runs`+where+` ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`,
+		append(args, f.Limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	list := []DiagnosticRun{}
+	for rows.Next() {
+		var r DiagnosticRun
+		var params, metrics string
+		if err := rows.Scan(&r.ID, &r.Kind, &r.Target, &r.Source, &r.Status, &r.Error, &r.JobID,
+			&params, &metrics, &r.Duration, &r.CreatedAt); err != nil {
+			continue
+		}
+		if params != "" {
+			r.Params = json.RawMessage(params)
+		}
+		r.Metrics = decodeMetrics(metrics)
+		list = append(list, r)
+	}
+	return list, total, nil
+}
+
+// DeleteDiagnosticRun 删除一条诊断运行
+func DeleteDiagnosticRun(db *sql.DB, id int64) error {
+	if db == nil {
+		return fmt.Errorf("数据库未初始化")
+	}
+	_, err := db.Exec(`DELETE FROM diagnostics_runs WHERE id = ?`, id)
+	return err
+}
+
+// DeleteDiagnosticRuns 按条件批量删除（忽略 Limit/Offset），返回删除条数
+func DeleteDiagnosticRuns(db *sql.DB, f DiagnosticFilter) (int64, error) {
+	if db == nil {
+		return 0, fmt.Errorf("数据库未初始化")
+	}
+	where, args := f.where()
+	res, err := db.Exec(`DELETE FROM diagnostics_runs`+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DiagnosticTrend 最近 days 天内成功运行的某个指标按天统计（中位数/平均/最小/最大），
+// 例如 kind=speedtest、metric=download_mbps；target 为空不限目标
+func DiagnosticTrend(db *sql.DB, kind, metric, target string, days int) ([]DiagnosticTrendPoint, error) {
+	if db == nil {
+		return nil, fmt.Errorf("数据库未初始化")
+	}
+	if days <= 0 {
+		days = 30
+	}
+	now := time.Now()
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, -(days - 1))
+	f := DiagnosticFilter{Kind: kind, Target: target, Status: "ok", Since: since}
+	where, args := f.where()
+	rows, err := db.Query(`SELECT COALESCE(metrics, ''), created_at FROM diagnostics_runs`+where+` ORDER BY created_at`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byDay := map[string][]float64{}
+	for rows.Next() {
+		var metrics string
+		var at time.Time
+		if err := rows.Scan(&metrics, &at); err != nil {
+			continue
+		}
+		v, ok := decodeMetrics(metrics)[metric]
+		if !ok {
+			continue
+		}
+		day := at.In(now.Location()).Format("2006-01-02")
+		byDay[day] = append(byDay[day], v)
+	}
+
+	points := []DiagnosticTrendPoint{}
+	for day, vals := range byDay {
+		sort.Float64s(vals)
+		p := DiagnosticTrendPoint{Day: day, Count: len(vals), Min: vals[0], Max: vals[len(vals)-1]}
+		sum := 0.0
+		for _, v := range vals {
+			sum += v
+		}
+		p.Avg = roundMetric(sum / float64(len(vals)))
+		if n := len(vals); n%2 == 1 {
+			p.Median = vals[n/2]
+		} else {
+			p.Median = roundMetric((vals[n/2-1] + vals[n/2]) / 2)
+		}
+		points = append(points, p)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Day < points[j].Day })
+	return points, nil
+}
+
+func (f DiagnosticFilter) where() (string, []any) {
+	conds := []string{}
+	args := []any{}
+	if f.Kind != "" {
+		conds = append(conds, "kind = ?")
+		args = append(args, f.Kind)
+	}
+	if f.Target != "" {
+		conds = append(conds, "target = ?")
+		args = append(args, f.Target)
+	}
+	if f.Source != "" {
+		conds = append(conds, "source = ?")
+		args = append(args, f.Source)
+	}
+	if f.Status != "" {
+		conds = append(conds, "status = ?")
+		args = append(args, f.Status)
+	}
+	if !f.Since.IsZero() {
+		conds = append(conds, "created_at >= ?")
+		// created_at 以本地时区文本保存，按同一时区比较
+		args = append(args, f.Since.Local())
+	}
+	if !f.Until.IsZero() {
+		conds = append(conds, "created_at < ?")
+		args = append(args, f.Until.Local())
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+func decodeMetrics(s string) map[string]float64 {
+	if s == "" {
+		return nil
+	}
+	var m map[string]float64
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return nil
+	}
+	return m
+}
+
+func roundMetric(v float64) float64 {
+	return math.Round(v*100) / 100
+}
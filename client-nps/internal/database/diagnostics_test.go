@@ -0,0 +1,84 @@
+package database
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiagnosticTrend(t *testing.T) {
+	type run struct {
+		daysAgo int
+		value   float64
+		status  string // 空为 ok
+		target  string // 空为 a
+		other   bool   // 只有其他指标
+	}
+	now := time.Now()
+	day := func(ago int) string { return now.AddDate(0, 0, -ago).Format("2006-01-02") }
+	tests := []struct {
+		name string
+		runs []run
+		want []DiagnosticTrendPoint
+	}{
+		{
+			name: "奇数个取中间值",
+			runs: []run{{value: 30}, {value: 10}, {value: 20}},
+			want: []DiagnosticTrendPoint{{Day: day(0), Count: 3, Median: 20, Avg: 20, Min: 10, Max: 30}},
+		},
+		{
+			name: "偶数个取中间两值平均",
+			runs: []run{{value: 4}, {value: 1}, {value: 3}, {value: 2}},
+			want: []DiagnosticTrendPoint{{Day: day(0), Count: 4, Median: 2.5, Avg: 2.5, Min: 1, Max: 4}},
+		},
+		{
+			name: "跳过失败、缺指标和其他目标",
+			runs: []run{{value: 10}, {value: 1000, status: "failed"}, {value: 500, other: true}, {value: 700, target: "b"}},
+			want: []DiagnosticTrendPoint{{Day: day(0), Count: 1, Median: 10, Avg: 10, Min: 10, Max: 10}},
+		},
+		{
+			name: "按天分组升序，超出范围的不计",
+			runs: []run{{value: 5}, {daysAgo: 1, value: 3}, {daysAgo: 1, value: 1}, {daysAgo: 10, value: 99}},
+			want: []DiagnosticTrendPoint{
+				{Day: day(1), Count: 2, Median: 2, Avg: 2, Min: 1, Max: 3},
+				{Day: day(0), Count: 1, Median: 5, Avg: 5, Min: 5, Max: 5},
+			},
+		},
+		{name: "没有数据", want: []DiagnosticTrendPoint{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatalf("初始化数据库失败: %v", err)
+			}
+			defer db.Close()
+			for _, r := range tt.runs {
+				d := &DiagnosticRun{Kind: "speedtest", Target: "a", Source: DiagnosticSourceUI, Status: "ok",
+					Metrics: map[string]float64{"download_mbps": r.value}}
+				if r.status != "" {
+					d.Status = r.status
+				}
+				if r.target != "" {
+					d.Target = r.target
+				}
+				if r.other {
+					d.Metrics = map[string]float64{"upload_mbps": r.value}
+				}
+				at := now.AddDate(0, 0, -r.daysAgo)
+				d.CreatedAt = time.Date(at.Year(), at.Month(), at.Day(), 12, 0, 0, 0, now.Location())
+				if err := SaveDiagnosticRun(db, d); err != nil {
+					t.Fatalf("保存诊断记录失败: %v", err)
+				}
+			}
+			got, err := DiagnosticTrend(db, "speedtest", "download_mbps", "a", 7)
+			if err != nil {
+				t.Fatalf("统计趋势失败: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("DiagnosticTrend = %+v\n期望 %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,349 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// 分组类型
+const (
+	GroupKindStatic  = "static"  // 固定成员列表
+	GroupKindDynamic = "dynamic" // 按条件动态匹配
+)
+
+// DeviceGroup 设备分组
+type DeviceGroup struct {
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Kind        string     `json:"kind"`
+	Query       GroupQuery `json:"query"`   // dynamic 分组的条件
+	Members     []string   `json:"members"` // static 分组的设备 ID
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// GroupQuery 动态分组条件：各项之间为“且”，同一项内的多个值为“或”（tags 需全部包含）
+type GroupQuery struct {
+	Types   []string `json:"types,omitempty"`
+	Vendors []string `json:"vendors,omitempty"` // 厂商名包含（不区分大小写）
+	Tags    []string `json:"tags,omitempty"`
+	Subnets []string `json:"subnets,omitempty"` // CIDR，设备当前 IP 落在其中即可
+}
+
+// GroupSummary 分组在线统计
+type GroupSummary struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Total   int    `json:"total"`
+	Online  int    `json:"online"`
+	Offline int    `json:"offline"`
+}
+
+// Validate 校验分组参数
+func (g *DeviceGroup) Validate() error {
+	g.Name = strings.TrimSpace(g.Name)
+	if g.Name == "" {
+		return fmt.Errorf("分组名称不能为空")
+	}
+	switch g.Kind {
+	case "":
+		g.Kind = GroupKindStatic
+	case GroupKindStatic, GroupKindDynamic:
+	default:
+		return fmt.Errorf("不支持的分组类型: %s", g.Kind)
+	}
+	if g.Kind == GroupKindDynamic {
+		q := g.Query
+		if len(q.Types) == 0 && len(q.Vendors) == 0 && len(q.Tags) == 0 && len(q.Subnets) == 0 {
+			return fmt.Errorf("动态分组至少需要一个条件")
+		}
+		for _, s := range q.Subnets {
+			if _, _, err := net.ParseCIDR(strings.TrimSpace(s)); err != nil {
+				return fmt.Errorf("无效的网段: %s", s)
+			}
+		}
+	}
+	return nil
+}
+
+// Matches 设备是否满足动态分组条件
+func (q GroupQuery) Matches(d *Device) bool {
+	if len(q.Types) > 0 && !containsFold(q.Types, d.Type) {
+		return false
+	}
+	if len(q.Vendors) > 0 {
+		ok := false
+		for _, v := range q.Vendors {
+			if v = strings.TrimSpace(v); v != "" && strings.Contains(strings.ToLower(d.Vendor), strings.ToLower(v)) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	for _, t := range q.Tags {
+		if !containsFold(d.Tags, t) {
+			return false
+		}
+	}
+	if len(q.Subnets) > 0 {
+		ip := net.ParseIP(stripZone(d.IP))
+		ok := false
+		for _, s := range q.Subnets {
+			if _, ipnet, err := net.ParseCIDR(strings.TrimSpace(s)); err == nil && ip != nil && ipnet.Contains(ip) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(list []string, v string) bool {
+	for _, item := range list {
+		if strings.EqualFold(strings.TrimSpace(item), strings.TrimSpace(v)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveDeviceGroup 新建（ID 为 0）或更新分组；static 分组同时替换成员列表
+func SaveDeviceGroup(db *sql.DB, g *DeviceGroup) error {
+	if db == nil {
+		return fmt.Errorf("数据库未初始化")
+	}
+	if err := g.Validate(); err != nil {
+		return err
+	}
+	query, err := json.Marshal(g.Query)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if g.ID == 0 {
+		res, err := tx.Exec(`
+			INSERT INTO device_groups (name, description, kind, query, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, g.Name, g.Description, g.Kind, string(query), now, now)
+		if err != nil {
+			return groupNameError(err, g.Name)
+		}
+		if g.ID, err = res.LastInsertId(); err != nil {
+			return err
+		}
+		g.CreatedAt = now
+	} else {
+		res, err := tx.Exec(`
+			UPDATE device_groups SET name = ?, description = ?, kind = ?, query = ?, updated_at = ?
+			WHERE id = ?
+		`, g.Name, g.Description, g.Kind, string(query), now, g.ID)
+		if err != nil {
+			return groupNameError(err, g.Name)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+	}
+	g.UpdatedAt = now
+
+	// 动态分组不保存成员
+	if g.Kind == GroupKindDynamic {
+		g.Members = []string{}
+	}
+	if _, err := tx.Exec(`DELETE FROM device_group_members WHERE group_id = ?`, g.ID); err != nil {
+		return err
+	}
+	members := []string{}
+	seen := map[string]bool{}
+	for _, id := range g.Members {
+		if id = strings.TrimSpace(id); id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		if _, err := tx.Exec(`INSERT INTO device_group_members (group_id, device_id) VALUES (?, ?)`, g.ID, id); err != nil {
+			return err
+		}
+		members = append(members, id)
+	}
+	g.Members = members
+	return tx.Commit()
+}
+
+func groupNameError(err error, name string) error {
+	if strings.Contains(err.Error(), "UNIQUE") {
+		return fmt.Errorf("分组名称已存在: %s", name)
+	}
+	return err
+}
+
+// DeleteDeviceGroup 删除分组（成员关系随外键级联），不存在返回 false
+func DeleteDeviceGroup(db *sql.DB, id int64) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("数据库未初始化")
+	}
+	res, err := db.Exec(`DELETE FROM device_groups WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// GetDeviceGroup 获取分组（不存在返回 nil）
+func GetDeviceGroup(db *sql.DB, id int64) (*DeviceGroup, error) {
+	if db == nil {
+		return nil, fmt.Errorf("数据库未初始化")
+	}
+	groups, err := queryDeviceGroups(db, `WHERE id = ?`, id)
+	if err != nil || len(groups) == 0 {
+		return nil, err
+	}
+	return &groups[0], nil
+}
+
+// GetDeviceGroups 全部分组（按名称排序）
+func GetDeviceGroups(db *sql.DB) ([]DeviceGroup, error) {
+	if db == nil {
+		return nil, fmt.Errorf("数据库未初始化")
+	}
+	return queryDeviceGroups(db, `ORDER BY name`)
+}
+
+func queryDeviceGroups(db *sql.DB, where string, args ...any) ([]DeviceGroup, error) {
+	rows, err := db.Query(`
+		SELECT id, name, COALESCE(description, ''), kind, COALESCE(query, ''), created_at, updated_at
+		FROM device_groups `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	groups := []DeviceGroup{}
+	for rows.Next() {
+		var g DeviceGroup
+		var query string
+		if err := rows.Scan(&g.ID, &g.Name, &g.Description, &g.Kind, &query, &g.CreatedAt, &g.UpdatedAt); err != nil {
+			continue
+		}
+		if query != "" {
+			_ = json.Unmarshal([]byte(query), &g.Query)
+		}
+		groups = append(groups, g)
+	}
+	rows.Close()
+
+	for i := range groups {
+		members, err := groupMemberIDs(db, groups[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		groups[i].Members = members
+	}
+	return groups, nil
+}
+
+func groupMemberIDs(db *sql.DB, groupID int64) ([]string, error) {
+	rows, err := db.Query(`SELECT device_id FROM device_group_members WHERE group_id = ? ORDER BY device_id`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+// GroupDevices 分组当前包含的设备：static 为仍存在的成员，dynamic 为满足条件的设备
+func GroupDevices(db *sql.DB, g *DeviceGroup) ([]Device, error) {
+	if g.Kind == GroupKindStatic {
+		out := []Device{}
+		for _, id := range g.Members {
+			d, err := GetDevice(db, id)
+			if err != nil {
+				return nil, err
+			}
+			if d != nil {
+				out = append(out, *d)
+			}
+		}
+		return out, nil
+	}
+
+	all, _, err := GetDevices(db, "all", "", 5000, 0)
+	if err != nil {
+		return nil, err
+	}
+	out := []Device{}
+	for i := range all {
+		if g.Query.Matches(&all[i]) {
+			out = append(out, all[i])
+		}
+	}
+	return out, nil
+}
+
+// SummarizeGroups 统计全部分组的在线/离线数量（设备表只读一次）
+func SummarizeGroups(db *sql.DB) ([]GroupSummary, error) {
+	groups, err := GetDeviceGroups(db)
+	if err != nil {
+		return nil, err
+	}
+	all, _, err := GetDevices(db, "all", "", 5000, 0)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*Device, len(all))
+	for i := range all {
+		byID[all[i].ID] = &all[i]
+	}
+
+	out := make([]GroupSummary, 0, len(groups))
+	for _, g := range groups {
+		s := GroupSummary{ID: g.ID, Name: g.Name, Kind: g.Kind}
+		count := func(d *Device) {
+			s.Total++
+			if d.Status == "online" {
+				s.Online++
+			} else {
+				s.Offline++
+			}
+		}
+		if g.Kind == GroupKindStatic {
+			for _, id := range g.Members {
+				if d := byID[id]; d != nil {
+					count(d)
+				}
+			}
+		} else {
+			for i := range all {
+				if g.Query.Matches(&all[i]) {
+					count(&all[i])
+				}
+			}
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
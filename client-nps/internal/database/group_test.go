@@ -0,0 +1,97 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGroupQueryMatches(t *testing.T) {
+	d := &Device{IP: "192.168.1.20", Type: "camera", Vendor: "Hangzhou Hikvision", Tags: []string{"lab", "poe"}}
+	v6 := &Device{IP: "fe80::1%eth0", Type: "router"}
+	tests := []struct {
+		name string
+		q    GroupQuery
+		d    *Device
+		want bool
+	}{
+		{"空条件匹配全部", GroupQuery{}, d, true},
+		{"类型不区分大小写", GroupQuery{Types: []string{"Camera"}}, d, true},
+		{"类型不匹配", GroupQuery{Types: []string{"phone", "computer"}}, d, false},
+		{"厂商包含匹配", GroupQuery{Vendors: []string{"hikvision"}}, d, true},
+		{"空白厂商条件不匹配", GroupQuery{Vendors: []string{" "}}, d, false},
+		{"标签需全部包含", GroupQuery{Tags: []string{"lab", "POE"}}, d, true},
+		{"缺少标签", GroupQuery{Tags: []string{"lab", "iot"}}, d, false},
+		{"网段任一命中", GroupQuery{Subnets: []string{"10.0.0.0/8", "192.168.1.0/24"}}, d, true},
+		{"网段不命中", GroupQuery{Subnets: []string{"192.168.2.0/24"}}, d, false},
+		{"带 zone 的 IPv6", GroupQuery{Subnets: []string{"fe80::/10"}}, v6, true},
+		{"条件之间为且", GroupQuery{Types: []string{"camera"}, Subnets: []string{"192.168.2.0/24"}}, d, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Matches(tt.d); got != tt.want {
+				t.Fatalf("Matches(%+v) = %v，期望 %v", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeGroups(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("初始化数据库失败: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	devices := []*Device{
+		{IP: "192.168.1.10", MAC: "02:00:00:00:00:10", Type: "camera", Status: "online"},
+		{IP: "192.168.1.11", MAC: "02:00:00:00:00:11", Type: "camera", Status: "offline"},
+		{IP: "192.168.2.12", MAC: "02:00:00:00:00:12", Type: "phone", Status: "online"},
+	}
+	for _, d := range devices {
+		d.FirstSeen, d.LastSeen = time.Now(), time.Now()
+		if err := SaveDevice(db, d); err != nil {
+			t.Fatalf("保存设备失败: %v", err)
+		}
+	}
+	groups := []*DeviceGroup{
+		{Name: "摄像头", Kind: GroupKindDynamic, Query: GroupQuery{Types: []string{"camera"}}},
+		{Name: "二楼", Kind: GroupKindDynamic, Query: GroupQuery{Subnets: []string{"192.168.2.0/24"}}},
+		{Name: "固定", Kind: GroupKindStatic, Members: []string{devices[1].ID, devices[2].ID, "dev_missing"}},
+		{Name: "空", Kind: GroupKindStatic},
+	}
+	for _, g := range groups {
+		if err := SaveDeviceGroup(db, g); err != nil {
+			t.Fatalf("保存分组失败: %v", err)
+		}
+	}
+
+	got, err := SummarizeGroups(db)
+	if err != nil {
+		t.Fatalf("统计分组失败: %v", err)
+	}
+	byName := map[string]GroupSummary{}
+	for _, s := range got {
+		byName[s.Name] = s
+	}
+	tests := []struct {
+		name                   string
+		total, online, offline int
+	}{
+		{"摄像头", 2, 1, 1},
+		{"二楼", 1, 1, 0},
+		{"固定", 2, 1, 1}, // 不存在的成员不计数
+		{"空", 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, ok := byName[tt.name]
+			if !ok {
+				t.Fatalf("缺少分组 %s", tt.name)
+			}
+			if s.Total != tt.total || s.Online != tt.online || s.Offline != tt.offline {
+				t.Fatalf("得到 %d/%d/%d，期望 %d/%d/%d", s.Total, s.Online, s.Offline, tt.total, tt.online, tt.offline)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// JobRecord 持久化的后台任务记录
+type JobRecord struct {
+	ID         string          `json:"id"`
+	Kind       string          `json:"kind"`
+	Status     string          `json:"status"`
+	Source     string          `json:"source"` // ui / mqtt
+	Params     json.RawMessage `json:"params"`
+	Result     json.RawMessage `json:"result"`
+	Error      string          `json:"error,omitempty"`
+	Progress   int             `json:"progress"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+// SaveJob 新建或更新任务记录
+func SaveJob(db *sql.DB, j *JobRecord) error {
+	if db == nil {
+		return fmt.Errorf("数据库未初始化")
+	}
+	_, err := db.Exec(`
+		INSERT INTO jobs (id, kind, status, source, params, result, error, progress, created_at, started_at, finished_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			status = excluded.status,
+			result = excluded.result,
+			error = excluded.error,
+			progress = excluded.progress,
+			started_at = excluded.started_at,
+			finished_at = excluded.finished_at
+	`, j.ID, j.Kind, j.Status, j.Source, nullJSON(j.Params), nullJSON(j.Result), j.Error, j.Progress,
+		j.CreatedAt, nullTime(j.StartedAt), nullTime(j.FinishedAt))
+	return err
+}
+
+func nullJSON(v json.RawMessage) interface{} {
+	if len(v) == 0 {
+		return nil
+	}
+	return string(v)
+}
+
+func nullTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return *t
+}
+
+// GetJob 获取任务记录（不存在返回 nil）
+func GetJob(db *sql.DB, id string) (*JobRecord, error) {
+	if db == nil {
+		return nil, fmt.Errorf("数据库未初始化")
+	}
+	jobs, err := queryJobs(db, `WHERE id = ?`, id)
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return &jobs[0], nil
+}
+
+// ListJobs 最近的任务记录，kind 为空不限类型
+func ListJobs(db *sql.DB, kind string, limit int) ([]JobRecord, error) {
+	if db == nil {
+		return nil, fmt.Errorf("数据库未初始化")
+	}
+	if limit <= 0 {
+		limit = 50
+	}
+	if kind != "" {
+		return queryJobs(db, `WHERE kind = ? ORDER BY created_at DESC LIMIT ?`, kind, limit)
+	}
+	return queryJobs(db, `ORDER BY created_at DESC LIMIT ?`, limit)
+}
+
+func queryJobs(db *sql.DB, where string, args ...any) ([]JobRecord, error) {
+	rows, err := db.Query(`
+		SELECT id, kind, status, COALESCE(source, ''), COALESCE(params, ''), COALESCE(result, ''),
+			COALESCE(error, ''), progress, created_at, started_at, finished_at
+		FROM jobs `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	jobs := []JobRecord{}
+	for rows.Next() {
+		var j JobRecord
+		var params, result string
+		var started, finished sql.NullTime
+		if err := rows.Scan(&j.ID, &j.Kind, &j.Status, &j.Source, &params, &result,
+			&j.Error, &j.Progress, &j.CreatedAt, &started, &finished); err != nil {
+			continue
+		}
+		if params != "" {
+			j.Params = json.RawMessage(params)
+		}
+		if result != "" {
+			j.Result = json.RawMessage(result)
+		}
+		if started.Valid {
+			j.StartedAt = &started.Time
+		}
+		if finished.Valid {
+			j.FinishedAt = &finished.Time
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// PruneJobs 只保留最近 keep 条任务记录
+func PruneJobs(db *sql.DB, keep int) error {
+	if db == nil {
+		return fmt.Errorf("数据库未初始化")
+	}
+	_, err := db.Exec(`DELETE FROM jobs WHERE id NOT IN (SELECT id FROM jobs ORDER BY created_at DESC LIMIT ?)`, keep)
+	return err
+}
+
+// InterruptJobs 把上次运行遗留的未结束任务标记为失败（进程重启后任务不会恢复）
+func InterruptJobs(db *sql.DB, status, reason string) error {
+	if db == nil {
+		return fmt.Errorf("数据库未初始化")
+	}
+	_, err := db.Exec(`UPDATE jobs SET status = ?, error = ?, finished_at = ? WHERE status IN ('queued', 'running')`,
+		status, reason, time.Now())
+	return err
+}
@@ -2,8 +2,9 @@ package database
 
 import "time"
 
-// Device 设备模型
+// Device 设备模型（以 ID 标识，IP 为当前地址）
 type Device struct {
+	ID        string    `json:"id"`
 	IP        string    `json:"ip"`
 	MAC       string    `json:"mac"`
 	Name      string    `json:"name"`
@@ -12,31 +13,78 @@ type Device struct {
 	Type      string    `json:"type"`
 	OS        string    `json:"os"`
 	Extra     string    `json:"extra"`
+	Interface string    `json:"interface"` // 发现该设备的本机接口
+	Subnet    string    `json:"subnet"`    // 发现该设备的网段（CIDR）
 	Status    string    `json:"status"`
 	FirstSeen time.Time `json:"first_seen"`
 	LastSeen  time.Time `json:"last_seen"`
+
+	// 用户标注（device_annotations/device_tags，扫描不会覆盖）
+	Alias    string   `json:"alias"`
+	Owner    string   `json:"owner"`
+	Location string   `json:"location"`
+	Notes    string   `json:"notes"`
+	Tags     []string `json:"tags"`
+}
+
+// DeviceAnnotation 设备的用户标注：别名/负责人/位置/备注/标签
+type DeviceAnnotation struct {
+	DeviceID  string    `json:"device_id"`
+	Alias     string    `json:"alias"`
+	Owner     string    `json:"owner"`
+	Location  string    `json:"location"`
+	Notes     string    `json:"notes"`
+	Tags      []string  `json:"tags"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // DevicePort 设备端口模型
 type DevicePort struct {
 	ID        int       `json:"id"`
-	DeviceIP  string    `json:"device_ip"`
+	DeviceID  string    `json:"device_id"`
 	Port      int       `json:"port"`
 	Protocol  string    `json:"protocol"`
 	Service   string    `json:"service"`
 	Version   string    `json:"version"`
 	Status    string    `json:"status"`
+	Banner    string    `json:"banner"` // 协议探测拿到的原始 banner / 证书摘要
 	ScannedAt time.Time `json:"scanned_at"`
 }
 
 // DeviceHistory 设备历史模型
 type DeviceHistory struct {
 	ID        int       `json:"id"`
-	DeviceIP  string    `json:"device_ip"`
+	DeviceID  string    `json:"device_id"`
+	IP        string    `json:"ip"` // 记录时的地址
 	Status    string    `json:"status"`
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// DeviceAddress 设备地址模型（同一设备的多个 IPv4/IPv6 地址）
+type DeviceAddress struct {
+	ID        int       `json:"id"`
+	DeviceID  string    `json:"device_id"`
+	Address   string    `json:"address"`
+	Family    string    `json:"family"` // ipv4, ipv6
+	Scope     string    `json:"scope"`  // global, ula, link-local
+	Interface string    `json:"interface"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// ScanEvent 扫描差异事件模型
+type ScanEvent struct {
+	ID        int       `json:"id"`
+	ScanID    string    `json:"scan_id"`
+	Event     string    `json:"event"` // device_new, device_gone, device_ip_changed
+	DeviceID  string    `json:"device_id"`
+	DeviceIP  string    `json:"device_ip"`
+	MAC       string    `json:"mac"`
+	Name      string    `json:"name"`
+	Vendor    string    `json:"vendor"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
 // MQTTLog MQTT日志模型
 type MQTTLog struct {
 	ID        int       `json:"id"`
@@ -0,0 +1,360 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 定时检查类型
+const (
+	CheckTypePing = "ping" // ICMP ping
+	CheckTypeTCP  = "tcp"  // TCP 连接
+	CheckTypeHTTP = "http" // HTTP(S) GET
+	CheckTypeDNS  = "dns"  // DNS 解析
+	CheckTypeTLS  = "tls"  // TLS 证书有效期
+)
+
+// 定时检查状态
+const (
+	CheckStateUnknown = "unknown"
+	CheckStateOK      = "ok"
+	CheckStateWarn    = "warn"
+	CheckStateCrit    = "crit"
+)
+
+// MonitorCheck 定时检查定义及当前状态
+type MonitorCheck struct {
+	ID         int64              `json:"id"`
+	Name       string             `json:"name"`
+	Type       string             `json:"type"`
+	Target     string             `json:"target"` // ping/tcp/tls：主机（tcp/tls 可带 :端口）；http：URL；dns：查询域名
+	Enabled    bool               `json:"enabled"`
+	Interval   int                `json:"interval"` // 秒
+	Timeout    int                `json:"timeout"`  // 秒
+	Params     MonitorCheckParams `json:"params"`
+	Thresholds MonitorThresholds  `json:"thresholds"`
+
+	State       string     `json:"state"`
+	StateSince  *time.Time `json:"state_since,omitempty"`
+	LastRunAt   *time.Time `json:"last_run_at,omitempty"`
+	LastMessage string     `json:"last_message,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// MonitorCheckParams 各类型检查的参数
+type MonitorCheckParams struct {
+	Port         int    `json:"port,omitempty"`          // tcp（target 未带端口时必填）/ tls（默认 443）
+	Count        int    `json:"count,omitempty"`         // ping 包数，默认 3
+	ExpectStatus int    `json:"expect_status,omitempty"` // http 期望状态码，0 表示 2xx/3xx
+	ExpectBody   string `json:"expect_body,omitempty"`   // http 响应体包含该文本（re: 开头按正则）
+	RecordType   string `json:"record_type,omitempty"`   // dns 记录类型，默认 A
+	Server       string `json:"server,omitempty"`        // dns 服务器，为空使用系统 DNS
+	ExpectValue  string `json:"expect_value,omitempty"`  // dns 记录值需包含该文本
+	ServerName   string `json:"server_name,omitempty"`   // tls SNI，默认取 target 主机名
+	Insecure     bool   `json:"insecure,omitempty"`      // tls 不校验证书链（仍检查有效期）
+}
+
+// MonitorThresholds 告警阈值，0 表示不检查该项；超过 warn 为 WARN，超过 crit 为 CRIT
+type MonitorThresholds struct {
+	LatencyWarn float64 `json:"latency_warn,omitempty"` // ms
+	LatencyCrit float64 `json:"latency_crit,omitempty"`
+	LossWarn    float64 `json:"loss_warn,omitempty"` // %，仅 ping
+	LossCrit    float64 `json:"loss_crit,omitempty"`
+	// 证书剩余天数低于该值告警，仅 tls，默认 30 / 7
+	ExpiryWarnDays int `json:"expiry_warn_days,omitempty"`
+	ExpiryCritDays int `json:"expiry_crit_days,omitempty"`
+}
+
+// MonitorCheckResult 一次检查结果（时间序列）
+type MonitorCheckResult struct {
+	ID        int64     `json:"id,omitempty"`
+	CheckID   int64     `json:"check_id"`
+	State     string    `json:"state"`
+	LatencyMs float64   `json:"latency_ms"`
+	Loss      float64   `json:"loss,omitempty"`  // ping 丢包率
+	Value     float64   `json:"value,omitempty"` // http 状态码 / dns 记录数 / tls 证书剩余天数
+	Message   string    `json:"message,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Validate 校验检查定义并补全默认值
+func (c *MonitorCheck) Validate() error {
+	c.Name = strings.TrimSpace(c.Name)
+	c.Type = strings.ToLower(strings.TrimSpace(c.Type))
+	c.Target = strings.TrimSpace(c.Target)
+	if c.Target == "" {
+		return fmt.Errorf("检查目标不能为空")
+	}
+	if c.Name == "" {
+		c.Name = c.Type + " " + c.Target
+	}
+	if c.Interval <= 0 {
+		c.Interval = 60
+	}
+	if c.Interval < 10 || c.Interval > 86400 {
+		return fmt.Errorf("检查间隔范围为 10-86400 秒")
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5
+	}
+	if c.Timeout > 60 {
+		return fmt.Errorf("超时最长 60 秒")
+	}
+	p := &c.Params
+	if p.Port < 0 || p.Port > 65535 {
+		return fmt.Errorf("无效的端口: %d", p.Port)
+	}
+	switch c.Type {
+	case CheckTypePing:
+		if p.Count <= 0 {
+			p.Count = 3
+		}
+		if p.Count > 20 {
+			return fmt.Errorf("ping 包数最多 20")
+		}
+	case CheckTypeTCP:
+		if _, _, err := net.SplitHostPort(c.Target); err != nil && p.Port == 0 {
+			return fmt.Errorf("tcp 检查需要端口（target 写成 host:port 或指定 params.port）")
+		}
+	case CheckTypeHTTP:
+		if p.ExpectStatus != 0 && (p.ExpectStatus < 100 || p.ExpectStatus > 599) {
+			return fmt.Errorf("无效的期望状态码: %d", p.ExpectStatus)
+		}
+		if strings.HasPrefix(p.ExpectBody, "re:") {
+			if _, err := regexp.Compile(strings.TrimPrefix(p.ExpectBody, "re:")); err != nil {
+				return fmt.Errorf("无效的响应体正则: %v", err)
+			}
+		}
+	case CheckTypeDNS:
+		if p.RecordType == "" {
+			p.RecordType = "A"
+		}
+		p.RecordType = strings.ToUpper(p.RecordType)
+	case CheckTypeTLS:
+		if p.Port == 0 {
+			p.Port = 443
+		}
+		t := &c.Thresholds
+		if t.ExpiryWarnDays == 0 {
+			t.ExpiryWarnDays = 30
+		}
+		if t.ExpiryCritDays == 0 {
+			t.ExpiryCritDays = 7
+		}
+	case "":
+		return fmt.Errorf("检查类型不能为空")
+	default:
+		return fmt.Errorf("不支持的检查类型: %s", c.Type)
+	}
+	t := c.Thresholds
+	if t.LatencyWarn < 0 || t.LatencyCrit < 0 || t.LossWarn < 0 || t.LossCrit < 0 || t.ExpiryWarnDays < 0 || t.ExpiryCritDays < 0 {
+		return fmt.Errorf("阈值不能为负数")
+	}
+	return nil
+}
+
+// SaveMonitorCheck 新建（ID 为 0）或更新检查定义（不改变当前状态）
+func SaveMonitorCheck(db *sql.DB, c *MonitorCheck) error {
+	if db == nil {
+		return fmt.Errorf("数据库未初始化")
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	params, err := json.Marshal(c.Params)
+	if err != nil {
+		return err
+	}
+	thresholds, err := json.Marshal(c.Thresholds)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if c.ID == 0 {
+		if c.State == "" {
+			c.State = CheckStateUnknown
+		}
+		res, err := db.Exec(`
+			INSERT INTO monitor_checks (name, type, target, enabled, interval, timeout, params, thresholds, state, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, c.Name, c.Type, c.Target, c.Enabled, c.Interval, c.Timeout, string(params), string(thresholds), c.State, now, now)
+		if err != nil {
+			return err
+		}
+		if c.ID, err = res.LastInsertId(); err != nil {
+			return err
+		}
+		c.CreatedAt = now
+	} else {
+		res, err := db.Exec(`
+			UPDATE monitor_checks SET name = ?, type = ?, target = ?, enabled = ?, interval = ?, timeout = ?,
+				params = ?, thresholds = ?, updated_at = ?
+			WHERE id = ?
+		`, c.Name, c.Type, c.Target, c.Enabled, c.Interval, c.Timeout, string(params), string(thresholds), now, c.ID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+	}
+	c.UpdatedAt = now
+	return nil
+}
+
+// UpdateMonitorCheckState 记录最近一次检查的时间、状态和说明
+func UpdateMonitorCheckState(db *sql.DB, id int64, state string, since *time.Time, lastRun time.Time, message string) error {
+	if db == nil {
+		return fmt.Errorf("数据库未初始化")
+	}
+	_, err := db.Exec(`UPDATE monitor_checks SET state = ?, state_since = ?, last_run_at = ?, last_message = ? WHERE id = ?`,
+		state, nullTime(since), lastRun, message, id)
+	return err
+}
+
+// DeleteMonitorCheck 删除检查及其结果，不存在返回 false
+func DeleteMonitorCheck(db *sql.DB, id int64) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("数据库未初始化")
+	}
+	res, err := db.Exec(`DELETE FROM monitor_checks WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	if _, err := db.Exec(`DELETE FROM monitor_check_results WHERE check_id = ?`, id); err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// GetMonitorCheck 获取检查定义（不存在返回 nil）
+func GetMonitorCheck(db *sql.DB, id int64) (*MonitorCheck, error) {
+	if db == nil {
+		return nil, fmt.Errorf("数据库未初始化")
+	}
+	checks, err := queryMonitorChecks(db, `WHERE id = ?`, id)
+	if err != nil || len(checks) == 0 {
+		return nil, err
+	}
+	return &checks[0], nil
+}
+
+// ListMonitorChecks 全部检查定义（按 ID 排序）
+func ListMonitorChecks(db *sql.DB) ([]MonitorCheck, error) {
+	if db == nil {
+		return nil, fmt.Errorf("数据库未初始化")
+	}
+	return queryMonitorChecks(db, `ORDER BY id`)
+}
+
+func queryMonitorChecks(db *sql.DB, where string, args ...any) ([]MonitorCheck, error) {
+	rows, err := db.Query(`
+		SELECT id, name, type, target, enabled, interval, timeout, COALESCE(params, ''), COALESCE(thresholds, ''),
+			state, state_since, last_run_at, COALESCE(last_message, ''), created_at, updated_at
+		FROM monitor_checks `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	checks := []MonitorCheck{}
+	for rows.Next() {
+		var c MonitorCheck
+		var params, thresholds string
+		var since, lastRun sql.NullTime
+		if err := rows.Scan(&c.ID, &c.Name, &c.Type, &c.Target, &c.Enabled, &c.Interval, &c.Timeout, &params, &thresholds,
+			&c.State, &since, &lastRun, &c.LastMessage, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			continue
+		}
+		if params != "" {
+			_ = json.Unmarshal([]byte(params), &c.Params)
+		}
+		if thresholds != "" {
+			_ = json.Unmarshal([]byte(thresholds), &c.Thresholds)
+		}
+		if since.Valid {
+			c.StateSince = &since.Time
+		}
+		if lastRun.Valid {
+			c.LastRunAt = &lastRun.Time
+		}
+		checks = append(checks, c)
+	}
+	return checks, nil
+}
+
+// SaveMonitorCheckResult 保存一次检查结果
+func SaveMonitorCheckResult(db *sql.DB, r *MonitorCheckResult) error {
+	if db == nil {
+		return fmt.Errorf("数据库未初始化")
+	}
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	res, err := db.Exec(`
+		INSERT INTO monitor_check_results (check_id, state, latency_ms, loss, value, message, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, r.CheckID, r.State, r.LatencyMs, r.Loss, r.Value, r.Message, r.CreatedAt)
+	if err != nil {
+		return err
+	}
+	r.ID, _ = res.LastInsertId()
+	return nil
+}
+
+// ListMonitorCheckResults 检查结果时间序列（按时间升序），since/until 为零值不限
+func ListMonitorCheckResults(db *sql.DB, checkID int64, since, until time.Time, limit int) ([]MonitorCheckResult, error) {
+	if db == nil {
+		return nil, fmt.Errorf("数据库未初始化")
+	}
+	if limit <= 0 {
+		limit = 500
+	}
+	if limit > 10000 {
+		limit = 10000
+	}
+	query := `SELECT id, check_id, state, latency_ms, loss, value, COALESCE(message, ''), created_at
+		FROM monitor_check_results WHERE check_id = ?`
+	args := []any{checkID}
+	if !since.IsZero() {
+		query += ` AND created_at >= ?`
+		args = append(args, since.Local())
+	}
+	if !until.IsZero() {
+		query += ` AND created_at < ?`
+		args = append(args, until.Local())
+	}
+	// 取最近的 limit 条，再按时间升序返回
+	query = `SELECT * FROM (` + query + ` ORDER BY created_at DESC LIMIT ?) ORDER BY created_at`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []MonitorCheckResult{}
+	for rows.Next() {
+		var r MonitorCheckResult
+		if err := rows.Scan(&r.ID, &r.CheckID, &r.State, &r.LatencyMs, &r.Loss, &r.Value, &r.Message, &r.CreatedAt); err != nil {
+			continue
+		}
+		list = append(list, r)
+	}
+	return list, nil
+}
+
+// PruneMonitorCheckResults 删除 before 之前的检查结果
+func PruneMonitorCheckResults(db *sql.DB, before time.Time) error {
+	if db == nil {
+		return fmt.Errorf("数据库未初始化")
+	}
+	_, err := db.Exec(`DELETE FROM monitor_check_results WHERE created_at < ?`, before.Local())
+	return err
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// MtrReportRecord 保存的 MTR 报告
+type MtrReportRecord struct {
+	ID        string          `json:"id"`
+	Target    string          `json:"target"`
+	IP        string          `json:"ip"`
+	Protocol  string          `json:"protocol"`
+	Cycles    int             `json:"cycles"`
+	Note      string          `json:"note,omitempty"`
+	Report    json.RawMessage `json:"report,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// SaveMtrReport 保存 MTR 报告（同一 ID 覆盖）
+func SaveMtrReport(db *sql.DB, r *MtrReportRecord) error {
+	if db == nil {
+		return fmt.Errorf("数据库未初始化")
+	}
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	_, err := db.Exec(`
+		INSERT INTO mtr_reports (id, target, ip, protocol, cycles, note, report, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			cycles = excluded.cycles,
+			note = excluded.note,
+			report = excluded.report
+	`, r.ID, r.Target, r.IP, r.Protocol, r.Cycles, r.Note, string(r.Report), r.CreatedAt)
+	return err
+}
+
+// GetMtrReport 获取 MTR 报告（不存在返回 nil）
+func GetMtrReport(db *sql.DB, id string) (*MtrReportRecord, error) {
+	if db == nil {
+		return nil, fmt.Errorf("数据库未初始化")
+	}
+	var r MtrReportRecord
+	var report string
+	err := db.QueryRow(`
+		SELECT id, target, COALESCE(ip, ''), COALESCE(protocol, ''), cycles, COALESCE(note, ''), report, created_at
+		FROM mtr_reports WHERE id = ?`, id).
+		Scan(&r.ID, &r.Target, &r.IP, &r.Protocol, &r.Cycles, &r.Note, &report, &r.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.Report = json.RawMessage(report)
+	return &r, nil
+}
+
+// ListMtrReports 最近保存的 MTR 报告（不含报告内容），target 为空不限目标
+func ListMtrReports(db *sql.DB, target string, limit int) ([]MtrReportRecord, error) {
+	if db == nil {
+		return nil, fmt.Errorf("数据库未初始化")
+	}
+	if limit <= 0 {
+		limit = 50
+	}
+	query := `SELECT id, target, COALESCE(ip, ''), COALESCE(protocol, ''), cycles, COALESCE(note, ''), created_at FROM mtr_reports`
+	args := []any{}
+	if target != "" {
+		query += ` WHERE target = ?`
+		args = append(args, target)
+	}
+	query += ` ORDER BY created_at DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []MtrReportRecord{}
+	for rows.Next() {
+		var r MtrReportRecord
+		if err := rows.Scan(&r.ID, &r.Target, &r.IP, &r.Protocol, &r.Cycles, &r.Note, &r.CreatedAt); err != nil {
+			continue
+		}
+		list = append(list, r)
+	}
+	return list, nil
+}
+
+// DeleteMtrReport 删除 MTR 报告
+func DeleteMtrReport(db *sql.DB, id string) error {
+	if db == nil {
+		return fmt.Errorf("数据库未初始化")
+	}
+	_, err := db.Exec(`DELETE FROM mtr_reports WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SaveScanEvent 记录一次扫描差异事件（新设备/设备消失）
+func SaveScanEvent(db *sql.DB, ev *ScanEvent) error {
+	if db == nil {
+		return fmt.Errorf("数据库未初始化")
+	}
+	if ev.Timestamp.IsZero() {
+		ev.Timestamp = time.Now()
+	}
+	res, err := db.Exec(`
+		INSERT INTO scan_events (scan_id, event, device_id, device_ip, mac, name, vendor, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, ev.ScanID, ev.Event, ev.DeviceID, ev.DeviceIP, ev.MAC, ev.Name, ev.Vendor, ev.Timestamp)
+	if err != nil {
+		return err
+	}
+	if id, err := res.LastInsertId(); err == nil {
+		ev.ID = int(id)
+	}
+	return nil
+}
+
+// GetScanEvents 获取扫描差异事件（scanID 为空时返回所有扫描的最近事件）
+func GetScanEvents(db *sql.DB, scanID string, limit int) ([]ScanEvent, error) {
+	if db == nil {
+		return nil, fmt.Errorf("数据库未初始化")
+	}
+	if limit <= 0 {
+		limit = 50
+	}
+	if limit > 500 {
+		limit = 500
+	}
+	query := "SELECT id, scan_id, event, COALESCE(device_id, ''), device_ip, mac, name, vendor, timestamp FROM scan_events WHERE 1=1"
+	args := []interface{}{}
+	if scanID != "" {
+		query += " AND scan_id = ?"
+		args = append(args, scanID)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []ScanEvent{}
+	for rows.Next() {
+		var ev ScanEvent
+		var mac, name, vendor sql.NullString
+		if err := rows.Scan(&ev.ID, &ev.ScanID, &ev.Event, &ev.DeviceID, &ev.DeviceIP, &mac, &name, &vendor, &ev.Timestamp); err != nil {
+			continue
+		}
+		ev.MAC = mac.String
+		ev.Name = name.String
+		ev.Vendor = vendor.String
+		out = append(out, ev)
+	}
+	return out, nil
+}
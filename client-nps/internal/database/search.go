@@ -0,0 +1,449 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// 全文索引实现：优先 FTS5（需 sqlite_fts5 编译标签），其次 FTS4（go-sqlite3 默认带），
+// 都不可用时退化为普通表 + LIKE
+const (
+	searchModeFTS5  = "fts5"
+	searchModeFTS4  = "fts4"
+	searchModePlain = "plain"
+)
+
+var (
+	searchMode = searchModePlain
+	// searchMu 串行化索引同步，避免并发搜索重复重建同一设备
+	searchMu sync.Mutex
+)
+
+// device_search 存每个设备的检索文本；写入路径很多（扫描/被动发现/标注/端口），
+// 因此由触发器把变化的设备 ID 记到 device_search_pending，搜索前再统一重建
+const deviceSearchPendingSchema = `
+	CREATE TABLE IF NOT EXISTS device_search_pending (
+		device_id TEXT PRIMARY KEY
+	);`
+
+// 只关心影响检索文本的列：status/last_seen 心跳更新不触发重建。
+// 不用 INSERT OR IGNORE：触发器内的冲突策略会被外层语句（如标注的 UPSERT）覆盖
+var deviceSearchTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS trg_search_devices_ins AFTER INSERT ON devices BEGIN
+		INSERT INTO device_search_pending (device_id) SELECT NEW.id WHERE NOT EXISTS (SELECT 1 FROM device_search_pending WHERE device_id = NEW.id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS trg_search_devices_upd AFTER UPDATE OF id, mac, ip, name, vendor, model, type, os, extra, interface, subnet ON devices BEGIN
+		INSERT INTO device_search_pending (device_id) SELECT OLD.id WHERE NOT EXISTS (SELECT 1 FROM device_search_pending WHERE device_id = OLD.id);
+		INSERT INTO device_search_pending (device_id) SELECT NEW.id WHERE NOT EXISTS (SELECT 1 FROM device_search_pending WHERE device_id = NEW.id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS trg_search_devices_del AFTER DELETE ON devices BEGIN
+		INSERT INTO device_search_pending (device_id) SELECT OLD.id WHERE NOT EXISTS (SELECT 1 FROM device_search_pending WHERE device_id = OLD.id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS trg_search_ports_ins AFTER INSERT ON device_ports BEGIN
+		INSERT INTO device_search_pending (device_id) SELECT NEW.device_id WHERE NOT EXISTS (SELECT 1 FROM device_search_pending WHERE device_id = NEW.device_id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS trg_search_ports_upd AFTER UPDATE OF service, version ON device_ports BEGIN
+		INSERT INTO device_search_pending (device_id) SELECT NEW.device_id WHERE NOT EXISTS (SELECT 1 FROM device_search_pending WHERE device_id = NEW.device_id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS trg_search_ports_del AFTER DELETE ON device_ports BEGIN
+		INSERT INTO device_search_pending (device_id) SELECT OLD.device_id WHERE NOT EXISTS (SELECT 1 FROM device_search_pending WHERE device_id = OLD.device_id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS trg_search_addresses_ins AFTER INSERT ON device_addresses BEGIN
+		INSERT INTO device_search_pending (device_id) SELECT NEW.device_id WHERE NOT EXISTS (SELECT 1 FROM device_search_pending WHERE device_id = NEW.device_id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS trg_search_annotations_ins AFTER INSERT ON device_annotations BEGIN
+		INSERT INTO device_search_pending (device_id) SELECT NEW.device_id WHERE NOT EXISTS (SELECT 1 FROM device_search_pending WHERE device_id = NEW.device_id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS trg_search_annotations_upd AFTER UPDATE ON device_annotations BEGIN
+		INSERT INTO device_search_pending (device_id) SELECT OLD.device_id WHERE NOT EXISTS (SELECT 1 FROM device_search_pending WHERE device_id = OLD.device_id);
+		INSERT INTO device_search_pending (device_id) SELECT NEW.device_id WHERE NOT EXISTS (SELECT 1 FROM device_search_pending WHERE device_id = NEW.device_id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS trg_search_tags_ins AFTER INSERT ON device_tags BEGIN
+		INSERT INTO device_search_pending (device_id) SELECT NEW.device_id WHERE NOT EXISTS (SELECT 1 FROM device_search_pending WHERE device_id = NEW.device_id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS trg_search_tags_del AFTER DELETE ON device_tags BEGIN
+		INSERT INTO device_search_pending (device_id) SELECT OLD.device_id WHERE NOT EXISTS (SELECT 1 FROM device_search_pending WHERE device_id = OLD.device_id);
+	END`,
+}
+
+// createSearchIndex 建立检索表与触发器；检索表新建时把现有设备全部加入待索引
+func createSearchIndex() error {
+	existing := ""
+	if err := db.QueryRow(`SELECT COALESCE(sql, '') FROM sqlite_master WHERE name = 'device_search'`).Scan(&existing); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	lower := strings.ToLower(existing)
+	switch {
+	case strings.Contains(lower, "fts5"):
+		searchMode = searchModeFTS5
+	case strings.Contains(lower, "fts4"):
+		searchMode = searchModeFTS4
+	case existing != "":
+		searchMode = searchModePlain
+	default:
+		candidates := []struct{ mode, stmt string }{
+			{searchModeFTS5, `CREATE VIRTUAL TABLE device_search USING fts5(device_id UNINDEXED, body)`},
+			{searchModeFTS4, `CREATE VIRTUAL TABLE device_search USING fts4(device_id, body, notindexed=device_id)`},
+			{searchModePlain, `CREATE TABLE device_search (device_id TEXT PRIMARY KEY, body TEXT)`},
+		}
+		var lastErr error
+		for _, cand := range candidates {
+			if _, lastErr = db.Exec(cand.stmt); lastErr == nil {
+				searchMode = cand.mode
+				break
+			}
+		}
+		if lastErr != nil {
+			return lastErr
+		}
+	}
+
+	if _, err := db.Exec(deviceSearchPendingSchema); err != nil {
+		return err
+	}
+	for _, stmt := range deviceSearchTriggers {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if existing == "" {
+		if _, err := db.Exec(`INSERT OR IGNORE INTO device_search_pending (device_id) SELECT id FROM devices`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchMode 当前全文检索实现（fts5/fts4/plain）
+func SearchMode() string {
+	return searchMode
+}
+
+// syncDeviceSearch 重建待索引设备的检索文本
+// 先删除待处理记录再重建：重建过程中发生的新写入会重新入队，不会丢失
+func syncDeviceSearch(db *sql.DB) error {
+	searchMu.Lock()
+	defer searchMu.Unlock()
+
+	rows, err := db.Query(`SELECT device_id FROM device_search_pending`)
+	if err != nil {
+		return err
+	}
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if _, err := db.Exec(`DELETE FROM device_search_pending WHERE device_id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := db.Exec(`DELETE FROM device_search WHERE device_id = ?`, id); err != nil {
+			return err
+		}
+		d, err := GetDevice(db, id)
+		if err != nil {
+			return err
+		}
+		if d == nil {
+			continue
+		}
+		if _, err := db.Exec(`INSERT INTO device_search (device_id, body) VALUES (?, ?)`, id, searchBody(db, d)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// searchBody 设备的检索文本：基础属性、MAC（含无分隔写法）、全部地址、端口/服务、标注、extra 中的字符串值
+func searchBody(db *sql.DB, d *Device) string {
+	parts := []string{
+		d.ID, d.IP, d.MAC, compactMACText(d.MAC), d.Name, d.Vendor, d.Model, d.Type, d.OS,
+		d.Interface, d.Subnet, d.Alias, d.Owner, d.Location, d.Notes,
+	}
+	parts = append(parts, d.Tags...)
+	if addrs, err := GetDeviceAddresses(db, d.ID); err == nil {
+		for _, a := range addrs {
+			parts = append(parts, a.Address)
+		}
+	}
+	if ports, err := GetDevicePorts(db, d.ID); err == nil {
+		for _, p := range ports {
+			parts = append(parts, fmt.Sprintf("%d/%s", p.Port, p.Protocol), p.Service, p.Version)
+		}
+	}
+	if d.Extra != "" {
+		var v interface{}
+		if json.Unmarshal([]byte(d.Extra), &v) == nil {
+			parts = appendJSONStrings(parts, v)
+		}
+	}
+
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, strings.ToLower(p))
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func compactMACText(mac string) string {
+	return strings.NewReplacer(":", "", "-", "").Replace(mac)
+}
+
+// appendJSONStrings 收集 JSON 中的字符串/数字值（不含键名，避免 "http_title" 之类的键干扰检索）
+func appendJSONStrings(out []string, v interface{}) []string {
+	switch x := v.(type) {
+	case string:
+		out = append(out, x)
+	case float64:
+		out = append(out, fmt.Sprint(x))
+	case []interface{}:
+		for _, item := range x {
+			out = appendJSONStrings(out, item)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			out = appendJSONStrings(out, x[k])
+		}
+	}
+	return out
+}
+
+// DeviceSearch 设备检索条件（零值表示不限）
+type DeviceSearch struct {
+	Query         string // 全文关键词，空格分隔的多个词需同时命中
+	Status        string // online/offline，all 或空为不限
+	Type          string
+	Vendor        string // 厂商包含匹配
+	Port          int    // 有该开放端口
+	Protocol      string // 配合 Port，tcp/udp
+	Tags          []string
+	CIDR          string // 当前 IP 落在该网段内
+	FirstSeenFrom time.Time
+	FirstSeenTo   time.Time
+	LastSeenFrom  time.Time
+	LastSeenTo    time.Time
+	Sort          string // 见 deviceSortColumns，默认 last_seen
+	Order         string // asc/desc，默认 desc
+	Limit         int
+	Offset        int
+}
+
+// deviceSortColumns 可排序的列（白名单，防止拼接任意 SQL）
+var deviceSortColumns = map[string]string{
+	"id":         "devices.id",
+	"ip":         "devices.ip",
+	"mac":        "devices.mac",
+	"name":       "COALESCE(devices.name, '')",
+	"vendor":     "COALESCE(devices.vendor, '')",
+	"model":      "COALESCE(devices.model, '')",
+	"type":       "COALESCE(devices.type, '')",
+	"os":         "COALESCE(devices.os, '')",
+	"interface":  "COALESCE(devices.interface, '')",
+	"subnet":     "COALESCE(devices.subnet, '')",
+	"status":     "devices.status",
+	"first_seen": "devices.first_seen",
+	"last_seen":  "devices.last_seen",
+	"alias":      "COALESCE(a.alias, '')",
+	"owner":      "COALESCE(a.owner, '')",
+	"location":   "COALESCE(a.location, '')",
+	"open_ports": "(SELECT COUNT(*) FROM device_ports p WHERE p.device_id = devices.id AND p.status = 'open')",
+}
+
+// SearchDevices 按全文关键词和结构化条件检索设备，返回当前页及总数
+func SearchDevices(db *sql.DB, q DeviceSearch) ([]Device, int, error) {
+	if db == nil {
+		return nil, 0, fmt.Errorf("数据库未初始化")
+	}
+	sortExpr, ok := deviceSortColumns[strings.ToLower(strings.TrimSpace(q.Sort))]
+	if q.Sort == "" {
+		sortExpr, ok = deviceSortColumns["last_seen"], true
+	}
+	if !ok {
+		return nil, 0, fmt.Errorf("不支持的排序字段: %s", q.Sort)
+	}
+	order := "DESC"
+	switch strings.ToLower(strings.TrimSpace(q.Order)) {
+	case "", "desc":
+	case "asc":
+		order = "ASC"
+	default:
+		return nil, 0, fmt.Errorf("不支持的排序方向: %s", q.Order)
+	}
+	if q.Limit <= 0 {
+		q.Limit = 20
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+
+	where := []string{"devices.mac != ?"}
+	args := []interface{}{"FF:FF:FF:FF:FF:FF"}
+
+	if terms := strings.Fields(q.Query); len(terms) > 0 {
+		if err := syncDeviceSearch(db); err != nil {
+			return nil, 0, err
+		}
+		for _, t := range terms {
+			cond, arg := searchTermCondition(t)
+			if cond == "" {
+				continue
+			}
+			where = append(where, cond)
+			args = append(args, arg)
+		}
+	}
+	if q.Status != "" && q.Status != "all" {
+		where = append(where, "devices.status = ?")
+		args = append(args, q.Status)
+	}
+	if q.Type != "" {
+		where = append(where, "devices.type = ?")
+		args = append(args, q.Type)
+	}
+	if v := strings.TrimSpace(q.Vendor); v != "" {
+		where = append(where, `devices.vendor LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(v)+"%")
+	}
+	if q.Port > 0 {
+		cond := "EXISTS (SELECT 1 FROM device_ports p WHERE p.device_id = devices.id AND p.port = ? AND p.status = 'open'"
+		args = append(args, q.Port)
+		if q.Protocol != "" {
+			cond += " AND p.protocol = ?"
+			args = append(args, strings.ToLower(q.Protocol))
+		}
+		where = append(where, cond+")")
+	}
+	for _, t := range q.Tags {
+		if t = normalizeTag(t); t != "" {
+			where = append(where, "EXISTS (SELECT 1 FROM device_tags t WHERE t.device_id = devices.id AND t.tag = ?)")
+			args = append(args, t)
+		}
+	}
+	for _, r := range []struct {
+		cond string
+		v    time.Time
+	}{
+		{"devices.first_seen >= ?", q.FirstSeenFrom},
+		{"devices.first_seen <= ?", q.FirstSeenTo},
+		{"devices.last_seen >= ?", q.LastSeenFrom},
+		{"devices.last_seen <= ?", q.LastSeenTo},
+	} {
+		if !r.v.IsZero() {
+			// 时间以本地时区文本存储，参数需同一时区才能按文本比较
+			where = append(where, r.cond)
+			args = append(args, r.v.In(time.Local))
+		}
+	}
+	if c := strings.TrimSpace(q.CIDR); c != "" {
+		ids, err := devicesInCIDR(db, c)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(ids) == 0 {
+			return []Device{}, 0, nil
+		}
+		where = append(where, "devices.id IN ("+strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")+")")
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+
+	cond := " WHERE " + strings.Join(where, " AND ")
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM `+deviceFrom+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`SELECT `+deviceColumns+` FROM `+deviceFrom+cond+
+		` ORDER BY `+sortExpr+` `+order+`, devices.id LIMIT ? OFFSET ?`, append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	devices := []Device{}
+	for rows.Next() {
+		var d Device
+		if err := scanDevice(rows, &d); err != nil {
+			continue
+		}
+		devices = append(devices, d)
+	}
+	return devices, total, nil
+}
+
+// searchTermCondition 单个关键词的过滤条件：
+// 含中日韩等非 ASCII 字符时用 LIKE（分词器不切分 CJK，前缀匹配不够用），其余走全文索引前缀匹配
+func searchTermCondition(term string) (string, interface{}) {
+	term = strings.ToLower(term)
+	hasToken, ascii := false, true
+	for _, r := range term {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			hasToken = true
+		}
+		if r > unicode.MaxASCII {
+			ascii = false
+		}
+	}
+	if !hasToken {
+		return "", nil
+	}
+	if searchMode == searchModePlain || !ascii {
+		return `devices.id IN (SELECT device_id FROM device_search WHERE body LIKE ? ESCAPE '\')`, "%" + escapeLike(term) + "%"
+	}
+
+	// 词内的标点（如 IP 的点、MAC 的冒号）由分词器切成短语，末尾词按前缀匹配
+	phrase := `"` + strings.ReplaceAll(term, `"`, " ") + `"`
+	if searchMode == searchModeFTS5 {
+		phrase += "*"
+	} else {
+		phrase = strings.TrimSuffix(phrase, `"`) + `*"`
+	}
+	return `devices.id IN (SELECT device_id FROM device_search WHERE device_search MATCH ?)`, phrase
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// devicesInCIDR 当前 IP 落在网段内的设备 ID（SQLite 无地址运算，先取 id/ip 在内存中判断）
+func devicesInCIDR(db *sql.DB, cidr string) ([]string, error) {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("无效的网段: %s", cidr)
+	}
+	rows, err := db.Query(`SELECT id, ip FROM devices`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id, ip string
+		if err := rows.Scan(&id, &ip); err != nil {
+			continue
+		}
+		if addr := net.ParseIP(stripZone(ip)); addr != nil && ipnet.Contains(addr) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
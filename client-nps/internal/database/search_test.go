@@ -0,0 +1,50 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSearchTermCondition(t *testing.T) {
+	saved := searchMode
+	t.Cleanup(func() { searchMode = saved })
+
+	const (
+		like  = "LIKE"
+		match = "MATCH"
+	)
+	tests := []struct {
+		name     string
+		mode     string
+		term     string
+		wantKind string // 空表示不产生条件
+		wantArg  interface{}
+	}{
+		{"FTS5 前缀匹配并转小写", searchModeFTS5, "HikVision", match, `"hikvision"*`},
+		{"FTS4 前缀写在引号内", searchModeFTS4, "hikvision", match, `"hikvision*"`},
+		{"IP 按短语匹配", searchModeFTS5, "192.168.1", match, `"192.168.1"*`},
+		{"引号被替换", searchModeFTS5, `a"b`, match, `"a b"*`},
+		{"CJK 走 LIKE", searchModeFTS5, "摄像头", like, "%摄像头%"},
+		{"普通表走 LIKE 并转义", searchModePlain, "50%_off", like, `%50\%\_off%`},
+		{"纯标点忽略", searchModeFTS5, "-:.", "", nil},
+		{"空串忽略", searchModePlain, "", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			searchMode = tt.mode
+			cond, arg := searchTermCondition(tt.term)
+			if tt.wantKind == "" {
+				if cond != "" || arg != nil {
+					t.Fatalf("期望不产生条件，得到 %q %v", cond, arg)
+				}
+				return
+			}
+			if !strings.Contains(cond, tt.wantKind) {
+				t.Fatalf("条件 %q 不含 %s", cond, tt.wantKind)
+			}
+			if arg != tt.wantArg {
+				t.Fatalf("参数 = %v，期望 %v", arg, tt.wantArg)
+			}
+		})
+	}
+}
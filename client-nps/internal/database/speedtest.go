@@ -0,0 +1,96 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SpeedTestRecord 吞吐测速历史记录
+type SpeedTestRecord struct {
+	ID            int64     `json:"id"`
+	Mode          string    `json:"mode"` // peer / browser / internet
+	Server        string    `json:"server"`
+	Direction     string    `json:"direction"`
+	DownloadMbps  float64   `json:"download_mbps"`
+	UploadMbps    float64   `json:"upload_mbps"`
+	LatencyMs     float64   `json:"latency_ms"`
+	DownloadBytes int64     `json:"download_bytes"`
+	UploadBytes   int64     `json:"upload_bytes"`
+	Streams       int       `json:"streams"`
+	Duration      float64   `json:"duration"` // 秒
+	Source        string    `json:"source"`   // ui / mqtt / scheduler / browser
+	Client        string    `json:"client,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// speedTestKeep 保留的测速历史条数
+const speedTestKeep = 1000
+
+// SaveSpeedTestResult 保存测速结果，并清理过旧的记录
+func SaveSpeedTestResult(db *sql.DB, r *SpeedTestRecord) error {
+	if db == nil {
+		return fmt.Errorf("数据库未初始化")
+	}
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	res, err := db.Exec(`
+		INSERT INTO speedtest_results (mode, server, direction, download_mbps, upload_mbps, latency_ms,
+			download_bytes, upload_bytes, streams, duration, source, client, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, r.Mode, r.Server, r.Direction, r.DownloadMbps, r.UploadMbps, r.LatencyMs,
+		r.DownloadBytes, r.UploadBytes, r.Streams, r.Duration, r.Source, r.Client, r.CreatedAt)
+	if err != nil {
+		return err
+	}
+	r.ID, _ = res.LastInsertId()
+	_, err = db.Exec(`DELETE FROM speedtest_results WHERE id NOT IN (SELECT id FROM speedtest_results ORDER BY id DESC LIMIT ?)`, speedTestKeep)
+	return err
+}
+
+// ListSpeedTestResults 最近的测速历史，mode 为空不限类型
+func ListSpeedTestResults(db *sql.DB, mode string, limit int) ([]SpeedTestRecord, error) {
+	if db == nil {
+		return nil, fmt.Errorf("数据库未初始化")
+	}
+	if limit <= 0 {
+		limit = 50
+	}
+	query := `
+		SELECT id, mode, COALESCE(server, ''), COALESCE(direction, ''), download_mbps, upload_mbps, latency_ms,
+			download_bytes, upload_bytes, streams, duration, COALESCE(source, ''), COALESCE(client, ''), created_at
+		FROM speedtest_results`
+	args := []any{}
+	if mode != "" {
+		query += ` WHERE mode = ?`
+		args = append(args, mode)
+	}
+	query += ` ORDER BY created_at DESC, id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []SpeedTestRecord{}
+	for rows.Next() {
+		var r SpeedTestRecord
+		if err := rows.Scan(&r.ID, &r.Mode, &r.Server, &r.Direction, &r.DownloadMbps, &r.UploadMbps, &r.LatencyMs,
+			&r.DownloadBytes, &r.UploadBytes, &r.Streams, &r.Duration, &r.Source, &r.Client, &r.CreatedAt); err != nil {
+			continue
+		}
+		list = append(list, r)
+	}
+	return list, nil
+}
+
+// DeleteSpeedTestResult 删除一条测速历史
+func DeleteSpeedTestResult(db *sql.DB, id int64) error {
+	if db == nil {
+		return fmt.Errorf("数据库未初始化")
+	}
+	_, err := db.Exec(`DELETE FROM speedtest_results WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,253 @@
+package fingerprint
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DHCPMatch DHCP 指纹匹配结果
+type DHCPMatch struct {
+	OS         string `json:"os"`
+	Type       string `json:"type"`
+	Confidence int    `json:"confidence"` // 0-100
+	Option55   string `json:"option55,omitempty"`
+	Option60   string `json:"option60,omitempty"`
+}
+
+// dhcpRule 一条指纹规则：option55 精确匹配、option60 前缀匹配（不区分大小写），"*" 表示任意
+type dhcpRule struct {
+	option55   string
+	option60   string
+	os         string
+	typ        string
+	confidence int
+}
+
+// builtinDHCPFingerprints 内置常见指纹（文件格式同 dhcp_fingerprints.txt）
+// 本地文件中的规则优先于内置规则；现场特有的设备建议追加到本地文件
+const builtinDHCPFingerprints = `
+# option55|option60|os|type|confidence
+1,121,3,6,15,119,252|*|iOS|phone|85
+1,121,3,6,15,119,252,95,44,46|*|macOS|computer|85
+1,121,3,6,15,114,119,252,95,44,46|*|macOS|computer|85
+1,3,6,15,31,33,43,44,46,47,119,121,249,252|MSFT 5.0|Windows|computer|95
+1,15,3,6,44,46,47,31,33,121,249,43,252|MSFT 5.0|Windows|computer|90
+*|MSFT 5.0|Windows|computer|80
+*|MSFT 98|Windows|computer|70
+*|android-dhcp-|Android|phone|90
+1,3,6,15,26,28,51,58,59,43|*|Android|phone|75
+1,3,6,15,26,28,51,58,59,43,114|*|Android|phone|75
+1,28,2,3,15,6,119,12,44,47,26,121,42|*|Linux|computer|60
+1,28,2,121,15,6,12,40,41,42,26,119,3,121,249,33,252,42|*|Linux|computer|60
+*|dhcpcd-|Linux|computer|55
+*|udhcp|Linux (BusyBox)|iot_device|60
+1,3,6,12,15,28,42|*|Linux (BusyBox)|iot_device|55
+1,3,28,6|*|Embedded (lwIP)|iot_device|70
+1,3,28,6,15,44,46,47,31,33,121,43|*|Embedded (ESP-IDF)|iot_device|70
+*|Hewlett-Packard JetDirect|Embedded|printer|90
+*|HP LaserJet|Embedded|printer|85
+*|Canon|Embedded|printer|60
+*|EPSON|Embedded|printer|60
+*|Brother|Embedded|printer|60
+*|HIKVISION|Embedded|camera|85
+*|DAHUA|Embedded|camera|85
+*|axis|Embedded|camera|70
+*|Synology|DSM|nas|85
+*|QNAP|QTS|nas|85
+*|PS4|PlayStation|game_console|80
+*|PS5|PlayStation|game_console|80
+`
+
+type DHCPFingerprintOptions struct {
+	// FilePath 指定本地指纹文件路径（优先）。为空则按默认位置查找 assets/dhcp_fingerprints.txt
+	FilePath string
+}
+
+// DHCPFingerprintDB DHCP option 55/60 指纹库（本地文件 + 内置规则）
+type DHCPFingerprintDB struct {
+	mu      sync.RWMutex
+	loaded  bool
+	rules   []dhcpRule
+	path    string
+	lastErr error
+}
+
+var (
+	defaultDHCPOnce sync.Once
+	defaultDHCP     *DHCPFingerprintDB
+)
+
+func DefaultDHCPFingerprints() *DHCPFingerprintDB {
+	defaultDHCPOnce.Do(func() {
+		defaultDHCP = NewDHCPFingerprintDB(DHCPFingerprintOptions{
+			FilePath: strings.TrimSpace(os.Getenv("NWCT_DHCP_FP_PATH")),
+		})
+	})
+	return defaultDHCP
+}
+
+func NewDHCPFingerprintDB(opts DHCPFingerprintOptions) *DHCPFingerprintDB {
+	d := &DHCPFingerprintDB{path: strings.TrimSpace(opts.FilePath)}
+	if d.path != "" {
+		return d
+	}
+	// 查找顺序与 OUI 库一致：工作目录 assets/ → 仓库 client-nps/assets/ → 可执行文件目录 assets/
+	candidates := []string{
+		filepath.Join("assets", "dhcp_fingerprints.txt"),
+		filepath.Join("client-nps", "assets", "dhcp_fingerprints.txt"),
+	}
+	if exe, err := os.Executable(); err == nil && exe != "" {
+		candidates = append(candidates, filepath.Join(filepath.Dir(exe), "assets", "dhcp_fingerprints.txt"))
+	}
+	for _, p := range candidates {
+		if _, err := os.Stat(p); err == nil {
+			d.path = p
+			break
+		}
+	}
+	return d
+}
+
+func (d *DHCPFingerprintDB) ensureLoaded() {
+	d.mu.RLock()
+	if d.loaded {
+		d.mu.RUnlock()
+		return
+	}
+	d.mu.RUnlock()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.loaded {
+		return
+	}
+
+	var rules []dhcpRule
+	// 本地文件（可选）在前，匹配时同分优先
+	if d.path != "" {
+		if f, err := os.Open(d.path); err == nil {
+			if fileRules, err := parseDHCPFingerprints(f); err == nil {
+				rules = append(rules, fileRules...)
+			} else {
+				d.lastErr = err
+			}
+			f.Close()
+		} else {
+			d.lastErr = err
+		}
+	}
+	builtin, _ := parseDHCPFingerprints(strings.NewReader(builtinDHCPFingerprints))
+	d.rules = append(rules, builtin...)
+	d.loaded = true
+}
+
+// Match 按 option55（逗号分隔的参数列表）和 option60（vendor class）匹配；无匹配返回 nil
+// 同时命中两个字段的规则优先，其次按置信度
+func (d *DHCPFingerprintDB) Match(option55, option60 string) *DHCPMatch {
+	d.ensureLoaded()
+
+	option55 = normalizeParamList(option55)
+	option60 = strings.TrimSpace(option60)
+	if option55 == "" && option60 == "" {
+		return nil
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var best *dhcpRule
+	bestScore := -1
+	for i := range d.rules {
+		r := &d.rules[i]
+		specific := 0
+		if r.option55 != "*" {
+			if r.option55 != option55 {
+				continue
+			}
+			specific++
+		}
+		if r.option60 != "*" {
+			if option60 == "" || !strings.HasPrefix(strings.ToLower(option60), strings.ToLower(r.option60)) {
+				continue
+			}
+			specific++
+		}
+		if specific == 0 {
+			continue
+		}
+		score := specific*1000 + r.confidence
+		if score > bestScore {
+			best, bestScore = r, score
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return &DHCPMatch{
+		OS:         best.os,
+		Type:       best.typ,
+		Confidence: best.confidence,
+		Option55:   option55,
+		Option60:   option60,
+	}
+}
+
+// LastError 返回最近一次加载本地文件失败的原因（可能为空）
+func (d *DHCPFingerprintDB) LastError() error {
+	d.ensureLoaded()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.lastErr
+}
+
+func normalizeParamList(s string) string {
+	parts := strings.Split(strings.TrimSpace(s), ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if n, err := strconv.Atoi(strings.TrimSpace(p)); err == nil {
+			out = append(out, strconv.Itoa(n))
+		}
+	}
+	return strings.Join(out, ",")
+}
+
+func parseDHCPFingerprints(r io.Reader) ([]dhcpRule, error) {
+	// 每行：option55|option60|os|type|confidence，# 开头为注释
+	// 1,121,3,6,15,119,252|*|iOS|phone|85
+	sc := bufio.NewScanner(r)
+	rules := []dhcpRule{}
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Split(line, "|")
+		if len(f) != 5 {
+			continue
+		}
+		for i := range f {
+			f[i] = strings.TrimSpace(f[i])
+		}
+		conf, err := strconv.Atoi(f[4])
+		if err != nil || conf < 0 || conf > 100 {
+			continue
+		}
+		opt55 := f[0]
+		if opt55 != "*" {
+			opt55 = normalizeParamList(opt55)
+		}
+		if opt55 == "" || f[1] == "" || (opt55 == "*" && f[1] == "*") {
+			continue
+		}
+		rules = append(rules, dhcpRule{option55: opt55, option60: f[1], os: f[2], typ: f[3], confidence: conf})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
@@ -0,0 +1,61 @@
+package fingerprint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDHCPFingerprintMatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dhcp_fingerprints.txt")
+	local := `
+# 本地规则：与内置规则同分时优先
+1,121,3,6,15,119,252|*|iPadOS|tablet|85
+1,3,6|*|Custom|iot_device|50
+invalid line
+1,3,6|*|Bad|x|101
+`
+	if err := os.WriteFile(path, []byte(local), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db := NewDHCPFingerprintDB(DHCPFingerprintOptions{FilePath: path})
+
+	tests := []struct {
+		name       string
+		option55   string
+		option60   string
+		wantOS     string
+		wantType   string
+		wantConf   int
+		wantNoMatc bool
+	}{
+		{name: "本地规则优先于同分内置规则", option55: "1,121,3,6,15,119,252", wantOS: "iPadOS", wantType: "tablet", wantConf: 85},
+		{name: "option55 空格规范化", option55: " 1, 3, 6 ", wantOS: "Custom", wantType: "iot_device", wantConf: 50},
+		{name: "option55 + option60 同时命中优先", option55: "1,3,6,15,31,33,43,44,46,47,119,121,249,252", option60: "MSFT 5.0", wantOS: "Windows", wantType: "computer", wantConf: 95},
+		{name: "只命中 option60", option55: "1,2,3", option60: "MSFT 5.0", wantOS: "Windows", wantType: "computer", wantConf: 80},
+		{name: "option60 前缀不区分大小写", option60: "Android-DHCP-13", wantOS: "Android", wantType: "phone", wantConf: 90},
+		{name: "option60 按前缀而非包含", option60: "my-android-dhcp-13", wantNoMatc: true},
+		{name: "option55 需精确匹配", option55: "1,121,3,6,15,119", wantNoMatc: true},
+		{name: "两者都为空", wantNoMatc: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := db.Match(tt.option55, tt.option60)
+			if tt.wantNoMatc {
+				if m != nil {
+					t.Fatalf("期望无匹配，得到 %+v", m)
+				}
+				return
+			}
+			if m == nil {
+				t.Fatalf("期望匹配 %s，得到 nil", tt.wantOS)
+			}
+			if m.OS != tt.wantOS || m.Type != tt.wantType || m.Confidence != tt.wantConf {
+				t.Fatalf("得到 %s/%s/%d，期望 %s/%s/%d", m.OS, m.Type, m.Confidence, tt.wantOS, tt.wantType, tt.wantConf)
+			}
+		})
+	}
+	if err := db.LastError(); err != nil {
+		t.Fatalf("加载本地文件失败: %v", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"regexp"
 	"strings"
@@ -32,16 +33,20 @@ func ProbeHTTPFingerprint(ctx context.Context, host string, https bool) (*HTTPFi
 	if https {
 		scheme = "https"
 	}
-	url := fmt.Sprintf("%s://%s/", scheme, strings.TrimSpace(host))
-	if strings.TrimSpace(host) == "" {
+	host = strings.TrimSpace(host)
+	if host == "" {
 		return nil, fmt.Errorf("host 为空")
 	}
+	url := fingerprintURL(scheme, host)
 
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // 仅用于指纹；不做安全校验
 	}
 	cli := &http.Client{Transport: tr, Timeout: 1500 * time.Millisecond}
-	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Set("User-Agent", "nwct-fingerprint/1.0")
 	resp, err := cli.Do(req)
 	if err != nil {
@@ -73,4 +78,19 @@ func ProbeHTTPFingerprint(ctx context.Context, host string, https bool) (*HTTPFi
 	return fp, nil
 }
 
-
+// fingerprintURL 由 host[:port] 生成请求地址（host 可为 net.JoinHostPort 的结果或裸 IPv6）；
+// IPv6 地址加方括号，链路本地 zone 的 % 需转义
+func fingerprintURL(scheme, host string) string {
+	h, port, err := net.SplitHostPort(host)
+	if err != nil {
+		h, port = strings.Trim(host, "[]"), ""
+	}
+	h = strings.Replace(h, "%", "%25", 1)
+	if strings.Contains(h, ":") {
+		h = "[" + h + "]"
+	}
+	if port != "" {
+		h += ":" + port
+	}
+	return fmt.Sprintf("%s://%s/", scheme, h)
+}
@@ -0,0 +1,23 @@
+package fingerprint
+
+import "testing"
+
+func TestFingerprintURL(t *testing.T) {
+	tests := []struct {
+		scheme, host, want string
+	}{
+		{"http", "192.168.1.1:80", "http://192.168.1.1:80/"},
+		{"https", "192.168.1.1:443", "https://192.168.1.1:443/"},
+		{"http", "192.168.1.1", "http://192.168.1.1/"},
+		{"http", "[fe80::1%eth0]:80", "http://[fe80::1%25eth0]:80/"},
+		{"http", "[2001:db8::1]:8080", "http://[2001:db8::1]:8080/"},
+		{"http", "2001:db8::1", "http://[2001:db8::1]/"},
+		{"http", "fe80::1%eth0", "http://[fe80::1%25eth0]/"},
+		{"http", "router.lan:8080", "http://router.lan:8080/"},
+	}
+	for _, tt := range tests {
+		if got := fingerprintURL(tt.scheme, tt.host); got != tt.want {
+			t.Errorf("fingerprintURL(%q, %q) = %q, want %q", tt.scheme, tt.host, got, tt.want)
+		}
+	}
+}
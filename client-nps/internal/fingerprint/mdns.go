@@ -15,16 +15,29 @@ func MDNSReverseLookup(ip string, timeout time.Duration) (string, error) {
 	if timeout <= 0 {
 		timeout = 400 * time.Millisecond
 	}
-	parsed := net.ParseIP(strings.TrimSpace(ip))
+	ip = strings.TrimSpace(ip)
+	if i := strings.IndexByte(ip, '%'); i >= 0 {
+		ip = ip[:i] // 链路本地地址带 zone
+	}
+	parsed := net.ParseIP(ip)
 	if parsed == nil {
 		return "", fmt.Errorf("invalid ip")
 	}
-	v4 := parsed.To4()
-	if v4 == nil {
-		return "", fmt.Errorf("only ipv4 supported")
-	}
 
-	qname := fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", v4[3], v4[2], v4[1], v4[0])
+	// 反向查询名：IPv4 用 in-addr.arpa，IPv6 用 ip6.arpa（nibble 逆序）
+	// 查询统一走 IPv4 组播 224.0.0.251，响应方会按自身记录回答 ip6.arpa
+	var qname string
+	if v4 := parsed.To4(); v4 != nil {
+		qname = fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", v4[3], v4[2], v4[1], v4[0])
+	} else {
+		var sb strings.Builder
+		v6 := parsed.To16()
+		for i := len(v6) - 1; i >= 0; i-- {
+			fmt.Fprintf(&sb, "%x.%x.", v6[i]&0x0f, v6[i]>>4)
+		}
+		sb.WriteString("ip6.arpa.")
+		qname = sb.String()
+	}
 	name, err := dnsmessage.NewName(qname)
 	if err != nil {
 		return "", err
@@ -0,0 +1,388 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"nwct/client-nps/internal/database"
+	"nwct/client-nps/internal/logger"
+	"nwct/client-nps/internal/realtime"
+)
+
+// 任务状态
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+const (
+	// 同时运行的任务上限，超出的任务排队
+	maxRunning = 4
+	// 排队 + 运行中的任务上限，超出直接拒绝
+	maxPending = 32
+	// 数据库保留的任务记录数
+	keepRecords = 200
+	// 已结束任务在内存中保留的时长（之后只能从数据库读取）
+	finishedTTL = 10 * time.Minute
+	// 运行中任务保留的增量输出条数
+	maxOutput = 500
+	// 只有进度变化（无输出）的 job_progress 推送间隔
+	progressInterval = 500 * time.Millisecond
+)
+
+// Func 任务执行函数：通过 report 上报进度（0-100，<0 表示不变）和增量输出（nil 表示无），
+// ctx 取消后应尽快返回
+type Func func(ctx context.Context, report func(progress int, output interface{})) (interface{}, error)
+
+// Job 后台任务
+type Job struct {
+	ID         string        `json:"id"`
+	Kind       string        `json:"kind"`
+	Status     string        `json:"status"`
+	Source     string        `json:"source"`
+	Params     interface{}   `json:"params"`
+	Progress   int           `json:"progress"`
+	Output     []interface{} `json:"output,omitempty"` // 运行中的增量输出，结束后以 result 为准
+	Result     interface{}   `json:"result"`
+	Error      string        `json:"error,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+	StartedAt  *time.Time    `json:"started_at,omitempty"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+
+	cancel       context.CancelFunc
+	lastProgress time.Time
+}
+
+// Finished 任务是否已结束
+func (j *Job) Finished() bool {
+	return j.Status == StatusCompleted || j.Status == StatusFailed || j.Status == StatusCancelled
+}
+
+// Manager 后台任务管理：内存中保存运行中和最近结束的任务，结束的任务写入数据库
+type Manager struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+	sem  chan struct{}
+	db   *sql.DB
+}
+
+var (
+	defaultManager *Manager
+	defaultOnce    sync.Once
+)
+
+// Default 全局任务管理器；首次调用时把上次进程遗留的未结束任务标记为失败
+func Default() *Manager {
+	defaultOnce.Do(func() {
+		defaultManager = newManager(database.GetDB())
+		if defaultManager.db != nil {
+			if err := database.InterruptJobs(defaultManager.db, StatusFailed, "服务重启，任务已中断"); err != nil {
+				logger.Warn("清理遗留任务失败: %v", err)
+			}
+		}
+	})
+	return defaultManager
+}
+
+// newManager 创建任务管理器，db 为 nil 时只在内存中保存任务
+func newManager(db *sql.DB) *Manager {
+	return &Manager{
+		jobs: make(map[string]*Job),
+		sem:  make(chan struct{}, maxRunning),
+		db:   db,
+	}
+}
+
+// Submit 提交任务，立即返回任务快照；任务在后台排队执行
+func (m *Manager) Submit(kind, source string, params interface{}, fn Func) (*Job, error) {
+	m.mu.Lock()
+	pending := 0
+	for _, j := range m.jobs {
+		if !j.Finished() {
+			pending++
+		}
+	}
+	if pending >= maxPending {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("待执行的任务过多（上限 %d），请稍后再试", maxPending)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:        newJobID(),
+		Kind:      kind,
+		Status:    StatusQueued,
+		Source:    source,
+		Params:    params,
+		CreatedAt: time.Now(),
+		cancel:    cancel,
+	}
+	m.jobs[job.ID] = job
+	m.gcLocked()
+	snapshot := job.snapshot()
+	m.mu.Unlock()
+
+	m.persist(snapshot)
+	m.broadcast(snapshot, nil)
+	go m.run(ctx, job, fn)
+	return snapshot, nil
+}
+
+func (m *Manager) run(ctx context.Context, job *Job, fn Func) {
+	defer job.cancel()
+
+	select {
+	case m.sem <- struct{}{}:
+		defer func() { <-m.sem }()
+	case <-ctx.Done():
+		m.finish(ctx, job, nil, ctx.Err())
+		return
+	}
+
+	m.mu.Lock()
+	now := time.Now()
+	job.Status = StatusRunning
+	job.StartedAt = &now
+	snapshot := job.snapshot()
+	m.mu.Unlock()
+	m.persist(snapshot)
+	m.broadcast(snapshot, nil)
+
+	report := func(progress int, output interface{}) {
+		m.mu.Lock()
+		if progress >= 0 {
+			if progress > 100 {
+				progress = 100
+			}
+			job.Progress = progress
+		}
+		if output != nil {
+			job.Output = append(job.Output, output)
+			if len(job.Output) > maxOutput {
+				job.Output = job.Output[len(job.Output)-maxOutput:]
+			}
+		} else if time.Since(job.lastProgress) < progressInterval {
+			m.mu.Unlock()
+			return
+		}
+		job.lastProgress = time.Now()
+		snapshot := &Job{ID: job.ID, Kind: job.Kind, Status: job.Status, Progress: job.Progress}
+		m.mu.Unlock()
+		m.broadcast(snapshot, output)
+	}
+
+	var (
+		result interface{}
+		err    error
+	)
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("任务异常: %v", r)
+			}
+		}()
+		result, err = fn(context.WithValue(ctx, jobIDKey{}, job.ID), report)
+	}()
+	m.finish(ctx, job, result, err)
+}
+
+func (m *Manager) finish(ctx context.Context, job *Job, result interface{}, err error) {
+	m.mu.Lock()
+	now := time.Now()
+	job.FinishedAt = &now
+	job.Result = result
+	job.Output = nil
+	switch {
+	case ctx.Err() != nil:
+		job.Status = StatusCancelled
+	case err != nil:
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	default:
+		job.Status = StatusCompleted
+		job.Progress = 100
+	}
+	snapshot := job.snapshot()
+	m.mu.Unlock()
+
+	m.persist(snapshot)
+	m.broadcast(snapshot, nil)
+}
+
+// broadcast 推送 job_progress；状态变化、增量输出都走同一个事件
+func (m *Manager) broadcast(j *Job, output interface{}) {
+	data := map[string]interface{}{
+		"job_id":   j.ID,
+		"kind":     j.Kind,
+		"status":   j.Status,
+		"progress": j.Progress,
+	}
+	if output != nil {
+		data["output"] = output
+	}
+	if j.Error != "" {
+		data["error"] = j.Error
+	}
+	realtime.Default().Broadcast("job_progress", data)
+}
+
+func (m *Manager) persist(j *Job) {
+	if m.db == nil {
+		return
+	}
+	rec := &database.JobRecord{
+		ID:         j.ID,
+		Kind:       j.Kind,
+		Status:     j.Status,
+		Source:     j.Source,
+		Error:      j.Error,
+		Progress:   j.Progress,
+		CreatedAt:  j.CreatedAt,
+		StartedAt:  j.StartedAt,
+		FinishedAt: j.FinishedAt,
+	}
+	if j.Params != nil {
+		rec.Params, _ = json.Marshal(j.Params)
+	}
+	if j.Result != nil {
+		rec.Result, _ = json.Marshal(j.Result)
+	}
+	if err := database.SaveJob(m.db, rec); err != nil {
+		logger.Warn("保存任务 %s 失败: %v", j.ID, err)
+		return
+	}
+	if j.Finished() {
+		if err := database.PruneJobs(m.db, keepRecords); err != nil {
+			logger.Warn("清理任务记录失败: %v", err)
+		}
+	}
+}
+
+// Get 任务详情：内存中没有时从数据库读取，不存在返回 nil
+func (m *Manager) Get(id string) (*Job, error) {
+	m.mu.Lock()
+	if job, ok := m.jobs[id]; ok {
+		s := job.snapshot()
+		m.mu.Unlock()
+		return s, nil
+	}
+	m.mu.Unlock()
+	if m.db == nil {
+		return nil, nil
+	}
+	rec, err := database.GetJob(m.db, id)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+	return fromRecord(rec), nil
+}
+
+// List 最近的任务（不含结果和增量输出），kind 为空不限类型
+func (m *Manager) List(kind string, limit int) ([]Job, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	byID := map[string]Job{}
+	if m.db != nil {
+		recs, err := database.ListJobs(m.db, kind, limit)
+		if err != nil {
+			return nil, err
+		}
+		for i := range recs {
+			byID[recs[i].ID] = *fromRecord(&recs[i])
+		}
+	}
+	// 内存中的状态比数据库新（进度只在内存中更新）
+	m.mu.Lock()
+	for _, job := range m.jobs {
+		if kind == "" || job.Kind == kind {
+			byID[job.ID] = *job.snapshot()
+		}
+	}
+	m.mu.Unlock()
+
+	list := make([]Job, 0, len(byID))
+	for _, j := range byID {
+		j.Result = nil
+		j.Output = nil
+		list = append(list, j)
+	}
+	sort.Slice(list, func(i, k int) bool { return list[i].CreatedAt.After(list[k].CreatedAt) })
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+// Cancel 取消排队或运行中的任务；已结束的任务原样返回，不存在返回 nil
+func (m *Manager) Cancel(id string) (*Job, error) {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		return m.Get(id)
+	}
+	job.cancel()
+	return m.Get(id)
+}
+
+// gcLocked 移除内存中结束较久的任务
+func (m *Manager) gcLocked() {
+	for id, j := range m.jobs {
+		if j.FinishedAt != nil && time.Since(*j.FinishedAt) > finishedTTL {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+// snapshot 复制任务状态，调用方需持有锁
+func (j *Job) snapshot() *Job {
+	s := *j
+	s.cancel = nil
+	s.Output = append([]interface{}(nil), j.Output...)
+	return &s
+}
+
+func fromRecord(rec *database.JobRecord) *Job {
+	j := &Job{
+		ID:         rec.ID,
+		Kind:       rec.Kind,
+		Status:     rec.Status,
+		Source:     rec.Source,
+		Progress:   rec.Progress,
+		Error:      rec.Error,
+		CreatedAt:  rec.CreatedAt,
+		StartedAt:  rec.StartedAt,
+		FinishedAt: rec.FinishedAt,
+	}
+	if len(rec.Params) > 0 {
+		j.Params = rec.Params
+	}
+	if len(rec.Result) > 0 {
+		j.Result = rec.Result
+	}
+	return j
+}
+
+type jobIDKey struct{}
+
+// IDFromContext 任务执行函数内获取当前任务 ID（同步执行时为空）
+func IDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(jobIDKey{}).(string)
+	return id
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "job_" + hex.EncodeToString(b)
+}
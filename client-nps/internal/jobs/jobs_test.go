@@ -0,0 +1,215 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nwct/client-nps/internal/database"
+)
+
+// blockingFunc 在 release 关闭或 ctx 取消前一直阻塞；started 记录实际开始执行的次数
+func blockingFunc(release <-chan struct{}, started *atomic.Int32) Func {
+	return func(ctx context.Context, report func(int, interface{})) (interface{}, error) {
+		started.Add(1)
+		select {
+		case <-release:
+			return "done", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// waitStatus 轮询直到任务进入指定状态
+func waitStatus(t *testing.T, m *Manager, id, status string) *Job {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		j, err := m.Get(id)
+		if err != nil {
+			t.Fatalf("读取任务 %s 失败: %v", id, err)
+		}
+		if j != nil && j.Status == status {
+			return j
+		}
+		if time.Now().After(deadline) {
+			got := "<nil>"
+			if j != nil {
+				got = j.Status
+			}
+			t.Fatalf("任务 %s 状态为 %s，期望 %s", id, got, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestManagerQueuesBeyondMaxRunning(t *testing.T) {
+	m := newManager(nil)
+	release := make(chan struct{})
+	var started atomic.Int32
+
+	ids := make([]string, 0, maxRunning+1)
+	for i := 0; i < maxRunning+1; i++ {
+		j, err := m.Submit("test", database.DiagnosticSourceUI, nil, blockingFunc(release, &started))
+		if err != nil {
+			t.Fatalf("提交任务失败: %v", err)
+		}
+		if j.Status != StatusQueued {
+			t.Fatalf("新任务状态为 %s，期望 %s", j.Status, StatusQueued)
+		}
+		ids = append(ids, j.ID)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for started.Load() < maxRunning && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	// 多等一会，确认超出上限的任务没有开始执行
+	time.Sleep(50 * time.Millisecond)
+	if n := started.Load(); n != maxRunning {
+		t.Fatalf("同时运行 %d 个任务，期望 %d", n, maxRunning)
+	}
+	running, queued := 0, 0
+	for _, id := range ids {
+		j, _ := m.Get(id)
+		switch j.Status {
+		case StatusRunning:
+			running++
+		case StatusQueued:
+			queued++
+		}
+	}
+	if running != maxRunning || queued != 1 {
+		t.Fatalf("running=%d queued=%d，期望 %d/1", running, queued, maxRunning)
+	}
+
+	close(release)
+	for _, id := range ids {
+		j := waitStatus(t, m, id, StatusCompleted)
+		if j.Result != "done" || j.Progress != 100 {
+			t.Fatalf("任务结果 %v 进度 %d，期望 done/100", j.Result, j.Progress)
+		}
+	}
+}
+
+func TestManagerRejectsBeyondMaxPending(t *testing.T) {
+	m := newManager(nil)
+	release := make(chan struct{})
+	defer close(release)
+	var started atomic.Int32
+
+	for i := 0; i < maxPending; i++ {
+		if _, err := m.Submit("test", database.DiagnosticSourceUI, nil, blockingFunc(release, &started)); err != nil {
+			t.Fatalf("第 %d 个任务提交失败: %v", i+1, err)
+		}
+	}
+	if _, err := m.Submit("test", database.DiagnosticSourceUI, nil, blockingFunc(release, &started)); err == nil {
+		t.Fatalf("超过 %d 个未结束任务时应拒绝提交", maxPending)
+	}
+}
+
+func TestManagerCancel(t *testing.T) {
+	m := newManager(nil)
+	release := make(chan struct{})
+	defer close(release)
+	var started atomic.Int32
+
+	var running []string
+	for i := 0; i < maxRunning; i++ {
+		j, err := m.Submit("test", database.DiagnosticSourceUI, nil, blockingFunc(release, &started))
+		if err != nil {
+			t.Fatal(err)
+		}
+		running = append(running, j.ID)
+	}
+	for _, id := range running {
+		waitStatus(t, m, id, StatusRunning)
+	}
+
+	var queuedRan atomic.Int32
+	queued, err := m.Submit("test", database.DiagnosticSourceUI, nil, blockingFunc(release, &queuedRan))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 排队中的任务取消后直接结束，执行函数不会被调用
+	if _, err := m.Cancel(queued.ID); err != nil {
+		t.Fatal(err)
+	}
+	j := waitStatus(t, m, queued.ID, StatusCancelled)
+	if j.StartedAt != nil || queuedRan.Load() != 0 {
+		t.Fatalf("排队中被取消的任务不应开始执行: started_at=%v calls=%d", j.StartedAt, queuedRan.Load())
+	}
+
+	// 运行中的任务通过 ctx 取消，执行函数返回后标记为 cancelled 而不是 failed
+	if _, err := m.Cancel(running[0]); err != nil {
+		t.Fatal(err)
+	}
+	j = waitStatus(t, m, running[0], StatusCancelled)
+	if j.StartedAt == nil || j.Error != "" {
+		t.Fatalf("运行中被取消的任务: started_at=%v error=%q", j.StartedAt, j.Error)
+	}
+
+	// 已结束的任务再次取消原样返回；不存在的任务返回 nil
+	if j, _ := m.Cancel(running[0]); j == nil || j.Status != StatusCancelled {
+		t.Fatalf("重复取消返回 %+v", j)
+	}
+	if j, _ := m.Cancel("job_missing"); j != nil {
+		t.Fatalf("不存在的任务应返回 nil，实际 %+v", j)
+	}
+}
+
+// 内存中的已结束任务过期后，仍能从数据库读回结果
+func TestManagerGetFromDatabaseAfterTTL(t *testing.T) {
+	db, err := database.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("初始化数据库失败: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m := newManager(db)
+	params := map[string]interface{}{"target": "192.168.1.1"}
+	job, err := m.Submit("ping", database.DiagnosticSourceMQTT, params, func(ctx context.Context, report func(int, interface{})) (interface{}, error) {
+		report(50, "half")
+		return map[string]int{"received": 4}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, m, job.ID, StatusCompleted)
+
+	m.mu.Lock()
+	expired := time.Now().Add(-finishedTTL - time.Minute)
+	m.jobs[job.ID].FinishedAt = &expired
+	m.gcLocked()
+	_, inMemory := m.jobs[job.ID]
+	m.mu.Unlock()
+	if inMemory {
+		t.Fatal("过期的任务应从内存中移除")
+	}
+
+	got, err := m.Get(job.ID)
+	if err != nil || got == nil {
+		t.Fatalf("从数据库读取任务失败: %v %v", got, err)
+	}
+	if got.Status != StatusCompleted || got.Kind != "ping" || got.Source != database.DiagnosticSourceMQTT || got.Progress != 100 {
+		t.Fatalf("数据库中的任务 = %+v", got)
+	}
+	raw, ok := got.Result.(json.RawMessage)
+	if !ok {
+		t.Fatalf("数据库中的结果类型为 %T", got.Result)
+	}
+	var result map[string]int
+	if err := json.Unmarshal(raw, &result); err != nil || result["received"] != 4 {
+		t.Fatalf("数据库中的结果 = %s", raw)
+	}
+
+	list, err := m.List("ping", 10)
+	if err != nil || len(list) != 1 || list[0].ID != job.ID {
+		t.Fatalf("List 结果 = %+v %v", list, err)
+	}
+}
@@ -3,8 +3,9 @@ package mqtt
 import (
 	"encoding/json"
 	"fmt"
-	"net"
 	"nwct/client-nps/config"
+	"nwct/client-nps/internal/database"
+	"nwct/client-nps/internal/jobs"
 	"nwct/client-nps/internal/logger"
 	"nwct/client-nps/internal/network"
 	"nwct/client-nps/internal/realtime"
@@ -20,6 +21,10 @@ var globalMQTTClient Client
 var globalScanner scanner.Scanner
 var globalConfig *config.Config
 var globalNetManager network.Manager
+var globalToolSubmitter ToolSubmitter
+
+// ToolSubmitter 以后台任务方式运行工具箱工具（由 API 层注册，参数与 HTTP 接口相同）
+type ToolSubmitter func(kind, source string, params []byte) (*jobs.Job, error)
 
 // SetGlobalClient 设置全局MQTT客户端（用于命令处理）
 func SetGlobalClient(client Client) {
@@ -41,6 +46,11 @@ func SetGlobalNetManager(nm network.Manager) {
 	globalNetManager = nm
 }
 
+// SetGlobalToolSubmitter 设置工具任务提交函数（用于 MQTT tool 命令）
+func SetGlobalToolSubmitter(f ToolSubmitter) {
+	globalToolSubmitter = f
+}
+
 type mqttCommand struct {
 	Action    string                 `json:"action"`
 	Params    map[string]interface{} `json:"params"`
@@ -92,17 +102,27 @@ func publishEvent(event string, data map[string]interface{}, requestID string) {
 	realtime.Default().Broadcast("mqtt_event", msg)
 }
 
-func guessSubnetFromIP(ipStr string) (string, error) {
-	ip := net.ParseIP(strings.TrimSpace(ipStr))
-	if ip == nil {
-		return "", fmt.Errorf("无法解析IP: %s", ipStr)
-	}
-	ip4 := ip.To4()
-	if ip4 == nil {
-		return "", fmt.Errorf("仅支持IPv4推断网段: %s", ipStr)
+// PublishEvent 主动上报事件到 nwct/{device_id}/event（非命令触发，request_id 为空）
+func PublishEvent(event string, data map[string]interface{}) {
+	publishEvent(event, data, "")
+}
+
+// stringListParam 读取字符串数组参数（兼容单个字符串）
+func stringListParam(params map[string]interface{}, key string) []string {
+	out := []string{}
+	switch v := params[key].(type) {
+	case string:
+		if strings.TrimSpace(v) != "" {
+			out = append(out, strings.TrimSpace(v))
+		}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && strings.TrimSpace(s) != "" {
+				out = append(out, strings.TrimSpace(s))
+			}
+		}
 	}
-	// 默认 /24（后续可以根据接口 netmask 精确推断）
-	return fmt.Sprintf("%d.%d.%d.0/24", ip4[0], ip4[1], ip4[2]), nil
+	return out
 }
 
 // HandleCommandMessage 处理MQTT命令消息
@@ -122,6 +142,14 @@ func HandleCommandMessage(topic string, payload []byte) {
 		handleScanCommand(cmd.Params, cmd.RequestID)
 	case "config_update":
 		handleConfigUpdateCommand(cmd.Params, cmd.RequestID)
+	case "devices":
+		handleDevicesCommand(cmd.Params, cmd.RequestID)
+	case "jobs":
+		handleJobsCommand(cmd.Params, cmd.RequestID)
+	case "job":
+		handleJobCommand(cmd.Params, cmd.RequestID)
+	case "tool":
+		handleToolCommand(cmd.Params, cmd.RequestID)
 	default:
 		logger.Warn("未知的MQTT命令: %s", cmd.Action)
 		publishResponse(cmd.Action, "error", "未知命令", nil, cmd.RequestID)
@@ -179,36 +207,49 @@ func handleScanCommand(params map[string]interface{}, requestID string) {
 		return
 	}
 
-	subnet := ""
+	var subnets, ifaces []string
+	mode := ""
 	if params != nil {
-		if v, ok := params["subnet"].(string); ok {
-			subnet = strings.TrimSpace(v)
+		subnets = append(stringListParam(params, "subnet"), stringListParam(params, "subnets")...)
+		ifaces = stringListParam(params, "interfaces")
+		if v, ok := params["mode"].(string); ok {
+			mode = strings.TrimSpace(v)
 		}
 	}
-	if subnet == "" {
-		if globalNetManager == nil {
-			publishResponse("scan", "error", "未指定 subnet 且 netManager 未初始化", nil, requestID)
-			return
-		}
-		if st, err := globalNetManager.GetNetworkStatus(); err == nil {
-			if st.IP != "" {
-				if s, err := guessSubnetFromIP(st.IP); err == nil {
-					subnet = s
-				}
-			}
-		}
+	if len(subnets) == 0 && globalNetManager == nil {
+		publishResponse("scan", "error", "未指定 subnet 且 netManager 未初始化", nil, requestID)
+		return
 	}
-	if subnet == "" {
-		publishResponse("scan", "error", "无法确定扫描网段，请在 params.subnet 指定 CIDR（如 192.168.1.0/24）", nil, requestID)
+	// 未指定网段/接口时，自动使用所有已启动的物理接口所在网段
+	targets, err := scanner.ResolveTargets(globalNetManager, subnets, ifaces)
+	if err != nil {
+		publishResponse("scan", "error", err.Error(), nil, requestID)
 		return
 	}
-
-	if err := globalScanner.StartScan(subnet); err != nil {
+	subnet := strings.Join(scanner.TargetSubnets(targets), ",")
+
+	opts := scanner.ScanOptions{Targets: targets, Mode: mode, Source: scanner.ScanSourceMQTT}
+	if globalConfig != nil {
+		// API 可能同时修改扫描配置，取一份加锁副本
+		cfg := globalConfig.ScannerSettings()
+		opts.Timeout = time.Duration(cfg.Timeout) * time.Second
+		opts.Concurrency = cfg.Concurrency
+		opts.PortProfile = cfg.PortProfile
+		opts.Ports = cfg.Ports
+		opts.UDPPorts = cfg.UDPPorts
+	}
+	if err := globalScanner.StartScanWithOptions(opts); err != nil {
 		publishResponse("scan", "error", err.Error(), map[string]interface{}{"subnet": subnet}, requestID)
 		return
 	}
 
-	publishResponse("scan", "success", "扫描已启动", map[string]interface{}{"subnet": subnet}, requestID)
+	started := globalScanner.GetScanStatus()
+	publishResponse("scan", "success", "扫描已启动", map[string]interface{}{
+		"subnet":  subnet,
+		"targets": targets,
+		"scan_id": started.ScanID,
+		"mode":    started.Mode,
+	}, requestID)
 
 	// 后台推送进度/完成事件
 	go func() {
@@ -228,10 +269,13 @@ func handleScanCommand(params map[string]interface{}, requestID string) {
 			}, requestID)
 			if st.Status == "completed" || st.Status == "stopped" {
 				publishEvent("scan_done", map[string]interface{}{
+					"scan_id":       st.ScanID,
 					"status":        st.Status,
 					"progress":      st.Progress,
 					"scanned_count": st.ScannedCount,
 					"found_count":   st.FoundCount,
+					"new_count":     st.NewCount,
+					"gone_count":    st.GoneCount,
 				}, requestID)
 				return
 			}
@@ -240,6 +284,171 @@ func handleScanCommand(params map[string]interface{}, requestID string) {
 	}()
 }
 
+// handleDevicesCommand 返回设备列表（含用户标注），可按 status/tag/owner/location 过滤
+func handleDevicesCommand(params map[string]interface{}, requestID string) {
+	if globalScanner == nil {
+		publishResponse("devices", "error", "scanner 未初始化", nil, requestID)
+		return
+	}
+	devices, err := globalScanner.GetDevices()
+	if err != nil {
+		publishResponse("devices", "error", err.Error(), nil, requestID)
+		return
+	}
+
+	status, owner, location := "online", "", ""
+	var tags []string
+	if params != nil {
+		if v, ok := params["status"].(string); ok && strings.TrimSpace(v) != "" {
+			status = strings.TrimSpace(v)
+		}
+		if v, ok := params["owner"].(string); ok {
+			owner = strings.TrimSpace(v)
+		}
+		if v, ok := params["location"].(string); ok {
+			location = strings.TrimSpace(v)
+		}
+		tags = append(stringListParam(params, "tag"), stringListParam(params, "tags")...)
+	}
+
+	list := []map[string]interface{}{}
+	for _, d := range devices {
+		if status != "all" && d.Status != status {
+			continue
+		}
+		if owner != "" && !strings.EqualFold(d.Owner, owner) {
+			continue
+		}
+		if location != "" && !strings.EqualFold(d.Location, location) {
+			continue
+		}
+		if !containsAllFold(d.Tags, tags) {
+			continue
+		}
+		list = append(list, map[string]interface{}{
+			"id":        d.ID,
+			"ip":        d.IP,
+			"mac":       d.MAC,
+			"name":      d.Name,
+			"vendor":    d.Vendor,
+			"model":     d.Model,
+			"type":      d.Type,
+			"os":        d.OS,
+			"status":    d.Status,
+			"alias":     d.Alias,
+			"owner":     d.Owner,
+			"location":  d.Location,
+			"notes":     d.Notes,
+			"tags":      d.Tags,
+			"last_seen": d.LastSeen,
+		})
+	}
+	publishResponse("devices", "success", "ok", map[string]interface{}{
+		"devices": list,
+		"total":   len(list),
+	}, requestID)
+}
+
+// handleJobsCommand 查询最近的后台任务（params: kind、limit）
+func handleJobsCommand(params map[string]interface{}, requestID string) {
+	kind, limit := "", 20
+	if params != nil {
+		if v, ok := params["kind"].(string); ok {
+			kind = strings.TrimSpace(v)
+		}
+		if v, ok := params["limit"].(float64); ok && v > 0 {
+			limit = int(v)
+		}
+	}
+	list, err := jobs.Default().List(kind, limit)
+	if err != nil {
+		publishResponse("jobs", "error", err.Error(), nil, requestID)
+		return
+	}
+	publishResponse("jobs", "success", "ok", map[string]interface{}{
+		"jobs":  list,
+		"total": len(list),
+	}, requestID)
+}
+
+// handleToolCommand 远程运行工具（params: kind=ping/traceroute/mtr/speedtest/portscan/dns，params=与 HTTP 接口相同的参数），
+// 以后台任务执行，立即返回任务信息；结果通过 job 命令查询
+func handleToolCommand(params map[string]interface{}, requestID string) {
+	if globalToolSubmitter == nil {
+		publishResponse("tool", "error", "工具服务未就绪", nil, requestID)
+		return
+	}
+	kind := ""
+	var toolParams interface{} = map[string]interface{}{}
+	if params != nil {
+		if v, ok := params["kind"].(string); ok {
+			kind = strings.TrimSpace(v)
+		}
+		if v, ok := params["params"]; ok && v != nil {
+			toolParams = v
+		}
+	}
+	if kind == "" {
+		publishResponse("tool", "error", "缺少工具类型 kind", nil, requestID)
+		return
+	}
+	raw, err := json.Marshal(toolParams)
+	if err != nil {
+		publishResponse("tool", "error", err.Error(), nil, requestID)
+		return
+	}
+	job, err := globalToolSubmitter(kind, database.DiagnosticSourceMQTT, raw)
+	if err != nil {
+		publishResponse("tool", "error", err.Error(), nil, requestID)
+		return
+	}
+	publishResponse("tool", "success", "任务已提交", map[string]interface{}{
+		"job": job,
+	}, requestID)
+}
+
+// handleJobCommand 查询单个任务的状态与结果（params: id）
+func handleJobCommand(params map[string]interface{}, requestID string) {
+	id := ""
+	if params != nil {
+		if v, ok := params["id"].(string); ok {
+			id = strings.TrimSpace(v)
+		}
+	}
+	if id == "" {
+		publishResponse("job", "error", "缺少任务 id", nil, requestID)
+		return
+	}
+	job, err := jobs.Default().Get(id)
+	if err != nil {
+		publishResponse("job", "error", err.Error(), nil, requestID)
+		return
+	}
+	if job == nil {
+		publishResponse("job", "error", "任务不存在", nil, requestID)
+		return
+	}
+	publishResponse("job", "success", "ok", map[string]interface{}{
+		"job": job,
+	}, requestID)
+}
+
+func containsAllFold(have, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if strings.EqualFold(h, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
 func mergeMap(dst map[string]interface{}, patch map[string]interface{}) {
 	for k, v := range patch {
 		if vmap, ok := v.(map[string]interface{}); ok {
@@ -242,6 +242,16 @@ func netmaskToPrefix(netmask string) (int, error) {
 	if n, err := strconv.Atoi(s); err == nil && n >= 0 && n <= 32 {
 		return n, nil
 	}
+	// "ffffff00"（net.IPMask.String() 的格式，GetInterfaces 返回的就是这种）
+	if len(s) == 8 {
+		if v, err := strconv.ParseUint(s, 16, 32); err == nil {
+			mask := net.IPv4Mask(byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+			if ones, bits := mask.Size(); bits == 32 {
+				return ones, nil
+			}
+			return 0, fmt.Errorf("netmask 无效: %s", netmask)
+		}
+	}
 	// dotted
 	ip := net.ParseIP(s)
 	if ip == nil {
@@ -259,6 +269,20 @@ func netmaskToPrefix(netmask string) (int, error) {
 	return ones, nil
 }
 
+// InterfaceSubnet 根据接口的 IP/Netmask 计算所在网段（CIDR），如 192.168.1.0/24
+func InterfaceSubnet(iface Interface) (string, error) {
+	ip := net.ParseIP(strings.TrimSpace(iface.IP)).To4()
+	if ip == nil {
+		return "", fmt.Errorf("接口 %s 没有 IPv4 地址", iface.Name)
+	}
+	prefix, err := netmaskToPrefix(iface.Netmask)
+	if err != nil {
+		return "", err
+	}
+	ipnet := &net.IPNet{IP: ip.Mask(net.CIDRMask(prefix, 32)), Mask: net.CIDRMask(prefix, 32)}
+	return ipnet.String(), nil
+}
+
 func (nm *networkManager) darwinServiceForDevice(device string) (string, error) {
 	// networksetup -listallhardwareports 输出块：Hardware Port: Wi-Fi / Device: en0
 	out, err := nm.runCmd(4*time.Second, "networksetup", "-listallhardwareports")
@@ -442,7 +466,7 @@ func (nm *networkManager) GetNetworkStatus() (*NetworkStatus, error) {
 			if iface.Status != "up" || iface.IP == "" {
 				continue
 			}
-			if isVirtualInterfaceName(iface.Name) {
+			if IsVirtualInterfaceName(iface.Name) {
 				continue
 			}
 			status.CurrentInterface = iface.Name
@@ -471,11 +495,20 @@ func (nm *networkManager) GetNetworkStatus() (*NetworkStatus, error) {
 	return status, nil
 }
 
-func isVirtualInterfaceName(name string) bool {
+// IsVirtualInterfaceName 判断是否为虚拟/系统接口（VPN、容器网桥等）
+func IsVirtualInterfaceName(name string) bool {
 	n := strings.ToLower(strings.TrimSpace(name))
 	if n == "" {
 		return true
 	}
+	// Linux 常见虚拟接口：docker/veth/容器网桥/libvirt
+	// 注意 OpenWrt 等路由器的 LAN 网桥也叫 br-xxx（如 br-lan），只排除 docker 自动命名的网桥
+	if strings.HasPrefix(n, "docker") ||
+		strings.HasPrefix(n, "veth") ||
+		isDockerBridgeName(n) ||
+		strings.HasPrefix(n, "virbr") {
+		return true
+	}
 	// macOS 常见虚拟/系统接口：utun(vpn), bridge(docker), awdl/llw(Apple), lo
 	if strings.HasPrefix(n, "utun") ||
 		strings.HasPrefix(n, "bridge") ||
@@ -490,6 +523,20 @@ func isVirtualInterfaceName(name string) bool {
 	return false
 }
 
+// isDockerBridgeName docker 自定义网络的网桥名为 br- 加网络 ID 前 12 位十六进制
+func isDockerBridgeName(n string) bool {
+	id, ok := strings.CutPrefix(n, "br-")
+	if !ok || len(id) != 12 {
+		return false
+	}
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
 func (nm *networkManager) getDefaultRouteDeviceAndGateway() (string, string, error) {
 	switch runtime.GOOS {
 	case "darwin":
@@ -0,0 +1,30 @@
+package network
+
+import "testing"
+
+func TestIsVirtualInterfaceName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"eth0", false},
+		{"br-lan", false},
+		{"br-wan", false},
+		{"br0", false},
+		{"wlan0", false},
+		{"br-3f2a9c1d7e4b", true},
+		{"BR-3F2A9C1D7E4B", true},
+		{"br-3f2a9c1d7e4", false},
+		{"br-3f2a9c1d7e4g", false},
+		{"docker0", true},
+		{"veth1a2b3c", true},
+		{"virbr0", true},
+		{"utun3", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		if got := IsVirtualInterfaceName(tt.name); got != tt.want {
+			t.Errorf("IsVirtualInterfaceName(%q) = %v，期望 %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,483 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"nwct/client-nps/internal/database"
+	"nwct/client-nps/internal/logger"
+	"nwct/client-nps/internal/realtime"
+	"nwct/client-nps/internal/toolkit"
+)
+
+const (
+	// 同时执行的检查数上限
+	maxRunningChecks = 8
+	// 检查结果保留时长
+	checkResultRetention = 30 * 24 * time.Hour
+	// HTTP 检查读取的响应体上限（用于内容匹配）
+	checkBodyLimit = 256 * 1024
+)
+
+// CheckScheduler 定时检查调度：按各检查的间隔执行，状态变化时推送 check_state
+type CheckScheduler struct {
+	mu      sync.Mutex
+	db      *sql.DB
+	checks  map[int64]*database.MonitorCheck
+	nextRun map[int64]time.Time
+	running map[int64]bool
+	sem     chan struct{}
+	notify  func(event string, data map[string]interface{})
+}
+
+var (
+	defaultChecks     *CheckScheduler
+	defaultChecksOnce sync.Once
+)
+
+// DefaultChecks 全局定时检查调度器
+func DefaultChecks() *CheckScheduler {
+	defaultChecksOnce.Do(func() {
+		defaultChecks = &CheckScheduler{
+			db:      database.GetDB(),
+			checks:  make(map[int64]*database.MonitorCheck),
+			nextRun: make(map[int64]time.Time),
+			running: make(map[int64]bool),
+			sem:     make(chan struct{}, maxRunningChecks),
+		}
+	})
+	return defaultChecks
+}
+
+// SetNotifier 设置状态变化的额外通知（如 MQTT 事件），WebSocket 推送总是进行
+func (s *CheckScheduler) SetNotifier(f func(event string, data map[string]interface{})) {
+	s.mu.Lock()
+	s.notify = f
+	s.mu.Unlock()
+}
+
+// Start 加载检查定义并开始调度，ctx 取消时停止
+func (s *CheckScheduler) Start(ctx context.Context) {
+	if err := s.Reload(); err != nil {
+		logger.Error("定时检查：加载检查定义失败: %v", err)
+	}
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		lastPrune := time.Time{}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.dispatch(ctx, now)
+				if now.Sub(lastPrune) >= time.Hour {
+					lastPrune = now
+					if err := database.PruneMonitorCheckResults(s.db, now.Add(-checkResultRetention)); err != nil {
+						logger.Warn("定时检查：清理过期结果失败: %v", err)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Reload 重新读取检查定义（增删改后调用）；已有检查保持原有的下次执行时间，新检查立即执行
+func (s *CheckScheduler) Reload() error {
+	list, err := database.ListMonitorChecks(s.db)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	checks := make(map[int64]*database.MonitorCheck, len(list))
+	for i := range list {
+		c := list[i]
+		old, ok := s.checks[c.ID]
+		if !ok || old.Interval != c.Interval || !old.Enabled {
+			s.nextRun[c.ID] = time.Time{}
+		}
+		checks[c.ID] = &c
+	}
+	for id := range s.nextRun {
+		if _, ok := checks[id]; !ok {
+			delete(s.nextRun, id)
+		}
+	}
+	s.checks = checks
+	return nil
+}
+
+// dispatch 启动到期的检查（上一次还没结束的跳过）
+func (s *CheckScheduler) dispatch(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, c := range s.checks {
+		if !c.Enabled || s.running[id] || now.Before(s.nextRun[id]) {
+			continue
+		}
+		s.running[id] = true
+		s.nextRun[id] = now.Add(time.Duration(c.Interval) * time.Second)
+		check := *c
+		go func() {
+			defer func() {
+				s.mu.Lock()
+				delete(s.running, check.ID)
+				s.mu.Unlock()
+			}()
+			select {
+			case s.sem <- struct{}{}:
+				defer func() { <-s.sem }()
+			case <-ctx.Done():
+				return
+			}
+			r := RunCheck(ctx, &check)
+			recordDiagnostic(s.db, &check, r, database.DiagnosticSourceScheduler)
+			s.apply(&check, r)
+		}()
+	}
+}
+
+// RunNow 立即执行一次检查并更新状态（不影响定时计划）
+func (s *CheckScheduler) RunNow(ctx context.Context, id int64) (*database.MonitorCheckResult, error) {
+	c, err := database.GetMonitorCheck(s.db, id)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, nil
+	}
+	r := RunCheck(ctx, c)
+	recordDiagnostic(s.db, c, r, database.DiagnosticSourceUI)
+	s.apply(c, r)
+	return &r, nil
+}
+
+// recordDiagnostic 把一次检查写入诊断历史（kind 为 check），crit 记为 failed
+func recordDiagnostic(db *sql.DB, c *database.MonitorCheck, r database.MonitorCheckResult, source string) {
+	run := &database.DiagnosticRun{
+		Kind:      "check",
+		Target:    c.Target,
+		Source:    source,
+		Status:    "ok",
+		Duration:  math.Round(time.Since(r.CreatedAt).Seconds()*1000) / 1000,
+		CreatedAt: r.CreatedAt,
+	}
+	if r.State == database.CheckStateCrit {
+		run.Status = "failed"
+		run.Error = r.Message
+	} else {
+		run.Metrics = map[string]float64{"latency_ms": r.LatencyMs}
+		switch c.Type {
+		case database.CheckTypePing:
+			run.Metrics["loss"] = r.Loss
+		case database.CheckTypeHTTP, database.CheckTypeDNS, database.CheckTypeTLS:
+			run.Metrics["value"] = r.Value
+		}
+	}
+	run.Params, _ = json.Marshal(map[string]interface{}{
+		"check_id":   c.ID,
+		"name":       c.Name,
+		"type":       c.Type,
+		"params":     c.Params,
+		"thresholds": c.Thresholds,
+	})
+	run.Result, _ = json.Marshal(r)
+	if err := database.SaveDiagnosticRun(db, run); err != nil {
+		logger.Warn("定时检查：保存诊断记录失败: id=%d err=%v", c.ID, err)
+	}
+}
+
+// apply 保存结果；状态变化时更新状态起始时间并推送（首次得到 OK 不推送）
+func (s *CheckScheduler) apply(c *database.MonitorCheck, r database.MonitorCheckResult) {
+	if err := database.SaveMonitorCheckResult(s.db, &r); err != nil {
+		logger.Warn("定时检查：保存结果失败: id=%d err=%v", c.ID, err)
+	}
+
+	s.mu.Lock()
+	prev := c.State
+	since := c.StateSince
+	if cur, ok := s.checks[c.ID]; ok {
+		prev, since = cur.State, cur.StateSince
+	}
+	changed := prev != r.State
+	if changed {
+		at := r.CreatedAt
+		since = &at
+	}
+	if cur, ok := s.checks[c.ID]; ok {
+		cur.State, cur.StateSince, cur.LastMessage = r.State, since, r.Message
+		cur.LastRunAt = &r.CreatedAt
+	}
+	notify := s.notify
+	s.mu.Unlock()
+
+	if err := database.UpdateMonitorCheckState(s.db, c.ID, r.State, since, r.CreatedAt, r.Message); err != nil {
+		logger.Warn("定时检查：更新状态失败: id=%d err=%v", c.ID, err)
+	}
+	if !changed || (prev == database.CheckStateUnknown && r.State == database.CheckStateOK) {
+		return
+	}
+
+	logger.Info("定时检查状态变化: %s %s → %s (%s)", c.Name, prev, r.State, r.Message)
+	data := map[string]interface{}{
+		"check_id":   c.ID,
+		"name":       c.Name,
+		"type":       c.Type,
+		"target":     c.Target,
+		"from":       prev,
+		"to":         r.State,
+		"message":    r.Message,
+		"latency_ms": r.LatencyMs,
+		"value":      r.Value,
+		"ts":         r.CreatedAt.Format(time.RFC3339),
+	}
+	realtime.Default().Broadcast("check_state", data)
+	if notify != nil {
+		notify("check_state", data)
+	}
+}
+
+// RunCheck 执行一次检查并按阈值判定状态（不写库）
+func RunCheck(ctx context.Context, c *database.MonitorCheck) database.MonitorCheckResult {
+	timeout := time.Duration(c.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	r := database.MonitorCheckResult{CheckID: c.ID, State: database.CheckStateOK, CreatedAt: time.Now()}
+	var err error
+	switch c.Type {
+	case database.CheckTypePing:
+		err = runPingCheck(ctx, c, timeout, &r)
+	case database.CheckTypeTCP:
+		err = runTCPCheck(ctx, c, timeout, &r)
+	case database.CheckTypeHTTP:
+		err = runHTTPCheck(ctx, c, timeout, &r)
+	case database.CheckTypeDNS:
+		err = runDNSCheck(ctx, c, timeout, &r)
+	case database.CheckTypeTLS:
+		err = runTLSCheck(ctx, c, timeout, &r)
+	default:
+		err = fmt.Errorf("不支持的检查类型: %s", c.Type)
+	}
+	if err != nil {
+		r.State = database.CheckStateCrit
+		r.Message = err.Error()
+		return r
+	}
+	r.LatencyMs = math.Round(r.LatencyMs*100) / 100
+	t := c.Thresholds
+	reasons := []string{}
+	raise := func(state, reason string) {
+		if checkSeverity(state) > checkSeverity(r.State) {
+			r.State = state
+		}
+		reasons = append(reasons, reason)
+	}
+	if c.Type == database.CheckTypePing {
+		switch {
+		case t.LossCrit > 0 && r.Loss >= t.LossCrit:
+			raise(database.CheckStateCrit, fmt.Sprintf("丢包 %.1f%% ≥ %.1f%%", r.Loss, t.LossCrit))
+		case t.LossWarn > 0 && r.Loss >= t.LossWarn:
+			raise(database.CheckStateWarn, fmt.Sprintf("丢包 %.1f%% ≥ %.1f%%", r.Loss, t.LossWarn))
+		}
+	}
+	switch {
+	case t.LatencyCrit > 0 && r.LatencyMs >= t.LatencyCrit:
+		raise(database.CheckStateCrit, fmt.Sprintf("延迟 %.1fms ≥ %.1fms", r.LatencyMs, t.LatencyCrit))
+	case t.LatencyWarn > 0 && r.LatencyMs >= t.LatencyWarn:
+		raise(database.CheckStateWarn, fmt.Sprintf("延迟 %.1fms ≥ %.1fms", r.LatencyMs, t.LatencyWarn))
+	}
+	if c.Type == database.CheckTypeTLS {
+		switch {
+		case t.ExpiryCritDays > 0 && r.Value < float64(t.ExpiryCritDays):
+			raise(database.CheckStateCrit, fmt.Sprintf("证书 %.0f 天后过期", r.Value))
+		case t.ExpiryWarnDays > 0 && r.Value < float64(t.ExpiryWarnDays):
+			raise(database.CheckStateWarn, fmt.Sprintf("证书 %.0f 天后过期", r.Value))
+		}
+	}
+	if len(reasons) > 0 {
+		r.Message = strings.Join(reasons, "；")
+	}
+	return r
+}
+
+func checkSeverity(state string) int {
+	switch state {
+	case database.CheckStateWarn:
+		return 1
+	case database.CheckStateCrit:
+		return 2
+	}
+	return 0
+}
+
+// runPingCheck ICMP ping：延迟为平均 RTT，全部丢包为 CRIT
+func runPingCheck(ctx context.Context, c *database.MonitorCheck, timeout time.Duration, r *database.MonitorCheckResult) error {
+	count := c.Params.Count
+	if count <= 0 {
+		count = 3
+	}
+	interval := 500 * time.Millisecond
+	ctx, cancel := context.WithTimeout(ctx, timeout+time.Duration(count)*interval)
+	defer cancel()
+	res, err := toolkit.PingWithOptions(ctx, c.Target, toolkit.PingOptions{Count: count, Timeout: timeout, Interval: interval})
+	if err != nil {
+		return err
+	}
+	r.Loss = res.PacketLoss
+	if res.PacketsReceived == 0 {
+		return fmt.Errorf("全部丢包（%d/%d）", res.PacketsSent-res.PacketsReceived, res.PacketsSent)
+	}
+	r.LatencyMs = res.AvgLatency
+	r.Value = float64(res.PacketsReceived)
+	return nil
+}
+
+// runTCPCheck TCP 连接耗时
+func runTCPCheck(ctx context.Context, c *database.MonitorCheck, timeout time.Duration, r *database.MonitorCheckResult) error {
+	addr := checkAddr(c.Target, c.Params.Port)
+	d := net.Dialer{Timeout: timeout}
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("连接 %s 失败: %v", addr, err)
+	}
+	r.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	conn.Close()
+	return nil
+}
+
+// runHTTPCheck HTTP(S) GET：检查状态码和响应体，延迟为总耗时
+func runHTTPCheck(ctx context.Context, c *database.MonitorCheck, timeout time.Duration, r *database.MonitorCheckResult) error {
+	start := time.Now()
+	a, body, err := toolkit.WebProbe(ctx, c.Target, timeout, checkBodyLimit)
+	if err != nil {
+		return err
+	}
+	if a.StatusCode == 0 {
+		return fmt.Errorf("请求失败: %s", a.Error)
+	}
+	// 不用 a.TotalMs（整毫秒），局域网内的目标需要更细的精度
+	r.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	r.Value = float64(a.StatusCode)
+	if want := c.Params.ExpectStatus; want != 0 {
+		if a.StatusCode != want {
+			return fmt.Errorf("状态码 %d，期望 %d", a.StatusCode, want)
+		}
+	} else if a.StatusCode >= 400 {
+		return fmt.Errorf("状态码 %d", a.StatusCode)
+	}
+	if want := c.Params.ExpectBody; want != "" {
+		if strings.HasPrefix(want, "re:") {
+			re, err := regexp.Compile(strings.TrimPrefix(want, "re:"))
+			if err != nil {
+				return fmt.Errorf("无效的响应体正则: %v", err)
+			}
+			if !re.Match(body) {
+				return fmt.Errorf("响应体不匹配 %s", want)
+			}
+		} else if !strings.Contains(string(body), want) {
+			return fmt.Errorf("响应体不包含 %q", want)
+		}
+	}
+	return nil
+}
+
+// runDNSCheck DNS 解析：需返回 NOERROR 且有记录（可要求包含指定值），延迟为查询耗时
+func runDNSCheck(ctx context.Context, c *database.MonitorCheck, timeout time.Duration, r *database.MonitorCheckResult) error {
+	res, err := toolkit.DNSLookup(ctx, c.Target, toolkit.DNSOptions{
+		Type:    c.Params.RecordType,
+		Server:  c.Params.Server,
+		Timeout: timeout,
+	})
+	if err != nil {
+		return err
+	}
+	r.LatencyMs = res.Time
+	r.Value = float64(len(res.Records))
+	if res.Rcode != "NOERROR" {
+		return fmt.Errorf("解析失败: %s", res.Rcode)
+	}
+	if len(res.Records) == 0 {
+		return fmt.Errorf("没有 %s 记录", c.Params.RecordType)
+	}
+	if want := c.Params.ExpectValue; want != "" {
+		for _, rec := range res.Records {
+			if strings.Contains(strings.ToLower(rec.Value), strings.ToLower(want)) {
+				return nil
+			}
+		}
+		return fmt.Errorf("解析结果不包含 %s", want)
+	}
+	return nil
+}
+
+// runTLSCheck TLS 证书：校验证书链（insecure 时跳过）并计算剩余天数，延迟为连接 + 握手耗时
+func runTLSCheck(ctx context.Context, c *database.MonitorCheck, timeout time.Duration, r *database.MonitorCheckResult) error {
+	addr := checkAddr(c.Target, c.Params.Port)
+	host, _, _ := net.SplitHostPort(addr)
+	serverName := c.Params.ServerName
+	if serverName == "" {
+		serverName = host
+	}
+	d := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: timeout},
+		// 手动校验，以便证书无效时仍能拿到有效期
+		Config: &tls.Config{ServerName: serverName, InsecureSkipVerify: true},
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("TLS 连接 %s 失败: %v", addr, err)
+	}
+	defer conn.Close()
+	r.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return fmt.Errorf("服务端未提供证书")
+	}
+	leaf := certs[0]
+	r.Value = math.Floor(time.Until(leaf.NotAfter).Hours()/24*10) / 10
+	if time.Now().After(leaf.NotAfter) {
+		return fmt.Errorf("证书已于 %s 过期", leaf.NotAfter.Format("2006-01-02"))
+	}
+	if !c.Params.Insecure {
+		opts := x509.VerifyOptions{DNSName: serverName, Intermediates: x509.NewCertPool()}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		if _, err := leaf.Verify(opts); err != nil {
+			return fmt.Errorf("证书校验失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// checkAddr 目标补全端口：支持 host、host:port 和 URL
+func checkAddr(target string, port int) string {
+	if u, err := url.Parse(target); err == nil && u.Host != "" {
+		target = u.Host
+		if port == 0 && u.Port() == "" && u.Scheme == "https" {
+			port = 443
+		}
+	}
+	if _, _, err := net.SplitHostPort(target); err == nil {
+		return target
+	}
+	return net.JoinHostPort(strings.Trim(target, "[]"), strconv.Itoa(port))
+}
@@ -0,0 +1,122 @@
+package probe
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nwct/client-nps/internal/database"
+)
+
+// slowResponse 让 HTTP 检查的延迟稳定高于 20ms，便于判定延迟阈值
+const slowResponse = 20 * time.Millisecond
+
+func TestRunCheckThresholds(t *testing.T) {
+	httpSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(slowResponse)
+		w.Write([]byte("status: healthy"))
+	}))
+	defer httpSrv.Close()
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer tlsSrv.Close()
+	// 监听后立即关闭，得到一个拒绝连接的端口
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	closedAddr := ln.Addr().String()
+	ln.Close()
+
+	tests := []struct {
+		name       string
+		check      database.MonitorCheck
+		wantState  string
+		wantReason []string // Message 需包含的片段
+	}{
+		{
+			name:      "未超过阈值",
+			check:     database.MonitorCheck{Type: database.CheckTypeHTTP, Target: httpSrv.URL, Thresholds: database.MonitorThresholds{LatencyWarn: 5000, LatencyCrit: 10000}},
+			wantState: database.CheckStateOK,
+		},
+		{
+			name:       "延迟超过 warn",
+			check:      database.MonitorCheck{Type: database.CheckTypeHTTP, Target: httpSrv.URL, Thresholds: database.MonitorThresholds{LatencyWarn: 10, LatencyCrit: 10000}},
+			wantState:  database.CheckStateWarn,
+			wantReason: []string{"延迟", "≥ 10.0ms"},
+		},
+		{
+			name:       "延迟超过 crit 时只报 crit",
+			check:      database.MonitorCheck{Type: database.CheckTypeHTTP, Target: httpSrv.URL, Thresholds: database.MonitorThresholds{LatencyWarn: 5, LatencyCrit: 10}},
+			wantState:  database.CheckStateCrit,
+			wantReason: []string{"≥ 10.0ms"},
+		},
+		{
+			name:      "只设 crit",
+			check:     database.MonitorCheck{Type: database.CheckTypeHTTP, Target: httpSrv.URL, Thresholds: database.MonitorThresholds{LatencyCrit: 10000}},
+			wantState: database.CheckStateOK,
+		},
+		{
+			name:       "响应体正则不匹配为 crit",
+			check:      database.MonitorCheck{Type: database.CheckTypeHTTP, Target: httpSrv.URL, Params: database.MonitorCheckParams{ExpectBody: "re:^status: (ok|up)$"}},
+			wantState:  database.CheckStateCrit,
+			wantReason: []string{"响应体不匹配"},
+		},
+		{
+			name:       "状态码不符为 crit",
+			check:      database.MonitorCheck{Type: database.CheckTypeHTTP, Target: httpSrv.URL, Params: database.MonitorCheckParams{ExpectStatus: 204}},
+			wantState:  database.CheckStateCrit,
+			wantReason: []string{"状态码 200，期望 204"},
+		},
+		{
+			name:       "证书剩余天数低于 warn",
+			check:      database.MonitorCheck{Type: database.CheckTypeTLS, Target: tlsSrv.URL, Params: database.MonitorCheckParams{Insecure: true}, Thresholds: database.MonitorThresholds{ExpiryWarnDays: 1 << 20, LatencyWarn: 10000}},
+			wantState:  database.CheckStateWarn,
+			wantReason: []string{"天后过期"},
+		},
+		{
+			name: "多项超限取最高级别并合并原因",
+			check: database.MonitorCheck{Type: database.CheckTypeTLS, Target: tlsSrv.URL, Params: database.MonitorCheckParams{Insecure: true},
+				Thresholds: database.MonitorThresholds{LatencyWarn: 0.001, ExpiryWarnDays: 1 << 21, ExpiryCritDays: 1 << 20}},
+			wantState:  database.CheckStateCrit,
+			wantReason: []string{"延迟", "；", "天后过期"},
+		},
+		{
+			name:       "证书链校验失败为 crit",
+			check:      database.MonitorCheck{Type: database.CheckTypeTLS, Target: tlsSrv.URL},
+			wantState:  database.CheckStateCrit,
+			wantReason: []string{"证书校验失败"},
+		},
+		{
+			name:       "连接失败为 crit",
+			check:      database.MonitorCheck{Type: database.CheckTypeTCP, Target: closedAddr, Timeout: 1, Thresholds: database.MonitorThresholds{LatencyWarn: 10000}},
+			wantState:  database.CheckStateCrit,
+			wantReason: []string{"连接 " + closedAddr + " 失败"},
+		},
+		{
+			name:       "不支持的类型",
+			check:      database.MonitorCheck{Type: "snmp", Target: "127.0.0.1"},
+			wantState:  database.CheckStateCrit,
+			wantReason: []string{"不支持的检查类型"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := RunCheck(context.Background(), &tt.check)
+			if r.State != tt.wantState {
+				t.Fatalf("状态 = %s，期望 %s（%s）", r.State, tt.wantState, r.Message)
+			}
+			if len(tt.wantReason) == 0 && r.Message != "" {
+				t.Fatalf("期望无告警信息，得到 %q", r.Message)
+			}
+			for _, s := range tt.wantReason {
+				if !strings.Contains(r.Message, s) {
+					t.Fatalf("信息 %q 不含 %q", r.Message, s)
+				}
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package probe
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"net"
 	"strings"
 	"time"
@@ -65,22 +66,47 @@ func runOnce(db *sql.DB, timeout time.Duration) {
 		if d.Status == newStatus {
 			// online 的设备也更新 last_seen（避免 UI 误判 오래没见）
 			if newStatus == "online" {
-				_ = database.TouchDeviceLastSeen(db, d.IP)
+				_ = database.TouchDeviceLastSeen(db, d.ID)
 			}
 			continue
 		}
 
-		if err := database.UpdateDeviceStatus(db, d.IP, newStatus); err != nil {
+		if err := database.UpdateDeviceStatus(db, d.ID, newStatus); err != nil {
 			logger.Error("设备探测：更新状态失败: ip=%s err=%v", d.IP, err)
 			continue
 		}
 
 		realtime.Default().Broadcast("device_status_changed", map[string]interface{}{
+			"id":     d.ID,
 			"ip":     d.IP,
 			"status": newStatus,
 			"ts":     time.Now().Format(time.RFC3339),
 		})
 	}
+
+	broadcastGroupStatus(db)
+}
+
+// lastGroupStatus 上一轮推送的分组统计，仅在变化时再推送（只在监控 goroutine 内访问）
+var lastGroupStatus string
+
+// broadcastGroupStatus 推送各分组的在线/离线汇总
+func broadcastGroupStatus(db *sql.DB) {
+	summaries, err := database.SummarizeGroups(db)
+	if err != nil {
+		logger.Error("设备探测：统计分组状态失败: %v", err)
+		return
+	}
+	key, _ := json.Marshal(summaries)
+	if string(key) == lastGroupStatus {
+		return
+	}
+	lastGroupStatus = string(key)
+
+	realtime.Default().Broadcast("group_status", map[string]interface{}{
+		"groups": summaries,
+		"ts":     time.Now().Format(time.RFC3339),
+	})
 }
 
 func probeReachable(ip string, timeout time.Duration) bool {
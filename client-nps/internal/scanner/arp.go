@@ -10,6 +10,7 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/gopacket"
@@ -23,8 +24,8 @@ type ARPDevice struct {
 	MAC string
 }
 
-// ARPScan 执行ARP扫描
-func ARPScan(subnet string, timeout time.Duration) ([]ARPDevice, error) {
+// ARPScan 执行ARP扫描（ctx 取消后尽快返回已收集到的部分结果）
+func ARPScan(ctx context.Context, subnet string, timeout time.Duration) ([]ARPDevice, error) {
 	// 解析网段
 	ip, ipnet, err := net.ParseCIDR(subnet)
 	if err != nil {
@@ -41,15 +42,17 @@ func ARPScan(subnet string, timeout time.Duration) ([]ARPDevice, error) {
 	handle, err := pcap.OpenLive(iface.Name, 1024, true, timeout)
 	if err != nil {
 		// 如果没有权限，使用简化方法
-		return arpScanSimple(ipnet, timeout)
+		return arpScanSimple(ctx, ipnet, timeout)
 	}
 	defer handle.Close()
 
+	var mu sync.Mutex
 	devices := make(map[string]string) // IP -> MAC
-	done := make(chan bool)
+	done := make(chan struct{})
 
-	// 启动抓包goroutine
+	// 启动抓包goroutine（handle 关闭后 Packets 通道结束）
 	go func() {
+		defer close(done)
 		packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
 		for packet := range packetSource.Packets() {
 			arpLayer := packet.Layer(layers.LayerTypeARP)
@@ -59,12 +62,13 @@ func ARPScan(subnet string, timeout time.Duration) ([]ARPDevice, error) {
 					srcIP := net.IP(arp.SourceProtAddress).String()
 					srcMAC := net.HardwareAddr(arp.SourceHwAddress).String()
 					if ipnet.Contains(net.ParseIP(srcIP)) {
+						mu.Lock()
 						devices[srcIP] = srcMAC
+						mu.Unlock()
 					}
 				}
 			}
 		}
-		done <- true
 	}()
 
 	// 发送ARP请求
@@ -80,6 +84,9 @@ func ARPScan(subnet string, timeout time.Duration) ([]ARPDevice, error) {
 
 	// 遍历网段内所有IP
 	for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); inc(ip) {
+		if ctx.Err() != nil {
+			break
+		}
 		// 跳过网络地址和广播地址
 		if isNetworkOrBroadcast(ip, ipnet) {
 			continue
@@ -93,10 +100,12 @@ func ARPScan(subnet string, timeout time.Duration) ([]ARPDevice, error) {
 	// 等待响应
 	select {
 	case <-done:
+	case <-ctx.Done():
 	case <-time.After(timeout):
 	}
 
 	// 转换为结果
+	mu.Lock()
 	result := make([]ARPDevice, 0, len(devices))
 	for ip, mac := range devices {
 		result = append(result, ARPDevice{
@@ -104,12 +113,13 @@ func ARPScan(subnet string, timeout time.Duration) ([]ARPDevice, error) {
 			MAC: mac,
 		})
 	}
+	mu.Unlock()
 
 	return result, nil
 }
 
 // arpScanSimple 简化的ARP扫描（当没有抓包权限时）
-func arpScanSimple(ipnet *net.IPNet, timeout time.Duration) ([]ARPDevice, error) {
+func arpScanSimple(parent context.Context, ipnet *net.IPNet, timeout time.Duration) ([]ARPDevice, error) {
 	// 无 pcap 权限时的兜底：
 	// 1) 并发 ICMP ping sweep 填充 ARP 表
 	// 2) 读取系统 ARP 表，回收子网内的 IP/MAC
@@ -132,12 +142,15 @@ func arpScanSimple(ipnet *net.IPNet, timeout time.Duration) ([]ARPDevice, error)
 		return []ARPDevice{}, nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(parent, timeout)
 	defer cancel()
 
 	ch := make(chan string)
+	var wg sync.WaitGroup
 	for i := 0; i < workers; i++ {
+		wg.Add(1)
 		go func() {
+			defer wg.Done()
 			for ip := range ch {
 				_ = pingOnce(ctx, ip)
 			}
@@ -154,6 +167,7 @@ func arpScanSimple(ipnet *net.IPNet, timeout time.Duration) ([]ARPDevice, error)
 	close(ch)
 
 done:
+	wg.Wait()
 	// 读取 ARP 表（批量），返回子网内的 IP/MAC
 	entries, _ := getARPTableEntries(ipnet)
 	devices := make([]ARPDevice, 0, len(entries))
@@ -0,0 +1,336 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"net"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+// NDPDevice IPv6 邻居发现得到的设备
+type NDPDevice struct {
+	IP        string // 不带 zone 的 IPv6 地址
+	MAC       string
+	Interface string
+}
+
+const (
+	defaultNDPTimeout = 3 * time.Second
+	icmpv6Protocol    = 58
+	ndpOptSourceLL    = 1 // Source Link-Layer Address
+	ndpOptTargetLL    = 2 // Target Link-Layer Address
+)
+
+var allNodesMulticast = net.ParseIP("ff02::1")
+
+// NDPScan 在指定接口上做 IPv6 邻居发现：
+// 1) 向 ff02::1 发 ICMPv6 组播 echo，让链路上所有节点回应；
+// 2) 对已知 MAC 推导的 EUI-64 链路本地地址发邻居请求（NS），即使设备不回 echo 也能拿到 NA；
+// 3) 最后读取系统邻居表（ip -6 neigh / ndp -an）补全 MAC 及全局/ULA 地址。
+// 无 raw socket 权限时退化为系统 ping6 + 读邻居表。
+func NDPScan(ctx context.Context, ifaceName string, knownMACs []string, timeout time.Duration) ([]NDPDevice, error) {
+	ifi, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = defaultNDPTimeout
+	}
+
+	var mu sync.Mutex
+	found := map[string]string{} // IP -> MAC（echo reply 时 MAC 先为空，稍后由邻居表补全）
+	add := func(ip net.IP, mac string) {
+		if ip == nil || isLocalAddr(ifi, ip) {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		key := ip.String()
+		if mac != "" || found[key] == "" {
+			found[key] = mac
+		}
+	}
+
+	if err := ndpProbeRaw(ctx, ifi, knownMACs, timeout, add); err != nil {
+		// 没有 raw socket 权限：用系统 ping6 触发邻居表学习
+		ndpProbeSimple(ctx, ifi.Name, timeout)
+	}
+
+	neigh, err := readNeighborTable6(ifi.Name)
+	if err == nil {
+		for ip, mac := range neigh {
+			add(net.ParseIP(ip), mac)
+		}
+	}
+
+	out := make([]NDPDevice, 0, len(found))
+	for ip, mac := range found {
+		if mac == "" {
+			continue // 无法关联到 MAC 的地址不入库
+		}
+		out = append(out, NDPDevice{IP: ip, MAC: mac, Interface: ifi.Name})
+	}
+	return out, nil
+}
+
+// ndpProbeRaw 通过 ICMPv6 raw socket 发送组播 echo 和邻居请求并收集回应
+func ndpProbeRaw(ctx context.Context, ifi *net.Interface, knownMACs []string, timeout time.Duration, add func(net.IP, string)) error {
+	conn, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	pc := conn.IPv6PacketConn()
+	_ = pc.SetMulticastInterface(ifi)
+	_ = pc.SetMulticastLoopback(false)
+	// NDP 报文要求 hop limit = 255（RFC 4861）
+	_ = pc.SetHopLimit(255)
+	_ = pc.SetMulticastHopLimit(255)
+
+	var f ipv6.ICMPFilter
+	f.SetAll(true)
+	f.Accept(ipv6.ICMPTypeEchoReply)
+	f.Accept(ipv6.ICMPTypeNeighborAdvertisement)
+	_ = pc.SetICMPFilter(&f)
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetReadDeadline(deadline)
+
+	// ctx 取消时立即结束读取
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	// 1. 组播 echo
+	echo := icmp.Message{
+		Type: ipv6.ICMPTypeEchoRequest,
+		Body: &icmp.Echo{ID: int(time.Now().UnixNano() & 0xffff), Seq: 1, Data: []byte("nwct-ndp")},
+	}
+	if wire, err := echo.Marshal(nil); err == nil {
+		_, _ = conn.WriteTo(wire, &net.IPAddr{IP: allNodesMulticast, Zone: ifi.Name})
+	}
+
+	// 2. 对已知 MAC 的 EUI-64 链路本地地址发 NS
+	for _, mac := range knownMACs {
+		if ctx.Err() != nil {
+			break
+		}
+		target := eui64LinkLocal(mac)
+		if target == nil {
+			continue
+		}
+		if wire := neighborSolicitation(target, ifi.HardwareAddr); wire != nil {
+			_, _ = conn.WriteTo(wire, &net.IPAddr{IP: solicitedNodeMulticast(target), Zone: ifi.Name})
+		}
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil // 超时或 ctx 取消：正常结束
+		}
+		msg, err := icmp.ParseMessage(icmpv6Protocol, buf[:n])
+		if err != nil {
+			continue
+		}
+		switch msg.Type {
+		case ipv6.ICMPTypeEchoReply:
+			if pa, ok := peer.(*net.IPAddr); ok {
+				add(pa.IP, "")
+			}
+		case ipv6.ICMPTypeNeighborAdvertisement:
+			if body, ok := msg.Body.(*icmp.RawBody); ok {
+				if ip, mac := parseNeighborAdvertisement(body.Data); ip != nil {
+					add(ip, mac)
+				}
+			}
+		}
+	}
+}
+
+// ndpProbeSimple 用系统 ping 向 ff02::1 发组播 echo（仅用于触发内核邻居表学习）
+func ndpProbeSimple(ctx context.Context, ifaceName string, timeout time.Duration) {
+	pctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.CommandContext(pctx, "ping6", "-c", "2", "-I", ifaceName, "ff02::1")
+	default:
+		cmd = exec.CommandContext(pctx, "ping", "-6", "-c", "2", "-I", ifaceName, "ff02::1")
+	}
+	_ = cmd.Run()
+}
+
+// neighborSolicitation 构造 NS 报文（校验和由内核填写）
+func neighborSolicitation(target net.IP, srcMAC net.HardwareAddr) []byte {
+	data := make([]byte, 4+16)
+	copy(data[4:], target.To16())
+	if len(srcMAC) == 6 {
+		data = append(data, ndpOptSourceLL, 1)
+		data = append(data, srcMAC...)
+	}
+	msg := icmp.Message{Type: ipv6.ICMPTypeNeighborSolicitation, Body: &icmp.RawBody{Data: data}}
+	wire, err := msg.Marshal(nil)
+	if err != nil {
+		return nil
+	}
+	return wire
+}
+
+// parseNeighborAdvertisement 解析 NA：Flags(4) + Target(16) + Options
+func parseNeighborAdvertisement(data []byte) (net.IP, string) {
+	if len(data) < 20 {
+		return nil, ""
+	}
+	target := net.IP(append([]byte(nil), data[4:20]...))
+	opts := data[20:]
+	for len(opts) >= 8 {
+		typ, l := opts[0], int(opts[1])*8
+		if l == 0 || l > len(opts) {
+			break
+		}
+		if typ == ndpOptTargetLL && l >= 8 {
+			return target, normalizeMAC(net.HardwareAddr(opts[2:8]).String())
+		}
+		opts = opts[l:]
+	}
+	return target, ""
+}
+
+// eui64LinkLocal 由 MAC 推导 EUI-64 链路本地地址（fe80::/64）
+func eui64LinkLocal(mac string) net.IP {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return nil
+	}
+	ip := make(net.IP, net.IPv6len)
+	ip[0], ip[1] = 0xfe, 0x80
+	ip[8] = hw[0] ^ 0x02
+	ip[9], ip[10] = hw[1], hw[2]
+	ip[11], ip[12] = 0xff, 0xfe
+	ip[13], ip[14], ip[15] = hw[3], hw[4], hw[5]
+	return ip
+}
+
+// solicitedNodeMulticast ff02::1:ffXX:XXXX
+func solicitedNodeMulticast(ip net.IP) net.IP {
+	ip16 := ip.To16()
+	out := make(net.IP, net.IPv6len)
+	binary.BigEndian.PutUint16(out[0:2], 0xff02)
+	out[11] = 0x01
+	out[12] = 0xff
+	copy(out[13:], ip16[13:])
+	return out
+}
+
+func isLocalAddr(ifi *net.Interface, ip net.IP) bool {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok && ipn.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// readNeighborTable6 读取系统 IPv6 邻居表（IP -> MAC），ifaceName 为空时返回全部接口
+func readNeighborTable6(ifaceName string) (map[string]string, error) {
+	switch runtime.GOOS {
+	case "linux":
+		return readLinuxNeighborTable6(ifaceName)
+	case "darwin":
+		return readDarwinNeighborTable6(ifaceName)
+	default:
+		return map[string]string{}, nil
+	}
+}
+
+func readLinuxNeighborTable6(ifaceName string) (map[string]string, error) {
+	// ip -6 neigh 输出类似：
+	// fe80::1 dev eth0 lladdr 18:aa:0f:f7:9e:62 router REACHABLE
+	// fd00::10 dev eth0 lladdr 3c:22:fb:01:02:03 STALE
+	// fe80::5 dev eth0 FAILED
+	args := []string{"-6", "neigh", "show"}
+	if ifaceName != "" {
+		args = append(args, "dev", ifaceName)
+	}
+	b, err := exec.Command("ip", args...).Output()
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]string{}
+	sc := bufio.NewScanner(strings.NewReader(string(b)))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil || ip.To4() != nil || ip.IsMulticast() {
+			continue
+		}
+		mac := ""
+		for i := 1; i+1 < len(fields); i++ {
+			if fields[i] == "lladdr" {
+				mac = normalizeMAC(fields[i+1])
+				break
+			}
+		}
+		if mac == "" || mac == "00:00:00:00:00:00" {
+			continue
+		}
+		out[ip.String()] = mac
+	}
+	return out, sc.Err()
+}
+
+func readDarwinNeighborTable6(ifaceName string) (map[string]string, error) {
+	// ndp -an 输出类似：
+	// Neighbor                        Linklayer Address  Netif Expire    St Flgs Prbs
+	// fe80::1%en0                     18:aa:f:f7:9e:62   en0 23h59m58s S  R
+	b, err := exec.Command("ndp", "-an").Output()
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]string{}
+	sc := bufio.NewScanner(strings.NewReader(string(b)))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		if ifaceName != "" && fields[2] != ifaceName {
+			continue
+		}
+		addr := fields[0]
+		if i := strings.IndexByte(addr, '%'); i >= 0 {
+			addr = addr[:i]
+		}
+		ip := net.ParseIP(addr)
+		if ip == nil || ip.To4() != nil || ip.IsMulticast() {
+			continue
+		}
+		mac := normalizeMAC(fields[1])
+		if mac == "" || mac == "00:00:00:00:00:00" {
+			continue
+		}
+		out[ip.String()] = mac
+	}
+	return out, sc.Err()
+}
@@ -0,0 +1,555 @@
+package scanner
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"nwct/client-nps/config"
+	"nwct/client-nps/internal/database"
+	"nwct/client-nps/internal/logger"
+	"nwct/client-nps/internal/realtime"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// PassiveStatus 被动发现状态
+type PassiveStatus struct {
+	Enabled    bool      `json:"enabled"`
+	Running    bool      `json:"running"`
+	Interfaces []string  `json:"interfaces"`
+	Packets    int64     `json:"packets"`     // 抓到的 ARP/DHCP/mDNS 报文数
+	Observed   int64     `json:"observed"`    // 写入数据库的观测次数
+	NewDevices int64     `json:"new_devices"` // 被动发现的新设备数
+	LastEvent  time.Time `json:"last_event"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+// passiveObservation 一次被动观测（来自 ARP/DHCP/mDNS 报文）
+type passiveObservation struct {
+	Source      string // arp, dhcp, mdns
+	IP          string
+	MAC         string
+	Interface   string
+	Hostname    string
+	VendorClass string // DHCP option 60
+	ParamList   []byte // DHCP option 55
+	DHCPMessage string // DISCOVER / REQUEST / INFORM
+}
+
+// passiveHint 同一 MAC 的 DHCP/mDNS 线索（DHCP DISCOVER 时还没有 IP，先缓存，拿到 IP 后再入库）
+type passiveHint struct {
+	Hostname    string
+	VendorClass string
+	ParamList   []byte
+	DHCPMessage string
+	lastWrite   time.Time
+	lastIP      string
+	lastSeen    time.Time
+}
+
+// PassiveListener 被动发现：抓取 ARP 通告、DHCP DISCOVER/REQUEST、mDNS 通告，实时更新设备表
+// 与调度器一样每次 tick 读取 ScannerConfig.Passive，开关无需重启
+type PassiveListener struct {
+	mu       sync.Mutex
+	cfg      *config.Config
+	db       *sql.DB
+	resolver SubnetResolver
+
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	running []string          // 正在抓包的接口
+	subnets map[string]string // 接口 -> 网段
+	hints   map[string]*passiveHint
+	status  PassiveStatus
+}
+
+const (
+	passiveTick          = 10 * time.Second
+	passiveWriteInterval = 60 * time.Second // 同一设备无新信息时最多每分钟写一次库
+	passiveBPF           = "arp or (udp and (port 67 or port 68 or port 5353))"
+	passiveScanID        = "passive" // 被动发现写入 scan_events 时使用的 scan_id
+	// 线索缓存：超过 passiveHintTTL 未再出现的 MAC 被清理，总数超过 maxPassiveHints 时淘汰最久未出现的
+	passiveHintTTL  = 24 * time.Hour
+	maxPassiveHints = 4096
+)
+
+var (
+	globalPassive *PassiveListener
+	passiveMu     sync.RWMutex
+)
+
+// StartPassiveListener 启动被动发现（ctx 取消后退出）
+func StartPassiveListener(ctx context.Context, cfg *config.Config, db *sql.DB, resolver SubnetResolver) *PassiveListener {
+	pl := &PassiveListener{
+		cfg:      cfg,
+		db:       db,
+		resolver: resolver,
+		subnets:  map[string]string{},
+		hints:    map[string]*passiveHint{},
+	}
+
+	passiveMu.Lock()
+	globalPassive = pl
+	passiveMu.Unlock()
+
+	go pl.loop(ctx)
+	return pl
+}
+
+// DefaultPassiveListener 返回已启动的被动发现（未启动时为 nil）
+func DefaultPassiveListener() *PassiveListener {
+	passiveMu.RLock()
+	defer passiveMu.RUnlock()
+	return globalPassive
+}
+
+// Status 获取被动发现状态快照
+func (pl *PassiveListener) Status() PassiveStatus {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	st := pl.status
+	st.Enabled = pl.enabled()
+	st.Running = len(pl.running) > 0
+	st.Interfaces = append([]string(nil), pl.running...)
+	return st
+}
+
+func (pl *PassiveListener) enabled() bool {
+	return pl.cfg != nil && pl.cfg.ScannerSettings().Passive
+}
+
+func (pl *PassiveListener) loop(ctx context.Context) {
+	ticker := time.NewTicker(passiveTick)
+	defer ticker.Stop()
+	defer pl.stop()
+
+	for {
+		pl.reconcile(ctx)
+		pl.mu.Lock()
+		pl.evictHintsLocked(time.Now(), maxPassiveHints)
+		pl.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcile 按配置启动/停止抓包；接口集合变化（网卡插拔/配置修改）时重启
+func (pl *PassiveListener) reconcile(ctx context.Context) {
+	var want []string
+	subnets := map[string]string{}
+	if pl.enabled() {
+		want, subnets = pl.interfaces()
+	}
+
+	pl.mu.Lock()
+	same := strings.Join(want, ",") == strings.Join(pl.running, ",")
+	pl.mu.Unlock()
+	if same {
+		return
+	}
+
+	pl.stop()
+	if len(want) == 0 {
+		return
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	pl.mu.Lock()
+	pl.cancel = cancel
+	pl.running = want
+	pl.subnets = subnets
+	pl.status.LastError = ""
+	pl.mu.Unlock()
+
+	logger.Info("被动发现启动: interfaces=%v", want)
+	for _, name := range want {
+		pl.wg.Add(1)
+		go func(name string) {
+			defer pl.wg.Done()
+			if err := pl.capture(cctx, name); err != nil {
+				logger.Warn("被动发现抓包失败: iface=%s err=%v", name, err)
+				pl.mu.Lock()
+				pl.status.LastError = name + ": " + err.Error()
+				pl.mu.Unlock()
+			}
+		}(name)
+	}
+}
+
+func (pl *PassiveListener) stop() {
+	pl.mu.Lock()
+	cancel := pl.cancel
+	pl.cancel = nil
+	wasRunning := len(pl.running) > 0
+	pl.running = nil
+	pl.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	pl.wg.Wait()
+	if wasRunning {
+		logger.Info("被动发现已停止")
+	}
+}
+
+// interfaces 要监听的接口：优先 ScannerConfig.PassiveInterfaces，否则与扫描目标一致
+func (pl *PassiveListener) interfaces() ([]string, map[string]string) {
+	subnets := map[string]string{}
+	if pl.resolver != nil {
+		if targets, err := pl.resolver(); err == nil {
+			for _, t := range targets {
+				if t.Interface != "" {
+					subnets[t.Interface] = t.Subnet
+				}
+			}
+		}
+	}
+
+	names := []string{}
+	if configured := pl.cfg.ScannerSettings().PassiveInterfaces; len(configured) > 0 {
+		for _, n := range configured {
+			if n = strings.TrimSpace(n); n != "" {
+				names = append(names, n)
+			}
+		}
+	} else {
+		for n := range subnets {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names, subnets
+}
+
+// capture 在单个接口上抓包直到 ctx 取消
+func (pl *PassiveListener) capture(ctx context.Context, ifaceName string) error {
+	handle, err := pcap.OpenLive(ifaceName, 1600, false, 500*time.Millisecond)
+	if err != nil {
+		return err
+	}
+	if err := handle.SetBPFFilter(passiveBPF); err != nil {
+		handle.Close()
+		return err
+	}
+
+	// handle 关闭后 Packets 通道结束
+	var closeOnce sync.Once
+	closeHandle := func() { closeOnce.Do(handle.Close) }
+	stop := context.AfterFunc(ctx, closeHandle)
+	defer stop()
+	defer closeHandle()
+
+	local := localMACs()
+	src := gopacket.NewPacketSource(handle, handle.LinkType())
+	for packet := range src.Packets() {
+		if ctx.Err() != nil {
+			return nil
+		}
+		for _, obs := range parsePassivePacket(packet) {
+			if local[obs.MAC] {
+				continue
+			}
+			obs.Interface = ifaceName
+			pl.observe(obs)
+		}
+	}
+	return nil
+}
+
+// parsePassivePacket 从报文中提取观测：ARP 发送方、DHCP 客户端请求、mDNS 响应中的 A 记录
+func parsePassivePacket(packet gopacket.Packet) []passiveObservation {
+	var srcMAC string
+	if eth, ok := packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet); ok {
+		srcMAC = normalizeMAC(eth.SrcMAC.String())
+	}
+
+	if arp, ok := packet.Layer(layers.LayerTypeARP).(*layers.ARP); ok {
+		ip := net.IP(arp.SourceProtAddress)
+		// ARP probe 的发送方 IP 为 0.0.0.0，地址尚未确定
+		if ip.IsUnspecified() {
+			return nil
+		}
+		return []passiveObservation{{
+			Source: "arp",
+			IP:     ip.String(),
+			MAC:    normalizeMAC(net.HardwareAddr(arp.SourceHwAddress).String()),
+		}}
+	}
+
+	if dhcp, ok := packet.Layer(layers.LayerTypeDHCPv4).(*layers.DHCPv4); ok {
+		if dhcp.Operation != layers.DHCPOpRequest {
+			return nil
+		}
+		obs := passiveObservation{Source: "dhcp", MAC: normalizeMAC(dhcp.ClientHWAddr.String())}
+		if dhcp.ClientIP != nil && !dhcp.ClientIP.IsUnspecified() {
+			obs.IP = dhcp.ClientIP.String()
+		}
+		for _, opt := range dhcp.Options {
+			switch opt.Type {
+			case layers.DHCPOptMessageType:
+				if len(opt.Data) == 1 {
+					obs.DHCPMessage = strings.ToUpper(layers.DHCPMsgType(opt.Data[0]).String())
+				}
+			case layers.DHCPOptHostname:
+				obs.Hostname = strings.TrimSpace(string(opt.Data))
+			case layers.DHCPOptClassID:
+				obs.VendorClass = strings.TrimSpace(string(opt.Data))
+			case layers.DHCPOptParamsRequest:
+				obs.ParamList = append([]byte(nil), opt.Data...)
+			case layers.DHCPOptRequestIP:
+				if obs.IP == "" && len(opt.Data) == 4 {
+					obs.IP = net.IP(opt.Data).String()
+				}
+			}
+		}
+		switch obs.DHCPMessage {
+		case "DISCOVER", "REQUEST", "INFORM":
+			return []passiveObservation{obs}
+		}
+		return nil
+	}
+
+	// mDNS（UDP 5353）gopacket 不会自动解码为 DNS，这里手动解析载荷
+	udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok || udp.SrcPort != 5353 {
+		return nil
+	}
+	ip4, ok := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	if !ok {
+		return nil
+	}
+	var dns layers.DNS
+	if err := dns.DecodeFromBytes(udp.Payload, gopacket.NilDecodeFeedback); err != nil || !dns.QR {
+		return nil
+	}
+	obs := passiveObservation{Source: "mdns", IP: ip4.SrcIP.String(), MAC: srcMAC}
+	for _, rr := range append(dns.Answers, dns.Additionals...) {
+		if rr.Type == layers.DNSTypeA && rr.IP.Equal(ip4.SrcIP) {
+			name := strings.TrimSuffix(string(rr.Name), ".")
+			obs.Hostname = strings.TrimSuffix(name, ".local")
+			break
+		}
+	}
+	return []passiveObservation{obs}
+}
+
+// evictHintsLocked 清理过期的线索，并把数量限制在 limit 以内（淘汰最久未出现的），调用方需持有 pl.mu
+func (pl *PassiveListener) evictHintsLocked(now time.Time, limit int) {
+	for mac, h := range pl.hints {
+		if now.Sub(h.lastSeen) > passiveHintTTL {
+			delete(pl.hints, mac)
+		}
+	}
+	if len(pl.hints) <= limit {
+		return
+	}
+	macs := make([]string, 0, len(pl.hints))
+	for mac := range pl.hints {
+		macs = append(macs, mac)
+	}
+	sort.Slice(macs, func(i, j int) bool { return pl.hints[macs[i]].lastSeen.Before(pl.hints[macs[j]].lastSeen) })
+	for _, mac := range macs[:len(macs)-limit] {
+		delete(pl.hints, mac)
+	}
+}
+
+// observe 合并线索并按节流写库；新设备记录 device_new 事件
+func (pl *PassiveListener) observe(obs passiveObservation) {
+	if obs.MAC == "" || obs.MAC == "FF:FF:FF:FF:FF:FF" || obs.MAC == "00:00:00:00:00:00" {
+		return
+	}
+
+	pl.mu.Lock()
+	pl.status.Packets++
+	h := pl.hints[obs.MAC]
+	if h == nil {
+		if len(pl.hints) >= maxPassiveHints {
+			pl.evictHintsLocked(time.Now(), maxPassiveHints-1)
+		}
+		h = &passiveHint{}
+		pl.hints[obs.MAC] = h
+	}
+	h.lastSeen = time.Now()
+	changed := false
+	if obs.Hostname != "" && obs.Hostname != h.Hostname {
+		h.Hostname, changed = obs.Hostname, true
+	}
+	if obs.VendorClass != "" && obs.VendorClass != h.VendorClass {
+		h.VendorClass, changed = obs.VendorClass, true
+	}
+	if len(obs.ParamList) > 0 && string(obs.ParamList) != string(h.ParamList) {
+		h.ParamList, changed = obs.ParamList, true
+	}
+	if obs.DHCPMessage != "" {
+		h.DHCPMessage = obs.DHCPMessage
+	}
+	if obs.IP != "" && obs.IP != h.lastIP {
+		changed = true
+	}
+	due := changed || time.Since(h.lastWrite) >= passiveWriteInterval
+	merged := *h
+	subnet := pl.subnets[obs.Interface]
+	pl.mu.Unlock()
+
+	if !due {
+		return
+	}
+
+	// DHCP DISCOVER 时还没有地址：已知设备用其当前地址补充信息，未知设备等 ARP/REQUEST 再入库
+	prev, _ := database.GetDevice(pl.db, database.DeviceIDFor(obs.MAC, obs.IP))
+	if prev == nil && obs.IP != "" {
+		// 之前只按地址入库（ip_<addr>）的设备，SaveDevice 会改为 MAC 标识，不是新设备
+		prev, _ = database.GetDevice(pl.db, database.DeviceIDFor("", obs.IP))
+	}
+	if obs.IP == "" {
+		if prev == nil {
+			return
+		}
+		obs.IP = prev.IP
+	}
+
+	dev := &database.Device{
+		IP:        obs.IP,
+		MAC:       obs.MAC,
+		Name:      merged.Hostname,
+		Vendor:    identifyVendor(obs.MAC),
+		Type:      identifyDeviceType(nil),
+		OS:        identifyOS(nil),
+		Interface: obs.Interface,
+		Subnet:    subnet,
+		Status:    "online",
+	}
+	evidence := map[string]any{}
+	if prev != nil {
+		// 被动观测信息较少，不覆盖主动扫描得到的类型/系统/厂商（DHCP 指纹除外，见下）
+		dev.Type, dev.OS, dev.Model = prev.Type, prev.OS, prev.Model
+		if prev.Vendor != "" && !strings.EqualFold(prev.Vendor, "unknown") {
+			dev.Vendor = prev.Vendor
+		}
+		if prev.Extra != "" {
+			_ = json.Unmarshal([]byte(prev.Extra), &evidence)
+		}
+	}
+	passive := map[string]any{
+		"source":  obs.Source,
+		"seen_at": time.Now().Format(time.RFC3339),
+	}
+	if merged.Hostname != "" {
+		passive["hostname"] = merged.Hostname
+	}
+	if merged.VendorClass != "" {
+		passive["vendor_class"] = merged.VendorClass
+	}
+	if len(merged.ParamList) > 0 {
+		passive["param_request_list"] = formatParamList(merged.ParamList)
+	}
+	if merged.DHCPMessage != "" {
+		passive["dhcp_message"] = merged.DHCPMessage
+	}
+	evidence["passive"] = passive
+	applyDHCPFingerprint(dhcpFingerprintFromEvidence(evidence), &dev.Type, &dev.OS, evidence)
+	applyRuleResult(matchRules(pl.db, prev, dev.MAC, dev.Name, nil, evidence), &dev.Vendor, &dev.Model, &dev.Type, &dev.OS, &dev.Name, evidence)
+	if b, err := json.Marshal(evidence); err == nil {
+		dev.Extra = string(b)
+	}
+
+	if err := database.SaveDevice(pl.db, dev); err != nil {
+		logger.Error("被动发现：保存设备失败: mac=%s err=%v", obs.MAC, err)
+		return
+	}
+
+	now := time.Now()
+	pl.mu.Lock()
+	h.lastWrite = now
+	h.lastIP = obs.IP
+	pl.status.Observed++
+	pl.status.LastEvent = now
+	if prev == nil {
+		pl.status.NewDevices++
+	}
+	pl.mu.Unlock()
+
+	if prev == nil {
+		logger.Info("被动发现新设备: ip=%s mac=%s source=%s name=%s", dev.IP, dev.MAC, obs.Source, dev.Name)
+		ev := &database.ScanEvent{
+			ScanID:   passiveScanID,
+			Event:    "device_new",
+			DeviceID: dev.ID,
+			DeviceIP: dev.IP,
+			MAC:      dev.MAC,
+			Name:     dev.Name,
+			Vendor:   dev.Vendor,
+		}
+		if err := database.SaveScanEvent(pl.db, ev); err != nil {
+			logger.Error("记录扫描事件失败: %v", err)
+		}
+		realtime.Default().Broadcast("scan_event", map[string]interface{}{
+			"scan_id": passiveScanID,
+			"event":   "device_new",
+			"id":      dev.ID,
+			"ip":      dev.IP,
+			"mac":     dev.MAC,
+			"name":    dev.Name,
+			"vendor":  dev.Vendor,
+			"source":  obs.Source,
+			"ts":      ev.Timestamp.Format(time.RFC3339),
+		})
+	}
+
+	ann, _ := database.GetDeviceAnnotation(pl.db, dev.ID)
+	realtime.Default().Broadcast("device_upsert", map[string]interface{}{
+		"id":        dev.ID,
+		"ip":        dev.IP,
+		"mac":       dev.MAC,
+		"name":      dev.Name,
+		"vendor":    dev.Vendor,
+		"type":      dev.Type,
+		"os":        dev.OS,
+		"interface": dev.Interface,
+		"subnet":    dev.Subnet,
+		"status":    dev.Status,
+		"alias":     ann.Alias,
+		"owner":     ann.Owner,
+		"location":  ann.Location,
+		"tags":      ann.Tags,
+		"source":    "passive",
+		"last_seen": now.Format(time.RFC3339),
+	})
+}
+
+// formatParamList DHCP option 55 格式化为 "1,3,6,15"（常见指纹库的写法）
+func formatParamList(b []byte) string {
+	parts := make([]string, len(b))
+	for i, v := range b {
+		parts[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(parts, ",")
+}
+
+// localMACs 本机网卡 MAC（自己发出的 ARP/mDNS 不算发现）
+func localMACs() map[string]bool {
+	out := map[string]bool{}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return out
+	}
+	for _, ifi := range ifaces {
+		if m := normalizeMAC(ifi.HardwareAddr.String()); m != "" {
+			out[m] = true
+		}
+	}
+	return out
+}
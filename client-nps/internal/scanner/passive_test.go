@@ -0,0 +1,191 @@
+package scanner
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestEvictHints(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		ages  []time.Duration // 各 MAC 距上次出现的时间
+		limit int
+		want  []int // 保留的下标
+	}{
+		{name: "未超限不清理", ages: []time.Duration{time.Minute, time.Hour}, limit: 10, want: []int{0, 1}},
+		{name: "过期清理", ages: []time.Duration{time.Minute, passiveHintTTL + time.Second}, limit: 10, want: []int{0}},
+		{name: "超限淘汰最久未出现", ages: []time.Duration{3 * time.Hour, time.Minute, 2 * time.Hour, time.Hour}, limit: 2, want: []int{1, 3}},
+		{name: "限制为 0", ages: []time.Duration{time.Minute}, limit: 0, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pl := &PassiveListener{hints: map[string]*passiveHint{}}
+			for i, age := range tt.ages {
+				pl.hints[fmt.Sprintf("02:00:00:00:00:%02x", i)] = &passiveHint{lastSeen: now.Add(-age)}
+			}
+			pl.evictHintsLocked(now, tt.limit)
+			if len(pl.hints) != len(tt.want) {
+				t.Fatalf("剩余 %d 条线索，期望 %d", len(pl.hints), len(tt.want))
+			}
+			for _, i := range tt.want {
+				if pl.hints[fmt.Sprintf("02:00:00:00:00:%02x", i)] == nil {
+					t.Fatalf("第 %d 条线索被错误淘汰", i)
+				}
+			}
+		})
+	}
+}
+
+var (
+	testClientMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x42}
+	testBroadcast = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+)
+
+// serializePacket 按以太网帧序列化各层（自动计算长度与校验和）后重新解码，与抓包得到的数据一致
+func serializePacket(t *testing.T, ls ...gopacket.SerializableLayer) gopacket.Packet {
+	t.Helper()
+	for _, l := range ls {
+		if udp, ok := l.(*layers.UDP); ok {
+			for _, n := range ls {
+				if ip4, ok := n.(*layers.IPv4); ok {
+					udp.SetNetworkLayerForChecksum(ip4)
+				}
+			}
+		}
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ls...); err != nil {
+		t.Fatalf("序列化报文失败: %v", err)
+	}
+	return gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+}
+
+func arpPacket(t *testing.T, srcIP string) gopacket.Packet {
+	return serializePacket(t,
+		&layers.Ethernet{SrcMAC: testClientMAC, DstMAC: testBroadcast, EthernetType: layers.EthernetTypeARP},
+		&layers.ARP{
+			AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4,
+			HwAddressSize: 6, ProtAddressSize: 4, Operation: layers.ARPRequest,
+			SourceHwAddress: testClientMAC, SourceProtAddress: net.ParseIP(srcIP).To4(),
+			DstHwAddress: make([]byte, 6), DstProtAddress: net.ParseIP("192.168.1.1").To4(),
+		})
+}
+
+func dhcpPacket(t *testing.T, op layers.DHCPOp, msg layers.DHCPMsgType, opts ...layers.DHCPOption) gopacket.Packet {
+	dhcp := &layers.DHCPv4{
+		Operation: op, HardwareType: layers.LinkTypeEthernet, HardwareLen: 6,
+		Xid: 0x1234, ClientIP: net.IPv4zero, YourClientIP: net.IPv4zero, NextServerIP: net.IPv4zero, RelayAgentIP: net.IPv4zero,
+		ClientHWAddr: testClientMAC,
+		Options:      append(layers.DHCPOptions{layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(msg)})}, opts...),
+	}
+	srcPort, dstPort := layers.UDPPort(68), layers.UDPPort(67)
+	if op == layers.DHCPOpReply {
+		srcPort, dstPort = 67, 68
+	}
+	return serializePacket(t,
+		&layers.Ethernet{SrcMAC: testClientMAC, DstMAC: testBroadcast, EthernetType: layers.EthernetTypeIPv4},
+		&layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IPv4zero.To4(), DstIP: net.IPv4bcast.To4()},
+		&layers.UDP{SrcPort: srcPort, DstPort: dstPort},
+		dhcp)
+}
+
+func mdnsPacket(t *testing.T, srcIP string, dns *layers.DNS) gopacket.Packet {
+	return serializePacket(t,
+		&layers.Ethernet{SrcMAC: testClientMAC, DstMAC: net.HardwareAddr{0x01, 0x00, 0x5e, 0x00, 0x00, 0xfb}, EthernetType: layers.EthernetTypeIPv4},
+		&layers.IPv4{Version: 4, TTL: 255, Protocol: layers.IPProtocolUDP, SrcIP: net.ParseIP(srcIP).To4(), DstIP: net.ParseIP("224.0.0.251").To4()},
+		&layers.UDP{SrcPort: 5353, DstPort: 5353},
+		dns)
+}
+
+func mdnsA(name, ip string) layers.DNSResourceRecord {
+	return layers.DNSResourceRecord{Name: []byte(name), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 120, IP: net.ParseIP(ip).To4()}
+}
+
+func TestParsePassivePacket(t *testing.T) {
+	mac := normalizeMAC(testClientMAC.String())
+	hostname := layers.NewDHCPOption(layers.DHCPOptHostname, []byte(" iPhone "))
+	vendor := layers.NewDHCPOption(layers.DHCPOptClassID, []byte("android-dhcp-13"))
+	params := layers.NewDHCPOption(layers.DHCPOptParamsRequest, []byte{1, 3, 6, 15, 119, 252})
+	requestIP := layers.NewDHCPOption(layers.DHCPOptRequestIP, net.ParseIP("192.168.1.42").To4())
+
+	tests := []struct {
+		name   string
+		packet gopacket.Packet
+		want   []passiveObservation
+	}{
+		{
+			name:   "ARP 记录发送方地址",
+			packet: arpPacket(t, "192.168.1.42"),
+			want:   []passiveObservation{{Source: "arp", IP: "192.168.1.42", MAC: mac}},
+		},
+		{
+			name:   "ARP probe 发送方为 0.0.0.0 时跳过",
+			packet: arpPacket(t, "0.0.0.0"),
+		},
+		{
+			name:   "DHCP DISCOVER 提取主机名、厂商类别和参数列表",
+			packet: dhcpPacket(t, layers.DHCPOpRequest, layers.DHCPMsgTypeDiscover, hostname, vendor, params),
+			want: []passiveObservation{{
+				Source: "dhcp", MAC: mac, Hostname: "iPhone", VendorClass: "android-dhcp-13",
+				ParamList: []byte{1, 3, 6, 15, 119, 252}, DHCPMessage: "DISCOVER",
+			}},
+		},
+		{
+			name:   "DHCP REQUEST 取 option 50 请求的地址",
+			packet: dhcpPacket(t, layers.DHCPOpRequest, layers.DHCPMsgTypeRequest, requestIP),
+			want:   []passiveObservation{{Source: "dhcp", IP: "192.168.1.42", MAC: mac, DHCPMessage: "REQUEST"}},
+		},
+		{
+			name:   "DHCP INFORM",
+			packet: dhcpPacket(t, layers.DHCPOpRequest, layers.DHCPMsgTypeInform),
+			want:   []passiveObservation{{Source: "dhcp", MAC: mac, DHCPMessage: "INFORM"}},
+		},
+		{
+			name:   "DHCP RELEASE 不记录",
+			packet: dhcpPacket(t, layers.DHCPOpRequest, layers.DHCPMsgTypeRelease, hostname),
+		},
+		{
+			name:   "服务器的 DHCP OFFER 不记录",
+			packet: dhcpPacket(t, layers.DHCPOpReply, layers.DHCPMsgTypeOffer, hostname),
+		},
+		{
+			name: "mDNS 应答取与源地址一致的 A 记录主机名",
+			packet: mdnsPacket(t, "192.168.1.42", &layers.DNS{
+				QR: true, AA: true,
+				Answers:     []layers.DNSResourceRecord{mdnsA("other.local", "192.168.1.7"), mdnsA("Living-Room-TV.local", "192.168.1.42")},
+				Additionals: []layers.DNSResourceRecord{mdnsA("ignored.local", "192.168.1.42")},
+			}),
+			want: []passiveObservation{{Source: "mdns", IP: "192.168.1.42", MAC: mac, Hostname: "Living-Room-TV"}},
+		},
+		{
+			name: "mDNS 附加记录中的 A 记录",
+			packet: mdnsPacket(t, "192.168.1.42", &layers.DNS{
+				QR: true, AA: true,
+				Additionals: []layers.DNSResourceRecord{mdnsA("printer.local", "192.168.1.42")},
+			}),
+			want: []passiveObservation{{Source: "mdns", IP: "192.168.1.42", MAC: mac, Hostname: "printer"}},
+		},
+		{
+			name: "mDNS 查询不记录",
+			packet: mdnsPacket(t, "192.168.1.42", &layers.DNS{
+				Questions: []layers.DNSQuestion{{Name: []byte("_airplay._tcp.local"), Type: layers.DNSTypePTR, Class: layers.DNSClassIN}},
+			}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parsePassivePacket(tt.packet)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parsePassivePacket = %+v，期望 %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,578 @@
+package scanner
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/goccy/go-yaml"
+
+	"nwct/client-nps/config"
+	"nwct/client-nps/internal/database"
+	"nwct/client-nps/internal/fingerprint"
+	"nwct/client-nps/internal/logger"
+)
+
+// IdentifyRule 自定义设备识别规则：Match 中填写的条件全部满足时，用 Set 覆盖识别结果
+// 多条规则命中时按 Priority（大者优先）逐字段取值
+type IdentifyRule struct {
+	ID       string     `json:"id"`
+	Name     string     `json:"name,omitempty"`
+	Disabled bool       `json:"disabled,omitempty"`
+	Priority int        `json:"priority"`
+	Match    RuleMatch  `json:"match"`
+	Set      RuleAction `json:"set"`
+}
+
+// RuleMatch 规则条件；字符串条件默认不区分大小写的包含匹配，以 "re:" 开头时按正则匹配
+type RuleMatch struct {
+	MACPrefix         []string `json:"mac_prefix,omitempty"` // "AA:BB:CC" / "aabbcc"，任一命中
+	OUIVendor         string   `json:"oui_vendor,omitempty"` // OUI 厂商名
+	PortsAll          []int    `json:"ports_all,omitempty"`  // 全部开放
+	PortsAny          []int    `json:"ports_any,omitempty"`  // 任一开放
+	HTTPTitle         string   `json:"http_title,omitempty"`
+	HTTPServer        string   `json:"http_server,omitempty"`
+	SSDPServer        string   `json:"ssdp_server,omitempty"`
+	SSDPManufacturer  string   `json:"ssdp_manufacturer,omitempty"`
+	SSDPModel         string   `json:"ssdp_model,omitempty"`
+	SSDPDeviceType    string   `json:"ssdp_device_type,omitempty"`
+	ONVIFManufacturer string   `json:"onvif_manufacturer,omitempty"`
+	ONVIFModel        string   `json:"onvif_model,omitempty"`
+	Hostname          string   `json:"hostname,omitempty"` // 设备名 / mDNS / DHCP 主机名
+	DHCPVendorClass   string   `json:"dhcp_vendor_class,omitempty"`
+}
+
+// RuleAction 命中后写入的字段（空值不覆盖）
+type RuleAction struct {
+	Vendor string `json:"vendor,omitempty"`
+	Model  string `json:"model,omitempty"`
+	Type   string `json:"type,omitempty"`
+	OS     string `json:"os,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+// RuleInput 规则匹配的输入：设备基本信息 + extra 证据 JSON
+type RuleInput struct {
+	MAC   string
+	Name  string
+	Ports []int
+	Extra string
+}
+
+// RuleResult 规则匹配结果
+type RuleResult struct {
+	RuleAction
+	Matched []string `json:"matched"` // 命中的规则 ID（按优先级）
+}
+
+// RuleEngine 识别规则引擎：规则保存在本地 JSON/YAML 文件，可通过 API 增删
+type RuleEngine struct {
+	mu      sync.RWMutex
+	path    string
+	rules   []IdentifyRule
+	matched []*compiledRule // 已启用规则，按优先级排序
+	lastErr error
+}
+
+type compiledRule struct {
+	rule    *IdentifyRule
+	macs    []string
+	matches map[string]*regexp.Regexp // 正则条件
+}
+
+var (
+	defaultRulesOnce sync.Once
+	defaultRules     *RuleEngine
+)
+
+// DefaultRuleEngine 全局规则引擎；文件路径取 NWCT_RULES_PATH，默认与 config.json 同目录的 identify_rules.json
+func DefaultRuleEngine() *RuleEngine {
+	defaultRulesOnce.Do(func() {
+		path := strings.TrimSpace(os.Getenv("NWCT_RULES_PATH"))
+		if path == "" {
+			path = filepath.Join(filepath.Dir(config.GetConfigPath()), "identify_rules.json")
+		}
+		defaultRules = NewRuleEngine(path)
+		if err := defaultRules.Reload(); err != nil {
+			logger.Warn("加载识别规则失败: %v", err)
+		}
+	})
+	return defaultRules
+}
+
+func NewRuleEngine(path string) *RuleEngine {
+	return &RuleEngine{path: path, rules: []IdentifyRule{}}
+}
+
+// Path 规则文件路径
+func (e *RuleEngine) Path() string {
+	return e.path
+}
+
+// LastError 最近一次加载失败的原因（可能为空）
+func (e *RuleEngine) LastError() error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.lastErr
+}
+
+// Reload 从文件重新加载规则；文件不存在视为无规则
+func (e *RuleEngine) Reload() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	rules, err := e.readFile()
+	if err == nil {
+		err = e.setRulesLocked(rules)
+	}
+	e.lastErr = err
+	return err
+}
+
+// Rules 返回全部规则（含禁用的）
+func (e *RuleEngine) Rules() []IdentifyRule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	out := make([]IdentifyRule, len(e.rules))
+	copy(out, e.rules)
+	return out
+}
+
+// Upsert 新增或更新规则（按 ID）并写回文件；整个读-改-写过程持有锁，并发修改不会互相覆盖
+func (e *RuleEngine) Upsert(r IdentifyRule) (IdentifyRule, error) {
+	r.ID = strings.TrimSpace(r.ID)
+	if _, err := compileRule(&r); err != nil {
+		return r, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	rules := append([]IdentifyRule(nil), e.rules...)
+	if r.ID == "" {
+		r.ID = nextRuleID(rules)
+	}
+	replaced := false
+	for i := range rules {
+		if rules[i].ID == r.ID {
+			rules[i] = r
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		rules = append(rules, r)
+	}
+	return r, e.replaceLocked(rules)
+}
+
+// Delete 删除规则并写回文件；不存在返回 false
+func (e *RuleEngine) Delete(id string) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	rules := e.rules
+	out := make([]IdentifyRule, 0, len(rules))
+	found := false
+	for _, r := range rules {
+		if r.ID == id {
+			found = true
+			continue
+		}
+		out = append(out, r)
+	}
+	if !found {
+		return false, nil
+	}
+	return true, e.replaceLocked(out)
+}
+
+// Evaluate 用全部已启用规则匹配
+func (e *RuleEngine) Evaluate(in RuleInput) *RuleResult {
+	e.mu.RLock()
+	rules := e.matched
+	e.mu.RUnlock()
+	return evaluateRules(rules, in)
+}
+
+// EvaluateRule 只用给定规则匹配（用于规则保存前的试运行）
+func EvaluateRule(r IdentifyRule, in RuleInput) (*RuleResult, error) {
+	cr, err := compileRule(&r)
+	if err != nil {
+		return nil, err
+	}
+	return evaluateRules([]*compiledRule{cr}, in), nil
+}
+
+// replaceLocked 替换当前规则并写回文件；写文件失败时恢复原规则，
+// 避免 API 报错后规则仍在内存中生效、重启后又消失。调用方需持有 e.mu 写锁
+func (e *RuleEngine) replaceLocked(rules []IdentifyRule) error {
+	prevRules, prevMatched := e.rules, e.matched
+	if err := e.setRulesLocked(rules); err != nil {
+		return err
+	}
+	if err := e.saveLocked(); err != nil {
+		e.rules, e.matched = prevRules, prevMatched
+		return err
+	}
+	return nil
+}
+
+// setRulesLocked 校验并编译规则后替换当前规则，调用方需持有 e.mu 写锁
+func (e *RuleEngine) setRulesLocked(rules []IdentifyRule) error {
+	seen := map[string]bool{}
+	compiled := make([]*compiledRule, 0, len(rules))
+	for i := range rules {
+		r := &rules[i]
+		if r.ID == "" {
+			r.ID = nextRuleID(rules)
+		}
+		if seen[r.ID] {
+			return fmt.Errorf("规则 ID 重复: %s", r.ID)
+		}
+		seen[r.ID] = true
+		cr, err := compileRule(r)
+		if err != nil {
+			return fmt.Errorf("规则 %s: %v", r.ID, err)
+		}
+		if !r.Disabled {
+			compiled = append(compiled, cr)
+		}
+	}
+	// 稳定排序：同优先级保持文件中的顺序
+	sort.SliceStable(compiled, func(i, j int) bool {
+		return compiled[i].rule.Priority > compiled[j].rule.Priority
+	})
+	e.rules = rules
+	e.matched = compiled
+	return nil
+}
+
+func (e *RuleEngine) readFile() ([]IdentifyRule, error) {
+	b, err := os.ReadFile(e.path)
+	if os.IsNotExist(err) {
+		return []IdentifyRule{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 文件格式：{"rules": [...]}，YAML 同结构
+	var doc struct {
+		Rules []IdentifyRule `json:"rules"`
+	}
+	if isYAMLPath(e.path) {
+		err = yaml.Unmarshal(b, &doc)
+	} else {
+		err = json.Unmarshal(b, &doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析规则文件失败: %v", err)
+	}
+	if doc.Rules == nil {
+		doc.Rules = []IdentifyRule{}
+	}
+	return doc.Rules, nil
+}
+
+// saveLocked 把当前规则写回文件，调用方需持有 e.mu
+func (e *RuleEngine) saveLocked() error {
+	doc := struct {
+		Rules []IdentifyRule `json:"rules"`
+	}{Rules: e.rules}
+	var (
+		b   []byte
+		err error
+	)
+	if isYAMLPath(e.path) {
+		b, err = yaml.Marshal(doc)
+	} else {
+		b, err = json.MarshalIndent(doc, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(e.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(e.path, b, 0644)
+}
+
+func isYAMLPath(p string) bool {
+	ext := strings.ToLower(filepath.Ext(p))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+func nextRuleID(rules []IdentifyRule) string {
+	used := map[string]bool{}
+	for _, r := range rules {
+		used[r.ID] = true
+	}
+	for i := len(rules) + 1; ; i++ {
+		id := fmt.Sprintf("rule_%d", i)
+		if !used[id] {
+			return id
+		}
+	}
+}
+
+// compileRule 校验规则并预编译正则条件
+func compileRule(r *IdentifyRule) (*compiledRule, error) {
+	if r.Set == (RuleAction{}) {
+		return nil, fmt.Errorf("规则未设置任何输出字段")
+	}
+	cr := &compiledRule{rule: r, matches: map[string]*regexp.Regexp{}}
+	for _, p := range r.Match.MACPrefix {
+		if mac := compactMAC(p); mac != "" {
+			cr.macs = append(cr.macs, mac)
+		}
+	}
+	conds := 0
+	if len(cr.macs) > 0 {
+		conds++
+	} else if len(r.Match.MACPrefix) > 0 {
+		return nil, fmt.Errorf("无效的 MAC 前缀: %v", r.Match.MACPrefix)
+	}
+	if len(r.Match.PortsAll) > 0 || len(r.Match.PortsAny) > 0 {
+		conds++
+	}
+	for key, pattern := range r.Match.textConditions() {
+		if pattern == "" {
+			continue
+		}
+		conds++
+		if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+			re, err := regexp.Compile("(?i)" + expr)
+			if err != nil {
+				return nil, fmt.Errorf("%s 正则无效: %v", key, err)
+			}
+			cr.matches[key] = re
+		}
+	}
+	if conds == 0 {
+		return nil, fmt.Errorf("规则至少需要一个匹配条件")
+	}
+	return cr, nil
+}
+
+func (m RuleMatch) textConditions() map[string]string {
+	return map[string]string{
+		"oui_vendor":         m.OUIVendor,
+		"http_title":         m.HTTPTitle,
+		"http_server":        m.HTTPServer,
+		"ssdp_server":        m.SSDPServer,
+		"ssdp_manufacturer":  m.SSDPManufacturer,
+		"ssdp_model":         m.SSDPModel,
+		"ssdp_device_type":   m.SSDPDeviceType,
+		"onvif_manufacturer": m.ONVIFManufacturer,
+		"onvif_model":        m.ONVIFModel,
+		"hostname":           m.Hostname,
+		"dhcp_vendor_class":  m.DHCPVendorClass,
+	}
+}
+
+// ruleFacts 从设备信息和 extra 证据中提取可匹配的字段（同一字段可能有多个值）
+func ruleFacts(in RuleInput) map[string][]string {
+	var ev struct {
+		SSDP *struct {
+			Server       string `json:"server"`
+			Manufacturer string `json:"manufacturer"`
+			ModelName    string `json:"modelName"`
+			DeviceType   string `json:"deviceType"`
+		} `json:"ssdp"`
+		ONVIF    *fingerprint.ONVIFDeviceInfo `json:"onvif"`
+		HTTP80   *fingerprint.HTTPFingerprint `json:"http_80"`
+		HTTPS443 *fingerprint.HTTPFingerprint `json:"https_443"`
+		Passive  *struct {
+			Hostname    string `json:"hostname"`
+			VendorClass string `json:"vendor_class"`
+		} `json:"passive"`
+	}
+	if in.Extra != "" {
+		_ = json.Unmarshal([]byte(in.Extra), &ev)
+	}
+
+	facts := map[string][]string{
+		"oui_vendor": {identifyVendor(in.MAC)},
+		"hostname":   {in.Name},
+	}
+	for _, fp := range []*fingerprint.HTTPFingerprint{ev.HTTP80, ev.HTTPS443} {
+		if fp != nil {
+			facts["http_title"] = append(facts["http_title"], fp.Title)
+			facts["http_server"] = append(facts["http_server"], fp.Server)
+		}
+	}
+	if ev.SSDP != nil {
+		facts["ssdp_server"] = []string{ev.SSDP.Server}
+		facts["ssdp_manufacturer"] = []string{ev.SSDP.Manufacturer}
+		facts["ssdp_model"] = []string{ev.SSDP.ModelName}
+		facts["ssdp_device_type"] = []string{ev.SSDP.DeviceType}
+	}
+	if ev.ONVIF != nil {
+		facts["onvif_manufacturer"] = []string{ev.ONVIF.Manufacturer}
+		facts["onvif_model"] = []string{ev.ONVIF.Model}
+	}
+	if ev.Passive != nil {
+		facts["hostname"] = append(facts["hostname"], ev.Passive.Hostname)
+		facts["dhcp_vendor_class"] = []string{ev.Passive.VendorClass}
+	}
+	return facts
+}
+
+func evaluateRules(rules []*compiledRule, in RuleInput) *RuleResult {
+	res := &RuleResult{Matched: []string{}}
+	if len(rules) == 0 {
+		return res
+	}
+	facts := ruleFacts(in)
+	mac := compactMAC(in.MAC)
+	ports := map[int]bool{}
+	for _, p := range in.Ports {
+		ports[p] = true
+	}
+
+	// rules 已按优先级从高到低排列：每个字段取第一个命中规则的值
+	for _, cr := range rules {
+		if !cr.match(mac, ports, facts) {
+			continue
+		}
+		res.Matched = append(res.Matched, cr.rule.ID)
+		set := cr.rule.Set
+		firstNonEmpty(&res.Vendor, set.Vendor)
+		firstNonEmpty(&res.Model, set.Model)
+		firstNonEmpty(&res.Type, set.Type)
+		firstNonEmpty(&res.OS, set.OS)
+		firstNonEmpty(&res.Name, set.Name)
+	}
+	return res
+}
+
+func firstNonEmpty(dst *string, v string) {
+	if *dst == "" {
+		*dst = v
+	}
+}
+
+func (cr *compiledRule) match(mac string, ports map[int]bool, facts map[string][]string) bool {
+	m := cr.rule.Match
+	if len(cr.macs) > 0 {
+		ok := false
+		for _, p := range cr.macs {
+			if mac != "" && strings.HasPrefix(mac, p) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	for _, p := range m.PortsAll {
+		if !ports[p] {
+			return false
+		}
+	}
+	if len(m.PortsAny) > 0 {
+		ok := false
+		for _, p := range m.PortsAny {
+			if ports[p] {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	for key, pattern := range m.textConditions() {
+		if pattern == "" {
+			continue
+		}
+		if !cr.matchText(key, pattern, facts[key]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (cr *compiledRule) matchText(key, pattern string, values []string) bool {
+	re := cr.matches[key]
+	needle := strings.ToLower(pattern)
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		if re != nil {
+			if re.MatchString(v) {
+				return true
+			}
+		} else if strings.Contains(strings.ToLower(v), needle) {
+			return true
+		}
+	}
+	return false
+}
+
+// compactMAC 去掉分隔符并转小写（"AA:BB:CC" -> "aabbcc"），非十六进制返回空
+func compactMAC(s string) string {
+	s = strings.ToLower(strings.NewReplacer(":", "", "-", "", ".", "", " ", "").Replace(strings.TrimSpace(s)))
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return ""
+		}
+	}
+	return s
+}
+
+// matchRules 用本次识别结果和证据匹配全部规则；已入库设备补充历史扫描到的开放端口
+func matchRules(db *sql.DB, prev *database.Device, mac, name string, ports []int, evidence map[string]any) *RuleResult {
+	in := RuleInput{MAC: mac, Name: name, Ports: ports}
+	if prev != nil {
+		in.Ports = append(append([]int{}, ports...), storedOpenPorts(db, prev.ID)...)
+	}
+	if b, err := json.Marshal(evidence); err == nil {
+		in.Extra = string(b)
+	}
+	return DefaultRuleEngine().Evaluate(in)
+}
+
+// applyRuleResult 把规则结果写回设备字段，并在 evidence 中记录命中的规则
+func applyRuleResult(r *RuleResult, vendor, model, typ, osName, name *string, evidence map[string]any) {
+	if r == nil || len(r.Matched) == 0 {
+		delete(evidence, "rules")
+		return
+	}
+	evidence["rules"] = r.Matched
+	for _, f := range [][2]*string{
+		{vendor, &r.Vendor},
+		{model, &r.Model},
+		{typ, &r.Type},
+		{osName, &r.OS},
+		{name, &r.Name},
+	} {
+		if *f[1] != "" {
+			*f[0] = *f[1]
+		}
+	}
+}
+
+// storedOpenPorts 数据库中记录的开放端口
+func storedOpenPorts(db *sql.DB, deviceID string) []int {
+	ports, err := database.GetDevicePorts(db, deviceID)
+	if err != nil {
+		return nil
+	}
+	out := make([]int, 0, len(ports))
+	for _, p := range ports {
+		if p.Status == "open" {
+			out = append(out, p.Port)
+		}
+	}
+	return out
+}
+
+// StoredRuleInput 由已入库设备构造规则输入（用于试运行）
+func StoredRuleInput(db *sql.DB, d *database.Device) RuleInput {
+	return RuleInput{MAC: d.MAC, Name: d.Name, Ports: storedOpenPorts(db, d.ID), Extra: d.Extra}
+}
@@ -0,0 +1,124 @@
+package scanner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestEvaluateRules(t *testing.T) {
+	rules := []IdentifyRule{
+		{ID: "cam", Priority: 10, Match: RuleMatch{MACPrefix: []string{"AA:BB:CC"}, PortsAny: []int{554, 8554}}, Set: RuleAction{Type: "camera", Vendor: "Acme"}},
+		{ID: "nas", Priority: 5, Match: RuleMatch{PortsAll: []int{139, 445}, HTTPTitle: "re:^DiskStation"}, Set: RuleAction{Type: "nas", OS: "DSM"}},
+		{ID: "host", Priority: 1, Match: RuleMatch{Hostname: "printer"}, Set: RuleAction{Type: "printer", Vendor: "Generic"}},
+		{ID: "off", Disabled: true, Match: RuleMatch{Hostname: "printer"}, Set: RuleAction{Model: "disabled"}},
+	}
+	e := NewRuleEngine(filepath.Join(t.TempDir(), "rules.json"))
+	if err := e.setRulesLocked(rules); err != nil {
+		t.Fatalf("加载规则失败: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		in      RuleInput
+		matched []string
+		want    RuleAction
+	}{
+		{name: "MAC 前缀 + 任一端口", in: RuleInput{MAC: "aa-bb-cc-01-02-03", Ports: []int{80, 554}}, matched: []string{"cam"}, want: RuleAction{Type: "camera", Vendor: "Acme"}},
+		{name: "MAC 命中但端口不符", in: RuleInput{MAC: "AA:BB:CC:01:02:03", Ports: []int{80}}, matched: []string{}},
+		{name: "全部端口 + 正则标题", in: RuleInput{Ports: []int{139, 445}, Extra: `{"http_80":{"scheme":"http","title":"diskstation DS920"}}`}, matched: []string{"nas"}, want: RuleAction{Type: "nas", OS: "DSM"}},
+		{name: "正则要求开头", in: RuleInput{Ports: []int{139, 445}, Extra: `{"http_80":{"scheme":"http","title":"My DiskStation"}}`}, matched: []string{}},
+		{name: "缺少端口", in: RuleInput{Ports: []int{445}, Extra: `{"http_80":{"scheme":"http","title":"DiskStation"}}`}, matched: []string{}},
+		{name: "主机名包含匹配不区分大小写", in: RuleInput{Name: "Office-PRINTER-2"}, matched: []string{"host"}, want: RuleAction{Type: "printer", Vendor: "Generic"}},
+		{name: "DHCP 主机名", in: RuleInput{Extra: `{"passive":{"hostname":"printer-lobby"}}`}, matched: []string{"host"}, want: RuleAction{Type: "printer", Vendor: "Generic"}},
+		{
+			name:    "多条命中按优先级逐字段取值",
+			in:      RuleInput{MAC: "aabbcc000001", Name: "printer", Ports: []int{554}},
+			matched: []string{"cam", "host"},
+			want:    RuleAction{Type: "camera", Vendor: "Acme"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := e.Evaluate(tt.in)
+			if !reflect.DeepEqual(got.Matched, tt.matched) {
+				t.Fatalf("命中 %v，期望 %v", got.Matched, tt.matched)
+			}
+			if got.RuleAction != tt.want {
+				t.Fatalf("结果 %+v，期望 %+v", got.RuleAction, tt.want)
+			}
+		})
+	}
+}
+
+// 并发新增规则不能互相覆盖
+func TestRuleEngineConcurrentUpsert(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	e := NewRuleEngine(path)
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := IdentifyRule{ID: fmt.Sprintf("r%d", i), Match: RuleMatch{Hostname: fmt.Sprintf("host%d", i)}, Set: RuleAction{Type: "pc"}}
+			if _, err := e.Upsert(r); err != nil {
+				t.Errorf("Upsert 失败: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := len(e.Rules()); got != n {
+		t.Fatalf("内存中有 %d 条规则，期望 %d", got, n)
+	}
+
+	reloaded := NewRuleEngine(path)
+	if err := reloaded.Reload(); err != nil {
+		t.Fatalf("重新加载失败: %v", err)
+	}
+	if got := len(reloaded.Rules()); got != n {
+		t.Fatalf("文件中有 %d 条规则，期望 %d", got, n)
+	}
+	if ok, err := e.Delete("r0"); !ok || err != nil {
+		t.Fatalf("删除失败: %v %v", ok, err)
+	}
+	if got := len(e.Rules()); got != n-1 {
+		t.Fatalf("删除后有 %d 条规则，期望 %d", got, n-1)
+	}
+}
+
+// 写文件失败时不能留下只在内存中生效的规则
+func TestRuleEngineSaveFailureKeepsRules(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.json")
+	e := NewRuleEngine(path)
+	keep := IdentifyRule{ID: "keep", Match: RuleMatch{Hostname: "nas"}, Set: RuleAction{Type: "nas"}}
+	if _, err := e.Upsert(keep); err != nil {
+		t.Fatalf("Upsert 失败: %v", err)
+	}
+
+	// 让规则文件所在目录变成普通文件，之后的写入都会失败
+	e.path = filepath.Join(dir, "blocked", "rules.json")
+	if err := os.WriteFile(filepath.Join(dir, "blocked"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Upsert(IdentifyRule{ID: "new", Match: RuleMatch{Hostname: "cam"}, Set: RuleAction{Type: "camera"}}); err == nil {
+		t.Fatal("写文件失败时 Upsert 应返回错误")
+	}
+	if ok, err := e.Delete("keep"); !ok || err == nil {
+		t.Fatalf("写文件失败时 Delete 应返回错误: %v %v", ok, err)
+	}
+	rules := e.Rules()
+	if len(rules) != 1 || rules[0].ID != "keep" {
+		t.Fatalf("写文件失败后规则为 %+v，期望只有 keep", rules)
+	}
+	if res := e.Evaluate(RuleInput{Name: "cam"}); res != nil && len(res.Matched) > 0 {
+		t.Fatalf("未保存的规则不应生效: %+v", res)
+	}
+	if res := e.Evaluate(RuleInput{Name: "nas"}); res == nil || res.Type != "nas" {
+		t.Fatal("删除失败后原规则应继续生效")
+	}
+}
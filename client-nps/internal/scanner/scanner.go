@@ -13,6 +13,7 @@ import (
 	"nwct/client-nps/internal/logger"
 	"nwct/client-nps/internal/realtime"
 	"nwct/client-nps/internal/toolkit"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -21,33 +22,55 @@ import (
 // Scanner 设备扫描器接口
 type Scanner interface {
 	StartScan(subnet string) error
+	StartScanWithOptions(opts ScanOptions) error
 	StopScan() error
 	GetDevices() ([]Device, error)
-	GetDeviceDetail(ip string) (*DeviceDetail, error)
+	SearchDevices(q database.DeviceSearch) ([]Device, int, error)
+	GetDeviceDetail(key string) (*DeviceDetail, error) // key: 设备 ID 或 IP
 	GetScanStatus() *ScanStatus
 }
 
 // Device 设备信息
 type Device struct {
-	IP        string `json:"ip"`
-	MAC       string `json:"mac"`
-	Name      string `json:"name"`
-	Vendor    string `json:"vendor"`
-	Model     string `json:"model"`
-	Type      string `json:"type"`
-	OS        string `json:"os"`
-	Extra     string `json:"extra"`
-	Status    string `json:"status"`
-	OpenPorts []int  `json:"open_ports"`
-	LastSeen  string `json:"last_seen"`
-	FirstSeen string `json:"first_seen"`
+	ID        string   `json:"id"`
+	IP        string   `json:"ip"`
+	MAC       string   `json:"mac"`
+	Name      string   `json:"name"`
+	Vendor    string   `json:"vendor"`
+	Model     string   `json:"model"`
+	Type      string   `json:"type"`
+	OS        string   `json:"os"`
+	Extra     string   `json:"extra"`
+	Interface string   `json:"interface"`
+	Subnet    string   `json:"subnet"`
+	Addresses []string `json:"addresses"` // 全部已知地址（IPv4 + IPv6）
+	Status    string   `json:"status"`
+	OpenPorts []int    `json:"open_ports"`
+	LastSeen  string   `json:"last_seen"`
+	FirstSeen string   `json:"first_seen"`
+	Alias     string   `json:"alias"`
+	Owner     string   `json:"owner"`
+	Location  string   `json:"location"`
+	Notes     string   `json:"notes"`
+	Tags      []string `json:"tags"`
 }
 
 // DeviceDetail 设备详情
 type DeviceDetail struct {
 	Device
-	Ports   []PortInfo `json:"ports"`
-	History []History  `json:"history"`
+	Ports          []PortInfo    `json:"ports"`
+	History        []History     `json:"history"`
+	AddressHistory []AddressInfo `json:"address_history"`
+}
+
+// AddressInfo 设备地址历史
+type AddressInfo struct {
+	Address   string `json:"address"`
+	Family    string `json:"family"`
+	Scope     string `json:"scope"`
+	Interface string `json:"interface"`
+	FirstSeen string `json:"first_seen"`
+	LastSeen  string `json:"last_seen"`
 }
 
 // PortInfo 端口信息
@@ -57,6 +80,7 @@ type PortInfo struct {
 	Service  string `json:"service"`
 	Version  string `json:"version"`
 	Status   string `json:"status"`
+	Banner   string `json:"banner,omitempty"`
 }
 
 // History 历史记录
@@ -65,12 +89,56 @@ type History struct {
 	Status    string `json:"status"`
 }
 
+// 扫描模式
+const (
+	ScanModeIncremental = "incremental" // 默认：合并到已有设备表，未发现的设备标记为离线
+	ScanModeReset       = "reset"       // 扫描前清空设备/端口/历史（旧行为）
+)
+
+// 扫描触发来源
+const (
+	ScanSourceAPI       = "api"
+	ScanSourceMQTT      = "mqtt"
+	ScanSourceScheduler = "scheduler"
+)
+
+const (
+	defaultARPTimeout      = 30 * time.Second
+	defaultScanConcurrency = 5
+	hostIdentifyTimeout    = 10 * time.Second // 单台主机识别的截止时间
+	portScanWorkers        = 32               // 单台设备端口清点的并发拨号数
+	portDialTimeout        = 1500 * time.Millisecond
+	udpProbeTimeout        = 2 * time.Second
+)
+
+// ScanOptions 扫描参数
+type ScanOptions struct {
+	Subnet      string        `json:"subnet"`       // 兼容：单个网段（Targets 为空时使用）
+	Targets     []ScanTarget  `json:"targets"`      // 多网段/多接口，见 ResolveTargets
+	Mode        string        `json:"mode"`         // incremental(默认) / reset
+	Timeout     time.Duration `json:"timeout"`      // ARP 发现阶段超时，<=0 使用默认 30s
+	Concurrency int           `json:"concurrency"`  // 并发数，<=0 使用默认值
+	Source      string        `json:"source"`       // api / mqtt / scheduler
+	PortProfile string        `json:"port_profile"` // 端口清点配置档：quick(默认)/standard/full/custom
+	Ports       string        `json:"ports"`        // custom 配置档的端口表达式，如 "22,80,8000-8100"
+	UDPPorts    string        `json:"udp_ports"`    // UDP 端口表达式，为空时 standard/full 扫描带探测包的端口，"none" 不扫
+
+	portList    []int // 由 PortProfile/Ports 解析得到
+	udpPortList []int // 由 PortProfile/UDPPorts 解析得到
+}
+
 // ScanStatus 扫描状态
 type ScanStatus struct {
+	ScanID       string    `json:"scan_id"`
+	Mode         string    `json:"mode"`
+	Source       string    `json:"source"`
+	Subnets      []string  `json:"subnets"`
 	Status       string    `json:"status"` // running, stopped, completed
 	Progress     int       `json:"progress"`
 	ScannedCount int       `json:"scanned_count"`
 	FoundCount   int       `json:"found_count"`
+	NewCount     int       `json:"new_count"`
+	GoneCount    int       `json:"gone_count"`
 	StartTime    time.Time `json:"start_time"`
 }
 
@@ -78,6 +146,9 @@ type ScanStatus struct {
 type deviceScanner struct {
 	scanning   bool
 	scanStatus *ScanStatus
+	cancel     context.CancelFunc // 取消当前扫描（StopScan）
+	stopped    bool               // 本次扫描是否被 StopScan 停止
+	done       chan struct{}      // 当前扫描 goroutine 结束时关闭
 	mu         sync.RWMutex
 	db         *sql.DB
 }
@@ -101,54 +172,152 @@ func NewScanner(db *sql.DB) Scanner {
 	return globalScanner
 }
 
-// StartScan 启动扫描
+// StartScan 启动扫描（增量模式）
 func (ds *deviceScanner) StartScan(subnet string) error {
+	return ds.StartScanWithOptions(ScanOptions{Subnet: subnet})
+}
+
+// StartScanWithOptions 按参数启动扫描
+func (ds *deviceScanner) StartScanWithOptions(opts ScanOptions) error {
+	if len(opts.Targets) == 0 {
+		opts.Targets = []ScanTarget{{Subnet: strings.TrimSpace(opts.Subnet)}}
+	}
+	for _, t := range opts.Targets {
+		if _, _, err := net.ParseCIDR(t.Subnet); err != nil {
+			return fmt.Errorf("无效的网段: %s", t.Subnet)
+		}
+	}
+	subnets := TargetSubnets(opts.Targets)
+	mode := strings.ToLower(strings.TrimSpace(opts.Mode))
+	switch mode {
+	case "":
+		mode = ScanModeIncremental
+	case ScanModeIncremental, ScanModeReset:
+	default:
+		return fmt.Errorf("不支持的扫描模式: %s", opts.Mode)
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultARPTimeout
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultScanConcurrency
+	}
+	if opts.Source == "" {
+		opts.Source = ScanSourceAPI
+	}
+	ports, err := toolkit.PortsForProfile(opts.PortProfile, opts.Ports)
+	if err != nil {
+		return err
+	}
+	opts.portList = ports
+	if opts.udpPortList, err = toolkit.UDPPortsForProfile(opts.PortProfile, opts.UDPPorts); err != nil {
+		return err
+	}
+	opts.Subnet = subnets[0]
+	opts.Mode = mode
+
 	ds.mu.Lock()
 	if ds.scanning {
 		ds.mu.Unlock()
 		return fmt.Errorf("扫描已在进行中")
 	}
 	ds.scanning = true
+	ds.scanStatus.ScanID = fmt.Sprintf("scan_%d", time.Now().UnixNano())
+	ds.scanStatus.Mode = mode
+	ds.scanStatus.Source = opts.Source
+	ds.scanStatus.Subnets = subnets
 	ds.scanStatus.Status = "running"
 	ds.scanStatus.StartTime = time.Now()
 	ds.scanStatus.Progress = 0
 	ds.scanStatus.ScannedCount = 0
 	ds.scanStatus.FoundCount = 0
+	ds.scanStatus.NewCount = 0
+	ds.scanStatus.GoneCount = 0
+	scanID := ds.scanStatus.ScanID
+	ctx, cancel := context.WithCancel(context.Background())
+	ds.cancel = cancel
+	ds.stopped = false
+	ds.done = make(chan struct{})
+	done := ds.done
 	ds.mu.Unlock()
 
-	// 每次扫描前清空旧结果，避免“历史设备”混入当前列表
-	if err := database.ClearAllDeviceData(ds.db); err != nil {
-		logger.Error("清空历史设备数据失败: %v", err)
+	// 重置模式：扫描前清空旧结果（旧行为）；增量模式保留 first_seen/端口/历史
+	cleared := false
+	if mode == ScanModeReset {
+		if err := database.ClearAllDeviceData(ds.db); err != nil {
+			logger.Error("清空历史设备数据失败: %v", err)
+		} else {
+			cleared = true
+		}
 	}
 
 	realtime.Default().Broadcast("scan_started", map[string]interface{}{
-		"subnet": subnet,
-		"cleared": true,
+		"scan_id": scanID,
+		"subnet":  strings.Join(subnets, ","),
+		"subnets": subnets,
+		"targets": opts.Targets,
+		"mode":    mode,
+		"source":  opts.Source,
+		"cleared": cleared,
+		"ports":   len(opts.portList),
+		"udp":     len(opts.udpPortList),
 	})
 
 	// 在goroutine中执行扫描
-	go ds.performScan(subnet)
+	go func() {
+		defer close(done)
+		ds.performScan(ctx, scanID, opts)
+	}()
 
 	return nil
 }
 
 // performScan 执行扫描
-func (ds *deviceScanner) performScan(subnet string) {
+func (ds *deviceScanner) performScan(ctx context.Context, scanID string, opts ScanOptions) {
+	subnet := strings.Join(TargetSubnets(opts.Targets), ",")
+	run := &scanRun{
+		ctx:      ctx,
+		id:       scanID,
+		ports:    opts.portList,
+		udpPorts: opts.udpPortList,
+		// 端口扫描并发上限（按 ScannerConfig.Concurrency）
+		portSem: make(chan struct{}, opts.Concurrency),
+	}
+
 	defer func() {
+		// 异步端口扫描也属于本次扫描：全部结束后才上报完成
+		run.portWG.Wait()
+
 		ds.mu.Lock()
 		ds.scanning = false
-		ds.scanStatus.Status = "completed"
-		ds.scanStatus.Progress = 100
+		if ds.cancel != nil {
+			ds.cancel()
+			ds.cancel = nil
+		}
+		// 被停止的扫描保持 stopped 和当时的进度（部分结果已入库），不覆盖为 completed
+		status := "completed"
+		if ds.stopped {
+			status = "stopped"
+		} else {
+			ds.scanStatus.Progress = 100
+		}
+		ds.scanStatus.Status = status
+		progress := ds.scanStatus.Progress
 		found := ds.scanStatus.FoundCount
 		scanned := ds.scanStatus.ScannedCount
+		newCount := ds.scanStatus.NewCount
+		goneCount := ds.scanStatus.GoneCount
 		ds.mu.Unlock()
 
 		realtime.Default().Broadcast("scan_done", map[string]interface{}{
+			"scan_id":       scanID,
 			"subnet":        subnet,
-			"status":        "completed",
-			"progress":      100,
+			"status":        status,
+			"progress":      progress,
 			"scanned_count": scanned,
 			"found_count":   found,
+			"new_count":     newCount,
+			"gone_count":    goneCount,
 		})
 	}()
 
@@ -159,9 +328,9 @@ func (ds *deviceScanner) performScan(subnet string) {
 	ssdpMap := map[string]*fingerprint.SSDPDevice{}
 	{
 		// 发现阶段 2s，但留足时间抓取描述 XML 做信息补全
-		ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+		ssdpCtx, cancel := context.WithTimeout(ctx, 6*time.Second)
 		defer cancel()
-		if m, err := fingerprint.SSDPDiscover(ctx, 2*time.Second); err == nil && m != nil {
+		if m, err := fingerprint.SSDPDiscover(ssdpCtx, 2*time.Second); err == nil && m != nil {
 			ssdpMap = m
 		}
 	}
@@ -182,25 +351,84 @@ func (ds *deviceScanner) performScan(subnet string) {
 	// 0.5 WS-Discovery（ONVIF/Windows 等），用于发现摄像头并拿到 xaddrs
 	wsdMap := map[string]*fingerprint.WSDiscoveryDevice{}
 	{
-		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		wsdCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
 		defer cancel()
-		if m, err := fingerprint.WSDiscoveryProbe(ctx, 2*time.Second); err == nil && m != nil {
+		if m, err := fingerprint.WSDiscoveryProbe(wsdCtx, 2*time.Second); err == nil && m != nil {
 			wsdMap = m
 		}
 	}
 	logger.Info("WS-Discovery发现设备数: %d", len(wsdMap))
 
-	// 1. ARP扫描
-	arpDevices, err := ARPScan(subnet, 30*time.Second)
-	if err != nil {
-		logger.Error("ARP扫描失败: %v", err)
-		// 继续使用简化方法
+	// 1. ARP扫描（逐个网段），同一 IP 只处理一次，记录其所在接口/网段
+	hosts := []hostJob{}
+	hostSeen := map[string]bool{}
+	arpOK := map[string]bool{} // subnet -> ARP 是否成功（失败的网段不做“消失”判定）
+	for _, t := range opts.Targets {
+		if ctx.Err() != nil {
+			break
+		}
+		devs, err := ARPScan(ctx, t.Subnet, opts.Timeout)
+		if err != nil {
+			logger.Error("ARP扫描失败: subnet=%s err=%v", t.Subnet, err)
+			continue
+		}
+		arpOK[t.Subnet] = true
+		for _, d := range devs {
+			if hostSeen[d.IP] {
+				continue
+			}
+			hostSeen[d.IP] = true
+			hosts = append(hosts, hostJob{arp: d, target: t})
+		}
+	}
+
+	// 1.1 IPv6 邻居发现：与本次/库中 IPv4 设备按 MAC 关联；只有 IPv6 可达的设备单独入队识别
+	scannedMACs := map[string]bool{}
+	knownMACs := []string{}
+	for _, h := range hosts {
+		if h.arp.MAC != "" && !scannedMACs[h.arp.MAC] {
+			scannedMACs[h.arp.MAC] = true
+			knownMACs = append(knownMACs, h.arp.MAC)
+		}
+	}
+	v6ByMAC := discoverIPv6(ctx, opts.Targets, knownMACs)
+	for mac, addrs := range v6ByMAC {
+		if scannedMACs[mac] {
+			continue
+		}
+		primary := preferredIPv6(addrs)
+		if known, _ := database.GetDevice(ds.db, database.DeviceIDFor(mac, "")); known != nil {
+			if !strings.Contains(known.IP, ":") {
+				continue // 已有 IPv4 设备：只补充地址
+			}
+			primary = known.IP
+		}
+		if hostSeen[primary] {
+			continue
+		}
+		hostSeen[primary] = true
+		hosts = append(hosts, hostJob{arp: ARPDevice{IP: primary, MAC: mac}, target: ScanTarget{Interface: addrs[0].Interface}})
 	}
-	total := len(arpDevices)
+	total := len(hosts)
+	run.ssdp, run.wsd = ssdpMap, wsdMap
+
+	// 2. 处理发现的设备：按 Concurrency 启动固定数量 worker 并发识别，
+	// 每台主机单独设置截止时间，扫描停止时通过 ctx 取消
+	var (
+		seenMu   sync.Mutex
+		seen     = make(map[string]bool, total)
+		pushMu   sync.Mutex
+		lastPush = time.Now()
+	)
+	forEachHost(ctx, hosts, opts.Concurrency, func(job hostJob) {
+		hostCtx, cancel := context.WithTimeout(ctx, hostIdentifyTimeout)
+		ds.processHost(hostCtx, run, job)
+		cancel()
+
+		seenMu.Lock()
+		seen[database.DeviceIDFor(job.arp.MAC, job.arp.IP)] = true
+		seenMu.Unlock()
 
-	// 2. 处理发现的设备
-	lastPush := time.Now()
-	for _, arpDevice := range arpDevices {
 		ds.mu.Lock()
 		ds.scanStatus.FoundCount++
 		ds.scanStatus.ScannedCount++
@@ -214,8 +442,15 @@ func (ds *deviceScanner) performScan(subnet string) {
 		ds.mu.Unlock()
 
 		// 节流推送（最多 2s 一次）
-		if time.Since(lastPush) >= 2*time.Second {
+		pushMu.Lock()
+		push := time.Since(lastPush) >= 2*time.Second
+		if push {
+			lastPush = time.Now()
+		}
+		pushMu.Unlock()
+		if push {
 			realtime.Default().Broadcast("scan_progress", map[string]interface{}{
+				"scan_id":       scanID,
 				"subnet":        subnet,
 				"status":        st.Status,
 				"progress":      st.Progress,
@@ -223,170 +458,427 @@ func (ds *deviceScanner) performScan(subnet string) {
 				"found_count":   st.FoundCount,
 				"start_time":    st.StartTime.Format(time.RFC3339),
 			})
-			lastPush = time.Now()
 		}
+	})
+
+	// 2.1 记录 IPv6 地址到对应设备
+	if ctx.Err() == nil {
+		ds.attachIPv6Addresses(v6ByMAC)
+	}
 
-		// 识别设备
-		device := ds.identifyDevice(arpDevice.IP, arpDevice.MAC)
-		evidence := map[string]any{}
-
-		// SSDP/UPnP 补充信息（名称/厂商/类型）
-		if adv := ssdpMap[arpDevice.IP]; adv != nil {
-			evidence["ssdp"] = map[string]any{
-				"location":     adv.Location,
-				"server":       adv.Server,
-				"usn":          adv.USN,
-				"st":           adv.ST,
-				"friendlyName": adv.FriendlyName,
-				"manufacturer": adv.Manufacturer,
-				"modelName":    adv.ModelName,
-				"deviceType":   adv.DeviceType,
+	// 3. 增量模式：本网段内本次未发现的在线设备标记为离线
+	// ARP 失败或扫描被停止时结果不完整，不做“消失”判定，避免误报
+	if ctx.Err() == nil {
+		for _, t := range opts.Targets {
+			if arpOK[t.Subnet] {
+				ds.markMissingDevices(scanID, t.Subnet, seen)
 			}
-			if device.Name == "" {
-				if adv.FriendlyName != "" {
-					device.Name = adv.FriendlyName
-				} else if adv.ModelName != "" {
-					device.Name = adv.ModelName
-				}
+		}
+	}
+
+	logger.Info("扫描完成，发现 %d 个设备", total)
+}
+
+// scanRun 一次扫描中各主机共享的状态
+type scanRun struct {
+	ctx      context.Context // 整个扫描的生命周期（异步端口扫描使用）
+	id       string
+	ssdp     map[string]*fingerprint.SSDPDevice
+	wsd      map[string]*fingerprint.WSDiscoveryDevice
+	ports    []int // 端口清点的 TCP / UDP 端口，均为空时不做端口清点
+	udpPorts []int
+	portSem  chan struct{}
+	portWG   sync.WaitGroup
+}
+
+// hostJob 待识别的主机及其所在网段
+type hostJob struct {
+	arp    ARPDevice
+	target ScanTarget
+}
+
+// forEachHost 用 workers 个 goroutine 并发处理主机，ctx 取消后不再派发新主机；全部处理完才返回
+func forEachHost(ctx context.Context, hosts []hostJob, workers int, fn func(hostJob)) {
+	if workers > len(hosts) {
+		workers = len(hosts)
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+	jobs := make(chan hostJob)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				fn(job)
 			}
-			if device.Vendor == "" || strings.EqualFold(device.Vendor, "unknown") {
-				if adv.Manufacturer != "" {
-					device.Vendor = adv.Manufacturer
-				}
+		}()
+	}
+feed:
+	for _, job := range hosts {
+		// 空闲 worker 与 ctx.Done 同时就绪时 select 随机选择，先检查一次
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			break feed
+		case jobs <- job:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// discoverIPv6 在各目标接口上做 NDP 发现，按 MAC 分组
+func discoverIPv6(ctx context.Context, targets []ScanTarget, knownMACs []string) map[string][]NDPDevice {
+	out := map[string][]NDPDevice{}
+	done := map[string]bool{}
+	for _, t := range targets {
+		if t.Interface == "" || done[t.Interface] || ctx.Err() != nil {
+			continue
+		}
+		done[t.Interface] = true
+		devs, err := NDPScan(ctx, t.Interface, knownMACs, defaultNDPTimeout)
+		if err != nil {
+			logger.Warn("IPv6邻居发现失败: iface=%s err=%v", t.Interface, err)
+			continue
+		}
+		logger.Info("IPv6邻居发现: iface=%s 地址数=%d", t.Interface, len(devs))
+		for _, d := range devs {
+			out[d.MAC] = append(out[d.MAC], d)
+		}
+	}
+	return out
+}
+
+// preferredIPv6 选出 IPv6 设备的主地址：全局 > ULA > 链路本地（链路本地带 zone 以便直接访问）
+func preferredIPv6(addrs []NDPDevice) string {
+	best, bestRank := "", -1
+	for _, a := range addrs {
+		rank := 0
+		switch database.AddressScope(a.IP) {
+		case "global":
+			rank = 2
+		case "ula":
+			rank = 1
+		}
+		if rank > bestRank {
+			best, bestRank = a.IP, rank
+			if rank == 0 && a.Interface != "" {
+				best = a.IP + "%" + a.Interface
 			}
-			// 仅在当前类型不够明确时用 SSDP 的 deviceType 做辅助判断
-			if device.Type == "" || device.Type == "unknown" || device.Type == "network_device" {
-				if t := mapDeviceTypeFromUPnP(adv.DeviceType); t != "" {
-					device.Type = t
-				}
+		}
+	}
+	return best
+}
+
+// attachIPv6Addresses 把 NDP 发现的地址挂到同 MAC 的设备上
+func (ds *deviceScanner) attachIPv6Addresses(v6ByMAC map[string][]NDPDevice) {
+	for mac, addrs := range v6ByMAC {
+		dev, _ := database.GetDevice(ds.db, database.DeviceIDFor(mac, ""))
+		if dev == nil {
+			continue
+		}
+		for _, a := range addrs {
+			isNew, err := database.SaveDeviceAddress(ds.db, &database.DeviceAddress{
+				DeviceID:  dev.ID,
+				Address:   a.IP,
+				Interface: a.Interface,
+			})
+			if err != nil || !isNew {
+				continue
 			}
+			realtime.Default().Broadcast("device_address_added", map[string]interface{}{
+				"id":        dev.ID,
+				"ip":        dev.IP,
+				"mac":       mac,
+				"address":   a.IP,
+				"family":    "ipv6",
+				"scope":     database.AddressScope(a.IP),
+				"interface": a.Interface,
+			})
 		}
+	}
+}
 
-		// WS-Discovery / ONVIF 补充信息（摄像头型号/厂商）
-		if w := wsdMap[arpDevice.IP]; w != nil {
-			evidence["wsd"] = map[string]any{
-				"types":  w.Types,
-				"xaddrs": w.XAddrs,
-				"scopes": w.Scopes,
+// processHost 识别单台主机（端口/名称/SSDP/ONVIF/HTTP 指纹）并入库
+// ctx 为单台主机的截止时间，run.ctx 为整个扫描的生命周期（用于异步端口扫描）
+func (ds *deviceScanner) processHost(ctx context.Context, run *scanRun, job hostJob) {
+	arpDevice, target := job.arp, job.target
+	// 识别设备
+	device := ds.identifyDevice(ctx, arpDevice.IP, arpDevice.MAC)
+	evidence := map[string]any{}
+
+	// SSDP/UPnP 补充信息（名称/厂商/类型）
+	if adv := run.ssdp[arpDevice.IP]; adv != nil {
+		evidence["ssdp"] = map[string]any{
+			"location":     adv.Location,
+			"server":       adv.Server,
+			"usn":          adv.USN,
+			"st":           adv.ST,
+			"friendlyName": adv.FriendlyName,
+			"manufacturer": adv.Manufacturer,
+			"modelName":    adv.ModelName,
+			"deviceType":   adv.DeviceType,
+		}
+		if device.Name == "" {
+			if adv.FriendlyName != "" {
+				device.Name = adv.FriendlyName
+			} else if adv.ModelName != "" {
+				device.Name = adv.ModelName
 			}
-			// 从 scopes 简单提取品牌/型号线索（不同厂商 scope 格式差异很大）
-			// 主要依赖 ONVIF GetDeviceInformation
-			for _, x := range w.XAddrs {
-				// 常见 onvif 设备服务路径包含 /onvif/device_service
-				if strings.Contains(strings.ToLower(x), "onvif") {
-					ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-					info, err := fingerprint.ONVIFGetDeviceInformation(ctx, x)
-					cancel()
-					if err == nil && info != nil {
-						evidence["onvif"] = info
-						if device.Vendor == "" || strings.EqualFold(device.Vendor, "unknown") {
-							if info.Manufacturer != "" {
-								device.Vendor = info.Manufacturer
-							}
-						}
-						if device.Name == "" && info.Model != "" {
-							device.Name = info.Model
-						}
-						if device.OS == "" || strings.EqualFold(device.OS, "unknown") {
-							// ONVIF 设备通常归类为 camera
-							device.OS = "Embedded"
-						}
-						if device.Type == "" || device.Type == "unknown" || device.Type == "network_device" {
-							device.Type = "camera"
-						}
-						// 把型号写入 Vendor/Name 以外字段（数据库新增 model）
-						// 后续入库时会写入
-						if info.Model != "" {
-							device.Model = info.Model
+		}
+		if device.Vendor == "" || strings.EqualFold(device.Vendor, "unknown") {
+			if adv.Manufacturer != "" {
+				device.Vendor = adv.Manufacturer
+			}
+		}
+		// 仅在当前类型不够明确时用 SSDP 的 deviceType 做辅助判断
+		if device.Type == "" || device.Type == "unknown" || device.Type == "network_device" {
+			if t := mapDeviceTypeFromUPnP(adv.DeviceType); t != "" {
+				device.Type = t
+			}
+		}
+	}
+
+	// WS-Discovery / ONVIF 补充信息（摄像头型号/厂商）
+	if w := run.wsd[arpDevice.IP]; w != nil {
+		evidence["wsd"] = map[string]any{
+			"types":  w.Types,
+			"xaddrs": w.XAddrs,
+			"scopes": w.Scopes,
+		}
+		// 从 scopes 简单提取品牌/型号线索（不同厂商 scope 格式差异很大）
+		// 主要依赖 ONVIF GetDeviceInformation
+		for _, x := range w.XAddrs {
+			// 常见 onvif 设备服务路径包含 /onvif/device_service
+			if strings.Contains(strings.ToLower(x), "onvif") {
+				probeCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+				info, err := fingerprint.ONVIFGetDeviceInformation(probeCtx, x)
+				cancel()
+				if err == nil && info != nil {
+					evidence["onvif"] = info
+					if device.Vendor == "" || strings.EqualFold(device.Vendor, "unknown") {
+						if info.Manufacturer != "" {
+							device.Vendor = info.Manufacturer
 						}
-						break
-					} else if err != nil {
-						// 记录最后一次错误，便于排查（常见 401 需要认证）
-						evidence["onvif_error"] = err.Error()
 					}
+					if device.Name == "" && info.Model != "" {
+						device.Name = info.Model
+					}
+					if device.OS == "" || strings.EqualFold(device.OS, "unknown") {
+						// ONVIF 设备通常归类为 camera
+						device.OS = "Embedded"
+					}
+					if device.Type == "" || device.Type == "unknown" || device.Type == "network_device" {
+						device.Type = "camera"
+					}
+					// 把型号写入 Vendor/Name 以外字段（数据库新增 model）
+					// 后续入库时会写入
+					if info.Model != "" {
+						device.Model = info.Model
+					}
+					break
+				} else if err != nil {
+					// 记录最后一次错误，便于排查（常见 401 需要认证）
+					evidence["onvif_error"] = err.Error()
 				}
 			}
 		}
+	}
 
-		// HTTP 指纹补充（路由器/NAS/摄像头 Web 管理页）
-		// 仅当 80/443 端口开放时探测
-		if len(device.OpenPorts) > 0 {
-			portSet := map[int]bool{}
-			for _, p := range device.OpenPorts {
-				portSet[p] = true
-			}
-			if portSet[80] || portSet[443] {
-				ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
-				defer cancel()
-				if portSet[80] {
-					if fp, err := fingerprint.ProbeHTTPFingerprint(ctx, arpDevice.IP+":80", false); err == nil && fp != nil {
-						evidence["http_80"] = fp
-						if device.Model == "" && fp.Title != "" {
-							device.Model = fp.Title
-						}
-						if device.Vendor == "" || strings.EqualFold(device.Vendor, "unknown") {
-							if fp.Server != "" {
-								device.Vendor = fp.Server
-							}
+	// HTTP 指纹补充（路由器/NAS/摄像头 Web 管理页）
+	// 仅当 80/443 端口开放时探测
+	if len(device.OpenPorts) > 0 {
+		portSet := map[int]bool{}
+		for _, p := range device.OpenPorts {
+			portSet[p] = true
+		}
+		if portSet[80] || portSet[443] {
+			probeCtx, cancel := context.WithTimeout(ctx, 1500*time.Millisecond)
+			defer cancel()
+			if portSet[80] {
+				if fp, err := fingerprint.ProbeHTTPFingerprint(probeCtx, net.JoinHostPort(arpDevice.IP, "80"), false); err == nil && fp != nil {
+					evidence["http_80"] = fp
+					if device.Model == "" && fp.Title != "" {
+						device.Model = fp.Title
+					}
+					if device.Vendor == "" || strings.EqualFold(device.Vendor, "unknown") {
+						if fp.Server != "" {
+							device.Vendor = fp.Server
 						}
 					}
 				}
-				if device.Model == "" && portSet[443] {
-					if fp, err := fingerprint.ProbeHTTPFingerprint(ctx, arpDevice.IP+":443", true); err == nil && fp != nil {
-						evidence["https_443"] = fp
-						if device.Model == "" && fp.Title != "" {
-							device.Model = fp.Title
-						}
+			}
+			if device.Model == "" && portSet[443] {
+				if fp, err := fingerprint.ProbeHTTPFingerprint(probeCtx, net.JoinHostPort(arpDevice.IP, "443"), true); err == nil && fp != nil {
+					evidence["https_443"] = fp
+					if device.Model == "" && fp.Title != "" {
+						device.Model = fp.Title
 					}
 				}
 			}
 		}
+	}
 
-		extraJSON := ""
-		if len(evidence) > 0 {
-			if b, err := json.Marshal(evidence); err == nil {
-				extraJSON = string(b)
-			}
+	// 按 MAC 识别同一设备：DHCP 换了地址仍是同一条记录
+	prev, _ := database.GetDevice(ds.db, database.DeviceIDFor(device.MAC, device.IP))
+	if prev == nil && device.MAC != "" {
+		// 之前只按地址入库（ip_<addr>）的设备，SaveDevice 会改为 MAC 标识，不是新设备
+		prev, _ = database.GetDevice(ds.db, database.DeviceIDFor("", device.IP))
+	}
+	// 被动监听的 DHCP 线索不会在主动扫描中重现，沿用并据此修正端口推测的类型/系统
+	if prev != nil && prev.Extra != "" {
+		var prevEvidence map[string]any
+		if json.Unmarshal([]byte(prev.Extra), &prevEvidence) == nil && prevEvidence["passive"] != nil {
+			evidence["passive"] = prevEvidence["passive"]
+			applyDHCPFingerprint(dhcpFingerprintFromEvidence(evidence), &device.Type, &device.OS, evidence)
 		}
+	}
 
-		// 保存到数据库
-		dbDevice := &database.Device{
-			IP:        device.IP,
-			MAC:       device.MAC,
-			Name:      device.Name,
-			Vendor:    device.Vendor,
-			Model:     device.Model,
-			Type:      device.Type,
-			OS:        device.OS,
-			Extra:     extraJSON,
-			Status:    "online",
-			FirstSeen: time.Now(),
-			LastSeen:  time.Now(),
+	// 自定义识别规则优先级最高
+	applyRuleResult(matchRules(ds.db, prev, device.MAC, device.Name, device.OpenPorts, evidence), &device.Vendor, &device.Model, &device.Type, &device.OS, &device.Name, evidence)
+
+	extraJSON := ""
+	if len(evidence) > 0 {
+		if b, err := json.Marshal(evidence); err == nil {
+			extraJSON = string(b)
 		}
+	}
 
-		if err := database.SaveDevice(ds.db, dbDevice); err != nil {
-			logger.Error("保存设备失败: %v", err)
-		} else {
-			// 设备列表变化推送（upsert）
-			realtime.Default().Broadcast("device_upsert", map[string]interface{}{
-				"ip":        dbDevice.IP,
-				"mac":       dbDevice.MAC,
-				"name":      dbDevice.Name,
-				"vendor":    dbDevice.Vendor,
-				"type":      dbDevice.Type,
-				"os":        dbDevice.OS,
-				"status":    dbDevice.Status,
-				"last_seen": dbDevice.LastSeen.Format(time.RFC3339),
-			})
+	// 保存到数据库
+	dbDevice := &database.Device{
+		IP:        device.IP,
+		MAC:       device.MAC,
+		Name:      device.Name,
+		Vendor:    device.Vendor,
+		Model:     device.Model,
+		Type:      device.Type,
+		OS:        device.OS,
+		Extra:     extraJSON,
+		Interface: target.Interface,
+		Subnet:    target.Subnet,
+		Status:    "online",
+		FirstSeen: time.Now(),
+		LastSeen:  time.Now(),
+	}
+
+	if err := database.SaveDevice(ds.db, dbDevice); err != nil {
+		logger.Error("保存设备失败: %v", err)
+	} else {
+		if prev == nil {
+			ds.recordScanEvent(run.id, "device_new", dbDevice.ID, dbDevice.IP, dbDevice.MAC, dbDevice.Name, dbDevice.Vendor)
+		} else if prev.IP != dbDevice.IP {
+			logger.Info("设备地址变化: id=%s %s -> %s", dbDevice.ID, prev.IP, dbDevice.IP)
+			ds.recordScanEvent(run.id, "device_ip_changed", dbDevice.ID, dbDevice.IP, dbDevice.MAC, dbDevice.Name, dbDevice.Vendor)
 		}
+		// 设备列表变化推送（upsert）
+		ann, _ := database.GetDeviceAnnotation(ds.db, dbDevice.ID)
+		realtime.Default().Broadcast("device_upsert", map[string]interface{}{
+			"id":        dbDevice.ID,
+			"ip":        dbDevice.IP,
+			"mac":       dbDevice.MAC,
+			"name":      dbDevice.Name,
+			"vendor":    dbDevice.Vendor,
+			"type":      dbDevice.Type,
+			"os":        dbDevice.OS,
+			"interface": dbDevice.Interface,
+			"subnet":    dbDevice.Subnet,
+			"status":    dbDevice.Status,
+			"alias":     ann.Alias,
+			"owner":     ann.Owner,
+			"location":  ann.Location,
+			"tags":      ann.Tags,
+			"last_seen": dbDevice.LastSeen.Format(time.RFC3339),
+		})
+	}
 
-		// 端口扫描（异步，避免阻塞）
-		if len(device.OpenPorts) == 0 {
-			go ds.scanPorts(device.IP)
+	// 端口清点（异步，避免阻塞）：按配置档扫描并做协议探测，结果写入 device_ports
+	if len(run.ports)+len(run.udpPorts) > 0 && run.ctx.Err() == nil {
+		run.portWG.Add(1)
+		go func(id, ip string) {
+			defer run.portWG.Done()
+			select {
+			case run.portSem <- struct{}{}:
+			case <-run.ctx.Done():
+				return
+			}
+			defer func() { <-run.portSem }()
+			ds.scanPorts(run.ctx, id, ip, run.ports, run.udpPorts)
+		}(dbDevice.ID, device.IP)
+	}
+}
+
+// markMissingDevices 将网段内未在本次扫描中出现的在线设备标记为离线，并记录 device_gone 事件
+func (ds *deviceScanner) markMissingDevices(scanID, subnet string, seen map[string]bool) {
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return
+	}
+	devs, _, err := database.GetDevices(ds.db, "online", "", 5000, 0)
+	if err != nil {
+		logger.Error("读取设备列表失败: %v", err)
+		return
+	}
+	for _, d := range devs {
+		if seen[d.ID] {
+			continue
+		}
+		ip := net.ParseIP(d.IP)
+		if ip == nil || !ipnet.Contains(ip) {
+			continue
+		}
+		if err := database.MarkDeviceOffline(ds.db, d.ID); err != nil {
+			logger.Error("标记设备离线失败: ip=%s err=%v", d.IP, err)
+			continue
 		}
+		ds.recordScanEvent(scanID, "device_gone", d.ID, d.IP, d.MAC, d.Name, d.Vendor)
+		realtime.Default().Broadcast("device_status_changed", map[string]interface{}{
+			"id":     d.ID,
+			"ip":     d.IP,
+			"status": "offline",
+			"ts":     time.Now().Format(time.RFC3339),
+		})
 	}
+}
 
-	logger.Info("扫描完成，发现 %d 个设备", len(arpDevices))
+// recordScanEvent 记录扫描差异事件并推送
+func (ds *deviceScanner) recordScanEvent(scanID, event, id, ip, mac, name, vendor string) {
+	ev := &database.ScanEvent{
+		ScanID:   scanID,
+		Event:    event,
+		DeviceID: id,
+		DeviceIP: ip,
+		MAC:      mac,
+		Name:     name,
+		Vendor:   vendor,
+	}
+	if err := database.SaveScanEvent(ds.db, ev); err != nil {
+		logger.Error("记录扫描事件失败: %v", err)
+	}
+
+	ds.mu.Lock()
+	switch event {
+	case "device_new":
+		ds.scanStatus.NewCount++
+	case "device_gone":
+		ds.scanStatus.GoneCount++
+	}
+	ds.mu.Unlock()
+
+	realtime.Default().Broadcast("scan_event", map[string]interface{}{
+		"scan_id": scanID,
+		"event":   event,
+		"id":      id,
+		"ip":      ip,
+		"mac":     mac,
+		"name":    name,
+		"vendor":  vendor,
+		"ts":      ev.Timestamp.Format(time.RFC3339),
+	})
 }
 
 func mapDeviceTypeFromUPnP(deviceType string) string {
@@ -413,8 +905,41 @@ func mapDeviceTypeFromUPnP(deviceType string) string {
 	return ""
 }
 
+// dhcpFingerprintFromEvidence 用被动监听记录的 option 55/60 匹配 DHCP 指纹库
+func dhcpFingerprintFromEvidence(evidence map[string]any) *fingerprint.DHCPMatch {
+	passive, ok := evidence["passive"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	paramList, _ := passive["param_request_list"].(string)
+	vendorClass, _ := passive["vendor_class"].(string)
+	if paramList == "" && vendorClass == "" {
+		return nil
+	}
+	return fingerprint.DefaultDHCPFingerprints().Match(paramList, vendorClass)
+}
+
+// applyDHCPFingerprint 把 DHCP 指纹结果写入 evidence，并修正类型/系统
+// 端口猜出的系统很弱，指纹置信度 >= 60 即覆盖；类型只在不明确或置信度 >= 80 时覆盖
+func applyDHCPFingerprint(m *fingerprint.DHCPMatch, typ, osName *string, evidence map[string]any) {
+	if m == nil {
+		delete(evidence, "dhcp_fingerprint")
+		return
+	}
+	evidence["dhcp_fingerprint"] = m
+	if m.OS != "" && (*osName == "" || strings.EqualFold(*osName, "unknown") || m.Confidence >= 60) {
+		*osName = m.OS
+	}
+	if m.Type != "" {
+		weak := *typ == "" || *typ == "unknown" || *typ == "network_device" || *typ == "iot_device"
+		if weak || m.Confidence >= 80 {
+			*typ = m.Type
+		}
+	}
+}
+
 // identifyDevice 识别设备
-func (ds *deviceScanner) identifyDevice(ip, mac string) *Device {
+func (ds *deviceScanner) identifyDevice(ctx context.Context, ip, mac string) *Device {
 	device := &Device{
 		IP:     ip,
 		MAC:    mac,
@@ -425,7 +950,7 @@ func (ds *deviceScanner) identifyDevice(ip, mac string) *Device {
 	device.Vendor = identifyVendor(mac)
 
 	// 端口扫描识别设备类型
-	ports := ds.scanCommonPorts(ip)
+	ports := ds.scanCommonPorts(ctx, ip)
 	device.OpenPorts = ports
 
 	// 根据端口识别设备类型
@@ -433,72 +958,138 @@ func (ds *deviceScanner) identifyDevice(ip, mac string) *Device {
 	device.OS = identifyOS(ports)
 
 	// 尝试获取设备名称
-	device.Name = getDeviceName(ip)
+	device.Name = getDeviceName(ctx, ip)
 
 	return device
 }
 
-// scanPorts 扫描设备端口
-func (ds *deviceScanner) scanPorts(ip string) {
-	// 覆盖常见路由器/NAS/摄像头/NVR Web 端口
-	commonPorts := []int{22, 23, 53, 80, 81, 443, 445, 554, 8000, 8008, 8080, 8081, 8088, 8443, 8888, 8899, 5000, 5001, 3306, 5432, 9100}
-	updated := 0
-
-	for _, port := range commonPorts {
-		result, err := toolkit.PortScan(ip, []int{port}, 2*time.Second, "tcp")
-		if err != nil {
-			continue
-		}
-
-		for _, portInfo := range result.OpenPorts {
-			dbPort := &database.DevicePort{
-				DeviceIP: ip,
-				Port:     portInfo.Port,
-				Protocol: portInfo.Protocol,
-				Service:  portInfo.Service,
-				Version:  portInfo.Version,
-				Status:   portInfo.Status,
-			}
-			if err := database.SaveDevicePort(ds.db, ip, dbPort); err == nil {
-				updated++
+// scanPorts 按端口列表清点设备端口（portScanWorkers 个并发探测，TCP 开放端口做 banner 探测，
+// UDP 用协议探测包；UDP 只记录确认开放的端口，open|filtered 不入库）
+func (ds *deviceScanner) scanPorts(ctx context.Context, deviceID, ip string, ports, udpPorts []int) {
+	type portJob struct {
+		port  int
+		proto string
+	}
+	total := len(ports) + len(udpPorts)
+	workers := portScanWorkers
+	if workers > total {
+		workers = total
+	}
+	jobs := make(chan portJob)
+	var (
+		mu      sync.Mutex
+		updated int
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				timeout := portDialTimeout
+				if job.proto == "udp" {
+					timeout = udpProbeTimeout
+				}
+				result, err := toolkit.PortScanContext(ctx, ip, []int{job.port}, timeout, job.proto)
+				if err != nil {
+					continue
+				}
+				for _, portInfo := range result.OpenPorts {
+					dbPort := &database.DevicePort{
+						DeviceID: deviceID,
+						Port:     portInfo.Port,
+						Protocol: portInfo.Protocol,
+						Service:  portInfo.Service,
+						Version:  portInfo.Version,
+						Status:   portInfo.Status,
+						Banner:   portInfo.Banner,
+					}
+					if err := database.SaveDevicePort(ds.db, deviceID, dbPort); err == nil {
+						mu.Lock()
+						updated++
+						mu.Unlock()
+					}
+				}
 			}
+		}()
+	}
+	all := make([]portJob, 0, total)
+	for _, p := range ports {
+		all = append(all, portJob{p, "tcp"})
+	}
+	for _, p := range udpPorts {
+		all = append(all, portJob{p, "udp"})
+	}
+feed:
+	for _, job := range all {
+		select {
+		case jobs <- job:
+		case <-ctx.Done():
+			break feed
 		}
 	}
+	close(jobs)
+	wg.Wait()
 
 	if updated > 0 {
 		realtime.Default().Broadcast("device_ports_updated", map[string]interface{}{
+			"id":      deviceID,
 			"ip":      ip,
 			"updated": updated,
 		})
 	}
 }
 
-// scanCommonPorts 扫描常用端口
-func (ds *deviceScanner) scanCommonPorts(ip string) []int {
+// scanCommonPorts 扫描常用端口（各端口并发拨号，受 ctx 截止时间约束）
+func (ds *deviceScanner) scanCommonPorts(ctx context.Context, ip string) []int {
 	commonPorts := []int{22, 23, 53, 80, 81, 443, 445, 554, 8000, 8080, 8081, 8443, 8888}
-	openPorts := []int{}
+	open := make([]bool, len(commonPorts))
+
+	dialer := &net.Dialer{Timeout: 1 * time.Second}
+	var wg sync.WaitGroup
+	for i, port := range commonPorts {
+		wg.Add(1)
+		go func(i, port int) {
+			defer wg.Done()
+			conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
+			if err == nil {
+				open[i] = true
+				conn.Close()
+			}
+		}(i, port)
+	}
+	wg.Wait()
 
-	for _, port := range commonPorts {
-		result, err := toolkit.PortScan(ip, []int{port}, 1*time.Second, "tcp")
-		if err == nil && len(result.OpenPorts) > 0 {
+	openPorts := []int{}
+	for i, port := range commonPorts {
+		if open[i] {
 			openPorts = append(openPorts, port)
 		}
 	}
-
 	return openPorts
 }
 
-// StopScan 停止扫描
+// StopScan 停止扫描：取消扫描 ctx，并等待扫描 goroutine 收尾（最多 5s）
 func (ds *deviceScanner) StopScan() error {
 	ds.mu.Lock()
-	defer ds.mu.Unlock()
-
-	if !ds.scanning {
+	if !ds.scanning || ds.stopped {
+		ds.mu.Unlock()
 		return fmt.Errorf("扫描未在进行中")
 	}
+	ds.stopped = true
+	if ds.cancel != nil {
+		ds.cancel()
+	}
+	done := ds.done
+	ds.mu.Unlock()
 
-	ds.scanning = false
-	ds.scanStatus.Status = "stopped"
+	if done != nil {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			logger.Warn("停止扫描：等待扫描结束超时")
+		}
+	}
 	return nil
 }
 
@@ -511,42 +1102,84 @@ func (ds *deviceScanner) GetDevices() ([]Device, error) {
 
 	devices := make([]Device, len(dbDevices))
 	for i, d := range dbDevices {
-		devices[i] = Device{
-			IP:        d.IP,
-			MAC:       d.MAC,
-			Name:      d.Name,
-			Vendor:    d.Vendor,
-			Type:      d.Type,
-			OS:        d.OS,
-			Status:    d.Status,
-			LastSeen:  d.LastSeen.Format(time.RFC3339),
-			FirstSeen: d.FirstSeen.Format(time.RFC3339),
-		}
-
-		// 获取开放端口
-		ports, _ := database.GetDevicePorts(ds.db, d.IP)
-		openPorts := make([]int, len(ports))
-		for j, p := range ports {
-			openPorts[j] = p.Port
-		}
-		devices[i].OpenPorts = openPorts
+		devices[i] = ds.toDevice(d)
 	}
 
 	return devices, nil
 }
 
+// SearchDevices 按条件检索设备，返回当前页及总数
+func (ds *deviceScanner) SearchDevices(q database.DeviceSearch) ([]Device, int, error) {
+	dbDevices, total, err := database.SearchDevices(ds.db, q)
+	if err != nil {
+		return nil, 0, err
+	}
+	devices := make([]Device, len(dbDevices))
+	for i, d := range dbDevices {
+		devices[i] = ds.toDevice(d)
+	}
+	return devices, total, nil
+}
+
+// toDevice 数据库设备记录转为列表项（附带开放端口和地址）
+func (ds *deviceScanner) toDevice(d database.Device) Device {
+	dev := Device{
+		ID:        d.ID,
+		IP:        d.IP,
+		MAC:       d.MAC,
+		Name:      d.Name,
+		Vendor:    d.Vendor,
+		Model:     d.Model,
+		Type:      d.Type,
+		OS:        d.OS,
+		Interface: d.Interface,
+		Subnet:    d.Subnet,
+		Status:    d.Status,
+		LastSeen:  d.LastSeen.Format(time.RFC3339),
+		FirstSeen: d.FirstSeen.Format(time.RFC3339),
+		Alias:     d.Alias,
+		Owner:     d.Owner,
+		Location:  d.Location,
+		Notes:     d.Notes,
+		Tags:      d.Tags,
+	}
+
+	// 获取开放端口
+	ports, _ := database.GetDevicePorts(ds.db, d.ID)
+	openPorts := make([]int, len(ports))
+	for j, p := range ports {
+		openPorts[j] = p.Port
+	}
+	dev.OpenPorts = openPorts
+	dev.Addresses = ds.deviceAddresses(d.ID, d.IP)
+	return dev
+}
+
+// deviceAddresses 设备的全部地址（地址表为空时只有当前地址）
+func (ds *deviceScanner) deviceAddresses(id, ip string) []string {
+	list, _ := database.GetDeviceAddresses(ds.db, id)
+	out := make([]string, 0, len(list)+1)
+	for _, a := range list {
+		out = append(out, a.Address)
+	}
+	if len(out) == 0 {
+		out = append(out, ip)
+	}
+	return out
+}
+
 // GetDeviceDetail 获取设备详情
-func (ds *deviceScanner) GetDeviceDetail(ip string) (*DeviceDetail, error) {
-	dbDevice, err := database.GetDevice(ds.db, ip)
+func (ds *deviceScanner) GetDeviceDetail(key string) (*DeviceDetail, error) {
+	// key 可以是设备 ID、当前 IP 或历史地址
+	dbDevice, err := database.ResolveDevice(ds.db, key)
 	if err != nil {
 		return nil, err
 	}
-
 	if dbDevice == nil {
 		return nil, fmt.Errorf("设备不存在")
 	}
 
-	ports, _ := database.GetDevicePorts(ds.db, ip)
+	ports, _ := database.GetDevicePorts(ds.db, dbDevice.ID)
 	portInfos := make([]PortInfo, len(ports))
 	for i, p := range ports {
 		portInfos[i] = PortInfo{
@@ -555,11 +1188,26 @@ func (ds *deviceScanner) GetDeviceDetail(ip string) (*DeviceDetail, error) {
 			Service:  p.Service,
 			Version:  p.Version,
 			Status:   p.Status,
+			Banner:   p.Banner,
 		}
 	}
 
+	addrs, _ := database.GetDeviceAddresses(ds.db, dbDevice.ID)
+	addrHistory := make([]AddressInfo, 0, len(addrs))
+	for _, a := range addrs {
+		addrHistory = append(addrHistory, AddressInfo{
+			Address:   a.Address,
+			Family:    a.Family,
+			Scope:     a.Scope,
+			Interface: a.Interface,
+			FirstSeen: a.FirstSeen.Format(time.RFC3339),
+			LastSeen:  a.LastSeen.Format(time.RFC3339),
+		})
+	}
+
 	return &DeviceDetail{
 		Device: Device{
+			ID:        dbDevice.ID,
 			IP:        dbDevice.IP,
 			MAC:       dbDevice.MAC,
 			Name:      dbDevice.Name,
@@ -568,11 +1216,20 @@ func (ds *deviceScanner) GetDeviceDetail(ip string) (*DeviceDetail, error) {
 			Extra:     dbDevice.Extra,
 			Type:      dbDevice.Type,
 			OS:        dbDevice.OS,
+			Interface: dbDevice.Interface,
+			Subnet:    dbDevice.Subnet,
+			Addresses: ds.deviceAddresses(dbDevice.ID, dbDevice.IP),
 			Status:    dbDevice.Status,
 			LastSeen:  dbDevice.LastSeen.Format(time.RFC3339),
 			FirstSeen: dbDevice.FirstSeen.Format(time.RFC3339),
+			Alias:     dbDevice.Alias,
+			Owner:     dbDevice.Owner,
+			Location:  dbDevice.Location,
+			Notes:     dbDevice.Notes,
+			Tags:      dbDevice.Tags,
 		},
-		Ports: portInfos,
+		Ports:          portInfos,
+		AddressHistory: addrHistory,
 	}, nil
 }
 
@@ -659,26 +1316,43 @@ func identifyOS(ports []int) string {
 }
 
 // getDeviceName 获取设备名称
-func getDeviceName(ip string) string {
+func getDeviceName(ctx context.Context, ip string) string {
 	// 尝试反向DNS查询
-	names, err := net.LookupAddr(ip)
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
 	if err == nil && len(names) > 0 {
 		return strings.TrimSuffix(names[0], ".")
 	}
 
 	// NetBIOS (NBNS) 节点状态查询（UDP/137），对 Windows/部分NAS 很有效
-	if name, err := nbnsNodeStatusName(ip, 800*time.Millisecond); err == nil && name != "" {
-		return name
+	if timeout := boundTimeout(ctx, 800*time.Millisecond); timeout > 0 {
+		if name, err := nbnsNodeStatusName(ip, timeout); err == nil && name != "" {
+			return name
+		}
 	}
 
 	// mDNS 反向解析（PTR in-addr.arpa），对 Apple/iOS/IoT 的 `.local` 名称很有效
-	if name, err := fingerprint.MDNSReverseLookup(ip, 600*time.Millisecond); err == nil && name != "" {
-		return name
+	if timeout := boundTimeout(ctx, 600*time.Millisecond); timeout > 0 {
+		if name, err := fingerprint.MDNSReverseLookup(ip, timeout); err == nil && name != "" {
+			return name
+		}
 	}
 
 	return ""
 }
 
+// boundTimeout 用 ctx 剩余时间约束探测超时；ctx 已结束返回 0
+func boundTimeout(ctx context.Context, d time.Duration) time.Duration {
+	if ctx.Err() != nil {
+		return 0
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline); left < d {
+			return left
+		}
+	}
+	return d
+}
+
 // nbnsNodeStatusName 通过 NBNS Node Status (0x21) 获取设备 NetBIOS 名称
 func nbnsNodeStatusName(ip string, timeout time.Duration) (string, error) {
 	// 构造 NBNS 请求
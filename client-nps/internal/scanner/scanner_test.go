@@ -0,0 +1,220 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"nwct/client-nps/internal/database"
+	"nwct/client-nps/internal/fingerprint"
+)
+
+const (
+	benchHosts = 16
+	// 模拟嵌入式设备 Web 管理页的响应延迟
+	benchHTTPDelay = 50 * time.Millisecond
+)
+
+// fakeHosts 在回环地址 127.0.0.20+ 上启动假主机：8080 为 HTTP，8888 为普通 TCP；
+// 有权限时 80 端口也提供 HTTP，使 HTTP 指纹探测路径被覆盖。
+// 只有 Linux 默认把整个 127.0.0.0/8 配在 lo 上，其他系统跳过
+func fakeHosts(b *testing.B, n int) []hostJob {
+	b.Helper()
+	if runtime.GOOS != "linux" {
+		b.Skip("需要 127.0.0.0/8 整段回环地址（仅 Linux 默认可用）")
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(benchHTTPDelay)
+		w.Header().Set("Server", "fake-embedded/1.0")
+		fmt.Fprint(w, "<html><head><title>Fake Router</title></head></html>")
+	})
+	hosts := make([]hostJob, 0, n)
+	http80 := 0
+	for i := 0; i < n; i++ {
+		ip := "127.0.0." + strconv.Itoa(20+i)
+		for _, port := range []int{80, 8080, 8888} {
+			ln, err := net.Listen("tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
+			if err != nil {
+				if port == 80 {
+					continue // 无权限绑定低端口
+				}
+				b.Fatalf("监听 %s:%d 失败: %v", ip, port, err)
+			}
+			b.Cleanup(func() { ln.Close() })
+			if port == 8888 {
+				go func() {
+					for {
+						c, err := ln.Accept()
+						if err != nil {
+							return
+						}
+						c.Close()
+					}
+				}()
+				continue
+			}
+			if port == 80 {
+				http80++
+			}
+			srv := &http.Server{Handler: handler}
+			go srv.Serve(ln)
+			b.Cleanup(func() { srv.Close() })
+		}
+		mac := fmt.Sprintf("02:00:00:00:00:%02x", i)
+		hosts = append(hosts, hostJob{arp: ARPDevice{IP: ip, MAC: mac}, target: ScanTarget{Subnet: "127.0.0.0/8"}})
+	}
+	if http80 == 0 {
+		b.Logf("无法绑定 80 端口，HTTP 指纹探测未覆盖（需要 root 或 CAP_NET_BIND_SERVICE）")
+	}
+	return hosts
+}
+
+func benchScanner(b testing.TB) *deviceScanner {
+	b.Helper()
+	db, err := database.InitDB(filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatalf("初始化数据库失败: %v", err)
+	}
+	b.Cleanup(func() { db.Close() })
+	return &deviceScanner{db: db, scanStatus: &ScanStatus{}}
+}
+
+// legacyIdentify 改为 worker 池之前的识别路径：常用端口逐个拨号（1s 超时），
+// 名称查询和 HTTP 指纹各自独立超时，然后入库
+func (ds *deviceScanner) legacyIdentify(job hostJob) {
+	ip := job.arp.IP
+	open := []int{}
+	for _, port := range []int{22, 23, 53, 80, 81, 443, 445, 554, 8000, 8080, 8081, 8443, 8888} {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(port)), time.Second)
+		if err == nil {
+			open = append(open, port)
+			conn.Close()
+		}
+	}
+	device := &Device{IP: ip, MAC: job.arp.MAC, Status: "online", OpenPorts: open}
+	device.Vendor = identifyVendor(job.arp.MAC)
+	device.Type = identifyDeviceType(open)
+	device.OS = identifyOS(open)
+	device.Name = getDeviceName(context.Background(), ip)
+	for _, p := range open {
+		if p == 80 {
+			ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+			if fp, err := fingerprint.ProbeHTTPFingerprint(ctx, ip+":80", false); err == nil && fp != nil {
+				device.Model = fp.Title
+			}
+			cancel()
+		}
+	}
+	_ = database.SaveDevice(ds.db, &database.Device{
+		IP: device.IP, MAC: device.MAC, Name: device.Name, Vendor: device.Vendor, Model: device.Model,
+		Type: device.Type, OS: device.OS, Subnet: job.target.Subnet, Status: "online",
+		FirstSeen: time.Now(), LastSeen: time.Now(),
+	})
+}
+
+// BenchmarkIdentifySequential 旧路径：逐台主机、逐个端口串行识别
+func BenchmarkIdentifySequential(b *testing.B) {
+	hosts := fakeHosts(b, benchHosts)
+	ds := benchScanner(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, h := range hosts {
+			ds.legacyIdentify(h)
+		}
+	}
+	b.ReportMetric(float64(b.Elapsed().Milliseconds())/float64(b.N*len(hosts)), "ms/host")
+}
+
+// BenchmarkIdentifyPooled 新路径：forEachHost 按默认并发识别，每台主机独立截止时间
+func BenchmarkIdentifyPooled(b *testing.B) {
+	hosts := fakeHosts(b, benchHosts)
+	ds := benchScanner(b)
+	portSem := make(chan struct{}, defaultScanConcurrency)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx := context.Background()
+		run := &scanRun{ctx: ctx, id: "bench", portSem: portSem}
+		forEachHost(ctx, hosts, defaultScanConcurrency, func(h hostJob) {
+			hostCtx, cancel := context.WithTimeout(ctx, hostIdentifyTimeout)
+			ds.processHost(hostCtx, run, h)
+			cancel()
+		})
+		run.portWG.Wait()
+	}
+	b.ReportMetric(float64(b.Elapsed().Milliseconds())/float64(b.N*len(hosts)), "ms/host")
+}
+
+func TestForEachHost(t *testing.T) {
+	hosts := make([]hostJob, 20)
+	for i := range hosts {
+		hosts[i].arp.IP = "10.0.0." + strconv.Itoa(i)
+	}
+	var mu sync.Mutex
+	running, peak, done := 0, 0, 0
+	forEachHost(context.Background(), hosts, 4, func(hostJob) {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running--
+		done++
+		mu.Unlock()
+	})
+	if done != len(hosts) {
+		t.Fatalf("处理了 %d 台主机，期望 %d", done, len(hosts))
+	}
+	if peak > 4 {
+		t.Fatalf("并发峰值 %d 超过 worker 数 4", peak)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done = 0
+	forEachHost(ctx, hosts, 4, func(hostJob) { done++ })
+	if done != 0 {
+		t.Fatalf("ctx 已取消仍处理了 %d 台主机", done)
+	}
+}
+
+// 之前只按地址入库（ip_<addr>）的设备拿到 MAC 后改为 dev_<mac>，不应记为新设备
+func TestProcessHostLegacyRekey(t *testing.T) {
+	ds := benchScanner(t)
+	const ip, mac = "127.0.0.1", "02:00:00:00:00:90"
+	legacy := &database.Device{IP: ip, Status: "online", FirstSeen: time.Now(), LastSeen: time.Now()}
+	if err := database.SaveDevice(ds.db, legacy); err != nil {
+		t.Fatalf("保存设备失败: %v", err)
+	}
+	if legacy.ID != database.DeviceIDFor("", ip) {
+		t.Fatalf("旧设备 ID = %s", legacy.ID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	run := &scanRun{ctx: ctx, id: "test", ports: []int{1}, portSem: make(chan struct{}, 1)}
+	ds.processHost(ctx, run, hostJob{arp: ARPDevice{IP: ip, MAC: mac}, target: ScanTarget{Subnet: "127.0.0.0/8"}})
+	run.portWG.Wait()
+
+	if dev, _ := database.GetDevice(ds.db, database.DeviceIDFor(mac, ip)); dev == nil {
+		t.Fatalf("设备未改为 MAC 标识")
+	}
+	events, err := database.GetScanEvents(ds.db, "test", 10)
+	if err != nil {
+		t.Fatalf("读取扫描事件失败: %v", err)
+	}
+	for _, ev := range events {
+		if ev.Event == "device_new" {
+			t.Fatalf("改为 MAC 标识的旧设备被记为 device_new: %+v", ev)
+		}
+	}
+}
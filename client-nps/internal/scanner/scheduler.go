@@ -0,0 +1,229 @@
+package scanner
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"nwct/client-nps/config"
+	"nwct/client-nps/internal/logger"
+)
+
+// SubnetResolver 返回定时扫描要覆盖的目标（网段 + 接口）
+type SubnetResolver func() ([]ScanTarget, error)
+
+// SchedulerStatus 定时扫描状态
+type SchedulerStatus struct {
+	Enabled    bool      `json:"enabled"`
+	Interval   int       `json:"interval"` // 秒
+	LastRun    time.Time `json:"last_run"`
+	NextRun    time.Time `json:"next_run"`
+	LastResult string    `json:"last_result"` // started, skipped, error
+	LastError  string    `json:"last_error,omitempty"`
+	Subnets    []string  `json:"subnets"`
+}
+
+// Scheduler 定时扫描调度器：按 ScannerConfig.AutoScan/ScanInterval 周期性扫描
+// 每次 tick 都重新读取配置，修改配置后无需重启即可生效
+type Scheduler struct {
+	mu       sync.RWMutex
+	cfg      *config.Config
+	scanner  Scanner
+	resolver SubnetResolver
+	status   SchedulerStatus
+}
+
+const (
+	schedulerTick       = 5 * time.Second
+	schedulerFirstDelay = 30 * time.Second // 启动后稍等网络就绪再跑第一轮
+	minScanInterval     = 60               // 秒，避免配置过小导致持续扫描
+	defaultScanInterval = 300
+	defaultScanTimeout  = 30
+)
+
+var (
+	globalScheduler *Scheduler
+	schedulerMu     sync.RWMutex
+)
+
+// StartScheduler 启动定时扫描调度器（ctx 取消后退出）
+func StartScheduler(ctx context.Context, cfg *config.Config, s Scanner, resolver SubnetResolver) *Scheduler {
+	sc := &Scheduler{
+		cfg:      cfg,
+		scanner:  s,
+		resolver: resolver,
+	}
+	sc.status.NextRun = time.Now().Add(schedulerFirstDelay)
+
+	schedulerMu.Lock()
+	globalScheduler = sc
+	schedulerMu.Unlock()
+
+	go sc.loop(ctx)
+	return sc
+}
+
+// DefaultScheduler 返回已启动的调度器（未启动时为 nil）
+func DefaultScheduler() *Scheduler {
+	schedulerMu.RLock()
+	defer schedulerMu.RUnlock()
+	return globalScheduler
+}
+
+// Status 获取调度状态快照
+func (sc *Scheduler) Status() SchedulerStatus {
+	enabled, interval := sc.settings()
+
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	st := sc.status
+	st.Enabled = enabled
+	st.Interval = interval
+	st.Subnets = append([]string(nil), sc.status.Subnets...)
+	if !enabled {
+		st.NextRun = time.Time{}
+	}
+	return st
+}
+
+// settings 读取当前配置（每次调用都加锁读最新值，配置可能同时被 API 修改）
+func (sc *Scheduler) settings() (bool, int) {
+	if sc.cfg == nil {
+		return false, defaultScanInterval
+	}
+	cfg := sc.cfg.ScannerSettings()
+	interval := cfg.ScanInterval
+	if interval <= 0 {
+		interval = defaultScanInterval
+	}
+	if interval < minScanInterval {
+		interval = minScanInterval
+	}
+	return cfg.AutoScan, interval
+}
+
+// options 根据配置生成扫描参数
+func (sc *Scheduler) options(targets []ScanTarget) ScanOptions {
+	timeout := defaultScanTimeout
+	concurrency := 0
+	profile, ports, udpPorts := "", "", ""
+	if sc.cfg != nil {
+		cfg := sc.cfg.ScannerSettings()
+		if cfg.Timeout > 0 {
+			timeout = cfg.Timeout
+		}
+		concurrency = cfg.Concurrency
+		profile, ports, udpPorts = cfg.PortProfile, cfg.Ports, cfg.UDPPorts
+	}
+	return ScanOptions{
+		Targets:     targets,
+		Mode:        ScanModeIncremental,
+		Timeout:     time.Duration(timeout) * time.Second,
+		Concurrency: concurrency,
+		Source:      ScanSourceScheduler,
+		PortProfile: profile,
+		Ports:       ports,
+		UDPPorts:    udpPorts,
+	}
+}
+
+func (sc *Scheduler) loop(ctx context.Context) {
+	ticker := time.NewTicker(schedulerTick)
+	defer ticker.Stop()
+
+	prevInterval := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		enabled, interval := sc.settings()
+		now := time.Now()
+
+		sc.mu.Lock()
+		// 间隔被修改：按新间隔重新计算下一次运行时间
+		if prevInterval != 0 && interval != prevInterval && !sc.status.LastRun.IsZero() {
+			sc.status.NextRun = sc.status.LastRun.Add(time.Duration(interval) * time.Second)
+		}
+		prevInterval = interval
+		due := enabled && !now.Before(sc.status.NextRun)
+		sc.mu.Unlock()
+
+		if !due {
+			continue
+		}
+		sc.runOnce(ctx, interval)
+	}
+}
+
+// runOnce 执行一轮定时扫描；手动扫描进行中则跳过本轮
+func (sc *Scheduler) runOnce(ctx context.Context, interval int) {
+	now := time.Now()
+	next := now.Add(time.Duration(interval) * time.Second)
+
+	if st := sc.scanner.GetScanStatus(); st != nil && st.Status == "running" {
+		logger.Info("定时扫描：已有扫描进行中，跳过本轮")
+		sc.finish(now, next, "skipped", "", nil)
+		return
+	}
+
+	targets, err := sc.resolver()
+	if err != nil || len(targets) == 0 {
+		msg := "未检测到可扫描的网段"
+		if err != nil {
+			msg = err.Error()
+		}
+		logger.Error("定时扫描：%s", msg)
+		sc.finish(now, next, "error", msg, nil)
+		return
+	}
+
+	subnets := TargetSubnets(targets)
+	sc.finish(now, next, "started", "", subnets)
+	logger.Info("定时扫描开始: subnets=%v", subnets)
+
+	// 所有网段在同一个扫描任务中完成
+	if err := sc.scanner.StartScanWithOptions(sc.options(targets)); err != nil {
+		logger.Error("定时扫描启动失败: subnets=%v err=%v", subnets, err)
+		sc.mu.Lock()
+		sc.status.LastResult = "error"
+		sc.status.LastError = err.Error()
+		sc.mu.Unlock()
+		return
+	}
+	sc.waitScanDone(ctx)
+}
+
+// waitScanDone 等待当前扫描结束；ctx 取消或扫描被手动停止返回 false
+func (sc *Scheduler) waitScanDone(ctx context.Context) bool {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+		st := sc.scanner.GetScanStatus()
+		if st == nil {
+			return true
+		}
+		if st.Status != "running" {
+			return st.Status != "stopped"
+		}
+	}
+}
+
+func (sc *Scheduler) finish(run, next time.Time, result, errMsg string, subnets []string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.status.LastRun = run
+	sc.status.NextRun = next
+	sc.status.LastResult = result
+	sc.status.LastError = errMsg
+	if subnets != nil {
+		sc.status.Subnets = subnets
+	}
+}
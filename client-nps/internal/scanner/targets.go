@@ -0,0 +1,139 @@
+package scanner
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"nwct/client-nps/internal/logger"
+	"nwct/client-nps/internal/network"
+)
+
+// ScanTarget 一个扫描目标：网段 + 所在接口（用于给设备打标签）
+type ScanTarget struct {
+	Subnet    string `json:"subnet"`
+	Interface string `json:"interface,omitempty"`
+}
+
+const (
+	// 显式指定的网段最大 /20（4094 台主机），再大 ARP 逐个发包耗时不可接受
+	minExplicitPrefix = 20
+	// 按接口自动推导时最大 /22；更大的网段收敛到接口 IP 所在的 /22
+	minAutoPrefix = 22
+)
+
+// ResolveTargets 把 CIDR 列表和接口名列表解析为扫描目标
+// 两者都为空时，自动使用所有已启动、有 IPv4 的物理接口；接口网段按其真实 netmask 推导
+func ResolveTargets(nm network.Manager, subnets, ifaces []string) ([]ScanTarget, error) {
+	var all []network.Interface
+	if nm != nil {
+		if list, err := nm.GetInterfaces(); err == nil {
+			all = list
+		} else if len(ifaces) > 0 || len(subnets) == 0 {
+			return nil, err
+		}
+	}
+
+	targets := []ScanTarget{}
+	seen := map[string]bool{}
+	add := func(t ScanTarget) {
+		if seen[t.Subnet] {
+			return
+		}
+		seen[t.Subnet] = true
+		targets = append(targets, t)
+	}
+
+	for _, raw := range subnets {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(raw)
+		if err != nil || ipnet.IP.To4() == nil {
+			return nil, fmt.Errorf("无效的网段: %s", raw)
+		}
+		if ones, _ := ipnet.Mask.Size(); ones < minExplicitPrefix {
+			return nil, fmt.Errorf("网段过大: %s（最大 /%d）", raw, minExplicitPrefix)
+		}
+		add(ScanTarget{Subnet: ipnet.String(), Interface: interfaceForNet(all, ipnet)})
+	}
+
+	for _, name := range ifaces {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		var found *network.Interface
+		for i := range all {
+			if all[i].Name == name {
+				found = &all[i]
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("接口不存在: %s", name)
+		}
+		t, err := targetForInterface(*found)
+		if err != nil {
+			return nil, err
+		}
+		add(t)
+	}
+
+	if len(subnets) == 0 && len(ifaces) == 0 {
+		for _, iface := range all {
+			if iface.Status != "up" || iface.IP == "" || network.IsVirtualInterfaceName(iface.Name) {
+				continue
+			}
+			t, err := targetForInterface(iface)
+			if err != nil {
+				logger.Warn("跳过接口 %s: %v", iface.Name, err)
+				continue
+			}
+			add(t)
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("未检测到可扫描的网段")
+	}
+	return targets, nil
+}
+
+// TargetSubnets 返回目标的网段列表
+func TargetSubnets(targets []ScanTarget) []string {
+	out := make([]string, 0, len(targets))
+	for _, t := range targets {
+		out = append(out, t.Subnet)
+	}
+	return out
+}
+
+func targetForInterface(iface network.Interface) (ScanTarget, error) {
+	subnet, err := network.InterfaceSubnet(iface)
+	if err != nil {
+		return ScanTarget{}, err
+	}
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return ScanTarget{}, err
+	}
+	if ones, _ := ipnet.Mask.Size(); ones < minAutoPrefix {
+		mask := net.CIDRMask(minAutoPrefix, 32)
+		narrowed := &net.IPNet{IP: net.ParseIP(iface.IP).To4().Mask(mask), Mask: mask}
+		logger.Warn("接口 %s 网段 %s 过大，仅扫描 %s", iface.Name, subnet, narrowed.String())
+		subnet = narrowed.String()
+	}
+	return ScanTarget{Subnet: subnet, Interface: iface.Name}, nil
+}
+
+// interfaceForNet 查找 IP 落在该网段内的接口名（找不到返回空）
+func interfaceForNet(all []network.Interface, ipnet *net.IPNet) string {
+	for _, iface := range all {
+		if ip := net.ParseIP(iface.IP); ip != nil && ipnet.Contains(ip) {
+			return iface.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,357 @@
+package toolkit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxBannerLen 保存的原始 banner 上限（字节）
+	maxBannerLen = 512
+	// maxBannerTimeout 端口扫描时整个探测链的总时限上限，静默的开放端口最多额外耗时这么久
+	maxBannerTimeout = 2 * time.Second
+)
+
+// ServiceBanner 服务探测结果
+type ServiceBanner struct {
+	Service string `json:"service"`
+	Version string `json:"version"`
+	Banner  string `json:"banner"` // 原始响应（不可打印字符替换为 .），TLS 为证书摘要
+}
+
+// 按端口提示优先尝试的协议探测
+var (
+	tlsPorts   = map[int]bool{443: true, 465: true, 636: true, 853: true, 993: true, 995: true, 5001: true, 8443: true, 9443: true}
+	rtspPorts  = map[int]bool{554: true, 8554: true, 10554: true}
+	redisPorts = map[int]bool{6379: true, 6380: true}
+	httpPorts  = map[int]bool{80: true, 81: true, 8000: true, 8008: true, 8080: true, 8081: true, 8088: true, 8888: true, 8899: true, 5000: true, 9000: true}
+)
+
+// GrabBanner 对开放的 TCP 端口做轻量的协议探测，识别服务名和版本；
+// 先按端口提示选择探测方式，失败后依次尝试被动读取欢迎信息、HTTP、TLS。
+// timeout 是整个探测链的总时限，剩余时间平均分给尚未尝试的探测。全部失败返回 nil
+func GrabBanner(ctx context.Context, host string, port int, timeout time.Duration) *ServiceBanner {
+	if timeout <= 0 {
+		timeout = maxBannerTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+
+	type probe struct {
+		name string
+		fn   func(ctx context.Context, address string, port int, timeout time.Duration) *ServiceBanner
+	}
+	var chain []probe
+	switch {
+	case tlsPorts[port]:
+		chain = append(chain, probe{"tls", probeTLS})
+	case rtspPorts[port]:
+		chain = append(chain, probe{"rtsp", probeRTSP})
+	case redisPorts[port]:
+		chain = append(chain, probe{"redis", probeRedis})
+	case httpPorts[port]:
+		chain = append(chain, probe{"http", probeHTTP})
+	}
+	chain = append(chain, probe{"greeting", probeGreeting}, probe{"http", probeHTTP}, probe{"tls", probeTLS})
+
+	tried := map[string]bool{}
+	probes := chain[:0]
+	for _, p := range chain {
+		if !tried[p.name] {
+			tried[p.name] = true
+			probes = append(probes, p)
+		}
+	}
+	for i, p := range probes {
+		remaining := time.Until(deadline)
+		if remaining <= 0 || ctx.Err() != nil {
+			break
+		}
+		if b := p.fn(ctx, address, port, remaining/time.Duration(len(probes)-i)); b != nil {
+			return b
+		}
+	}
+	return nil
+}
+
+func dialProbe(ctx context.Context, address string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	return conn, nil
+}
+
+// readSome 读取对端数据直到超时/对端关闭/达到上限
+func readSome(conn net.Conn, limit int) []byte {
+	buf := make([]byte, 0, limit)
+	tmp := make([]byte, 512)
+	for len(buf) < limit {
+		n, err := conn.Read(tmp)
+		buf = append(buf, tmp[:n]...)
+		if err != nil {
+			break
+		}
+		// 行式协议收到完整一行即可
+		if n > 0 && bytes.Contains(buf, []byte("\n")) && !bytes.HasPrefix(buf, []byte("HTTP/")) && !bytes.HasPrefix(buf, []byte("RTSP/")) {
+			break
+		}
+		if bytes.Contains(buf, []byte("\r\n\r\n")) || isMySQLHandshake(buf) {
+			break
+		}
+	}
+	if len(buf) > limit {
+		buf = buf[:limit]
+	}
+	return buf
+}
+
+// probeGreeting 连接后等待服务端主动发送的欢迎信息（SSH/FTP/SMTP/MySQL/POP3/IMAP 等）
+func probeGreeting(ctx context.Context, address string, port int, timeout time.Duration) *ServiceBanner {
+	conn, err := dialProbe(ctx, address, timeout)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	data := readSome(conn, maxBannerLen)
+	if len(data) == 0 {
+		return nil
+	}
+	return classifyGreeting(data, port)
+}
+
+// classifyGreeting 按欢迎信息识别协议
+func classifyGreeting(data []byte, port int) *ServiceBanner {
+	b := &ServiceBanner{Banner: sanitizeBanner(data)}
+	line := strings.TrimSpace(firstLine(string(data)))
+
+	switch {
+	case strings.HasPrefix(line, "SSH-"):
+		// SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.1
+		b.Service = "ssh"
+		if parts := strings.SplitN(line, "-", 3); len(parts) == 3 {
+			b.Version = parts[2]
+		}
+	case isMySQLHandshake(data):
+		b.Service = "mysql"
+		b.Version = mysqlVersion(data)
+	case strings.HasPrefix(line, "220"):
+		text := strings.TrimSpace(strings.TrimLeft(line[3:], "- "))
+		upper := strings.ToUpper(text)
+		switch {
+		case strings.Contains(upper, "SMTP") || port == 25 || port == 465 || port == 587:
+			b.Service = "smtp"
+			if i := strings.Index(upper, "SMTP"); i >= 0 {
+				b.Version = strings.TrimSpace(text[i+4:])
+			}
+		default:
+			b.Service = "ftp"
+			b.Version = strings.Trim(text, "()[] ")
+		}
+	case strings.HasPrefix(line, "+OK"):
+		b.Service = "pop3"
+		b.Version = strings.TrimSpace(strings.TrimPrefix(line, "+OK"))
+	case strings.HasPrefix(line, "* OK"):
+		b.Service = "imap"
+		b.Version = strings.TrimSpace(strings.TrimPrefix(line, "* OK"))
+	case strings.HasPrefix(line, "HTTP/"):
+		b.Service = "http"
+		b.Version = headerValue(string(data), "Server")
+	default:
+		b.Service = identifyService(port)
+	}
+	return b
+}
+
+// probeHTTP 发送 HEAD 请求，取 Server 头作为版本
+func probeHTTP(ctx context.Context, address string, port int, timeout time.Duration) *ServiceBanner {
+	conn, err := dialProbe(ctx, address, timeout)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	return httpOverConn(conn, address, "http")
+}
+
+func httpOverConn(conn net.Conn, address, service string) *ServiceBanner {
+	host, _, _ := net.SplitHostPort(address)
+	fmt.Fprintf(conn, "HEAD / HTTP/1.0\r\nHost: %s\r\nUser-Agent: nwct-probe\r\nConnection: close\r\n\r\n", host)
+	data := readSome(conn, maxBannerLen)
+	if !bytes.HasPrefix(data, []byte("HTTP/")) {
+		return nil
+	}
+	// 明文请求打到 TLS 端口（Go/nginx 会返回 400 提示），交给后续 TLS 探测
+	if lower := bytes.ToLower(data); bytes.Contains(lower, []byte("https server")) || bytes.Contains(lower, []byte("to https port")) {
+		return nil
+	}
+	return &ServiceBanner{
+		Service: service,
+		Version: headerValue(string(data), "Server"),
+		Banner:  sanitizeBanner(data),
+	}
+}
+
+// probeRTSP 发送 OPTIONS 请求（摄像头/NVR）
+func probeRTSP(ctx context.Context, address string, port int, timeout time.Duration) *ServiceBanner {
+	conn, err := dialProbe(ctx, address, timeout)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "OPTIONS rtsp://%s/ RTSP/1.0\r\nCSeq: 1\r\nUser-Agent: nwct-probe\r\n\r\n", address)
+	data := readSome(conn, maxBannerLen)
+	if !bytes.HasPrefix(data, []byte("RTSP/")) {
+		return nil
+	}
+	return &ServiceBanner{
+		Service: "rtsp",
+		Version: headerValue(string(data), "Server"),
+		Banner:  sanitizeBanner(data),
+	}
+}
+
+// probeRedis 发送 INFO server；需要认证时只能确认是 Redis
+func probeRedis(ctx context.Context, address string, port int, timeout time.Duration) *ServiceBanner {
+	conn, err := dialProbe(ctx, address, timeout)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	conn.Write([]byte("INFO server\r\n"))
+	reader := bufio.NewReader(conn)
+	head, err := reader.ReadString('\n')
+	if err != nil || head == "" {
+		return nil
+	}
+	b := &ServiceBanner{Service: "redis", Banner: sanitizeBanner([]byte(head))}
+	switch head[0] {
+	case '$':
+		n, _ := strconv.Atoi(strings.TrimSpace(head[1:]))
+		if n <= 0 || n > 64*1024 {
+			return b
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return b
+		}
+		b.Banner = sanitizeBanner(body)
+		for _, l := range strings.Split(string(body), "\n") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(l), "redis_version:"); ok {
+				b.Version = v
+				break
+			}
+		}
+	case '-':
+		// -NOAUTH Authentication required.
+	default:
+		return nil
+	}
+	return b
+}
+
+// probeTLS TLS 握手取证书主题/签发者/到期时间，握手成功后再尝试 HTTP 取 Server 头
+func probeTLS(ctx context.Context, address string, port int, timeout time.Duration) *ServiceBanner {
+	raw, err := dialProbe(ctx, address, timeout)
+	if err != nil {
+		return nil
+	}
+	defer raw.Close()
+	host, _, _ := net.SplitHostPort(address)
+	cfg := &tls.Config{InsecureSkipVerify: true} // 只读取证书信息，不做校验
+	if net.ParseIP(host) == nil {
+		cfg.ServerName = host
+	}
+	conn := tls.Client(raw, cfg)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return nil
+	}
+
+	b := &ServiceBanner{Service: "tls"}
+	if certs := conn.ConnectionState().PeerCertificates; len(certs) > 0 {
+		c := certs[0]
+		b.Banner = fmt.Sprintf("subject=%s; issuer=%s; not_after=%s",
+			c.Subject.String(), c.Issuer.String(), c.NotAfter.Format(time.RFC3339))
+	}
+	if h := httpOverConn(conn, address, "https"); h != nil {
+		b.Service = "https"
+		b.Version = h.Version
+		if h.Version != "" {
+			b.Banner += "; server=" + h.Version
+		}
+	} else if s := identifyService(port); s != "unknown" {
+		b.Service = s
+	}
+	return b
+}
+
+func isMySQLHandshake(data []byte) bool {
+	// 3 字节长度 + 1 字节序号 0 + 协议版本 10；或 0xff 错误包（如 "Host ... is not allowed to connect"）
+	if len(data) < 7 || data[3] != 0 {
+		return false
+	}
+	n := int(data[0]) | int(data[1])<<8 | int(data[2])<<16
+	if n == 0 || n > 1024 {
+		return false
+	}
+	if data[4] == 0xff {
+		return binary.LittleEndian.Uint16(data[5:7]) >= 1000
+	}
+	return data[4] == 0x0a && bytes.IndexByte(data[5:], 0) > 0
+}
+
+func mysqlVersion(data []byte) string {
+	if data[4] != 0x0a {
+		return ""
+	}
+	if end := bytes.IndexByte(data[5:], 0); end > 0 {
+		return string(data[5 : 5+end])
+	}
+	return ""
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexAny(s, "\r\n"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// headerValue 从 HTTP/RTSP 响应中取头部值
+func headerValue(resp, key string) string {
+	reader := textproto.NewReader(bufio.NewReader(strings.NewReader(resp)))
+	if _, err := reader.ReadLine(); err != nil {
+		return ""
+	}
+	h, _ := reader.ReadMIMEHeader()
+	return strings.TrimSpace(h.Get(key))
+}
+
+// sanitizeBanner 不可打印字符替换为 .（保留换行），截断到 maxBannerLen
+func sanitizeBanner(data []byte) string {
+	if len(data) > maxBannerLen {
+		data = data[:maxBannerLen]
+	}
+	out := make([]byte, len(data))
+	for i, c := range data {
+		if c == '\n' || c == '\r' || c == '\t' || (c >= 0x20 && c < 0x7f) {
+			out[i] = c
+		} else {
+			out[i] = '.'
+		}
+	}
+	return strings.TrimSpace(string(out))
+}
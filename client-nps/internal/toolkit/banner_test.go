@@ -0,0 +1,89 @@
+package toolkit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveLoopback 在回环地址上监听，每个连接交给 handle 处理
+func serveLoopback(t *testing.T, handle func(net.Conn)) (string, int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func TestGrabBanner(t *testing.T) {
+	tests := []struct {
+		name        string
+		handle      func(net.Conn)
+		wantService string
+		wantVersion string
+	}{
+		{
+			name: "SSH 欢迎信息",
+			handle: func(c net.Conn) {
+				defer c.Close()
+				c.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+				time.Sleep(time.Second)
+			},
+			wantService: "ssh",
+			wantVersion: "OpenSSH_9.6",
+		},
+		{
+			name: "HTTP 在欢迎信息之后探测",
+			handle: func(c net.Conn) {
+				defer c.Close()
+				buf := make([]byte, 512)
+				if n, _ := c.Read(buf); n > 0 {
+					c.Write([]byte("HTTP/1.0 200 OK\r\nServer: lighttpd/1.4\r\n\r\n"))
+				}
+			},
+			wantService: "http",
+			wantVersion: "lighttpd/1.4",
+		},
+		{
+			name: "静默端口",
+			handle: func(c net.Conn) {
+				defer c.Close()
+				time.Sleep(2 * time.Second)
+			},
+		},
+	}
+	const budget = 600 * time.Millisecond
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port := serveLoopback(t, tt.handle)
+			start := time.Now()
+			b := GrabBanner(context.Background(), host, port, budget)
+			// 整个探测链共用一个时限，不能按探测个数成倍增加
+			if elapsed := time.Since(start); elapsed > budget+300*time.Millisecond {
+				t.Fatalf("探测耗时 %v，超过总时限 %v", elapsed, budget)
+			}
+			if tt.wantService == "" {
+				if b != nil {
+					t.Fatalf("静默端口不应识别出服务: %+v", b)
+				}
+				return
+			}
+			if b == nil || b.Service != tt.wantService || b.Version != tt.wantVersion {
+				t.Fatalf("GrabBanner = %+v，期望 %s %s", b, tt.wantService, tt.wantVersion)
+			}
+		})
+	}
+}
@@ -1,8 +1,26 @@
 package toolkit
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"math"
 	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 // DNSRecord DNS记录
@@ -13,104 +31,553 @@ type DNSRecord struct {
 	TTL   int    `json:"ttl"`
 }
 
-// DNSQuery 执行DNS查询
+// DNSFlags 响应头标志位
+type DNSFlags struct {
+	Authoritative      bool `json:"aa"`
+	Truncated          bool `json:"tc"`
+	RecursionDesired   bool `json:"rd"`
+	RecursionAvailable bool `json:"ra"`
+	AuthenticData      bool `json:"ad"`
+	CheckingDisabled   bool `json:"cd"`
+}
+
+// DNSResult 一次DNS查询的完整结果
+type DNSResult struct {
+	Query       string      `json:"query"`
+	Type        string      `json:"type"`
+	Server      string      `json:"server"`    // 实际查询的服务器（host:port 或 DoH URL）
+	Transport   string      `json:"transport"` // udp / tcp / dot / doh
+	TCPFallback bool        `json:"tcp_fallback,omitempty"`
+	Rcode       string      `json:"rcode"` // NOERROR / NXDOMAIN / SERVFAIL / REFUSED ...
+	Flags       DNSFlags    `json:"flags"`
+	Records     []DNSRecord `json:"records"` // answer 段
+	Authority   []DNSRecord `json:"authority,omitempty"`
+	Additional  []DNSRecord `json:"additional,omitempty"`
+	Time        float64     `json:"time"` // 往返耗时（ms，含建立连接/TLS 握手）
+	Size        int         `json:"size"` // 响应字节数
+}
+
+// DNSOptions DNS查询参数
+type DNSOptions struct {
+	Type string
+	// Server 为空时使用系统配置的第一个 DNS 服务器；支持 host[:port]、udp://、tcp://、tls://host[:853]、https://.../dns-query
+	Server string
+	// Transport 在 Server 没有写协议前缀时指定：udp（默认）/ tcp / dot / doh
+	Transport string
+	Timeout   time.Duration // 默认 5 秒
+	NoRecurse bool          // 不设置 RD 位（直接查询权威服务器时使用）
+}
+
+// DNSCompareEntry 对比模式下单个服务器的结果
+type DNSCompareEntry struct {
+	Server string     `json:"server"`
+	Result *DNSResult `json:"result,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// DNSCompareResult 多个服务器对同一查询的结果对比
+type DNSCompareResult struct {
+	Query      string            `json:"query"`
+	Type       string            `json:"type"`
+	Consistent bool              `json:"consistent"` // 成功的服务器返回的 rcode 和记录值（忽略 TTL 和顺序）是否一致
+	Results    []DNSCompareEntry `json:"results"`
+}
+
+// DefaultCompareServers 对比模式未指定服务器时使用：系统 DNS + 常用公共 DNS
+var DefaultCompareServers = []string{"", "223.5.5.5", "119.29.29.29", "8.8.8.8", "1.1.1.1"}
+
+const (
+	dnsCAAType  = dnsmessage.Type(257) // dnsmessage 没有 CAA 常量
+	dnsUDPSize  = 1232
+	dnsMaxReply = 65535
+)
+
+var dnsTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"TXT":   dnsmessage.TypeTXT,
+	"NS":    dnsmessage.TypeNS,
+	"SOA":   dnsmessage.TypeSOA,
+	"SRV":   dnsmessage.TypeSRV,
+	"PTR":   dnsmessage.TypePTR,
+	"CAA":   dnsCAAType,
+}
+
+// DNSQuery 执行DNS查询，只返回 answer 段记录
 func DNSQuery(query, queryType, server string) ([]DNSRecord, error) {
-	if server == "" {
-		server = "8.8.8.8:53"
-	} else {
-		if _, _, err := net.SplitHostPort(server); err != nil {
-			server = server + ":53"
+	r, err := DNSLookup(context.Background(), query, DNSOptions{Type: queryType, Server: server})
+	if err != nil {
+		return nil, err
+	}
+	return r.Records, nil
+}
+
+// DNSLookup 向指定服务器发送查询（UDP 响应被截断时自动改用 TCP 重试），返回记录、TTL、响应码、标志位和耗时；
+// NXDOMAIN/SERVFAIL 等不算错误，通过 rcode 返回
+func DNSLookup(ctx context.Context, query string, opts DNSOptions) (*DNSResult, error) {
+	qtype := strings.ToUpper(strings.TrimSpace(opts.Type))
+	if qtype == "" {
+		qtype = "A"
+	}
+	t, ok := dnsTypes[qtype]
+	if !ok {
+		return nil, fmt.Errorf("不支持的记录类型: %s", opts.Type)
+	}
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, fmt.Errorf("查询名称不能为空")
+	}
+	qname := query
+	if t == dnsmessage.TypePTR {
+		if ip := net.ParseIP(query); ip != nil {
+			qname = reverseName(ip)
 		}
 	}
+	if !strings.HasSuffix(qname, ".") {
+		qname += "."
+	}
+	name, err := dnsmessage.NewName(qname)
+	if err != nil {
+		return nil, fmt.Errorf("无效的查询名称: %v", err)
+	}
+	srv, err := parseDNSServer(opts.Server, opts.Transport)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
 
-	records := []DNSRecord{}
+	id := dnsID()
+	if srv.transport == "doh" {
+		// RFC 8484：DoH 请求 ID 置 0，便于 HTTP 缓存
+		id = 0
+	}
+	wire, err := buildDNSQuery(id, name, t, !opts.NoRecurse)
+	if err != nil {
+		return nil, err
+	}
 
-	switch queryType {
-	case "A", "AAAA":
-		ips, err := net.LookupIP(query)
-		if err != nil {
-			return nil, err
-		}
+	result := &DNSResult{Query: query, Type: qtype, Server: srv.display(), Transport: srv.transport}
+	start := time.Now()
+	resp, err := srv.exchange(ctx, wire, id)
+	if err == nil && srv.transport == "udp" && len(resp) > 2 && resp[2]&0x02 != 0 {
+		// TC 位：改用 TCP 重新查询
+		tcp := srv
+		tcp.transport = "tcp"
+		start = time.Now()
+		resp, err = tcp.exchange(ctx, wire, id)
+		result.TCPFallback = true
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询 %s 失败: %v", result.Server, err)
+	}
+	result.Time = math.Round(float64(time.Since(start).Microseconds())) / 1000
+	result.Size = len(resp)
 
-		for _, ip := range ips {
-			recordType := "A"
-			if ip.To4() == nil {
-				recordType = "AAAA"
-			}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		return nil, fmt.Errorf("解析DNS响应失败: %v", err)
+	}
+	if msg.Header.ID != id || !msg.Header.Response {
+		return nil, fmt.Errorf("DNS响应与请求不匹配")
+	}
+	result.Rcode = rcodeName(msg.Header.RCode)
+	result.Flags = DNSFlags{
+		Authoritative:      msg.Header.Authoritative,
+		Truncated:          msg.Header.Truncated,
+		RecursionDesired:   msg.Header.RecursionDesired,
+		RecursionAvailable: msg.Header.RecursionAvailable,
+		AuthenticData:      msg.Header.AuthenticData,
+		CheckingDisabled:   msg.Header.CheckingDisabled,
+	}
+	result.Records = convertResources(msg.Answers)
+	result.Authority = convertResources(msg.Authorities)
+	result.Additional = convertResources(msg.Additionals)
+	return result, nil
+}
 
-			if queryType == "" || queryType == recordType {
-				records = append(records, DNSRecord{
-					Name:  query,
-					Type:  recordType,
-					Value: ip.String(),
-					TTL:   3600,
-				})
+// DNSCompare 同时向多个服务器发送同一查询，对比结果
+func DNSCompare(ctx context.Context, query string, servers []string, opts DNSOptions) *DNSCompareResult {
+	if len(servers) == 0 {
+		servers = DefaultCompareServers
+	}
+	qtype := strings.ToUpper(strings.TrimSpace(opts.Type))
+	if qtype == "" {
+		qtype = "A"
+	}
+	out := &DNSCompareResult{Query: query, Type: qtype, Results: make([]DNSCompareEntry, len(servers))}
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server string) {
+			defer wg.Done()
+			o := opts
+			o.Server = server
+			entry := DNSCompareEntry{Server: server}
+			r, err := DNSLookup(ctx, query, o)
+			if err != nil {
+				entry.Error = err.Error()
+			} else {
+				entry.Server = r.Server
+				entry.Result = r
 			}
-		}
+			out.Results[i] = entry
+		}(i, server)
+	}
+	wg.Wait()
 
-	case "PTR":
-		names, err := net.LookupAddr(query)
-		if err != nil {
-			return nil, err
+	out.Consistent = true
+	first := ""
+	seen := false
+	for _, e := range out.Results {
+		if e.Result == nil {
+			continue
+		}
+		sig := answerSignature(e.Result)
+		if !seen {
+			first, seen = sig, true
+		} else if sig != first {
+			out.Consistent = false
 		}
+	}
+	return out
+}
+
+// answerSignature rcode + 排序后的记录（类型和值），用于对比
+func answerSignature(r *DNSResult) string {
+	vals := make([]string, 0, len(r.Records))
+	for _, rec := range r.Records {
+		vals = append(vals, rec.Type+" "+strings.ToLower(rec.Value))
+	}
+	sort.Strings(vals)
+	return r.Rcode + "|" + strings.Join(vals, "|")
+}
+
+func buildDNSQuery(id uint16, name dnsmessage.Name, t dnsmessage.Type, recurse bool) ([]byte, error) {
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{ID: id, RecursionDesired: recurse})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: name, Type: t, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	// EDNS0：声明可接收较大的 UDP 响应，减少截断
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	var rh dnsmessage.ResourceHeader
+	if err := rh.SetEDNS0(dnsUDPSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	if err := b.OPTResource(rh, dnsmessage.OPTResource{}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// dnsServer 解析后的服务器
+type dnsServer struct {
+	transport string // udp / tcp / dot / doh
+	addr      string // host:port（doh 为空）
+	host      string // DoT 证书校验用的主机名
+	url       string // doh
+}
+
+func (s dnsServer) display() string {
+	if s.transport == "doh" {
+		return s.url
+	}
+	return s.addr
+}
+
+// parseDNSServer 解析服务器写法：协议前缀优先于 transport 参数
+func parseDNSServer(server, transport string) (dnsServer, error) {
+	server = strings.TrimSpace(server)
+	transport = strings.ToLower(strings.TrimSpace(transport))
+	switch transport {
+	case "", "udp", "tcp", "dot", "doh":
+	case "tls":
+		transport = "dot"
+	case "https":
+		transport = "doh"
+	default:
+		return dnsServer{}, fmt.Errorf("不支持的传输方式: %s", transport)
+	}
 
-		for _, name := range names {
-			records = append(records, DNSRecord{
-				Name:  query,
-				Type:  "PTR",
-				Value: name,
-				TTL:   3600,
-			})
+	lower := strings.ToLower(server)
+	switch {
+	case strings.HasPrefix(lower, "https://"):
+		return dnsServer{transport: "doh", url: server}, nil
+	case strings.HasPrefix(lower, "tls://"):
+		transport, server = "dot", server[len("tls://"):]
+	case strings.HasPrefix(lower, "tcp://"):
+		transport, server = "tcp", server[len("tcp://"):]
+	case strings.HasPrefix(lower, "udp://"):
+		transport, server = "udp", server[len("udp://"):]
+	}
+	if transport == "" {
+		transport = "udp"
+	}
+	if server == "" {
+		if transport == "doh" {
+			return dnsServer{}, fmt.Errorf("DoH 需要指定服务器")
 		}
+		server = systemNameserver()
+	}
+	if transport == "doh" {
+		return dnsServer{transport: "doh", url: "https://" + strings.TrimSuffix(server, "/") + "/dns-query"}, nil
+	}
 
-	case "CNAME":
-		cname, err := net.LookupCNAME(query)
-		if err != nil {
-			return nil, err
+	port := "53"
+	if transport == "dot" {
+		port = "853"
+	}
+	host := server
+	if ip := net.ParseIP(strings.Trim(server, "[]")); ip != nil {
+		host = ip.String()
+		server = net.JoinHostPort(host, port)
+	} else if h, _, err := net.SplitHostPort(server); err == nil {
+		host = h
+	} else {
+		server = net.JoinHostPort(server, port)
+	}
+	return dnsServer{transport: transport, addr: server, host: host}, nil
+}
+
+// systemNameserver 系统配置的第一个 DNS 服务器，读取不到时用 8.8.8.8
+func systemNameserver() string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err == nil {
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			fields := strings.Fields(sc.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" && net.ParseIP(strings.SplitN(fields[1], "%", 2)[0]) != nil {
+				return fields[1]
+			}
 		}
+	}
+	return "8.8.8.8"
+}
 
-		records = append(records, DNSRecord{
-			Name:  query,
-			Type:  "CNAME",
-			Value: cname,
-			TTL:   3600,
-		})
+func (s dnsServer) exchange(ctx context.Context, query []byte, id uint16) ([]byte, error) {
+	switch s.transport {
+	case "doh":
+		return s.exchangeHTTPS(ctx, query)
+	case "tcp", "dot":
+		return s.exchangeStream(ctx, query)
+	}
+	return s.exchangeUDP(ctx, query, id)
+}
 
-	case "MX":
-		mxs, err := net.LookupMX(query)
+func (s dnsServer) exchangeUDP(ctx context.Context, query []byte, id uint16) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+	if _, err := conn.Write(query); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	buf := make([]byte, dnsMaxReply)
+	for {
+		n, err := conn.Read(buf)
 		if err != nil {
-			return nil, err
+			return nil, ctxErr(ctx, err)
 		}
-
-		for _, mx := range mxs {
-			records = append(records, DNSRecord{
-				Name:  query,
-				Type:  "MX",
-				Value: fmt.Sprintf("%d %s", mx.Pref, mx.Host),
-				TTL:   3600,
-			})
+		// 忽略 ID 不符的迟到响应
+		if n >= 12 && binary.BigEndian.Uint16(buf) == id {
+			return append([]byte(nil), buf[:n]...), nil
 		}
+	}
+}
 
-	case "TXT":
-		txts, err := net.LookupTXT(query)
-		if err != nil {
-			return nil, err
+func (s dnsServer) exchangeStream(ctx context.Context, query []byte) ([]byte, error) {
+	var conn net.Conn
+	var err error
+	if s.transport == "dot" {
+		d := tls.Dialer{Config: &tls.Config{ServerName: s.host}}
+		conn, err = d.DialContext(ctx, "tcp", s.addr)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	var l [2]byte
+	if _, err := io.ReadFull(conn, l[:]); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	return resp, nil
+}
+
+func (s dnsServer) exchangeHTTPS(ctx context.Context, query []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, dnsMaxReply))
+}
+
+// closeOnDone ctx 结束时关闭连接，使阻塞的读写立即返回
+func closeOnDone(ctx context.Context, conn net.Conn) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
 		}
+	}()
+	return func() { close(done) }
+}
+
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("超时")
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func dnsID() uint16 {
+	var b [2]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint16(b[:])
+}
+
+func reverseName(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", v4[3], v4[2], v4[1], v4[0])
+	}
+	var sb strings.Builder
+	v6 := ip.To16()
+	for i := len(v6) - 1; i >= 0; i-- {
+		fmt.Fprintf(&sb, "%x.%x.", v6[i]&0x0f, v6[i]>>4)
+	}
+	sb.WriteString("ip6.arpa.")
+	return sb.String()
+}
 
-		for _, txt := range txts {
-			records = append(records, DNSRecord{
-				Name:  query,
-				Type:  "TXT",
-				Value: txt,
-				TTL:   3600,
-			})
+func rcodeName(rc dnsmessage.RCode) string {
+	switch rc {
+	case dnsmessage.RCodeSuccess:
+		return "NOERROR"
+	case dnsmessage.RCodeFormatError:
+		return "FORMERR"
+	case dnsmessage.RCodeServerFailure:
+		return "SERVFAIL"
+	case dnsmessage.RCodeNameError:
+		return "NXDOMAIN"
+	case dnsmessage.RCodeNotImplemented:
+		return "NOTIMP"
+	case dnsmessage.RCodeRefused:
+		return "REFUSED"
+	}
+	return "RCODE" + strconv.Itoa(int(rc))
+}
+
+func typeName(t dnsmessage.Type) string {
+	for name, v := range dnsTypes {
+		if v == t {
+			return name
 		}
+	}
+	return strings.TrimPrefix(t.String(), "Type")
+}
 
-	default:
-		// 默认查询A记录
-		return DNSQuery(query, "A", server)
+// convertResources 转为展示用记录（跳过 EDNS0 OPT 伪记录）
+func convertResources(rrs []dnsmessage.Resource) []DNSRecord {
+	records := []DNSRecord{}
+	for _, rr := range rrs {
+		if rr.Header.Type == dnsmessage.TypeOPT {
+			continue
+		}
+		records = append(records, DNSRecord{
+			Name:  rr.Header.Name.String(),
+			Type:  typeName(rr.Header.Type),
+			Value: resourceValue(rr.Body),
+			TTL:   int(rr.Header.TTL),
+		})
 	}
+	return records
+}
 
-	return records, nil
+// resourceValue 按 dig 的格式输出记录值
+func resourceValue(body dnsmessage.ResourceBody) string {
+	switch b := body.(type) {
+	case *dnsmessage.AResource:
+		return net.IP(b.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		return net.IP(b.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		return b.CNAME.String()
+	case *dnsmessage.NSResource:
+		return b.NS.String()
+	case *dnsmessage.PTRResource:
+		return b.PTR.String()
+	case *dnsmessage.MXResource:
+		return fmt.Sprintf("%d %s", b.Pref, b.MX.String())
+	case *dnsmessage.TXTResource:
+		// 与 net.LookupTXT 一致：同一条记录的多个字符串直接拼接
+		return strings.Join(b.TXT, "")
+	case *dnsmessage.SOAResource:
+		return fmt.Sprintf("%s %s %d %d %d %d %d", b.NS.String(), b.MBox.String(), b.Serial, b.Refresh, b.Retry, b.Expire, b.MinTTL)
+	case *dnsmessage.SRVResource:
+		return fmt.Sprintf("%d %d %d %s", b.Priority, b.Weight, b.Port, b.Target.String())
+	case *dnsmessage.UnknownResource:
+		if b.Type == dnsCAAType {
+			if v, ok := caaValue(b.Data); ok {
+				return v
+			}
+		}
+		// RFC 3597 未知类型写法
+		return fmt.Sprintf("\\# %d %s", len(b.Data), hex.EncodeToString(b.Data))
+	}
+	return ""
 }
 
+// caaValue CAA：flags(1) + tag 长度(1) + tag + value
+func caaValue(data []byte) (string, bool) {
+	if len(data) < 2 || len(data) < 2+int(data[1]) {
+		return "", false
+	}
+	tagEnd := 2 + int(data[1])
+	return fmt.Sprintf("%d %s %q", data[0], data[2:tagEnd], data[tagEnd:]), true
+}
@@ -0,0 +1,197 @@
+package toolkit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestBuildDNSQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		qname   string
+		qtype   dnsmessage.Type
+		recurse bool
+	}{
+		{"A 递归", "example.com.", dnsmessage.TypeA, true},
+		{"PTR 不递归", "1.1.168.192.in-addr.arpa.", dnsmessage.TypePTR, false},
+		{"CAA", "example.org.", dnsCAAType, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wire, err := buildDNSQuery(0x1234, dnsmessage.MustNewName(tt.qname), tt.qtype, tt.recurse)
+			if err != nil {
+				t.Fatalf("构造查询失败: %v", err)
+			}
+			var msg dnsmessage.Message
+			if err := msg.Unpack(wire); err != nil {
+				t.Fatalf("解析查询失败: %v", err)
+			}
+			if msg.Header.ID != 0x1234 || msg.Header.Response || msg.Header.RecursionDesired != tt.recurse {
+				t.Fatalf("头部不符: %+v", msg.Header)
+			}
+			if len(msg.Questions) != 1 {
+				t.Fatalf("问题数 = %d", len(msg.Questions))
+			}
+			q := msg.Questions[0]
+			if q.Name.String() != tt.qname || q.Type != tt.qtype || q.Class != dnsmessage.ClassINET {
+				t.Fatalf("问题不符: %+v", q)
+			}
+			if len(msg.Additionals) != 1 || msg.Additionals[0].Header.Type != dnsmessage.TypeOPT ||
+				msg.Additionals[0].Header.Class != dnsmessage.Class(dnsUDPSize) {
+				t.Fatalf("缺少 EDNS0 OPT 记录: %+v", msg.Additionals)
+			}
+		})
+	}
+}
+
+func TestParseDNSServer(t *testing.T) {
+	tests := []struct {
+		server, transport string
+		want              dnsServer
+		wantErr           bool
+	}{
+		{server: "192.168.1.1", want: dnsServer{transport: "udp", addr: "192.168.1.1:53", host: "192.168.1.1"}},
+		{server: "192.168.1.1:5353", transport: "tcp", want: dnsServer{transport: "tcp", addr: "192.168.1.1:5353", host: "192.168.1.1"}},
+		{server: "2001:db8::1", want: dnsServer{transport: "udp", addr: "[2001:db8::1]:53", host: "2001:db8::1"}},
+		{server: "[2001:db8::1]", transport: "tls", want: dnsServer{transport: "dot", addr: "[2001:db8::1]:853", host: "2001:db8::1"}},
+		{server: "tls://dns.google", want: dnsServer{transport: "dot", addr: "dns.google:853", host: "dns.google"}},
+		{server: "TCP://1.1.1.1", transport: "udp", want: dnsServer{transport: "tcp", addr: "1.1.1.1:53", host: "1.1.1.1"}},
+		{server: "https://dns.example/dns-query", want: dnsServer{transport: "doh", url: "https://dns.example/dns-query"}},
+		{server: "dns.example/", transport: "doh", want: dnsServer{transport: "doh", url: "https://dns.example/dns-query"}},
+		{server: "", transport: "doh", wantErr: true},
+		{server: "1.1.1.1", transport: "quic", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.server+"/"+tt.transport, func(t *testing.T) {
+			got, err := parseDNSServer(tt.server, tt.transport)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDNSServer(%q, %q) err = %v, wantErr %v", tt.server, tt.transport, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Fatalf("parseDNSServer(%q, %q) = %+v, want %+v", tt.server, tt.transport, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	tests := []struct{ ip, want string }{
+		{"192.168.1.10", "10.1.168.192.in-addr.arpa."},
+		{"::ffff:10.0.0.1", "1.0.0.10.in-addr.arpa."},
+		{"2001:db8::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+	}
+	for _, tt := range tests {
+		if got := reverseName(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("reverseName(%s) = %s, want %s", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestResourceValue(t *testing.T) {
+	name := dnsmessage.MustNewName("target.example.")
+	tests := []struct {
+		name string
+		body dnsmessage.ResourceBody
+		want string
+	}{
+		{"A", &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}}, "10.0.0.1"},
+		{"AAAA", &dnsmessage.AAAAResource{AAAA: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}}, "2001:db8::1"},
+		{"MX", &dnsmessage.MXResource{Pref: 10, MX: name}, "10 target.example."},
+		{"TXT 多段拼接", &dnsmessage.TXTResource{TXT: []string{"v=spf1 ", "-all"}}, "v=spf1 -all"},
+		{"SRV", &dnsmessage.SRVResource{Priority: 1, Weight: 5, Port: 5060, Target: name}, "1 5 5060 target.example."},
+		{"SOA", &dnsmessage.SOAResource{NS: name, MBox: name, Serial: 1, Refresh: 2, Retry: 3, Expire: 4, MinTTL: 5}, "target.example. target.example. 1 2 3 4 5"},
+		{"CAA", &dnsmessage.UnknownResource{Type: dnsCAAType, Data: append([]byte{0, 5}, "issueletsencrypt.org"...)}, `0 issue "letsencrypt.org"`},
+		{"CAA 数据截断", &dnsmessage.UnknownResource{Type: dnsCAAType, Data: []byte{0, 9, 'a'}}, `\# 3 000961`},
+		{"未知类型", &dnsmessage.UnknownResource{Type: 99, Data: []byte{0xde, 0xad}}, `\# 2 dead`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resourceValue(tt.body); got != tt.want {
+				t.Fatalf("resourceValue = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeDNSServer 本地 UDP DNS：A 查询返回固定地址，其余返回 NXDOMAIN
+func fakeDNSServer(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var req dnsmessage.Message
+			if err := req.Unpack(buf[:n]); err != nil || len(req.Questions) != 1 {
+				continue
+			}
+			q := req.Questions[0]
+			resp := dnsmessage.Message{
+				Header: dnsmessage.Header{ID: req.Header.ID, Response: true, RecursionDesired: req.Header.RecursionDesired,
+					RecursionAvailable: true, RCode: dnsmessage.RCodeNameError},
+				Questions: req.Questions,
+			}
+			if q.Type == dnsmessage.TypeA {
+				resp.Header.RCode = dnsmessage.RCodeSuccess
+				resp.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 300},
+					Body:   &dnsmessage.AResource{A: [4]byte{192, 168, 1, 1}},
+				}}
+			}
+			wire, err := resp.Pack()
+			if err != nil {
+				continue
+			}
+			pc.WriteTo(wire, addr)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestDNSLookupLocal(t *testing.T) {
+	server := fakeDNSServer(t)
+	tests := []struct {
+		name      string
+		query     string
+		qtype     string
+		wantRcode string
+		want      []DNSRecord
+	}{
+		{"A 记录", "router.lan", "a", "NOERROR", []DNSRecord{{Name: "router.lan.", Type: "A", Value: "192.168.1.1", TTL: 300}}},
+		{"NXDOMAIN", "missing.lan", "AAAA", "NXDOMAIN", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := DNSLookup(context.Background(), tt.query, DNSOptions{Type: tt.qtype, Server: server, Timeout: 2 * time.Second})
+			if err != nil {
+				t.Fatalf("查询失败: %v", err)
+			}
+			if res.Rcode != tt.wantRcode || res.Transport != "udp" || !res.Flags.RecursionAvailable {
+				t.Fatalf("结果不符: rcode=%s transport=%s flags=%+v", res.Rcode, res.Transport, res.Flags)
+			}
+			if len(res.Records) != len(tt.want) {
+				t.Fatalf("记录 = %+v，期望 %+v", res.Records, tt.want)
+			}
+			for i := range tt.want {
+				if res.Records[i] != tt.want[i] {
+					t.Fatalf("记录 = %+v，期望 %+v", res.Records[i], tt.want[i])
+				}
+			}
+		})
+	}
+
+	if _, err := DNSLookup(context.Background(), "x.lan", DNSOptions{Type: "HINFO", Server: server}); err == nil {
+		t.Fatalf("不支持的记录类型应返回错误")
+	}
+}
@@ -0,0 +1,248 @@
+package toolkit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"time"
+)
+
+// MtrOptions MTR 参数（探测方式与 TracerouteOptions 相同，每轮每跳发一个探测）
+type MtrOptions struct {
+	Protocol  string
+	Port      int
+	Paris     bool
+	MaxHops   int           // 默认 30
+	Cycles    int           // 探测轮数，0 表示直到取消
+	Interval  time.Duration // 每轮间隔，默认 1 秒
+	Timeout   time.Duration // 每轮等待回复的时间，默认 2 秒
+	NoResolve bool
+	IPVersion int
+	// OnCycle 每轮结束后回调当前统计快照
+	OnCycle func(r *MtrReport)
+}
+
+// MtrHop 单跳统计（RTT 单位 ms）
+type MtrHop struct {
+	Hop      int      `json:"hop"`
+	IP       string   `json:"ip"` // 无响应为 "*"
+	Hostname string   `json:"hostname,omitempty"`
+	IPs      []string `json:"ips,omitempty"` // 多个响应地址（负载均衡/路径变化）
+	Sent     int      `json:"sent"`
+	Recv     int      `json:"recv"`
+	Loss     float64  `json:"loss"`
+	Last     float64  `json:"last"`
+	Avg      float64  `json:"avg"`
+	Best     float64  `json:"best"`
+	Worst    float64  `json:"worst"`
+	StDev    float64  `json:"stdev"`
+
+	sum, sum2 float64
+}
+
+// MtrReport MTR 报告
+type MtrReport struct {
+	ID         string     `json:"id"`
+	Target     string     `json:"target"`
+	IP         string     `json:"ip"`
+	Protocol   string     `json:"protocol"`
+	Port       int        `json:"port,omitempty"`
+	Paris      bool       `json:"paris"`
+	Cycles     int        `json:"cycles"` // 已完成轮数
+	Reached    bool       `json:"reached"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Hops       []MtrHop   `json:"hops"`
+}
+
+// Mtr 持续探测到目标路径上的每一跳（traceroute + ping），统计丢包和延迟；
+// 达到轮数或 ctx 取消时返回最终报告（取消时返回已有统计，不返回错误）
+func Mtr(ctx context.Context, target string, opts MtrOptions) (*MtrReport, error) {
+	topts := TracerouteOptions{
+		Protocol:  opts.Protocol,
+		Port:      opts.Port,
+		Paris:     opts.Paris,
+		Probes:    1,
+		MaxHops:   opts.MaxHops,
+		Timeout:   opts.Timeout,
+		IPVersion: opts.IPVersion,
+	}
+	if topts.Timeout <= 0 {
+		topts.Timeout = 2 * time.Second
+	}
+	if err := normalizeTraceOptions(&topts); err != nil {
+		return nil, err
+	}
+	if opts.Cycles < 0 {
+		return nil, fmt.Errorf("无效的探测轮数: %d", opts.Cycles)
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+
+	dst, err := resolvePingTarget(ctx, target, opts.IPVersion)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newTracer(dst, topts)
+	if err != nil {
+		return nil, err
+	}
+	defer t.close()
+	t.hopPorts = true
+
+	report := &MtrReport{
+		ID:        newMtrID(),
+		Target:    target,
+		IP:        dst.String(),
+		Protocol:  topts.Protocol,
+		Paris:     topts.Paris,
+		StartedAt: time.Now(),
+		Hops:      []MtrHop{},
+	}
+	if topts.Protocol != "icmp" {
+		report.Port = topts.Port
+	}
+
+	names := newReverseResolver(opts.NoResolve)
+	hops := make([]MtrHop, topts.MaxHops)
+	for i := range hops {
+		hops[i] = MtrHop{Hop: i + 1, IP: "*"}
+	}
+	// 到达目的地的最小 TTL；之后的轮次只探测到这一跳
+	last := topts.MaxHops
+
+	for cycle := 1; opts.Cycles == 0 || cycle <= opts.Cycles; cycle++ {
+		start := time.Now()
+		probes := make([]*traceProbe, last)
+		for ttl := 1; ttl <= last; ttl++ {
+			probes[ttl-1] = t.sendProbes(ctx, ttl, 1)[0]
+		}
+		replies := t.waitCycle(ctx, probes, hops, start, opts.Interval)
+		if ctx.Err() != nil {
+			break
+		}
+		for i, r := range replies {
+			h := &hops[i]
+			h.Sent++
+			if r.ip != nil {
+				h.add(r, names.lookup(ctx, r.ip.String()))
+				if r.done && i+1 < last {
+					last = i + 1
+				}
+				if r.done && r.ip.Equal(dst.IP) {
+					report.Reached = true
+				}
+			}
+			h.Loss = math.Round(float64(h.Sent-h.Recv)/float64(h.Sent)*1000) / 10
+		}
+		report.Cycles = cycle
+		report.Hops = snapshotMtrHops(hops[:last])
+		if opts.OnCycle != nil {
+			opts.OnCycle(report)
+		}
+		if opts.Cycles != 0 && cycle == opts.Cycles {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Until(start.Add(opts.Interval))):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	now := time.Now()
+	report.FinishedAt = &now
+	report.Hops = snapshotMtrHops(hops[:last])
+	return report, nil
+}
+
+// waitCycle 等待一轮探测的回复：回复过的跳（以及第一轮的所有跳）最多等 Timeout，
+// 从未回复过的跳只等到本轮间隔结束，这样沉默跳不会让每一轮都等满超时；
+// 本轮结束于 max(间隔, 最后一个回复)
+func (t *tracer) waitCycle(ctx context.Context, probes []*traceProbe, hops []MtrHop, start time.Time, interval time.Duration) []traceReply {
+	replies := make([]traceReply, len(probes))
+	for i, p := range probes {
+		if p == nil {
+			continue
+		}
+		deadline := start.Add(t.opts.Timeout)
+		if silent := hops[i].Sent > 0 && hops[i].Recv == 0; silent && interval < t.opts.Timeout {
+			deadline = start.Add(interval)
+		}
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case r := <-p.reply:
+			replies[i] = r
+			timer.Stop()
+			continue
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+		select {
+		case r := <-p.reply:
+			replies[i] = r
+		default:
+			t.drop(p.key)
+		}
+	}
+	return replies
+}
+
+func (h *MtrHop) add(r traceReply, hostname string) {
+	ms := math.Round(float64(r.rtt.Microseconds())) / 1000
+	ip := r.ip.String()
+	if h.IP == "*" {
+		h.IP, h.Hostname = ip, hostname
+	}
+	seen := false
+	for _, v := range h.IPs {
+		seen = seen || v == ip
+	}
+	if !seen {
+		h.IPs = append(h.IPs, ip)
+	}
+	h.Recv++
+	h.Last = ms
+	if h.Recv == 1 || ms < h.Best {
+		h.Best = ms
+	}
+	if ms > h.Worst {
+		h.Worst = ms
+	}
+	h.sum += ms
+	h.sum2 += ms * ms
+	n := float64(h.Recv)
+	mean := h.sum / n
+	h.Avg = roundMs(mean)
+	h.StDev = roundMs(math.Sqrt(math.Max(h.sum2/n-mean*mean, 0)))
+}
+
+// snapshotMtrHops 复制统计，去掉末尾从未响应的跳；只有一个响应地址时不列 ips
+func snapshotMtrHops(hops []MtrHop) []MtrHop {
+	end := len(hops)
+	for end > 0 && hops[end-1].Recv == 0 {
+		end--
+	}
+	out := make([]MtrHop, end)
+	for i := 0; i < end; i++ {
+		out[i] = hops[i]
+		if len(hops[i].IPs) < 2 {
+			out[i].IPs = nil
+		} else {
+			out[i].IPs = append([]string(nil), hops[i].IPs...)
+		}
+	}
+	return out
+}
+
+func newMtrID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "mtr_" + hex.EncodeToString(b)
+}
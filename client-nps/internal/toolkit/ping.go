@@ -1,26 +1,33 @@
 package toolkit
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"os/exec"
-	"regexp"
-	"runtime"
-	"strconv"
+	"math"
+	"net"
+	"os"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 // PingResult Ping测试结果
 type PingResult struct {
 	Target          string       `json:"target"`
+	IP              string       `json:"ip"`     // 实际探测的地址
+	Method          string       `json:"method"` // icmp-dgram（无特权 ICMP 套接字）/ icmp-raw
 	PacketsSent     int          `json:"packets_sent"`
 	PacketsReceived int          `json:"packets_received"`
 	PacketLoss      float64      `json:"packet_loss"`
 	MinLatency      float64      `json:"min_latency"`
 	MaxLatency      float64      `json:"max_latency"`
 	AvgLatency      float64      `json:"avg_latency"`
+	Mdev            float64      `json:"mdev"`   // RTT 标准差（同 iputils ping 的 mdev）
+	Jitter          float64      `json:"jitter"` // 相邻两次 RTT 之差的平均值
 	Results         []PingPacket `json:"results"`
 }
 
@@ -29,117 +36,391 @@ type PingPacket struct {
 	Sequence int     `json:"sequence"`
 	Latency  float64 `json:"latency"`
 	Status   string  `json:"status"`
+	TTL      int     `json:"ttl,omitempty"` // IPv4 TTL / IPv6 hop limit
+	Bytes    int     `json:"bytes,omitempty"`
+}
+
+// PingOptions Ping参数
+type PingOptions struct {
+	Count     int           // 发送次数，默认 4
+	Timeout   time.Duration // 单个包等待回复的时间，默认 2 秒
+	Interval  time.Duration // 发包间隔，默认 1 秒
+	Size      int           // ICMP 数据长度，默认 56
+	IPVersion int           // 4 / 6，0 表示按解析结果（优先 IPv4）
+	// OnPacket 每个包有结果（收到回复或超时）时回调
+	OnPacket func(p PingPacket)
+}
+
+const (
+	defaultPingSize = 56
+	maxPingSize     = 65000
+)
+
+// pingID echo 标识：每次 Ping 取一个新值，raw 套接字下并发 Ping 同一目标时互不串包
+var pingID atomic.Uint32
+
+func init() {
+	pingID.Store(uint32(os.Getpid()))
 }
 
 // Ping 执行Ping测试
 func Ping(target string, count int, timeout time.Duration) (*PingResult, error) {
-	if count <= 0 {
-		count = 4
+	return PingContext(context.Background(), target, count, timeout)
+}
+
+// PingContext 执行Ping测试，ctx 取消时终止
+func PingContext(ctx context.Context, target string, count int, timeout time.Duration) (*PingResult, error) {
+	return PingWithOptions(ctx, target, PingOptions{Count: count, Timeout: timeout})
+}
+
+// PingWithOptions 原生 ICMP echo：优先使用无特权的 ICMP 数据报套接字
+// （Linux 需 net.ipv4.ping_group_range 包含当前组，macOS 默认可用），失败时退回 raw 套接字（需 root/CAP_NET_RAW）。
+// 与 iputils ping 一样按 Interval 定时发包，回复由单独的读协程收集，每个序号各自在发出 Timeout 后判定超时，
+// 因此丢包不会推迟后续发包，总耗时约为 (Count-1)×Interval + Timeout。
+// 全部丢包时返回 packet_loss=100 的结果而不是错误
+func PingWithOptions(ctx context.Context, target string, opts PingOptions) (*PingResult, error) {
+	if opts.Count <= 0 {
+		opts.Count = 4
 	}
-	if timeout <= 0 {
-		timeout = 2 * time.Second
+	if opts.Timeout <= 0 {
+		opts.Timeout = 2 * time.Second
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.Size <= 0 {
+		opts.Size = defaultPingSize
+	}
+	if opts.Size > maxPingSize {
+		return nil, fmt.Errorf("无效的包大小: %d", opts.Size)
 	}
-	// 仅使用系统 ping（结果真实）；失败直接返回错误
-	return pingWithSystemCommand(target, count, timeout)
-}
 
-func pingWithSystemCommand(target string, count int, timeout time.Duration) (*PingResult, error) {
-	pingPath, err := exec.LookPath("ping")
+	dst, err := resolvePingTarget(ctx, target, opts.IPVersion)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := openPingConn(dst.IP)
 	if err != nil {
-		return nil, fmt.Errorf("ping not found")
+		return nil, err
 	}
+	defer pc.close()
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout*time.Duration(count)+2*time.Second)
-	defer cancel()
+	id := int(pingID.Add(1) & 0xffff)
+	quit := make(chan struct{})
+	defer close(quit)
+	replies := make(chan pingReply, opts.Count)
+	go pc.readReplies(dst.IP, id, opts.Size+512, replies, quit)
 
-	args := []string{}
-	switch runtime.GOOS {
-	case "darwin":
-		// -c count, -n no DNS, -W waittime (ms)
-		args = append(args, "-c", strconv.Itoa(count), "-n", "-W", strconv.Itoa(int(timeout.Milliseconds())), target)
-	case "linux":
-		// -c count, -n no DNS, -W timeout (seconds per reply)
-		sec := int(timeout.Seconds())
-		if sec <= 0 {
-			sec = 1
+	r := &PingResult{
+		Target: target,
+		IP:     dst.String(),
+		Method: pc.method,
+	}
+	packets := make([]PingPacket, opts.Count)
+	sentAt := make([]time.Time, opts.Count)
+	finished := make([]bool, opts.Count)
+	done := 0
+	finish := func(i int, p PingPacket) {
+		packets[i] = p
+		finished[i] = true
+		done++
+		if opts.OnPacket != nil {
+			opts.OnPacket(p)
 		}
-		args = append(args, "-c", strconv.Itoa(count), "-n", "-W", strconv.Itoa(sec), target)
-	default:
-		// 其他平台先不实现，交给回退逻辑
-		return nil, fmt.Errorf("unsupported platform for system ping")
 	}
 
-	cmd := exec.CommandContext(ctx, pingPath, args...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		// ping 非 0 也可能代表部分丢包，这里仍尝试解析；但如果完全无法解析则报错
-	}
-
-	lines := bytes.Split(out, []byte("\n"))
-	re := regexp.MustCompile(`icmp[_-]?seq[= ](\d+).*time[= ]([\d.]+)\s*ms`)
-
-	results := make(map[int]float64)
-	for _, ln := range lines {
-		m := re.FindSubmatch(ln)
-		if len(m) == 3 {
-			seq, _ := strconv.Atoi(string(m[1]))
-			lat, _ := strconv.ParseFloat(string(m[2]), 64)
-			// mac 的 icmp_seq 从 0 开始（0..count-1）；统一映射为 1..count
-			// 旧逻辑只把 0->1，会导致 seq=1 覆盖 seq=0，从而“固定丢 1 个包”
-			if runtime.GOOS == "darwin" {
-				seq = seq + 1
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	nextSend := time.Now()
+loop:
+	for done < opts.Count {
+		now := time.Now()
+		if r.PacketsSent < opts.Count && !now.Before(nextSend) {
+			i := r.PacketsSent
+			seq := i + 1
+			r.PacketsSent++
+			sentAt[i] = now
+			nextSend = nextSend.Add(opts.Interval)
+			if err := pc.send(dst, id, seq, opts.Size); err != nil {
+				finish(i, PingPacket{Sequence: seq, Status: "failed"})
+			}
+		}
+		// 已发出但超时未回复的包
+		for i := 0; i < r.PacketsSent; i++ {
+			if !finished[i] && !now.Before(sentAt[i].Add(opts.Timeout)) {
+				finish(i, PingPacket{Sequence: i + 1, Status: "failed"})
+			}
+		}
+		if done >= opts.Count {
+			break
+		}
+
+		wake := time.Time{}
+		if r.PacketsSent < opts.Count {
+			wake = nextSend
+		}
+		for i := 0; i < r.PacketsSent; i++ {
+			if d := sentAt[i].Add(opts.Timeout); !finished[i] && (wake.IsZero() || d.Before(wake)) {
+				wake = d
+			}
+		}
+		timer.Reset(time.Until(wake))
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-timer.C:
+		case rep := <-replies:
+			i := rep.seq - 1
+			if i < 0 || i >= r.PacketsSent || finished[i] {
+				continue
 			}
-			if seq >= 1 && seq <= count {
-				results[seq] = lat
+			rtt := rep.at.Sub(sentAt[i])
+			if rtt > opts.Timeout {
+				continue
 			}
+			finish(i, PingPacket{
+				Sequence: rep.seq,
+				Status:   "success",
+				Latency:  math.Round(float64(rtt.Microseconds())) / 1000,
+				TTL:      rep.ttl,
+				Bytes:    rep.bytes,
+			})
 		}
 	}
+	if err := ctx.Err(); err != nil && r.PacketsSent == 0 {
+		return nil, err
+	}
+	// 取消时仍在等待回复的包按失败计
+	for i := 0; i < r.PacketsSent; i++ {
+		if !finished[i] {
+			packets[i] = PingPacket{Sequence: i + 1, Status: "failed"}
+		}
+	}
+	r.Results = packets[:r.PacketsSent]
+	r.summarize()
+	return r, nil
+}
 
-	r := &PingResult{
-		Target:      target,
-		PacketsSent: count,
-		Results:     make([]PingPacket, 0, count),
+// resolvePingTarget 解析目标地址，ipVersion 为 0 时优先 IPv4；IPv6 链路本地地址保留 zone（如 fe80::1%eth0）
+func resolvePingTarget(ctx context.Context, target string, ipVersion int) (*net.IPAddr, error) {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return nil, fmt.Errorf("目标不能为空")
 	}
+	host, zone, _ := strings.Cut(strings.Trim(target, "[]"), "%")
+	var addrs []net.IPAddr
+	if ip := net.ParseIP(host); ip != nil {
+		addrs = []net.IPAddr{{IP: ip, Zone: zone}}
+	} else {
+		var err error
+		if addrs, err = net.DefaultResolver.LookupIPAddr(ctx, target); err != nil {
+			return nil, fmt.Errorf("解析目标地址失败: %v", err)
+		}
+	}
+	var v4, v6 *net.IPAddr
+	for i := range addrs {
+		a := &addrs[i]
+		if ip4 := a.IP.To4(); ip4 != nil {
+			if v4 == nil {
+				v4 = &net.IPAddr{IP: ip4}
+			}
+		} else if v6 == nil {
+			v6 = a
+		}
+	}
+	switch {
+	case ipVersion == 6 && v6 != nil, ipVersion != 4 && v4 == nil && v6 != nil:
+		return v6, nil
+	case ipVersion != 6 && v4 != nil:
+		return v4, nil
+	}
+	if ipVersion == 0 {
+		return nil, fmt.Errorf("解析目标地址失败: %s", target)
+	}
+	return nil, fmt.Errorf("目标 %s 没有 IPv%d 地址", target, ipVersion)
+}
+
+// pingConn ICMP 套接字及其地址族相关的细节
+type pingConn struct {
+	conn   *icmp.PacketConn
+	method string
+	v6     bool
+	dgram  bool
+}
 
-	for i := 1; i <= count; i++ {
-		if lat, ok := results[i]; ok {
-			r.PacketsReceived++
-			r.Results = append(r.Results, PingPacket{Sequence: i, Latency: lat, Status: "success"})
+func openPingConn(ip net.IP) (*pingConn, error) {
+	v6 := ip.To4() == nil
+	candidates := []struct{ network, addr, method string }{
+		{"udp4", "0.0.0.0", "icmp-dgram"},
+		{"ip4:icmp", "0.0.0.0", "icmp-raw"},
+	}
+	if v6 {
+		candidates = []struct{ network, addr, method string }{
+			{"udp6", "::", "icmp-dgram"},
+			{"ip6:ipv6-icmp", "::", "icmp-raw"},
+		}
+	}
+	var errs []string
+	for _, c := range candidates {
+		conn, err := icmp.ListenPacket(c.network, c.addr)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", c.method, err))
+			continue
+		}
+		pc := &pingConn{conn: conn, method: c.method, v6: v6, dgram: strings.HasPrefix(c.network, "udp")}
+		if v6 {
+			_ = conn.IPv6PacketConn().SetControlMessage(ipv6.FlagHopLimit, true)
 		} else {
-			r.Results = append(r.Results, PingPacket{Sequence: i, Latency: 0, Status: "failed"})
+			_ = conn.IPv4PacketConn().SetControlMessage(ipv4.FlagTTL, true)
 		}
+		return pc, nil
 	}
-	if r.PacketsSent > 0 {
-		r.PacketLoss = float64(r.PacketsSent-r.PacketsReceived) / float64(r.PacketsSent) * 100
+	return nil, fmt.Errorf("无法创建 ICMP 套接字（需要 root/CAP_NET_RAW，或把当前用户组加入 net.ipv4.ping_group_range）: %s", strings.Join(errs, "; "))
+}
+
+func (pc *pingConn) close() {
+	pc.conn.Close()
+}
+
+// pingReply 读协程收到的 echo 回复
+type pingReply struct {
+	seq   int
+	at    time.Time // 读出时间
+	ttl   int
+	bytes int
+}
+
+// send 发送一个 echo 请求
+func (pc *pingConn) send(dst *net.IPAddr, id, seq, size int) error {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	typ := icmp.Type(ipv4.ICMPTypeEcho)
+	if pc.v6 {
+		typ = ipv6.ICMPTypeEchoRequest
 	}
+	msg := icmp.Message{Type: typ, Body: &icmp.Echo{ID: id, Seq: seq, Data: data}}
+	wire, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	var to net.Addr = dst
+	if pc.dgram {
+		to = &net.UDPAddr{IP: dst.IP, Zone: dst.Zone}
+	}
+	_, err = pc.conn.WriteTo(wire, to)
+	return err
+}
 
-	// 统计
-	min := 0.0
-	max := 0.0
-	sum := 0.0
-	okCnt := 0
-	for _, p := range r.Results {
-		if p.Status != "success" {
+// readReplies 持续读取来自 dst 的 echo 回复直到套接字关闭；
+// 数据报套接字由内核按 ID 分发，raw 套接字需要自己核对 ID 和来源
+func (pc *pingConn) readReplies(dst net.IP, id, bufSize int, out chan<- pingReply, quit <-chan struct{}) {
+	buf := make([]byte, bufSize)
+	proto := 1
+	if pc.v6 {
+		proto = 58
+	}
+	for {
+		n, ttl, peer, err := pc.read(buf)
+		if err != nil {
+			return
+		}
+		at := time.Now()
+		reply, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil {
+			continue
+		}
+		if reply.Type != ipv4.ICMPTypeEchoReply && reply.Type != ipv6.ICMPTypeEchoReply {
 			continue
 		}
-		if okCnt == 0 || p.Latency < min {
-			min = p.Latency
+		echo, ok := reply.Body.(*icmp.Echo)
+		if !ok || (!pc.dgram && echo.ID != id) || !peerIs(peer, dst) {
+			continue
 		}
-		if okCnt == 0 || p.Latency > max {
-			max = p.Latency
+		select {
+		case out <- pingReply{seq: echo.Seq, at: at, ttl: ttl, bytes: n}:
+		case <-quit:
+			return
 		}
-		sum += p.Latency
-		okCnt++
 	}
-	if okCnt > 0 {
-		r.MinLatency = min
-		r.MaxLatency = max
-		r.AvgLatency = sum / float64(okCnt)
+}
+
+// read 读取一个 ICMP 报文并取得 TTL/hop limit；
+// Linux 的 IPv4 raw 套接字会带上 IP 头，此时从头部取 TTL 并去掉头部
+func (pc *pingConn) read(buf []byte) (int, int, net.Addr, error) {
+	if pc.v6 {
+		n, cm, peer, err := pc.conn.IPv6PacketConn().ReadFrom(buf)
+		ttl := 0
+		if cm != nil {
+			ttl = cm.HopLimit
+		}
+		return n, ttl, peer, err
+	}
+	n, cm, peer, err := pc.conn.IPv4PacketConn().ReadFrom(buf)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	ttl := 0
+	if cm != nil {
+		ttl = cm.TTL
+	}
+	if n >= ipv4.HeaderLen && buf[0]>>4 == 4 {
+		hl := int(buf[0]&0x0f) * 4
+		if hl >= ipv4.HeaderLen && hl <= n {
+			if ttl == 0 {
+				ttl = int(buf[8])
+			}
+			n = copy(buf, buf[hl:n])
+		}
 	}
+	return n, ttl, peer, nil
+}
 
-	// sanity：如果完全解析不到（不同语言输出/格式），直接报错（不回退）
-	if okCnt == 0 {
-		return nil, fmt.Errorf("系统 ping 执行或解析失败: %s", strings.TrimSpace(string(out)))
+func peerIs(peer net.Addr, ip net.IP) bool {
+	switch a := peer.(type) {
+	case *net.IPAddr:
+		return a.IP.Equal(ip)
+	case *net.UDPAddr:
+		return a.IP.Equal(ip)
 	}
-	return r, nil
+	return false
+}
+
+// summarize 计算丢包率、min/avg/max、mdev 和抖动
+func (r *PingResult) summarize() {
+	var rtts []float64
+	for _, p := range r.Results {
+		if p.Status == "success" {
+			rtts = append(rtts, p.Latency)
+		}
+	}
+	r.PacketsReceived = len(rtts)
+	if r.PacketsSent > 0 {
+		r.PacketLoss = float64(r.PacketsSent-r.PacketsReceived) / float64(r.PacketsSent) * 100
+	}
+	if len(rtts) == 0 {
+		return
+	}
+	var sum, sum2, diff float64
+	r.MinLatency, r.MaxLatency = rtts[0], rtts[0]
+	for i, v := range rtts {
+		sum += v
+		sum2 += v * v
+		r.MinLatency = math.Min(r.MinLatency, v)
+		r.MaxLatency = math.Max(r.MaxLatency, v)
+		if i > 0 {
+			diff += math.Abs(v - rtts[i-1])
+		}
+	}
+	n := float64(len(rtts))
+	r.AvgLatency = roundMs(sum / n)
+	r.Mdev = roundMs(math.Sqrt(math.Max(sum2/n-(sum/n)*(sum/n), 0)))
+	if len(rtts) > 1 {
+		r.Jitter = roundMs(diff / float64(len(rtts)-1))
+	}
+}
+
+func roundMs(v float64) float64 {
+	return math.Round(v*1000) / 1000
 }
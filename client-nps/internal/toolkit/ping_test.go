@@ -0,0 +1,46 @@
+package toolkit
+
+import "testing"
+
+func TestPingSummarize(t *testing.T) {
+	tests := []struct {
+		name     string
+		latency  []float64 // 负数表示超时
+		wantRecv int
+		wantLoss float64
+		wantMin  float64
+		wantMax  float64
+		wantAvg  float64
+		wantMdev float64
+		wantJit  float64
+	}{
+		{name: "稳定递增", latency: []float64{10, 20, 30}, wantRecv: 3, wantMin: 10, wantMax: 30, wantAvg: 20, wantMdev: 8.165, wantJit: 10},
+		{name: "超时包不计入抖动", latency: []float64{10, -1, 30, 20}, wantRecv: 3, wantLoss: 25, wantMin: 10, wantMax: 30, wantAvg: 20, wantMdev: 8.165, wantJit: 15},
+		{name: "RTT 相同", latency: []float64{4, 4, 4, 4}, wantRecv: 4, wantMin: 4, wantMax: 4, wantAvg: 4},
+		{name: "单个包无抖动", latency: []float64{5.5}, wantRecv: 1, wantMin: 5.5, wantMax: 5.5, wantAvg: 5.5},
+		{name: "全部超时", latency: []float64{-1, -1}, wantLoss: 100},
+		{name: "未发包", latency: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &PingResult{PacketsSent: len(tt.latency)}
+			for i, v := range tt.latency {
+				p := PingPacket{Sequence: i, Status: "success", Latency: v}
+				if v < 0 {
+					p = PingPacket{Sequence: i, Status: "failed"}
+				}
+				r.Results = append(r.Results, p)
+			}
+			r.summarize()
+			if r.PacketsReceived != tt.wantRecv || r.PacketLoss != tt.wantLoss {
+				t.Fatalf("收到 %d 丢包 %.1f%%，期望 %d / %.1f%%", r.PacketsReceived, r.PacketLoss, tt.wantRecv, tt.wantLoss)
+			}
+			if r.MinLatency != tt.wantMin || r.MaxLatency != tt.wantMax || r.AvgLatency != tt.wantAvg {
+				t.Fatalf("min/avg/max = %v/%v/%v，期望 %v/%v/%v", r.MinLatency, r.AvgLatency, r.MaxLatency, tt.wantMin, tt.wantAvg, tt.wantMax)
+			}
+			if r.Mdev != tt.wantMdev || r.Jitter != tt.wantJit {
+				t.Fatalf("mdev/jitter = %v/%v，期望 %v/%v", r.Mdev, r.Jitter, tt.wantMdev, tt.wantJit)
+			}
+		})
+	}
+}
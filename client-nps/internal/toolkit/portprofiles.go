@@ -0,0 +1,129 @@
+package toolkit
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 端口配置档
+const (
+	PortProfileQuick    = "quick"    // 路由器/NAS/摄像头常用管理端口
+	PortProfileStandard = "standard" // 常见服务 + IoT 端口（约 120 个）
+	PortProfileFull     = "full"     // 1-65535
+	PortProfileCustom   = "custom"   // 使用自定义端口表达式
+)
+
+var quickPorts = []int{22, 23, 53, 80, 81, 443, 445, 554, 8000, 8008, 8080, 8081, 8088, 8443, 8888, 8899, 5000, 5001, 3306, 5432, 9100}
+
+var standardPorts = []int{
+	// 常见服务
+	7, 9, 13, 21, 22, 23, 25, 26, 37, 53, 79, 80, 81, 88, 106, 110, 111, 113, 119, 135, 139, 143, 144, 179,
+	199, 389, 427, 443, 444, 445, 465, 513, 514, 515, 543, 544, 548, 554, 587, 631, 636, 646, 873, 990, 993,
+	995, 1025, 1026, 1027, 1028, 1029, 1080, 1110, 1433, 1521, 1720, 1723, 1755, 1900, 2000, 2001, 2049,
+	2121, 2717, 3000, 3128, 3306, 3389, 3986, 4899, 5000, 5001, 5009, 5051, 5060, 5101, 5190, 5357, 5432,
+	5631, 5666, 5800, 5900, 6000, 6001, 6379, 6646, 7070, 8000, 8008, 8009, 8080, 8081, 8088, 8443, 8888,
+	8899, 9000, 9090, 9100, 9200, 9999, 10000, 27017, 32768, 49152, 49153, 49154, 49155, 49156, 49157,
+	// IoT / 摄像头 / 智能家居
+	1883, 8883, 8554, 10554, 37777, 34567, 8200, 9443, 62078, 5353, 1400, 8009, 8060, 49000,
+}
+
+// PortProfiles 可选的端口配置档名称
+func PortProfiles() []string {
+	return []string{PortProfileQuick, PortProfileStandard, PortProfileFull, PortProfileCustom}
+}
+
+// PortsForProfile 返回配置档对应的端口列表（已去重排序）；custom 使用 spec，
+// 空配置档按 quick 处理
+func PortsForProfile(profile, spec string) ([]int, error) {
+	switch strings.ToLower(strings.TrimSpace(profile)) {
+	case "", PortProfileQuick:
+		return uniquePorts(quickPorts), nil
+	case PortProfileStandard:
+		return uniquePorts(standardPorts), nil
+	case PortProfileFull:
+		return ParsePortSpec("1-65535")
+	case PortProfileCustom:
+		if strings.TrimSpace(spec) == "" {
+			return nil, fmt.Errorf("无效的端口配置: custom 需要指定端口")
+		}
+		return ParsePortSpec(spec)
+	default:
+		return nil, fmt.Errorf("不支持的端口配置档: %s", profile)
+	}
+}
+
+// UDPPortsForProfile 设备端口清点的 UDP 端口：spec 非空时按表达式解析（"none" 表示不扫 UDP），
+// 否则 standard/full 配置档扫描有专用探测包的端口，quick/custom 不扫 UDP
+func UDPPortsForProfile(profile, spec string) ([]int, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.EqualFold(spec, "none") {
+		return nil, nil
+	}
+	if spec != "" {
+		return ParsePortSpec(spec)
+	}
+	switch strings.ToLower(strings.TrimSpace(profile)) {
+	case PortProfileStandard, PortProfileFull:
+		return uniquePorts(UDPProbePorts), nil
+	}
+	return nil, nil
+}
+
+// ParsePortSpec 解析端口表达式：逗号分隔的端口或范围，如 "22,80,8000-8100"；
+// 也接受配置档名称（quick/standard/full）
+func ParsePortSpec(spec string) ([]int, error) {
+	spec = strings.TrimSpace(spec)
+	switch strings.ToLower(spec) {
+	case PortProfileQuick, PortProfileStandard, PortProfileFull:
+		return PortsForProfile(spec, "")
+	}
+
+	ports := []int{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if lo, hi, ok := strings.Cut(part, "-"); ok {
+			start, err := strconv.Atoi(strings.TrimSpace(lo))
+			if err != nil {
+				return nil, fmt.Errorf("无效的端口范围: %s", part)
+			}
+			end, err := strconv.Atoi(strings.TrimSpace(hi))
+			if err != nil {
+				return nil, fmt.Errorf("无效的端口范围: %s", part)
+			}
+			if start < 1 || end > 65535 || start > end {
+				return nil, fmt.Errorf("无效的端口范围: %s", part)
+			}
+			for p := start; p <= end; p++ {
+				ports = append(ports, p)
+			}
+			continue
+		}
+		p, err := strconv.Atoi(part)
+		if err != nil || p < 1 || p > 65535 {
+			return nil, fmt.Errorf("无效的端口: %s", part)
+		}
+		ports = append(ports, p)
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("无效的端口表达式: 端口列表为空")
+	}
+	return uniquePorts(ports), nil
+}
+
+func uniquePorts(ports []int) []int {
+	seen := make(map[int]bool, len(ports))
+	out := make([]int, 0, len(ports))
+	for _, p := range ports {
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	sort.Ints(out)
+	return out
+}
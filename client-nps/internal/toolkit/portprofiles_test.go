@@ -0,0 +1,73 @@
+package toolkit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePortSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []int
+		wantLen int // want 为 nil 时只校验数量
+		wantErr bool
+	}{
+		{name: "单个端口", spec: "80", want: []int{80}},
+		{name: "端口和范围去重排序", spec: "8080, 22,80-82,81", want: []int{22, 80, 81, 82, 8080}},
+		{name: "范围两侧空白", spec: "1 - 3", want: []int{1, 2, 3}},
+		{name: "忽略空项", spec: ",22,,", want: []int{22}},
+		{name: "配置档 quick", spec: "Quick", wantLen: len(uniquePorts(quickPorts))},
+		{name: "配置档 full", spec: "full", wantLen: 65535},
+		{name: "空表达式", spec: "  ", wantErr: true},
+		{name: "端口为 0", spec: "0", wantErr: true},
+		{name: "端口超出范围", spec: "65536", wantErr: true},
+		{name: "范围倒置", spec: "90-80", wantErr: true},
+		{name: "范围超出", spec: "65000-70000", wantErr: true},
+		{name: "非数字", spec: "http", wantErr: true},
+		{name: "半开范围", spec: "80-", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePortSpec(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePortSpec(%q) err = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ParsePortSpec(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+			if tt.want == nil && len(got) != tt.wantLen {
+				t.Fatalf("ParsePortSpec(%q) 返回 %d 个端口，期望 %d", tt.spec, len(got), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestPortsForProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		spec    string
+		want    []int
+		wantErr bool
+	}{
+		{name: "空配置档按 quick", profile: "", want: uniquePorts(quickPorts)},
+		{name: "custom 使用表达式", profile: "custom", spec: "443,22", want: []int{22, 443}},
+		{name: "custom 缺少表达式", profile: "custom", wantErr: true},
+		{name: "未知配置档", profile: "huge", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PortsForProfile(tt.profile, tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PortsForProfile(%q, %q) err = %v, wantErr %v", tt.profile, tt.spec, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("PortsForProfile(%q, %q) = %v, want %v", tt.profile, tt.spec, got, tt.want)
+			}
+		})
+	}
+}
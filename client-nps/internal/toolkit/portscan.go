@@ -1,10 +1,13 @@
 package toolkit
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -15,137 +18,280 @@ type PortInfo struct {
 	Service  string `json:"service"`
 	Version  string `json:"version"`
 	Status   string `json:"status"`
+	Banner   string `json:"banner,omitempty"` // 原始 banner / 证书摘要
 }
 
 // PortScanResult 端口扫描结果
 type PortScanResult struct {
-	Target      string     `json:"target"`
-	ScannedPorts int      `json:"scanned_ports"`
-	OpenPorts   []PortInfo `json:"open_ports"`
-	ClosedPorts []int      `json:"closed_ports"`
-	ScanTime    time.Time `json:"scan_time"`
+	Target        string     `json:"target"`
+	ScannedPorts  int        `json:"scanned_ports"`
+	OpenPorts     []PortInfo `json:"open_ports"`
+	FilteredPorts []PortInfo `json:"filtered_ports"` // UDP open|filtered（无响应）
+	ClosedPorts   []int      `json:"closed_ports"`
+	ScanTime      time.Time  `json:"scan_time"`
+}
+
+// 端口扫描默认并发数
+const defaultPortScanConcurrency = 64
+
+// 端口扫描并发数与速率上限，避免单次扫描耗尽文件描述符或把限速器间隔算成 0
+const (
+	MaxPortScanConcurrency = 256
+	MaxPortScanRate        = 10000
+)
+
+// PortScanOptions 端口扫描参数
+type PortScanOptions struct {
+	Ports       interface{}   // 端口列表或表达式，见 ParsePorts
+	Timeout     time.Duration // 单个端口的连接/探测超时
+	ScanType    string        // tcp（默认）/ udp / both
+	Concurrency int           // 同时探测的端口数，<=0 使用默认值
+	Rate        int           // 每秒最多发起的探测数（pps），<=0 不限速
+	// OnResult 每个端口探测完成时回调（含 closed），done/total 为已完成/全部探测数，调用是串行的
+	OnResult func(info PortInfo, done, total int)
 }
 
 // PortScan 执行端口扫描
 func PortScan(target string, ports interface{}, timeout time.Duration, scanType string) (*PortScanResult, error) {
+	return PortScanContext(context.Background(), target, ports, timeout, scanType)
+}
+
+// PortScanContext 执行端口扫描，ctx 取消后停止并返回已扫描部分的结果
+func PortScanContext(ctx context.Context, target string, ports interface{}, timeout time.Duration, scanType string) (*PortScanResult, error) {
+	return PortScanWithOptions(ctx, target, PortScanOptions{Ports: ports, Timeout: timeout, ScanType: scanType})
+}
+
+// PortScanWithOptions 并发执行端口扫描（受 Concurrency/Rate 限制），ctx 取消后停止并返回已扫描部分的结果
+// ScanType 为 both 时端口在任一协议上开放即不计入 closed_ports
+func PortScanWithOptions(ctx context.Context, target string, opts PortScanOptions) (*PortScanResult, error) {
 	result := &PortScanResult{
 		Target:   target,
 		ScanTime: time.Now(),
 	}
 
 	// 解析端口列表
-	portList, err := parsePorts(ports)
+	portList, err := ParsePorts(opts.Ports)
 	if err != nil {
 		return nil, err
 	}
+	protocols, err := scanProtocols(opts.ScanType)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 2 * time.Second
+	}
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = defaultPortScanConcurrency
+	}
+	if workers > MaxPortScanConcurrency {
+		workers = MaxPortScanConcurrency
+	}
+	total, done := len(portList)*len(protocols), 0
+	if workers > total {
+		workers = total
+	}
 
 	result.ScannedPorts = len(portList)
 	result.OpenPorts = []PortInfo{}
+	result.FilteredPorts = []PortInfo{}
 	result.ClosedPorts = []int{}
 
-	// 扫描端口
-	for _, port := range portList {
-		info, err := scanPort(target, port, timeout, scanType)
-		if err != nil {
-			result.ClosedPorts = append(result.ClosedPorts, port)
-			continue
+	type portJob struct {
+		port  int
+		proto string
+	}
+	jobs := make(chan portJob)
+	limiter := newRateLimiter(opts.Rate)
+	defer limiter.stop()
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		reachable = map[int]bool{}
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				info := scanPort(ctx, target, job.port, opts.Timeout, job.proto)
+				// 扫描被取消时未完成的探测不计入结果
+				if ctx.Err() != nil {
+					continue
+				}
+				mu.Lock()
+				switch info.Status {
+				case PortStatusOpen:
+					result.OpenPorts = append(result.OpenPorts, *info)
+					reachable[job.port] = true
+				case PortStatusOpenFiltered:
+					result.FilteredPorts = append(result.FilteredPorts, *info)
+					reachable[job.port] = true
+				}
+				done++
+				if opts.OnResult != nil {
+					opts.OnResult(*info, done, total)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, proto := range protocols {
+		for _, port := range portList {
+			if err := limiter.wait(ctx); err != nil {
+				break feed
+			}
+			select {
+			case jobs <- portJob{port, proto}:
+			case <-ctx.Done():
+				break feed
+			}
 		}
+	}
+	close(jobs)
+	wg.Wait()
 
-		if info.Status == "open" {
-			result.OpenPorts = append(result.OpenPorts, *info)
-		} else {
+	for _, port := range portList {
+		if !reachable[port] {
 			result.ClosedPorts = append(result.ClosedPorts, port)
 		}
 	}
+	sortPortInfos(result.OpenPorts)
+	sortPortInfos(result.FilteredPorts)
 
 	return result, nil
 }
 
-// parsePorts 解析端口参数
-func parsePorts(ports interface{}) ([]int, error) {
+// sortPortInfos 并发扫描后按协议、端口排序，保证输出稳定
+func sortPortInfos(list []PortInfo) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Protocol != list[j].Protocol {
+			return list[i].Protocol < list[j].Protocol
+		}
+		return list[i].Port < list[j].Port
+	})
+}
+
+// rateLimiter 按固定间隔放行（每秒 rate 次，最多 MaxPortScanRate），rate<=0 不限速
+type rateLimiter struct {
+	ticker *time.Ticker
+}
+
+func newRateLimiter(rate int) *rateLimiter {
+	if rate <= 0 {
+		return &rateLimiter{}
+	}
+	if rate > MaxPortScanRate {
+		rate = MaxPortScanRate
+	}
+	return &rateLimiter{ticker: time.NewTicker(time.Second / time.Duration(rate))}
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l.ticker == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-l.ticker.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *rateLimiter) stop() {
+	if l.ticker != nil {
+		l.ticker.Stop()
+	}
+}
+
+// scanProtocols 扫描类型对应的协议列表
+func scanProtocols(scanType string) ([]string, error) {
+	switch strings.ToLower(strings.TrimSpace(scanType)) {
+	case "", "tcp":
+		return []string{"tcp"}, nil
+	case "udp":
+		return []string{"udp"}, nil
+	case "both":
+		return []string{"tcp", "udp"}, nil
+	default:
+		return nil, fmt.Errorf("不支持的扫描类型: %s", scanType)
+	}
+}
+
+// ParsePorts 解析端口参数：端口数组或端口表达式/配置档名称，结果去重并按端口排序
+func ParsePorts(ports interface{}) ([]int, error) {
 	var portList []int
 
 	switch v := ports.(type) {
 	case []int:
-		return v, nil
+		portList = v
 	case []interface{}:
+		// JSON 数组解码为 float64
 		for _, p := range v {
-			if port, ok := p.(int); ok {
+			switch port := p.(type) {
+			case int:
 				portList = append(portList, port)
-			} else if port, ok := p.(float64); ok {
+			case float64:
+				if port != float64(int(port)) {
+					return nil, fmt.Errorf("无效的端口: %v", port)
+				}
 				portList = append(portList, int(port))
+			default:
+				return nil, fmt.Errorf("无效的端口: %v", p)
 			}
 		}
-		return portList, nil
 	case string:
-		// 支持 "80,443,8080" 或 "1-1000" 格式
-		if strings.Contains(v, "-") {
-			// 范围格式
-			parts := strings.Split(v, "-")
-			if len(parts) != 2 {
-				return nil, fmt.Errorf("无效的端口范围格式")
-			}
-			start, err := strconv.Atoi(parts[0])
-			if err != nil {
-				return nil, err
-			}
-			end, err := strconv.Atoi(parts[1])
-			if err != nil {
-				return nil, err
-			}
-			for i := start; i <= end; i++ {
-				portList = append(portList, i)
-			}
-		} else {
-			// 逗号分隔
-			parts := strings.Split(v, ",")
-			for _, p := range parts {
-				port, err := strconv.Atoi(strings.TrimSpace(p))
-				if err != nil {
-					continue
-				}
-				portList = append(portList, port)
-			}
-		}
-		return portList, nil
+		// 支持 "80,443,8080"、"1-1000"、"22,8000-8100" 或配置档名称（quick/standard/full）
+		return ParsePortSpec(v)
 	default:
 		return nil, fmt.Errorf("不支持的端口格式")
 	}
+
+	if len(portList) == 0 {
+		return nil, fmt.Errorf("无效的端口表达式: 端口列表为空")
+	}
+	for _, p := range portList {
+		if p < 1 || p > 65535 {
+			return nil, fmt.Errorf("无效的端口: %d", p)
+		}
+	}
+	return uniquePorts(portList), nil
 }
 
 // scanPort 扫描单个端口
-func scanPort(target string, port int, timeout time.Duration, scanType string) (*PortInfo, error) {
+func scanPort(ctx context.Context, target string, port int, timeout time.Duration, protocol string) *PortInfo {
+	if protocol == "udp" {
+		return scanUDPPort(ctx, target, port, timeout)
+	}
+
 	info := &PortInfo{
 		Port:     port,
 		Protocol: "tcp",
-		Status:   "closed",
+		Status:   PortStatusClosed,
 	}
-
-	if scanType == "" || scanType == "tcp" || scanType == "both" {
-		address := net.JoinHostPort(target, strconv.Itoa(port))
-		conn, err := net.DialTimeout("tcp", address, timeout)
-		if err == nil {
-			info.Status = "open"
-			info.Service = identifyService(port)
-			conn.Close()
-			return info, nil
-		}
+	dialer := &net.Dialer{Timeout: timeout}
+	address := net.JoinHostPort(target, strconv.Itoa(port))
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return info
 	}
-
-	if scanType == "udp" || scanType == "both" {
-		// UDP扫描（简化实现）
-		address := net.JoinHostPort(target, strconv.Itoa(port))
-		conn, err := net.DialTimeout("udp", address, timeout)
-		if err == nil {
-			info.Protocol = "udp"
-			info.Status = "open"
-			info.Service = identifyService(port)
-			conn.Close()
-			return info, nil
+	info.Status = PortStatusOpen
+	info.Service = identifyService(port)
+	conn.Close()
+	// 开放端口做协议探测，补充服务名/版本；整个探测链共用一个较短的时限
+	if b := GrabBanner(ctx, target, port, min(timeout, maxBannerTimeout)); b != nil {
+		if b.Service != "" && b.Service != "unknown" {
+			info.Service = b.Service
 		}
+		info.Version = b.Version
+		info.Banner = b.Banner
 	}
-
-	return info, fmt.Errorf("端口关闭")
+	return info
 }
 
 // identifyService 识别服务
@@ -158,13 +304,31 @@ func identifyService(port int) string {
 		53:   "dns",
 		80:   "http",
 		110:  "pop3",
+		123:  "ntp",
+		137:  "netbios-ns",
+		139:  "netbios-ssn",
 		143:  "imap",
+		161:  "snmp",
 		443:  "https",
+		445:  "smb",
+		554:  "rtsp",
+		631:  "ipp",
+		993:  "imaps",
+		995:  "pop3s",
+		1883: "mqtt",
+		1900: "ssdp",
 		3306: "mysql",
 		3389: "rdp",
+		5353: "mdns",
 		5432: "postgresql",
+		5683: "coap",
+		5900: "vnc",
 		6379: "redis",
 		8080: "http-proxy",
+		8443: "https-alt",
+		8554: "rtsp",
+		8883: "mqtts",
+		9100: "jetdirect",
 	}
 
 	if service, ok := services[port]; ok {
@@ -172,4 +336,3 @@ func identifyService(port int) string {
 	}
 	return "unknown"
 }
-
@@ -0,0 +1,52 @@
+package toolkit
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		name    string
+		ports   interface{}
+		want    []int
+		wantErr bool
+	}{
+		{name: "int 切片去重排序", ports: []int{443, 80, 443}, want: []int{80, 443}},
+		{name: "JSON 数组", ports: []interface{}{float64(8080), float64(22), float64(22)}, want: []int{22, 8080}},
+		{name: "JSON 数组混合 int", ports: []interface{}{1, float64(2)}, want: []int{1, 2}},
+		{name: "表达式", ports: "22,80-82", want: []int{22, 80, 81, 82}},
+		{name: "端口为 0", ports: []int{0, 80}, wantErr: true},
+		{name: "端口超出范围", ports: []interface{}{float64(65536)}, wantErr: true},
+		{name: "负数端口", ports: []interface{}{float64(-1)}, wantErr: true},
+		{name: "小数端口", ports: []interface{}{float64(80.5)}, wantErr: true},
+		{name: "数组中含字符串", ports: []interface{}{"80"}, wantErr: true},
+		{name: "空数组", ports: []interface{}{}, wantErr: true},
+		{name: "不支持的类型", ports: 80, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePorts(tt.ports)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePorts(%v) err = %v, wantErr %v", tt.ports, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ParsePorts(%v) = %v, want %v", tt.ports, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterHugeRate(t *testing.T) {
+	for _, rate := range []int{MaxPortScanRate, 2e9, 1 << 62} {
+		l := newRateLimiter(rate)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if err := l.wait(ctx); err != nil {
+			t.Fatalf("rate=%d: wait 失败: %v", rate, err)
+		}
+		cancel()
+		l.stop()
+	}
+}
@@ -0,0 +1,391 @@
+package toolkit
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 测速服务端（/api/v1/speedtest/*）与对端测速客户端：盒子之间、盒子与局域网主机之间、穿过 NPS 隧道测吞吐
+
+const (
+	// SpeedTestTokenHeader 访问对端测速接口时携带的共享口令
+	SpeedTestTokenHeader = "X-Speedtest-Token"
+	// SpeedTestMaxDuration 单个方向的最长测速时间
+	SpeedTestMaxDuration = 60 * time.Second
+
+	speedPayloadSize   = 1 << 20
+	speedStreamChunk   = 64 * 1024
+	defaultPeerStreams = 4
+	defaultPeerSeconds = 10
+	peerPingCount      = 5
+)
+
+var (
+	speedPayload     []byte
+	speedPayloadOnce sync.Once
+)
+
+// SpeedTestPayload 测速用的随机数据（不可压缩，避免链路压缩虚高结果），进程内共享只读
+func SpeedTestPayload() []byte {
+	speedPayloadOnce.Do(func() {
+		speedPayload = make([]byte, speedPayloadSize)
+		rand.Read(speedPayload)
+	})
+	return speedPayload
+}
+
+// WriteSpeedStream 持续写入测速数据，直到写满 limit 字节（<=0 不限）、到达 deadline 或 ctx 取消；返回写入字节数
+func WriteSpeedStream(ctx context.Context, w io.Writer, limit int64, deadline time.Time) (int64, error) {
+	payload := SpeedTestPayload()
+	flusher, _ := w.(http.Flusher)
+	var written int64
+	off := 0
+	for time.Now().Before(deadline) {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		end := off + speedStreamChunk
+		if end > len(payload) {
+			end = len(payload)
+		}
+		chunk := payload[off:end]
+		if limit > 0 && int64(len(chunk)) > limit-written {
+			chunk = chunk[:limit-written]
+		}
+		n, err := w.Write(chunk)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		if limit > 0 && written >= limit {
+			break
+		}
+		off = end % len(payload)
+		if flusher != nil && off == 0 {
+			flusher.Flush()
+		}
+	}
+	return written, nil
+}
+
+// PeerSpeedOptions 对端测速参数
+type PeerSpeedOptions struct {
+	// Server 对端地址：IP/主机名[:端口]，或完整 URL（无路径时补 /api/v1/speedtest）
+	Server    string
+	Token     string        // 对端 speedtest_server.token
+	Direction string        // download（对端→本机）/ upload（本机→对端）/ both（默认）
+	Duration  time.Duration // 每个方向的时长，默认 10 秒
+	Streams   int           // 并发连接数，默认 4
+	// OnProgress 每秒回调一次当前方向的即时速率
+	OnProgress func(p PeerSpeedProgress)
+}
+
+// PeerSpeedProgress 测速进度
+type PeerSpeedProgress struct {
+	Direction string  `json:"direction"`
+	Elapsed   float64 `json:"elapsed"` // 秒
+	Bytes     int64   `json:"bytes"`
+	Speed     float64 `json:"speed"` // 最近 1 秒的速率（Mbps）
+}
+
+// PeerSpeedResult 对端测速结果
+type PeerSpeedResult struct {
+	Server        string  `json:"server"`
+	Direction     string  `json:"direction"`
+	Streams       int     `json:"streams"`
+	Latency       float64 `json:"latency"`        // ms，多次请求的中位数
+	DownloadSpeed float64 `json:"download_speed"` // Mbps
+	UploadSpeed   float64 `json:"upload_speed"`   // Mbps
+	DownloadBytes int64   `json:"download_bytes"`
+	UploadBytes   int64   `json:"upload_bytes"`
+	TestTime      string  `json:"test_time"`
+	Duration      float64 `json:"duration"` // 秒（含延迟测量）
+	// Upload 上传方向的采样曲线与分位数（上传速率扣除了预热阶段）
+	Upload *UploadTestResult `json:"upload,omitempty"`
+}
+
+// PeerSpeedTest 与另一台运行本程序的盒子（或任何实现了同样接口的测速服务端）测量双向吞吐
+func PeerSpeedTest(ctx context.Context, opts PeerSpeedOptions) (*PeerSpeedResult, error) {
+	base, err := peerSpeedBase(opts.Server)
+	if err != nil {
+		return nil, err
+	}
+	opts.Direction = strings.ToLower(strings.TrimSpace(opts.Direction))
+	switch opts.Direction {
+	case "":
+		opts.Direction = "both"
+	case "download", "upload", "both":
+	default:
+		return nil, fmt.Errorf("不支持的测速方向: %s", opts.Direction)
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = defaultPeerSeconds * time.Second
+	}
+	if opts.Duration > SpeedTestMaxDuration {
+		opts.Duration = SpeedTestMaxDuration
+	}
+	if opts.Streams <= 0 {
+		opts.Streams = defaultPeerStreams
+	}
+	if opts.Streams > 16 {
+		opts.Streams = 16
+	}
+
+	start := time.Now()
+	result := &PeerSpeedResult{
+		Server:    base,
+		Direction: opts.Direction,
+		Streams:   opts.Streams,
+		TestTime:  start.Format(time.RFC3339),
+	}
+	client := httpClientWithTransport()
+
+	latency, err := peerLatency(ctx, client, base, opts.Token)
+	if err != nil {
+		return nil, err
+	}
+	result.Latency = latency
+
+	if opts.Direction == "download" || opts.Direction == "both" {
+		bytes, secs, err := measureStreams(ctx, "download", opts, func(ctx context.Context, counter *atomic.Int64) error {
+			return peerDownloadStream(ctx, client, base, opts.Token, opts.Duration, counter)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("下载测速失败: %v", err)
+		}
+		result.DownloadBytes = bytes
+		result.DownloadSpeed = mbps(bytes, secs)
+	}
+	if opts.Direction == "upload" || opts.Direction == "both" {
+		up, err := UploadSpeedTest(ctx, UploadTestOptions{
+			URL:      base + "/upload",
+			Headers:  peerHeaders(opts.Token),
+			Streams:  opts.Streams,
+			Duration: opts.Duration,
+			OnSample: func(s SpeedSample) {
+				if opts.OnProgress != nil {
+					opts.OnProgress(PeerSpeedProgress{Direction: "upload", Elapsed: s.T, Bytes: s.Bytes, Speed: s.Speed})
+				}
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("上传测速失败: %v", err)
+		}
+		result.UploadBytes = up.Bytes
+		result.UploadSpeed = up.Speed
+		result.Upload = up
+	}
+	result.Duration = math.Round(time.Since(start).Seconds()*10) / 10
+	return result, nil
+}
+
+// peerSpeedBase 规范化对端地址为测速接口前缀
+func peerSpeedBase(server string) (string, error) {
+	server = strings.TrimSpace(server)
+	if server == "" {
+		return "", fmt.Errorf("对端地址不能为空")
+	}
+	if !strings.Contains(server, "://") {
+		server = "http://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("无效的对端地址: %s", server)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/api/v1/speedtest"
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawQuery, u.Fragment = "", ""
+	return u.String(), nil
+}
+
+func peerRequest(ctx context.Context, method, urlStr, token string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, urlStr, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range peerHeaders(token) {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Cache-Control", "no-store")
+	return req, nil
+}
+
+func peerHeaders(token string) map[string]string {
+	if token == "" {
+		return nil
+	}
+	return map[string]string{SpeedTestTokenHeader: token}
+}
+
+// peerLatency 多次请求 /ping，取往返耗时中位数；同时检查对端是否可用、口令是否正确
+func peerLatency(ctx context.Context, client *http.Client, base, token string) (float64, error) {
+	samples := make([]float64, 0, peerPingCount)
+	for i := 0; i < peerPingCount; i++ {
+		req, err := peerRequest(ctx, http.MethodGet, base+"/ping", token, nil)
+		if err != nil {
+			return 0, err
+		}
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, fmt.Errorf("连接对端失败: %v", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return 0, fmt.Errorf("对端测速接口不可用: %s", peerStatusText(resp.StatusCode))
+		}
+		samples = append(samples, float64(time.Since(start).Microseconds())/1000)
+	}
+	sort.Float64s(samples)
+	return roundMs(samples[len(samples)/2]), nil
+}
+
+func peerStatusText(code int) string {
+	switch code {
+	case http.StatusUnauthorized:
+		return "口令错误或未配置 speedtest_server.token"
+	case http.StatusNotFound:
+		return "对端未开启测速服务端"
+	}
+	return fmt.Sprintf("HTTP %d", code)
+}
+
+func peerDownloadStream(ctx context.Context, client *http.Client, base, token string, d time.Duration, counter *atomic.Int64) error {
+	q := url.Values{}
+	// 服务端多发一会儿，由客户端按自己的时间窗口截止
+	q.Set("duration", fmt.Sprintf("%d", int((d+2*time.Second)/time.Second)))
+	req, err := peerRequest(ctx, http.MethodGet, base+"/download?"+q.Encode(), token, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", peerStatusText(resp.StatusCode))
+	}
+	buf := make([]byte, speedStreamChunk)
+	for {
+		n, err := resp.Body.Read(buf)
+		counter.Add(int64(n))
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// speedUploadReader 上传请求体：循环输出测速数据，ctx 结束或输出满 limit 字节（>0 时）返回 EOF 让请求正常结束
+type speedUploadReader struct {
+	ctx     context.Context
+	counter *atomic.Int64
+	limit   int64
+	sent    int64
+	off     int
+}
+
+func (r *speedUploadReader) Read(p []byte) (int, error) {
+	if r.ctx.Err() != nil {
+		return 0, io.EOF
+	}
+	if r.limit > 0 {
+		if r.sent >= r.limit {
+			return 0, io.EOF
+		}
+		if rest := r.limit - r.sent; int64(len(p)) > rest {
+			p = p[:rest]
+		}
+	}
+	payload := SpeedTestPayload()
+	n := copy(p, payload[r.off:])
+	r.off = (r.off + n) % len(payload)
+	r.sent += int64(n)
+	r.counter.Add(int64(n))
+	return n, nil
+}
+
+// measureStreams 并发运行 streams 个传输，opts.Duration 后统一截止；返回总字节数和实际时长（秒）
+func measureStreams(parent context.Context, direction string, opts PeerSpeedOptions, stream func(ctx context.Context, counter *atomic.Int64) error) (int64, float64, error) {
+	ctx, cancel := context.WithTimeout(parent, opts.Duration)
+	defer cancel()
+
+	var counter atomic.Int64
+	var wg sync.WaitGroup
+	errs := make(chan error, opts.Streams)
+	start := time.Now()
+	for i := 0; i < opts.Streams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := stream(ctx, &counter); err != nil && ctx.Err() == nil {
+				errs <- err
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var last int64
+	lastAt := start
+wait:
+	for {
+		select {
+		case <-done:
+			break wait
+		case now := <-ticker.C:
+			cur := counter.Load()
+			if opts.OnProgress != nil {
+				opts.OnProgress(PeerSpeedProgress{
+					Direction: direction,
+					Elapsed:   math.Round(now.Sub(start).Seconds()*10) / 10,
+					Bytes:     cur,
+					Speed:     mbps(cur-last, now.Sub(lastAt).Seconds()),
+				})
+			}
+			last, lastAt = cur, now
+		}
+	}
+	secs := time.Since(start).Seconds()
+	if err := parent.Err(); err != nil {
+		return 0, secs, err
+	}
+	total := counter.Load()
+	if total == 0 {
+		select {
+		case err := <-errs:
+			return 0, secs, err
+		default:
+			return 0, secs, fmt.Errorf("未传输任何数据")
+		}
+	}
+	return total, secs, nil
+}
+
+func mbps(bytes int64, secs float64) float64 {
+	if secs <= 0 {
+		return 0
+	}
+	return math.Round(float64(bytes)*8/secs/1e6*100) / 100
+}
@@ -21,10 +21,23 @@ type SpeedResult struct {
 	Latency      int     `json:"latency"`      // ms
 	TestTime     string  `json:"test_time"`
 	Duration     int     `json:"duration"`     // 秒
+	// Upload 上传测速详情（字节数、采样曲线、分位数）
+	Upload      *UploadTestResult `json:"upload,omitempty"`
+	UploadError string            `json:"upload_error,omitempty"`
 }
 
 // SpeedTest 执行网速测试
 func SpeedTest(server string, testType string) (*SpeedResult, error) {
+	return SpeedTestContext(context.Background(), server, testType)
+}
+
+// SpeedTestContext 执行网速测试，ctx 取消时终止
+func SpeedTestContext(ctx context.Context, server string, testType string) (*SpeedResult, error) {
+	return SpeedTestWithUpload(ctx, server, testType, UploadTestOptions{})
+}
+
+// SpeedTestWithUpload 执行网速测试；upload.URL 为空时按 server 推断上传端点
+func SpeedTestWithUpload(ctx context.Context, server string, testType string, upload UploadTestOptions) (*SpeedResult, error) {
 	// 默认测速源：清华大学 TUNA 镜像站（更适合国内环境）；失败会自动多级兜底
 	if server == "" || server == "default" {
 		// 选用相对稳定的大文件路径（我们只在固定时间窗口内读取，不会强制下完整文件）
@@ -63,9 +76,12 @@ func SpeedTest(server string, testType string) (*SpeedResult, error) {
 	if testType == "download" || testType == "all" {
 		var lastErr error
 		for _, cand := range downloadCandidates {
-			downloadSpeed, latency, used, err := testDownloadSpeedAny(cand)
+			downloadSpeed, latency, used, err := testDownloadSpeedAny(ctx, cand)
 			if err != nil {
 				lastErr = err
+				if ctx.Err() != nil {
+					break
+				}
 				continue
 			}
 			result.DownloadSpeed = downloadSpeed
@@ -79,11 +95,20 @@ func SpeedTest(server string, testType string) (*SpeedResult, error) {
 		}
 	}
 
-	// 测试上传速度
+	// 测试上传速度：只测上传时失败直接返回错误；all 模式下记录错误，保留下载结果
 	if testType == "upload" || testType == "all" {
-		uploadSpeed, err := testUploadSpeed(server)
-		if err == nil {
-			result.UploadSpeed = uploadSpeed
+		up, err := testUploadSpeed(ctx, server, upload)
+		if err != nil {
+			if testType == "upload" || ctx.Err() != nil {
+				return nil, err
+			}
+			result.UploadError = err.Error()
+		} else {
+			result.Upload = up
+			result.UploadSpeed = up.Speed
+			if testType == "upload" {
+				result.Server = up.URL
+			}
 		}
 	}
 
@@ -135,7 +160,7 @@ func httpClientWithTransport() *http.Client {
 // - 传入 https://speed.cloudflare.com（会使用 /__down）
 // - 传入具体文件URL（如 http://speedtest.tele2.net/10MB.zip）
 // - 传入 base URL（会自动拼接一个常见下载文件名）
-func testDownloadSpeedAny(serverOrURL string) (float64, int, string, error) {
+func testDownloadSpeedAny(ctx context.Context, serverOrURL string) (float64, int, string, error) {
 	s := strings.TrimSpace(serverOrURL)
 	if s == "" {
 		return 0, 0, "", fmt.Errorf("测速服务器不能为空")
@@ -152,7 +177,7 @@ func testDownloadSpeedAny(serverOrURL string) (float64, int, string, error) {
 		q := downURL.Query()
 		q.Set("bytes", "100000000")
 		downURL.RawQuery = q.Encode()
-		return testDownloadSpeedURL(downURL.String(), 2, 12*time.Second)
+		return testDownloadSpeedURL(ctx, downURL.String(), 2, 12*time.Second)
 	}
 
 	// 如果用户给的是 base（没有明显文件后缀），给它拼一个常见文件
@@ -160,19 +185,19 @@ func testDownloadSpeedAny(serverOrURL string) (float64, int, string, error) {
 		if u.Path == "" || strings.HasSuffix(u.Path, "/") {
 			// 默认拼 /10MB.zip（多数公开测速源兼容）
 			u.Path = strings.TrimRight(u.Path, "/") + "/10MB.zip"
-			return testDownloadSpeedURL(u.String(), 1, 12*time.Second)
+			return testDownloadSpeedURL(ctx, u.String(), 1, 12*time.Second)
 		}
 		// 看起来像文件URL，直接用
-		return testDownloadSpeedURL(u.String(), 1, 12*time.Second)
+		return testDownloadSpeedURL(ctx, u.String(), 1, 12*time.Second)
 	}
 
 	// 最后尝试当作原始 URL
-	return testDownloadSpeedURL(s, 1, 12*time.Second)
+	return testDownloadSpeedURL(ctx, s, 1, 12*time.Second)
 }
 
-func testDownloadSpeedURL(urlStr string, concurrency int, duration time.Duration) (float64, int, string, error) {
-	latency, _ := testLatencyByRange(urlStr)
-	totalBytes, dur, err := parallelDownloadForDuration(urlStr, concurrency, duration)
+func testDownloadSpeedURL(ctx context.Context, urlStr string, concurrency int, duration time.Duration) (float64, int, string, error) {
+	latency, _ := testLatencyByRange(ctx, urlStr)
+	totalBytes, dur, err := parallelDownloadForDuration(ctx, urlStr, concurrency, duration)
 	if err != nil {
 		return 0, latency, urlStr, err
 	}
@@ -186,9 +211,9 @@ func testDownloadSpeedURL(urlStr string, concurrency int, duration time.Duration
 	return mbps, latency, urlStr, nil
 }
 
-func testLatencyByRange(urlStr string) (int, error) {
+func testLatencyByRange(ctx context.Context, urlStr string) (int, error) {
 	client := httpClientWithTransport()
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 	req, _ := http.NewRequest("GET", urlStr, nil)
 	req = req.WithContext(ctx)
@@ -207,14 +232,14 @@ func testLatencyByRange(urlStr string) (int, error) {
 	return int(time.Since(start).Milliseconds()), nil
 }
 
-func parallelDownloadForDuration(urlStr string, concurrency int, duration time.Duration) (int64, float64, error) {
+func parallelDownloadForDuration(parent context.Context, urlStr string, concurrency int, duration time.Duration) (int64, float64, error) {
 	if concurrency <= 0 {
 		concurrency = 1
 	}
 	if duration <= 0 {
 		duration = 8 * time.Second
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	ctx, cancel := context.WithTimeout(parent, duration)
 	defer cancel()
 	client := httpClientWithTransport()
 
@@ -282,6 +307,9 @@ func parallelDownloadForDuration(urlStr string, concurrency int, duration time.D
 	}
 	wg.Wait()
 	dur := time.Since(start).Seconds()
+	if err := parent.Err(); err != nil {
+		return 0, dur, err
+	}
 	if firstErr != nil && total == 0 {
 		return 0, dur, firstErr
 	}
@@ -293,13 +321,47 @@ func parallelDownloadForDuration(urlStr string, concurrency int, duration time.D
 
 // 保留以兼容旧接口（不再直接调用）
 func testDownloadSpeedTele2(server string) (float64, int, error) {
-	speed, latency, _, err := testDownloadSpeedURL(strings.TrimRight(server, "/")+"/10MB.zip", 1, 12*time.Second)
+	speed, latency, _, err := testDownloadSpeedURL(context.Background(), strings.TrimRight(server, "/")+"/10MB.zip", 1, 12*time.Second)
 	return speed, latency, err
 }
 
-// testUploadSpeed 测试上传速度
-func testUploadSpeed(server string) (float64, error) {
-	// 仅当测速服务端明确支持上传接口时才启用（否则返回明确错误）
-	return 0, fmt.Errorf("上传速度测试需要测速服务端提供上传接口（当前默认不支持）")
+// testUploadSpeed 测试上传速度：
+// - 指定了 upload.URL 时直接使用
+// - 默认源/Cloudflare 使用 https://speed.cloudflare.com/__up（按固定大小分块请求）
+// - 盒子测速服务端（IP/主机名[:端口]，或路径含 /api/v1/speedtest）使用其 /upload 接口
+func testUploadSpeed(ctx context.Context, server string, upload UploadTestOptions) (*UploadTestResult, error) {
+	if strings.TrimSpace(upload.URL) == "" {
+		s := strings.TrimSpace(server)
+		switch {
+		case s == "" || s == "default" || strings.Contains(s, "mirrors.tuna.tsinghua.edu.cn") || strings.Contains(s, "speed.cloudflare.com"):
+			upload.URL = cloudflareUploadURL
+			if upload.ChunkSize == 0 {
+				upload.ChunkSize = cloudflareUploadChunk
+			}
+		case strings.Contains(s, "/api/v1/speedtest") || isBareHost(s):
+			base, err := peerSpeedBase(s)
+			if err != nil {
+				return nil, err
+			}
+			upload.URL = strings.TrimSuffix(base, "/upload") + "/upload"
+		default:
+			return nil, fmt.Errorf("测速服务器 %s 不提供上传接口，请指定 upload_url", s)
+		}
+	}
+	return UploadSpeedTest(ctx, upload)
 }
 
+// isBareHost 只有主机（和端口），没有路径
+func isBareHost(s string) bool {
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	u, err := url.Parse(s)
+	return err == nil && u.Host != "" && (u.Path == "" || u.Path == "/")
+}
+
+const (
+	cloudflareUploadURL = "https://speed.cloudflare.com/__up"
+	// Cloudflare 单次请求体过大会被拒绝，分块发送
+	cloudflareUploadChunk = 8 << 20
+)
@@ -0,0 +1,313 @@
+package toolkit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UploadTestOptions 上传测速参数
+type UploadTestOptions struct {
+	URL     string            // 接收上传的地址（POST/PUT 请求体会被丢弃的端点）
+	Method  string            // POST（默认）/ PUT
+	Headers map[string]string // 额外请求头（如对端测速口令）
+	Streams int               // 并发连接数，默认 4
+	// Duration 总时长（含预热），默认 10 秒
+	Duration time.Duration
+	// WarmUp 预热时长，期间的数据不计入结果（TCP 慢启动），默认 2 秒（不超过总时长的一半）
+	WarmUp time.Duration
+	// Interval 采样窗口，默认 500ms
+	Interval time.Duration
+	// ChunkSize 每个请求的字节数：0 表示每个连接只发一个 chunked 请求直到结束；
+	// >0 时连接上循环发送固定大小的请求（适合限制单次请求体大小的公网端点）
+	ChunkSize int64
+	// OnSample 每个采样窗口结束时回调
+	OnSample func(s SpeedSample)
+}
+
+// SpeedSample 一个采样窗口的速率
+type SpeedSample struct {
+	T      float64 `json:"t"`     // 窗口结束时刻（距开始的秒数）
+	Speed  float64 `json:"speed"` // 窗口内速率（Mbps）
+	Bytes  int64   `json:"bytes"` // 累计字节数
+	WarmUp bool    `json:"warmup,omitempty"`
+}
+
+// SpeedPercentiles 采样窗口速率的分位数（Mbps，不含预热窗口）
+type SpeedPercentiles struct {
+	P10 float64 `json:"p10"`
+	P25 float64 `json:"p25"`
+	P50 float64 `json:"p50"`
+	P75 float64 `json:"p75"`
+	P90 float64 `json:"p90"`
+	Max float64 `json:"max"`
+}
+
+// UploadTestResult 上传测速结果
+type UploadTestResult struct {
+	URL           string           `json:"url"`
+	Method        string           `json:"method"`
+	Streams       int              `json:"streams"`
+	Speed         float64          `json:"speed"`          // 预热后的平均速率（Mbps）
+	Bytes         int64            `json:"bytes"`          // 总发送字节数（含预热）
+	MeasuredBytes int64            `json:"measured_bytes"` // 计入结果的字节数
+	Duration      float64          `json:"duration"`       // 秒
+	WarmUp        float64          `json:"warmup"`         // 秒
+	Requests      int64            `json:"requests"`
+	Failures      int64            `json:"failures"`
+	Percentiles   SpeedPercentiles `json:"percentiles"`
+	Samples       []SpeedSample    `json:"samples"`
+	TestTime      string           `json:"test_time"`
+}
+
+const (
+	defaultUploadStreams  = 4
+	defaultUploadDuration = 10 * time.Second
+	defaultUploadWarmUp   = 2 * time.Second
+	defaultSampleInterval = 500 * time.Millisecond
+	// 测速窗口结束后等待服务端响应的时间
+	uploadResponseGrace = 15 * time.Second
+)
+
+// UploadSpeedTest 并发上传测速：多个连接持续 POST/PUT 随机数据到 URL，按采样窗口统计速率，
+// 预热阶段的数据不计入平均值和分位数
+func UploadSpeedTest(ctx context.Context, opts UploadTestOptions) (*UploadTestResult, error) {
+	u, err := url.Parse(strings.TrimSpace(opts.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("无效的上传地址: %s", opts.URL)
+	}
+	opts.Method = strings.ToUpper(strings.TrimSpace(opts.Method))
+	switch opts.Method {
+	case "":
+		opts.Method = http.MethodPost
+	case http.MethodPost, http.MethodPut:
+	default:
+		return nil, fmt.Errorf("上传测速只支持 POST/PUT")
+	}
+	if opts.Streams <= 0 {
+		opts.Streams = defaultUploadStreams
+	}
+	if opts.Streams > 16 {
+		opts.Streams = 16
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = defaultUploadDuration
+	}
+	if opts.Duration > SpeedTestMaxDuration {
+		opts.Duration = SpeedTestMaxDuration
+	}
+	if opts.WarmUp < 0 {
+		opts.WarmUp = 0
+	} else if opts.WarmUp == 0 {
+		opts.WarmUp = defaultUploadWarmUp
+	}
+	if opts.WarmUp > opts.Duration/2 {
+		opts.WarmUp = opts.Duration / 2
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultSampleInterval
+	}
+	if opts.Interval < 100*time.Millisecond {
+		opts.Interval = 100 * time.Millisecond
+	}
+	if opts.ChunkSize < 0 {
+		opts.ChunkSize = 0
+	}
+
+	result := &UploadTestResult{
+		URL:      u.String(),
+		Method:   opts.Method,
+		Streams:  opts.Streams,
+		WarmUp:   opts.WarmUp.Seconds(),
+		Samples:  []SpeedSample{},
+		TestTime: time.Now().Format(time.RFC3339),
+	}
+	client := httpClientWithTransport()
+	// window 控制发送时长；请求本身用 reqCtx，使请求体结束后还能收到服务端响应
+	window, cancelWindow := context.WithTimeout(ctx, opts.Duration)
+	defer cancelWindow()
+	reqCtx, cancelReq := context.WithTimeout(ctx, opts.Duration+uploadResponseGrace)
+	defer cancelReq()
+
+	var (
+		counter    atomic.Int64
+		requests   atomic.Int64
+		succeeded  atomic.Int64
+		failures   atomic.Int64
+		errMu      sync.Mutex
+		firstErr   error
+		wg         sync.WaitGroup
+		warmBytes  atomic.Int64
+		warmAtNano atomic.Int64
+	)
+	start := time.Now()
+	warmTimer := time.AfterFunc(opts.WarmUp, func() {
+		warmBytes.Store(counter.Load())
+		warmAtNano.Store(time.Since(start).Nanoseconds())
+	})
+	defer warmTimer.Stop()
+
+	for i := 0; i < opts.Streams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			consecutive := 0
+			for window.Err() == nil {
+				requests.Add(1)
+				err := uploadRequest(reqCtx, window, client, u.String(), opts, &counter)
+				if err == nil {
+					succeeded.Add(1)
+					consecutive = 0
+					continue
+				}
+				if window.Err() != nil {
+					// 窗口结束时被截断的请求不算失败
+					return
+				}
+				failures.Add(1)
+				errMu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMu.Unlock()
+				if consecutive++; consecutive >= 3 {
+					return
+				}
+				select {
+				case <-window.Done():
+				case <-time.After(200 * time.Millisecond):
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	var last int64
+	lastAt := start
+	sending := true
+	for sending {
+		select {
+		case <-done:
+			sending = false
+		case <-window.Done():
+			sending = false
+		case now := <-ticker.C:
+			cur := counter.Load()
+			s := SpeedSample{
+				T:      math.Round(now.Sub(start).Seconds()*100) / 100,
+				Speed:  mbps(cur-last, now.Sub(lastAt).Seconds()),
+				Bytes:  cur,
+				WarmUp: now.Sub(start) <= opts.WarmUp,
+			}
+			result.Samples = append(result.Samples, s)
+			if opts.OnSample != nil {
+				opts.OnSample(s)
+			}
+			last, lastAt = cur, now
+		}
+	}
+	end := time.Since(start)
+	// 等待各连接收到响应（或被取消）
+	<-done
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	result.Bytes = counter.Load()
+	result.Requests = requests.Load()
+	result.Failures = failures.Load()
+	result.Duration = math.Round(end.Seconds()*100) / 100
+	// 没有任何请求被服务端接受（地址错误、口令错误等）时不给出速率
+	if succeeded.Load() == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	if result.Bytes == 0 {
+		return nil, fmt.Errorf("未发送任何数据")
+	}
+
+	warmAt := time.Duration(warmAtNano.Load())
+	if warmAt == 0 || warmAt >= end {
+		// 提前结束（例如全部连接失败）时不扣除预热
+		warmAt, result.WarmUp = 0, 0
+		warmBytes.Store(0)
+	}
+	result.MeasuredBytes = result.Bytes - warmBytes.Load()
+	result.Speed = mbps(result.MeasuredBytes, (end - warmAt).Seconds())
+	result.Percentiles = samplePercentiles(result.Samples)
+	return result, nil
+}
+
+// uploadRequest 发送一个上传请求；ChunkSize 为 0 时请求体持续到 window 结束
+func uploadRequest(ctx, window context.Context, client *http.Client, urlStr string, opts UploadTestOptions, counter *atomic.Int64) error {
+	body := &speedUploadReader{ctx: window, counter: counter, limit: opts.ChunkSize}
+	req, err := http.NewRequestWithContext(ctx, opts.Method, urlStr, body)
+	if err != nil {
+		return err
+	}
+	if opts.ChunkSize > 0 {
+		req.ContentLength = opts.ChunkSize
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Cache-Control", "no-store")
+	for k, v := range opts.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("上传请求失败: %s", resp.Status)
+	}
+	return nil
+}
+
+// samplePercentiles 预热后各窗口速率的分位数（线性插值）
+func samplePercentiles(samples []SpeedSample) SpeedPercentiles {
+	vals := make([]float64, 0, len(samples))
+	for _, s := range samples {
+		if !s.WarmUp {
+			vals = append(vals, s.Speed)
+		}
+	}
+	if len(vals) == 0 {
+		return SpeedPercentiles{}
+	}
+	sort.Float64s(vals)
+	return SpeedPercentiles{
+		P10: percentile(vals, 10),
+		P25: percentile(vals, 25),
+		P50: percentile(vals, 50),
+		P75: percentile(vals, 75),
+		P90: percentile(vals, 90),
+		Max: vals[len(vals)-1],
+	}
+}
+
+// percentile sorted 须已升序
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	pos := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	v := sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+	return math.Round(v*100) / 100
+}
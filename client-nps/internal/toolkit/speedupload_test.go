@@ -0,0 +1,51 @@
+package toolkit
+
+import "testing"
+
+func TestSamplePercentiles(t *testing.T) {
+	speeds := func(warm []float64, vals ...float64) []SpeedSample {
+		out := []SpeedSample{}
+		for _, v := range warm {
+			out = append(out, SpeedSample{Speed: v, WarmUp: true})
+		}
+		for _, v := range vals {
+			out = append(out, SpeedSample{Speed: v})
+		}
+		return out
+	}
+	tests := []struct {
+		name    string
+		samples []SpeedSample
+		want    SpeedPercentiles
+	}{
+		{
+			name:    "乱序输入线性插值",
+			samples: speeds(nil, 100, 10, 90, 20, 80, 30, 70, 40, 60, 50),
+			want:    SpeedPercentiles{P10: 19, P25: 32.5, P50: 55, P75: 77.5, P90: 91, Max: 100},
+		},
+		{
+			name:    "排除预热窗口",
+			samples: speeds([]float64{1000}, 20, 40),
+			want:    SpeedPercentiles{P10: 22, P25: 25, P50: 30, P75: 35, P90: 38, Max: 40},
+		},
+		{
+			name:    "保留两位小数",
+			samples: speeds(nil, 1, 2, 2),
+			want:    SpeedPercentiles{P10: 1.2, P25: 1.5, P50: 2, P75: 2, P90: 2, Max: 2},
+		},
+		{
+			name:    "单个窗口",
+			samples: speeds(nil, 42.5),
+			want:    SpeedPercentiles{P10: 42.5, P25: 42.5, P50: 42.5, P75: 42.5, P90: 42.5, Max: 42.5},
+		},
+		{name: "只有预热窗口", samples: speeds([]float64{5, 6})},
+		{name: "无采样", samples: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := samplePercentiles(tt.samples); got != tt.want {
+				t.Fatalf("samplePercentiles = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
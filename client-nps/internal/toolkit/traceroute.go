@@ -4,63 +4,708 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand"
 	"net"
 	"os/exec"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 // Hop Traceroute跳转信息
 type Hop struct {
-	Hop      int     `json:"hop"`
-	IP       string  `json:"ip"`
-	Hostname string  `json:"hostname"`
-	Latency  float64 `json:"latency"`
+	Hop        int        `json:"hop"`
+	IP         string     `json:"ip"` // 无响应为 "*"；多个响应地址时为第一个
+	Hostname   string     `json:"hostname"`
+	Latency    float64    `json:"latency"`       // 平均 RTT（ms）
+	IPs        []string   `json:"ips,omitempty"` // 同一跳有多个响应地址（负载均衡）时列出全部
+	Probes     []HopProbe `json:"probes,omitempty"`
+	Loss       float64    `json:"loss"` // 丢包率（%）
+	MinLatency float64    `json:"min_latency"`
+	AvgLatency float64    `json:"avg_latency"`
+	MaxLatency float64    `json:"max_latency"`
+	Mdev       float64    `json:"mdev"`
+	Note       string     `json:"note,omitempty"` // 不可达标记：!H !N !P !A
+}
+
+// HopProbe 单次探测结果
+type HopProbe struct {
+	IP      string  `json:"ip,omitempty"`
+	Latency float64 `json:"latency"`
+	Status  string  `json:"status"` // success / timeout
 }
 
 // TracerouteResult Traceroute结果
 type TracerouteResult struct {
-	Target string `json:"target"`
-	Hops   []Hop  `json:"hops"`
+	Target   string `json:"target"`
+	IP       string `json:"ip,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Paris    bool   `json:"paris"`
+	Method   string `json:"method"` // native / system
+	Reached  bool   `json:"reached"`
+	Hops     []Hop  `json:"hops"`
 }
 
+// TracerouteOptions Traceroute参数
+type TracerouteOptions struct {
+	Protocol  string        // icmp（默认）/ udp / tcp
+	Port      int           // udp 默认 33434，tcp 默认 80
+	Probes    int           // 每跳探测次数，默认 3
+	FirstHop  int           // 起始 TTL，默认 1
+	MaxHops   int           // 默认 30
+	Timeout   time.Duration // 每跳等待回复的时间，默认 3 秒
+	Paris     bool          // 保持负载均衡哈希用到的流标识不变，使各次探测走同一条路径
+	NoResolve bool          // 不做反向解析
+	IPVersion int           // 4 / 6，0 表示按解析结果（优先 IPv4）
+	// OnHop 每跳完成时回调，用于实时推送
+	OnHop func(h Hop)
+}
+
+const (
+	defaultTraceUDPPort = 33434
+	// UDP 探测标识（Paris 为数据长度，经典模式为目的端口偏移）循环使用的范围
+	traceKeySpace    = 1024
+	traceMaxProbes   = 10
+	traceRDNSTimeout = time.Second
+)
+
 // Traceroute 执行Traceroute
 func Traceroute(target string, maxHops int, timeout time.Duration) (*TracerouteResult, error) {
-	if maxHops <= 0 {
-		maxHops = 30
+	return TracerouteContext(context.Background(), target, maxHops, timeout)
+}
+
+// TracerouteContext 执行Traceroute，ctx 取消时终止
+func TracerouteContext(ctx context.Context, target string, maxHops int, timeout time.Duration) (*TracerouteResult, error) {
+	return TracerouteWithOptions(ctx, target, TracerouteOptions{MaxHops: maxHops, Timeout: timeout, Paris: true})
+}
+
+// TracerouteWithOptions 原生 traceroute：按 TTL 逐跳发送 ICMP echo / UDP / TCP SYN 探测，
+// 用 raw ICMP 套接字接收超时/不可达报文，并按报文中引用的原始探测头部匹配到具体探测。
+// 没有 raw 套接字权限（非 root 且无 CAP_NET_RAW）时退回系统 traceroute/tracert（固定为系统默认的 UDP/ICMP 探测，
+// 命令结束后才逐跳回调 OnHop）
+func TracerouteWithOptions(ctx context.Context, target string, opts TracerouteOptions) (*TracerouteResult, error) {
+	if err := normalizeTraceOptions(&opts); err != nil {
+		return nil, err
 	}
-	if timeout <= 0 {
-		timeout = 3 * time.Second
+
+	// 解析目标地址（验证目标是否有效）
+	dst, err := resolvePingTarget(ctx, target, opts.IPVersion)
+	if err != nil {
+		return nil, err
 	}
 
 	result := &TracerouteResult{
-		Target: target,
-		Hops:   []Hop{},
+		Target:   target,
+		IP:       dst.String(),
+		Protocol: opts.Protocol,
+		Paris:    opts.Paris,
+		Method:   "native",
+		Hops:     []Hop{},
+	}
+	if opts.Protocol != "icmp" {
+		result.Port = opts.Port
 	}
 
-	// 解析目标地址（验证目标是否有效）
-	_, err := net.ResolveIPAddr("ip", target)
+	t, err := newTracer(dst, opts)
 	if err != nil {
-		return nil, fmt.Errorf("解析目标地址失败: %v", err)
+		if opts.Protocol == "tcp" {
+			return nil, err
+		}
+		// 仅使用系统 traceroute/tracert（结果真实）；失败直接返回错误。
+		// 对已解析的地址执行，结果中的协议/端口按系统命令实际使用的填写
+		hops, serr := tracerouteWithSystemCommand(ctx, dst.IP, opts.MaxHops, opts.Timeout)
+		if serr != nil {
+			return nil, fmt.Errorf("%v；系统 traceroute 也不可用: %v", err, serr)
+		}
+		result.Method = "system"
+		result.Paris = false
+		result.Protocol, result.Port = systemTraceProtocol()
+		result.Hops = hops
+		for _, hop := range hops {
+			if opts.OnHop != nil {
+				opts.OnHop(hop)
+			}
+			if hop.IP == dst.IP.String() {
+				result.Reached = true
+			}
+		}
+		return result, nil
 	}
+	defer t.close()
 
-	// 仅使用系统 traceroute/tracert（结果真实）；失败直接返回错误
-	hops, err := tracerouteWithSystemCommand(target, maxHops, timeout)
-	if err != nil {
+	names := newReverseResolver(opts.NoResolve)
+	for ttl := opts.FirstHop; ttl <= opts.MaxHops && ctx.Err() == nil; ttl++ {
+		replies := t.probeHop(ctx, ttl)
+		if ctx.Err() != nil {
+			break
+		}
+		hop, reached := summarizeHop(ttl, replies)
+		if hop.IP != "*" {
+			hop.Hostname = names.lookup(ctx, hop.IP)
+		}
+		result.Hops = append(result.Hops, hop)
+		if opts.OnHop != nil {
+			opts.OnHop(hop)
+		}
+		if reached {
+			result.Reached = hop.IP == dst.IP.String()
+			break
+		}
+	}
+	if err := ctx.Err(); err != nil && len(result.Hops) == 0 {
 		return nil, err
 	}
-	result.Hops = hops
 	return result, nil
 }
 
-func tracerouteWithSystemCommand(target string, maxHops int, timeout time.Duration) ([]Hop, error) {
+// normalizeTraceOptions 校验参数并填充默认值
+func normalizeTraceOptions(opts *TracerouteOptions) error {
+	opts.Protocol = strings.ToLower(strings.TrimSpace(opts.Protocol))
+	switch opts.Protocol {
+	case "":
+		opts.Protocol = "icmp"
+	case "icmp", "udp", "tcp":
+	default:
+		return fmt.Errorf("不支持的探测类型: %s", opts.Protocol)
+	}
+	if opts.Port <= 0 {
+		switch opts.Protocol {
+		case "udp":
+			opts.Port = defaultTraceUDPPort
+		case "tcp":
+			opts.Port = 80
+		}
+	}
+	if opts.Port > 65535 {
+		return fmt.Errorf("无效的端口: %d", opts.Port)
+	}
+	if opts.Probes <= 0 {
+		opts.Probes = 3
+	}
+	if opts.Probes > traceMaxProbes {
+		opts.Probes = traceMaxProbes
+	}
+	if opts.MaxHops <= 0 {
+		opts.MaxHops = 30
+	}
+	if opts.MaxHops > 255 {
+		opts.MaxHops = 255
+	}
+	if opts.FirstHop <= 0 {
+		opts.FirstHop = 1
+	}
+	if opts.FirstHop > opts.MaxHops {
+		return fmt.Errorf("起始跳数不能大于最大跳数")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 3 * time.Second
+	}
+	return nil
+}
+
+// traceReply 一次探测的结果（ip 为 nil 表示超时）
+type traceReply struct {
+	ip   net.IP
+	rtt  time.Duration
+	done bool   // 到达目的地或收到不可达，之后不再增加 TTL
+	note string // 不可达标记
+}
+
+type traceProbe struct {
+	key    int
+	sent   time.Time
+	reply  chan traceReply
+	cancel context.CancelFunc // TCP 探测：收到 ICMP 后结束连接尝试
+}
+
+// tracer 一次 traceroute 的套接字与待匹配的探测
+type tracer struct {
+	opts TracerouteOptions
+	dst  *net.IPAddr
+	v6   bool
+
+	icmp    *icmp.PacketConn // raw ICMP：接收超时/不可达，ICMP 模式下同时用于发送
+	udp     *net.UDPConn
+	srcPort int // UDP 源端口 / TCP Paris 源端口
+	echoID  int
+	// hopPorts TCP Paris 模式下每个 TTL 使用固定的不同源端口（srcPort+ttl-1），
+	// 以便不同 TTL 的探测同时进行（MTR）；同一跳的流标识在各轮之间保持不变
+	hopPorts bool
+
+	mu      sync.Mutex
+	seq     int
+	pending map[int]*traceProbe
+}
+
+func newTracer(dst *net.IPAddr, opts TracerouteOptions) (*tracer, error) {
+	t := &tracer{
+		opts:    opts,
+		dst:     dst,
+		v6:      dst.IP.To4() == nil,
+		echoID:  int(pingID.Add(1) & 0xffff),
+		pending: make(map[int]*traceProbe),
+	}
+	network, addr := "ip4:icmp", "0.0.0.0"
+	if t.v6 {
+		network, addr = "ip6:ipv6-icmp", "::"
+	}
+	conn, err := icmp.ListenPacket(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("无法创建 raw ICMP 套接字（需要 root 或 CAP_NET_RAW）: %v", err)
+	}
+	t.icmp = conn
+
+	switch opts.Protocol {
+	case "udp":
+		udpNet := "udp4"
+		if t.v6 {
+			udpNet = "udp6"
+		}
+		uc, err := net.ListenUDP(udpNet, nil)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		t.udp = uc
+		t.srcPort = uc.LocalAddr().(*net.UDPAddr).Port
+	case "tcp":
+		t.srcPort = randomTracePort()
+	}
+	go t.receive()
+	return t, nil
+}
+
+func (t *tracer) close() {
+	t.icmp.Close()
+	if t.udp != nil {
+		t.udp.Close()
+	}
+}
+
+func randomTracePort() int {
+	return 33000 + rand.Intn(28000)
+}
+
+// probeHop 对一个 TTL 发送全部探测并等待结果；TCP Paris 模式源端口固定，只能逐个探测
+func (t *tracer) probeHop(ctx context.Context, ttl int) []traceReply {
+	if t.opts.Protocol == "tcp" && t.opts.Paris {
+		replies := make([]traceReply, 0, t.opts.Probes)
+		for i := 0; i < t.opts.Probes && ctx.Err() == nil; i++ {
+			replies = append(replies, t.waitAll(ctx, t.sendProbes(ctx, ttl, 1))...)
+		}
+		return replies
+	}
+	return t.waitAll(ctx, t.sendProbes(ctx, ttl, t.opts.Probes))
+}
+
+// waitAll 等待探测回复直到超时，返回与 probes 一一对应的结果（nil 探测视为超时）；
+// 超时未回复的探测不再匹配
+func (t *tracer) waitAll(ctx context.Context, probes []*traceProbe) []traceReply {
+	timer := time.NewTimer(t.opts.Timeout)
+	defer timer.Stop()
+	replies := make([]traceReply, len(probes))
+	expired := false
+	for i, p := range probes {
+		if p == nil {
+			continue
+		}
+		if !expired {
+			select {
+			case r := <-p.reply:
+				replies[i] = r
+				continue
+			case <-timer.C:
+				expired = true
+			case <-ctx.Done():
+				expired = true
+			}
+		}
+		select {
+		case r := <-p.reply:
+			replies[i] = r
+		default:
+			t.drop(p.key)
+		}
+	}
+	return replies
+}
+
+// sendProbes 发送 n 个探测，返回等待回复的探测列表（发送失败的为 nil）
+func (t *tracer) sendProbes(ctx context.Context, ttl, n int) []*traceProbe {
+	probes := make([]*traceProbe, 0, n)
+	switch t.opts.Protocol {
+	case "icmp":
+		if t.v6 {
+			t.icmp.IPv6PacketConn().SetHopLimit(ttl)
+		} else {
+			t.icmp.IPv4PacketConn().SetTTL(ttl)
+		}
+	case "udp":
+		if t.v6 {
+			ipv6.NewPacketConn(t.udp).SetHopLimit(ttl)
+		} else {
+			ipv4.NewPacketConn(t.udp).SetTTL(ttl)
+		}
+	}
+	for i := 0; i < n; i++ {
+		probes = append(probes, t.send(ctx, ttl))
+	}
+	return probes
+}
+
+// register 登记一个待匹配的探测，key 为探测标识（ICMP seq / UDP 长度或目的端口 / TCP 源端口）
+func (t *tracer) register(key int) *traceProbe {
+	p := &traceProbe{key: key, sent: time.Now(), reply: make(chan traceReply, 1)}
+	t.mu.Lock()
+	t.pending[key] = p
+	t.mu.Unlock()
+	return p
+}
+
+// deliver 把回复交给对应探测，每个探测只接收第一个回复
+func (t *tracer) deliver(key int, r traceReply) {
+	t.mu.Lock()
+	p, ok := t.pending[key]
+	if ok {
+		delete(t.pending, key)
+	}
+	t.mu.Unlock()
+	if !ok {
+		return
+	}
+	r.rtt = time.Since(p.sent)
+	p.reply <- r
+	if p.cancel != nil {
+		p.cancel()
+	}
+}
+
+func (t *tracer) nextSeq() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seq++
+	return t.seq
+}
+
+func (t *tracer) send(ctx context.Context, ttl int) *traceProbe {
+	seq := t.nextSeq()
+	switch t.opts.Protocol {
+	case "icmp":
+		key := seq & 0xffff
+		p := t.register(key)
+		data := make([]byte, 32)
+		if t.opts.Paris {
+			// Paris：用补偿字使 seq + 补偿恒为 0xffff，ICMP 校验和（负载均衡按其哈希）保持不变
+			binary.BigEndian.PutUint16(data, ^uint16(key))
+		}
+		typ := icmp.Type(ipv4.ICMPTypeEcho)
+		if t.v6 {
+			typ = ipv6.ICMPTypeEchoRequest
+		}
+		msg := icmp.Message{Type: typ, Body: &icmp.Echo{ID: t.echoID, Seq: key, Data: data}}
+		wire, err := msg.Marshal(nil)
+		if err == nil {
+			_, err = t.icmp.WriteTo(wire, t.dst)
+		}
+		if err != nil {
+			t.drop(key)
+			return nil
+		}
+		return p
+	case "udp":
+		// Paris：端口固定，用数据长度区分探测；经典模式每次探测目的端口 +1
+		key := (seq-1)%traceKeySpace + 1
+		port, size := t.opts.Port, key
+		if !t.opts.Paris {
+			port, size = t.opts.Port+key-1, 32
+			if port > 65535 {
+				return nil
+			}
+		}
+		p := t.register(key)
+		if _, err := t.udp.WriteTo(make([]byte, size), &net.UDPAddr{IP: t.dst.IP, Port: port, Zone: t.dst.Zone}); err != nil {
+			t.drop(key)
+			return nil
+		}
+		return p
+	case "tcp":
+		port := t.srcPort
+		if !t.opts.Paris {
+			port = randomTracePort()
+		} else if t.hopPorts {
+			port = t.srcPort + ttl - 1
+		}
+		p := t.register(port)
+		pctx, cancel := context.WithTimeout(ctx, t.opts.Timeout)
+		p.cancel = cancel
+		go t.dialTCP(pctx, cancel, ttl, port)
+		return p
+	}
+	return nil
+}
+
+func (t *tracer) drop(key int) {
+	t.mu.Lock()
+	delete(t.pending, key)
+	t.mu.Unlock()
+}
+
+// dialTCP 以指定 TTL 发起连接：收到 SYN-ACK（连接成功）或 RST（拒绝）即到达目的地
+func (t *tracer) dialTCP(ctx context.Context, cancel context.CancelFunc, ttl, srcPort int) {
+	defer cancel()
+	local := &net.TCPAddr{Port: srcPort}
+	d := net.Dialer{
+		LocalAddr: local,
+		Control: func(network, address string, c syscall.RawConn) error {
+			return setProbeSockopt(c, t.v6, ttl, t.opts.Paris)
+		},
+	}
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(t.dst.String(), strconv.Itoa(t.opts.Port)))
+	if err == nil {
+		// RST 关闭，避免 TIME_WAIT 占住 Paris 模式的源端口
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+		t.deliver(srcPort, traceReply{ip: t.dst.IP, done: true})
+		return
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		t.deliver(srcPort, traceReply{ip: t.dst.IP, done: true})
+	}
+}
+
+// receive 读取 ICMP 报文，按引用的原始探测头部匹配探测
+func (t *tracer) receive() {
+	buf := make([]byte, 1500)
+	proto := 1
+	if t.v6 {
+		proto = 58
+	}
+	for {
+		n, peer, err := t.icmp.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		msg, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil {
+			continue
+		}
+		from := addrIP(peer)
+		switch body := msg.Body.(type) {
+		case *icmp.Echo:
+			if (msg.Type == ipv4.ICMPTypeEchoReply || msg.Type == ipv6.ICMPTypeEchoReply) &&
+				t.opts.Protocol == "icmp" && body.ID == t.echoID && from.Equal(t.dst.IP) {
+				t.deliver(body.Seq, traceReply{ip: from, done: true})
+			}
+		case *icmp.TimeExceeded:
+			if key, ok := t.matchQuoted(body.Data); ok {
+				t.deliver(key, traceReply{ip: from})
+			}
+		case *icmp.DstUnreach:
+			if key, ok := t.matchQuoted(body.Data); ok {
+				t.deliver(key, traceReply{ip: from, done: true, note: unreachNote(t.v6, msg.Code)})
+			}
+		}
+	}
+}
+
+// matchQuoted 从 ICMP 差错报文引用的原始 IP 包中取出探测标识
+func (t *tracer) matchQuoted(data []byte) (int, bool) {
+	var proto int
+	var dst net.IP
+	var l4 []byte
+	if t.v6 {
+		if len(data) < 40 {
+			return 0, false
+		}
+		proto, dst, l4 = int(data[6]), net.IP(data[24:40]), data[40:]
+	} else {
+		if len(data) < 20 || data[0]>>4 != 4 {
+			return 0, false
+		}
+		hl := int(data[0]&0x0f) * 4
+		if len(data) < hl {
+			return 0, false
+		}
+		proto, dst, l4 = int(data[9]), net.IP(data[16:20]), data[hl:]
+	}
+	if !dst.Equal(t.dst.IP) || len(l4) < 8 {
+		return 0, false
+	}
+	srcPort := int(binary.BigEndian.Uint16(l4[0:2]))
+	dstPort := int(binary.BigEndian.Uint16(l4[2:4]))
+	switch t.opts.Protocol {
+	case "icmp":
+		if proto != 1 && proto != 58 {
+			return 0, false
+		}
+		if int(binary.BigEndian.Uint16(l4[4:6])) != t.echoID {
+			return 0, false
+		}
+		return int(binary.BigEndian.Uint16(l4[6:8])), true
+	case "udp":
+		if proto != 17 || srcPort != t.srcPort {
+			return 0, false
+		}
+		if t.opts.Paris {
+			return int(binary.BigEndian.Uint16(l4[4:6])) - 8, dstPort == t.opts.Port
+		}
+		return dstPort - t.opts.Port + 1, true
+	case "tcp":
+		if proto != 6 || dstPort != t.opts.Port {
+			return 0, false
+		}
+		return srcPort, true
+	}
+	return 0, false
+}
+
+// unreachNote 不可达代码对应的 traceroute 标记（端口不可达表示到达目的地，不标记）
+func unreachNote(v6 bool, code int) string {
+	if v6 {
+		switch code {
+		case 0:
+			return "!N"
+		case 1:
+			return "!A"
+		case 3:
+			return "!H"
+		}
+		return ""
+	}
+	switch code {
+	case 0:
+		return "!N"
+	case 1:
+		return "!H"
+	case 2:
+		return "!P"
+	case 9, 10, 13:
+		return "!A"
+	}
+	return ""
+}
+
+func addrIP(a net.Addr) net.IP {
+	switch v := a.(type) {
+	case *net.IPAddr:
+		return v.IP
+	case *net.UDPAddr:
+		return v.IP
+	}
+	return nil
+}
+
+// summarizeHop 汇总一跳的探测结果；任一探测到达目的地或收到不可达即结束
+func summarizeHop(ttl int, replies []traceReply) (Hop, bool) {
+	h := Hop{Hop: ttl, IP: "*", Probes: make([]HopProbe, 0, len(replies))}
+	var rtts []float64
+	seen := map[string]bool{}
+	done := false
+	for _, r := range replies {
+		if r.ip == nil {
+			h.Probes = append(h.Probes, HopProbe{Status: "timeout"})
+			continue
+		}
+		ms := math.Round(float64(r.rtt.Microseconds())) / 1000
+		ip := r.ip.String()
+		h.Probes = append(h.Probes, HopProbe{IP: ip, Latency: ms, Status: "success"})
+		rtts = append(rtts, ms)
+		if !seen[ip] {
+			seen[ip] = true
+			h.IPs = append(h.IPs, ip)
+		}
+		if r.note != "" {
+			h.Note = r.note
+		}
+		done = done || r.done
+	}
+	if len(h.IPs) > 0 {
+		h.IP = h.IPs[0]
+	}
+	if len(h.IPs) < 2 {
+		h.IPs = nil
+	}
+	if len(replies) > 0 {
+		h.Loss = math.Round(float64(len(replies)-len(rtts))/float64(len(replies))*1000) / 10
+	}
+	if len(rtts) > 0 {
+		sorted := append([]float64(nil), rtts...)
+		sort.Float64s(sorted)
+		var sum, sum2 float64
+		for _, v := range rtts {
+			sum += v
+			sum2 += v * v
+		}
+		n := float64(len(rtts))
+		h.MinLatency, h.MaxLatency = sorted[0], sorted[len(sorted)-1]
+		h.AvgLatency = roundMs(sum / n)
+		h.Mdev = roundMs(math.Sqrt(math.Max(sum2/n-(sum/n)*(sum/n), 0)))
+		h.Latency = h.AvgLatency
+	}
+	return h, done
+}
+
+// reverseResolver 反向解析（同一次 traceroute 内缓存结果）
+type reverseResolver struct {
+	disabled bool
+	cache    map[string]string
+}
+
+func newReverseResolver(disabled bool) *reverseResolver {
+	return &reverseResolver{disabled: disabled, cache: map[string]string{}}
+}
+
+func (r *reverseResolver) lookup(ctx context.Context, ip string) string {
+	if r.disabled {
+		return ""
+	}
+	if name, ok := r.cache[ip]; ok {
+		return name
+	}
+	lctx, cancel := context.WithTimeout(ctx, traceRDNSTimeout)
+	defer cancel()
+	name := ""
+	if names, err := net.DefaultResolver.LookupAddr(lctx, ip); err == nil && len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+	r.cache[ip] = name
+	return name
+}
+
+// systemTraceProtocol 系统命令实际使用的探测方式：tracert 为 ICMP echo，traceroute 默认 UDP（起始端口 33434，逐次递增）
+func systemTraceProtocol() (string, int) {
+	if runtime.GOOS == "windows" {
+		return "icmp", 0
+	}
+	return "udp", defaultTraceUDPPort
+}
+
+func tracerouteWithSystemCommand(ctx context.Context, dst net.IP, maxHops int, timeout time.Duration) ([]Hop, error) {
+	target := dst.String()
 	switch runtime.GOOS {
 	case "darwin", "linux":
-		path, err := exec.LookPath("traceroute")
+		name := "traceroute"
+		if dst.To4() == nil && runtime.GOOS == "darwin" {
+			name = "traceroute6"
+		}
+		path, err := exec.LookPath(name)
 		if err != nil {
 			return nil, fmt.Errorf("traceroute 未安装或不可用")
 		}
@@ -68,7 +713,7 @@ func tracerouteWithSystemCommand(target string, maxHops int, timeout time.Durati
 		if sec <= 0 {
 			sec = 1
 		}
-		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(maxHops)*timeout+3*time.Second)
+		ctx, cancel := context.WithTimeout(ctx, time.Duration(maxHops)*timeout+3*time.Second)
 		defer cancel()
 		// -n 数字形式；-m max hops；-w wait seconds
 		cmd := exec.CommandContext(ctx, path, "-n", "-m", strconv.Itoa(maxHops), "-w", strconv.Itoa(sec), target)
@@ -87,7 +732,7 @@ func tracerouteWithSystemCommand(target string, maxHops int, timeout time.Durati
 		if err != nil {
 			return nil, fmt.Errorf("tracert 未安装或不可用")
 		}
-		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(maxHops)*timeout+5*time.Second)
+		ctx, cancel := context.WithTimeout(ctx, time.Duration(maxHops)*timeout+5*time.Second)
 		defer cancel()
 		cmd := exec.CommandContext(ctx, path, "-d", "-h", strconv.Itoa(maxHops), target)
 		out, err := cmd.CombinedOutput()
@@ -111,7 +756,6 @@ func parseTracerouteOutput(out []byte) []Hop {
 	//  2  * * *
 	sc := bufio.NewScanner(bytes.NewReader(out))
 	reLine := regexp.MustCompile(`^\s*(\d+)\s+(.+)$`)
-	reIP := regexp.MustCompile(`\b(\d{1,3}(?:\.\d{1,3}){3})\b`)
 	reMS := regexp.MustCompile(`([\d.]+)\s*ms`)
 	hops := []Hop{}
 	for sc.Scan() {
@@ -126,12 +770,13 @@ func parseTracerouteOutput(out []byte) []Hop {
 		hopNum, _ := strconv.Atoi(m[1])
 		rest := m[2]
 		h := Hop{Hop: hopNum, IP: "*"}
-		if strings.Contains(rest, "*") && !reIP.MatchString(rest) {
+		ip := firstIPField(rest)
+		if strings.Contains(rest, "*") && ip == "" {
 			hops = append(hops, h)
 			continue
 		}
-		if ipm := reIP.FindStringSubmatch(rest); len(ipm) == 2 {
-			h.IP = ipm[1]
+		if ip != "" {
+			h.IP = ip
 		}
 		if msm := reMS.FindStringSubmatch(rest); len(msm) == 2 {
 			if v, err := strconv.ParseFloat(msm[1], 64); err == nil {
@@ -149,7 +794,6 @@ func parseTracertOutput(out []byte) []Hop {
 	//  2     *        *        *     Request timed out.
 	sc := bufio.NewScanner(bytes.NewReader(out))
 	reLine := regexp.MustCompile(`^\s*(\d+)\s+(.+)$`)
-	reIP := regexp.MustCompile(`\b(\d{1,3}(?:\.\d{1,3}){3})\b`)
 	reMS := regexp.MustCompile(`(\d+)\s*ms`)
 	hops := []Hop{}
 	for sc.Scan() {
@@ -161,8 +805,8 @@ func parseTracertOutput(out []byte) []Hop {
 		n, _ := strconv.Atoi(m[1])
 		rest := m[2]
 		h := Hop{Hop: n, IP: "*"}
-		if ipm := reIP.FindStringSubmatch(rest); len(ipm) == 2 {
-			h.IP = ipm[1]
+		if ip := firstIPField(rest); ip != "" {
+			h.IP = ip
 		}
 		if msm := reMS.FindStringSubmatch(rest); len(msm) == 2 {
 			if v, err := strconv.ParseFloat(msm[1], 64); err == nil {
@@ -173,3 +817,13 @@ func parseTracertOutput(out []byte) []Hop {
 	}
 	return hops
 }
+
+// firstIPField 取一行中第一个 IPv4/IPv6 地址（可带括号），没有时返回空
+func firstIPField(s string) string {
+	for _, f := range strings.Fields(s) {
+		if ip := net.ParseIP(strings.Trim(f, "()[]")); ip != nil {
+			return ip.String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package toolkit
+
+import (
+	"syscall"
+)
+
+// setProbeSockopt 设置 TCP 探测套接字的 TTL/hop limit；reuse 时允许 Paris 模式重复绑定同一源端口
+func setProbeSockopt(c syscall.RawConn, v6 bool, ttl int, reuse bool) error {
+	var serr error
+	err := c.Control(func(fd uintptr) {
+		if v6 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+		} else {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+		}
+		if serr == nil && reuse {
+			serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,11 @@
+package toolkit
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// setProbeSockopt Windows 暂不支持 TCP 探测（其余模式走 tracert）
+func setProbeSockopt(c syscall.RawConn, v6 bool, ttl int, reuse bool) error {
+	return fmt.Errorf("当前系统不支持 TCP traceroute")
+}
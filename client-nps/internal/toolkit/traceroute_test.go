@@ -0,0 +1,190 @@
+package toolkit
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// quotedV4 构造 ICMP 差错报文中引用的原始 IPv4 包：头部（可带选项）+ 传输层前 8 字节
+func quotedV4(proto int, options int, dst string, l4 [8]byte) []byte {
+	hl := 20 + options
+	b := make([]byte, hl+len(l4))
+	b[0] = 0x40 | byte(hl/4)
+	b[9] = byte(proto)
+	copy(b[16:20], net.ParseIP(dst).To4())
+	copy(b[hl:], l4[:])
+	return b
+}
+
+// quotedV6 构造引用的原始 IPv6 包（固定 40 字节头部）
+func quotedV6(next int, dst string, l4 [8]byte) []byte {
+	b := make([]byte, 40+len(l4))
+	b[0] = 0x60
+	b[6] = byte(next)
+	copy(b[24:40], net.ParseIP(dst).To16())
+	copy(b[40:], l4[:])
+	return b
+}
+
+// l4Header 传输层前 8 字节：UDP/TCP 为源端口、目的端口，w3 为 UDP 长度（TCP 为序号高 16 位），
+// ICMP echo 为类型/代码/校验和、ID、序号
+func l4Header(w1, w2, w3, w4 int) [8]byte {
+	var h [8]byte
+	binary.BigEndian.PutUint16(h[0:2], uint16(w1))
+	binary.BigEndian.PutUint16(h[2:4], uint16(w2))
+	binary.BigEndian.PutUint16(h[4:6], uint16(w3))
+	binary.BigEndian.PutUint16(h[6:8], uint16(w4))
+	return h
+}
+
+func TestTracerMatchQuoted(t *testing.T) {
+	const dst4, dst6 = "198.51.100.7", "2001:db8::7"
+	tracer4 := func(proto string, paris bool) *tracer {
+		return &tracer{
+			opts:    TracerouteOptions{Protocol: proto, Port: 33434, Paris: paris},
+			dst:     &net.IPAddr{IP: net.ParseIP(dst4).To4()},
+			srcPort: 40000,
+			echoID:  0x1234,
+		}
+	}
+	tests := []struct {
+		name    string
+		tr      *tracer
+		data    []byte
+		wantKey int
+		wantOK  bool
+	}{
+		{
+			name:    "ICMP 按 echo ID 匹配并取序号",
+			tr:      tracer4("icmp", true),
+			data:    quotedV4(1, 0, dst4, l4Header(0x0800, 0, 0x1234, 17)),
+			wantKey: 17, wantOK: true,
+		},
+		{
+			name: "ICMP 其他进程的 echo ID",
+			tr:   tracer4("icmp", true),
+			data: quotedV4(1, 0, dst4, l4Header(0x0800, 0, 0x4321, 17)),
+		},
+		{
+			name: "引用的目的地址不是本次目标",
+			tr:   tracer4("icmp", true),
+			data: quotedV4(1, 0, "198.51.100.8", l4Header(0x0800, 0, 0x1234, 17)),
+		},
+		{
+			name:    "IPv4 头部带选项",
+			tr:      tracer4("icmp", true),
+			data:    quotedV4(1, 8, dst4, l4Header(0x0800, 0, 0x1234, 3)),
+			wantKey: 3, wantOK: true,
+		},
+		{
+			name:    "UDP Paris 按数据长度区分探测",
+			tr:      tracer4("udp", true),
+			data:    quotedV4(17, 0, dst4, l4Header(40000, 33434, 8+42, 0)),
+			wantKey: 42, wantOK: true,
+		},
+		{
+			name: "UDP Paris 目的端口不符",
+			tr:   tracer4("udp", true),
+			data: quotedV4(17, 0, dst4, l4Header(40000, 33435, 8+42, 0)),
+		},
+		{
+			name:    "UDP 经典模式按目的端口偏移",
+			tr:      tracer4("udp", false),
+			data:    quotedV4(17, 0, dst4, l4Header(40000, 33434+9, 8, 0)),
+			wantKey: 10, wantOK: true,
+		},
+		{
+			name: "UDP 源端口属于其他进程",
+			tr:   tracer4("udp", false),
+			data: quotedV4(17, 0, dst4, l4Header(40001, 33434, 8, 0)),
+		},
+		{
+			name: "UDP 模式收到 ICMP 引用",
+			tr:   tracer4("udp", false),
+			data: quotedV4(1, 0, dst4, l4Header(40000, 33434, 8, 0)),
+		},
+		{
+			name:    "TCP 按源端口区分探测",
+			tr:      &tracer{opts: TracerouteOptions{Protocol: "tcp", Port: 443}, dst: &net.IPAddr{IP: net.ParseIP(dst4).To4()}},
+			data:    quotedV4(6, 0, dst4, l4Header(51234, 443, 0, 0)),
+			wantKey: 51234, wantOK: true,
+		},
+		{
+			name: "TCP 目的端口不符",
+			tr:   &tracer{opts: TracerouteOptions{Protocol: "tcp", Port: 443}, dst: &net.IPAddr{IP: net.ParseIP(dst4).To4()}},
+			data: quotedV4(6, 0, dst4, l4Header(51234, 80, 0, 0)),
+		},
+		{
+			name:    "IPv6 ICMP",
+			tr:      &tracer{opts: TracerouteOptions{Protocol: "icmp"}, dst: &net.IPAddr{IP: net.ParseIP(dst6)}, v6: true, echoID: 0x1234},
+			data:    quotedV6(58, dst6, l4Header(0x8000, 0, 0x1234, 5)),
+			wantKey: 5, wantOK: true,
+		},
+		{
+			name: "IPv6 引用不完整",
+			tr:   &tracer{opts: TracerouteOptions{Protocol: "icmp"}, dst: &net.IPAddr{IP: net.ParseIP(dst6)}, v6: true, echoID: 0x1234},
+			data: quotedV6(58, dst6, l4Header(0x8000, 0, 0x1234, 5))[:44],
+		},
+		{
+			name: "传输层不足 8 字节",
+			tr:   tracer4("icmp", true),
+			data: quotedV4(1, 0, dst4, l4Header(0x0800, 0, 0x1234, 17))[:26],
+		},
+		{
+			name: "不是 IPv4 包",
+			tr:   tracer4("icmp", true),
+			data: quotedV6(1, dst6, l4Header(0x0800, 0, 0x1234, 17)),
+		},
+		{
+			name: "空数据",
+			tr:   tracer4("icmp", true),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, ok := tt.tr.matchQuoted(tt.data)
+			if ok != tt.wantOK || (ok && key != tt.wantKey) {
+				t.Fatalf("matchQuoted = %d, %v，期望 %d, %v", key, ok, tt.wantKey, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseTracerouteOutput(t *testing.T) {
+	unix := []byte(`traceroute to 2001:db8::7 (2001:db8::7), 30 hops max, 80 byte packets
+ 1  192.168.1.1  1.123 ms  1.045 ms  1.031 ms
+ 2  * * *
+ 3  2001:db8::7  12.500 ms  12.3 ms  12.4 ms
+`)
+	win := []byte(`Tracing route to 198.51.100.7 over a maximum of 30 hops
+
+  1    <1 ms    <1 ms    <1 ms  192.168.1.1
+  2     *        *        *     Request timed out.
+  3    15 ms    14 ms    14 ms  [2001:db8::7]
+`)
+	tests := []struct {
+		name  string
+		hops  []Hop
+		want  []string
+		first float64
+	}{
+		{"traceroute", parseTracerouteOutput(unix), []string{"192.168.1.1", "*", "2001:db8::7"}, 1.123},
+		{"tracert", parseTracertOutput(win), []string{"192.168.1.1", "*", "2001:db8::7"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if len(tt.hops) != len(tt.want) {
+				t.Fatalf("解析出 %d 跳 %+v，期望 %d", len(tt.hops), tt.hops, len(tt.want))
+			}
+			for i, h := range tt.hops {
+				if h.Hop != i+1 || h.IP != tt.want[i] {
+					t.Fatalf("第 %d 跳 = %+v，期望 %s", i+1, h, tt.want[i])
+				}
+			}
+			if tt.hops[0].Latency != tt.first {
+				t.Fatalf("第 1 跳延迟 = %v，期望 %v", tt.hops[0].Latency, tt.first)
+			}
+		})
+	}
+}
@@ -0,0 +1,325 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// 端口状态
+const (
+	PortStatusOpen         = "open"
+	PortStatusOpenFiltered = "open|filtered" // UDP 无响应：可能开放但丢弃了探测包，也可能被防火墙过滤
+	PortStatusClosed       = "closed"
+)
+
+// UDPProbePorts 有专用探测包的 UDP 端口（设备端口清点默认扫描这些）
+var UDPProbePorts = []int{53, 123, 137, 161, 1900, 5353, 5683}
+
+// udpProbe 协议探测包及响应解析
+type udpProbe struct {
+	service string
+	payload func() []byte
+	parse   func(resp []byte) (version, banner string) // 返回空 banner 时保存原始响应
+}
+
+var udpProbes = map[int]udpProbe{
+	53:   {"dns", dnsVersionQuery, parseDNSVersion},
+	123:  {"ntp", ntpQuery, parseNTP},
+	137:  {"netbios-ns", netbiosStatQuery, parseNetBIOSStat},
+	161:  {"snmp", snmpSysDescrQuery, parseSNMPSysDescr},
+	1900: {"ssdp", ssdpQuery, parseSSDP},
+	5353: {"mdns", mdnsServicesQuery, parseMDNSServices},
+	5683: {"coap", coapWellKnownQuery, parseCoAP},
+}
+
+// scanUDPPort 发送协议探测包判断 UDP 端口状态：
+// 收到响应为 open；收到 ICMP 端口不可达为 closed（已连接的 UDP 套接字上表现为 ECONNREFUSED，无需 root）；
+// 超时无响应为 open|filtered。探测包发送两次以应对丢包
+func scanUDPPort(ctx context.Context, target string, port int, timeout time.Duration) *PortInfo {
+	info := &PortInfo{
+		Port:     port,
+		Protocol: "udp",
+		Status:   PortStatusOpenFiltered,
+		Service:  identifyService(port),
+	}
+	probe, hasProbe := udpProbes[port]
+	if hasProbe {
+		info.Service = probe.service
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(target, strconv.Itoa(port)))
+	if err != nil {
+		info.Status = PortStatusClosed
+		return info
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	payload := []byte{}
+	if hasProbe {
+		payload = probe.payload()
+	}
+	buf := make([]byte, 4096)
+	const attempts = 2
+	for i := 0; i < attempts && ctx.Err() == nil; i++ {
+		if _, err := conn.Write(payload); err != nil {
+			if isConnRefused(err) {
+				info.Status = PortStatusClosed
+			}
+			return info
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout / attempts))
+		n, err := conn.Read(buf)
+		if err != nil {
+			if isConnRefused(err) {
+				info.Status = PortStatusClosed
+				return info
+			}
+			continue
+		}
+		info.Status = PortStatusOpen
+		resp := buf[:n]
+		if hasProbe {
+			info.Version, info.Banner = probe.parse(resp)
+		}
+		if info.Banner == "" {
+			info.Banner = sanitizeBanner(resp)
+		}
+		return info
+	}
+	return info
+}
+
+func isConnRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// dnsVersionQuery 查询 version.bind（CHAOS TXT），多数 DNS 服务会返回版本或拒绝，都能证明端口开放
+func dnsVersionQuery() []byte {
+	b := dnsmessage.NewBuilder(make([]byte, 0, 64), dnsmessage.Header{ID: 0x4e57, RecursionDesired: false})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName("version.bind."),
+		Type:  dnsmessage.TypeTXT,
+		Class: dnsmessage.ClassCHAOS,
+	})
+	msg, _ := b.Finish()
+	return msg
+}
+
+func parseDNSVersion(resp []byte) (string, string) {
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil {
+		return "", ""
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return "", fmt.Sprintf("rcode=%s", h.RCode)
+	}
+	for {
+		ah, err := p.AnswerHeader()
+		if err != nil {
+			break
+		}
+		if ah.Type != dnsmessage.TypeTXT {
+			p.SkipAnswer()
+			continue
+		}
+		txt, err := p.TXTResource()
+		if err != nil {
+			break
+		}
+		v := strings.Join(txt.TXT, " ")
+		return v, "version.bind=" + v
+	}
+	return "", fmt.Sprintf("rcode=%s", h.RCode)
+}
+
+// ntpQuery NTPv4 客户端请求（mode 3）
+func ntpQuery() []byte {
+	msg := make([]byte, 48)
+	msg[0] = 0x23 // LI=0, VN=4, Mode=3
+	return msg
+}
+
+func parseNTP(resp []byte) (string, string) {
+	if len(resp) < 48 {
+		return "", ""
+	}
+	vn := (resp[0] >> 3) & 0x07
+	mode := resp[0] & 0x07
+	stratum := resp[1]
+	ref := ""
+	if stratum == 1 {
+		ref = strings.TrimRight(string(resp[12:16]), "\x00")
+	} else if stratum > 1 {
+		ref = net.IP(resp[12:16]).String()
+	}
+	return fmt.Sprintf("NTPv%d", vn), fmt.Sprintf("mode=%d stratum=%d refid=%s", mode, stratum, ref)
+}
+
+// netbiosStatQuery NBSTAT 查询（名称 "*"），Windows/Samba 会返回计算机名和工作组
+func netbiosStatQuery() []byte {
+	msg := []byte{0x4e, 0x57, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20}
+	name := make([]byte, 16)
+	name[0] = '*'
+	for _, c := range name {
+		msg = append(msg, 'A'+(c>>4), 'A'+(c&0x0f))
+	}
+	return append(msg, 0x00, 0x00, 0x21, 0x00, 0x01)
+}
+
+func parseNetBIOSStat(resp []byte) (string, string) {
+	// 头部 12 + 名称 34 + type/class 4 + ttl 4 + rdlength 2 之后是名称数量
+	const off = 12 + 34 + 4 + 4 + 2
+	if len(resp) <= off {
+		return "", ""
+	}
+	count := int(resp[off])
+	names := []string{}
+	var host, group string
+	for i := 0; i < count; i++ {
+		start := off + 1 + i*18
+		if start+18 > len(resp) {
+			break
+		}
+		name := strings.TrimSpace(string(resp[start : start+15]))
+		suffix := resp[start+15]
+		isGroup := resp[start+16]&0x80 != 0
+		names = append(names, fmt.Sprintf("%s<%02x>", name, suffix))
+		if suffix == 0x00 && !isGroup && host == "" {
+			host = name
+		}
+		if suffix == 0x00 && isGroup && group == "" {
+			group = name
+		}
+	}
+	if host == "" && len(names) == 0 {
+		return "", ""
+	}
+	return "", fmt.Sprintf("name=%s workgroup=%s names=%s", host, group, strings.Join(names, ","))
+}
+
+// snmpSysDescrQuery SNMPv2c GetRequest（community public，OID 1.3.6.1.2.1.1.1.0 sysDescr）
+func snmpSysDescrQuery() []byte {
+	return []byte{
+		0x30, 0x29, 0x02, 0x01, 0x01, 0x04, 0x06, 'p', 'u', 'b', 'l', 'i', 'c',
+		0xa0, 0x1c, 0x02, 0x04, 0x4e, 0x57, 0x43, 0x54, 0x02, 0x01, 0x00, 0x02, 0x01, 0x00,
+		0x30, 0x0e, 0x30, 0x0c, 0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00, 0x05, 0x00,
+	}
+}
+
+var sysDescrOID = []byte{0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00}
+
+func parseSNMPSysDescr(resp []byte) (string, string) {
+	i := bytes.Index(resp, sysDescrOID)
+	if i < 0 {
+		return "", ""
+	}
+	rest := resp[i+len(sysDescrOID):]
+	if len(rest) < 2 || rest[0] != 0x04 {
+		return "", ""
+	}
+	n, hdr := int(rest[1]), 2
+	switch rest[1] {
+	case 0x81:
+		if len(rest) < 3 {
+			return "", ""
+		}
+		n, hdr = int(rest[2]), 3
+	case 0x82:
+		if len(rest) < 4 {
+			return "", ""
+		}
+		n, hdr = int(binary.BigEndian.Uint16(rest[2:4])), 4
+	}
+	if hdr+n > len(rest) {
+		n = len(rest) - hdr
+	}
+	descr := sanitizeBanner(rest[hdr : hdr+n])
+	return firstLine(descr), "sysDescr=" + descr
+}
+
+// ssdpQuery 单播 M-SEARCH
+func ssdpQuery() []byte {
+	return []byte("M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 1\r\nST: ssdp:all\r\n\r\n")
+}
+
+func parseSSDP(resp []byte) (string, string) {
+	return headerValue(string(resp), "Server"), ""
+}
+
+// mdnsServicesQuery 单播查询 _services._dns-sd._udp.local（源端口非 5353，响应方按传统单播回复）
+func mdnsServicesQuery() []byte {
+	b := dnsmessage.NewBuilder(make([]byte, 0, 64), dnsmessage.Header{})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName("_services._dns-sd._udp.local."),
+		Type:  dnsmessage.TypePTR,
+		Class: dnsmessage.ClassINET,
+	})
+	msg, _ := b.Finish()
+	return msg
+}
+
+func parseMDNSServices(resp []byte) (string, string) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(resp); err != nil {
+		return "", ""
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return "", ""
+	}
+	services := []string{}
+	for {
+		ah, err := p.AnswerHeader()
+		if err != nil {
+			break
+		}
+		if ah.Type != dnsmessage.TypePTR {
+			p.SkipAnswer()
+			continue
+		}
+		ptr, err := p.PTRResource()
+		if err != nil {
+			break
+		}
+		services = append(services, strings.TrimSuffix(ptr.PTR.String(), "."))
+	}
+	if len(services) == 0 {
+		return "", ""
+	}
+	return "", "services=" + strings.Join(services, ",")
+}
+
+// coapWellKnownQuery CON GET /.well-known/core
+func coapWellKnownQuery() []byte {
+	msg := []byte{0x40, 0x01, 0x4e, 0x57}
+	msg = append(msg, 0xbb)
+	msg = append(msg, ".well-known"...)
+	msg = append(msg, 0x04)
+	return append(msg, "core"...)
+}
+
+func parseCoAP(resp []byte) (string, string) {
+	if len(resp) < 4 || resp[0]>>6 != 1 {
+		return "", ""
+	}
+	code := fmt.Sprintf("%d.%02d", resp[1]>>5, resp[1]&0x1f)
+	if i := bytes.IndexByte(resp[4:], 0xff); i >= 0 {
+		return "", "code=" + code + " " + sanitizeBanner(resp[4+i+1:])
+	}
+	return "", "code=" + code
+}
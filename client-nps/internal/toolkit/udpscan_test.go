@@ -0,0 +1,224 @@
+package toolkit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsResponse 构造带一个问题的 DNS 响应，answers 依次写入
+func dnsResponse(t *testing.T, rcode dnsmessage.RCode, q dnsmessage.Question, answers func(b *dnsmessage.Builder)) []byte {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 0x4e57, Response: true, RCode: rcode})
+	b.StartQuestions()
+	b.Question(q)
+	b.StartAnswers()
+	if answers != nil {
+		answers(&b)
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// netbiosResponse NBSTAT 响应：头部和名称部分填零，之后是名称表（每项 15 字节名称 + 后缀 + 2 字节标志）
+func netbiosResponse(count int, entries ...[]byte) []byte {
+	resp := make([]byte, 12+34+4+4+2)
+	resp = append(resp, byte(count))
+	for _, e := range entries {
+		resp = append(resp, e...)
+	}
+	return resp
+}
+
+func netbiosName(name string, suffix byte, group bool) []byte {
+	e := []byte(name + "                ")[:15]
+	flags := byte(0x04)
+	if group {
+		flags |= 0x80
+	}
+	return append(e, suffix, flags, 0x00)
+}
+
+func ntpResponse(stratum byte, refid []byte) []byte {
+	resp := make([]byte, 48)
+	resp[0] = 0x24 // VN=4, Mode=4（server）
+	resp[1] = stratum
+	copy(resp[12:16], refid)
+	return resp
+}
+
+func snmpResponse(descrHeader []byte, descr string) []byte {
+	resp := []byte{0x30, 0x82, 0x00, 0x00, 0x02, 0x01, 0x01, 0x04, 0x06, 'p', 'u', 'b', 'l', 'i', 'c', 0xa2, 0x00}
+	resp = append(resp, sysDescrOID...)
+	resp = append(resp, descrHeader...)
+	return append(resp, descr...)
+}
+
+func TestUDPProbeParsers(t *testing.T) {
+	versionQ := dnsmessage.Question{Name: dnsmessage.MustNewName("version.bind."), Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassCHAOS}
+	servicesQ := dnsmessage.Question{Name: dnsmessage.MustNewName("_services._dns-sd._udp.local."), Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}
+	longDescr := "Linux edge-router 5.10.0 #1 SMP PREEMPT aarch64 " + string(make([]byte, 100))
+
+	tests := []struct {
+		name        string
+		parse       func([]byte) (string, string)
+		resp        []byte
+		wantVersion string
+		wantBanner  string
+	}{
+		{
+			name:  "DNS version.bind",
+			parse: parseDNSVersion,
+			resp: dnsResponse(t, dnsmessage.RCodeSuccess, versionQ, func(b *dnsmessage.Builder) {
+				b.TXTResource(dnsmessage.ResourceHeader{Name: versionQ.Name, Class: dnsmessage.ClassCHAOS}, dnsmessage.TXTResource{TXT: []string{"9.18.24"}})
+			}),
+			wantVersion: "9.18.24",
+			wantBanner:  "version.bind=9.18.24",
+		},
+		{
+			name:       "DNS 拒绝查询版本",
+			parse:      parseDNSVersion,
+			resp:       dnsResponse(t, dnsmessage.RCodeRefused, versionQ, nil),
+			wantBanner: "rcode=" + dnsmessage.RCodeRefused.String(),
+		},
+		{
+			name:  "DNS 响应截断",
+			parse: parseDNSVersion,
+			resp:  []byte{0x4e, 0x57, 0x81},
+		},
+		{
+			name:        "NTP 一级时钟 refid 为字符串",
+			parse:       parseNTP,
+			resp:        ntpResponse(1, []byte("GPS")),
+			wantVersion: "NTPv4",
+			wantBanner:  "mode=4 stratum=1 refid=GPS",
+		},
+		{
+			name:        "NTP 二级时钟 refid 为上游地址",
+			parse:       parseNTP,
+			resp:        ntpResponse(2, []byte{192, 168, 1, 1}),
+			wantVersion: "NTPv4",
+			wantBanner:  "mode=4 stratum=2 refid=192.168.1.1",
+		},
+		{
+			name:  "NTP 响应过短",
+			parse: parseNTP,
+			resp:  make([]byte, 47),
+		},
+		{
+			name:       "NetBIOS 计算机名和工作组",
+			parse:      parseNetBIOSStat,
+			resp:       netbiosResponse(3, netbiosName("DESKTOP-1", 0x00, false), netbiosName("WORKGROUP", 0x00, true), netbiosName("DESKTOP-1", 0x20, false)),
+			wantBanner: "name=DESKTOP-1 workgroup=WORKGROUP names=DESKTOP-1<00>,WORKGROUP<00>,DESKTOP-1<20>",
+		},
+		{
+			name:       "NetBIOS 名称数量超出实际数据",
+			parse:      parseNetBIOSStat,
+			resp:       netbiosResponse(5, netbiosName("NAS", 0x00, false)),
+			wantBanner: "name=NAS workgroup= names=NAS<00>",
+		},
+		{
+			name:  "NetBIOS 没有名称表",
+			parse: parseNetBIOSStat,
+			resp:  make([]byte, 20),
+		},
+		{
+			name:        "SNMP sysDescr 短格式长度",
+			parse:       parseSNMPSysDescr,
+			resp:        snmpResponse([]byte{0x04, 0x1c}, "RouterOS 7.14\nMikroTik hAP"),
+			wantVersion: "RouterOS 7.14",
+			wantBanner:  "sysDescr=RouterOS 7.14\nMikroTik hAP",
+		},
+		{
+			name:        "SNMP sysDescr 长格式长度（0x81）",
+			parse:       parseSNMPSysDescr,
+			resp:        snmpResponse([]byte{0x04, 0x81, byte(len(longDescr))}, longDescr),
+			wantVersion: sanitizeBanner([]byte(longDescr)),
+			wantBanner:  "sysDescr=" + sanitizeBanner([]byte(longDescr)),
+		},
+		{
+			name:        "SNMP 声明长度超过数据时截断",
+			parse:       parseSNMPSysDescr,
+			resp:        snmpResponse([]byte{0x04, 0x82, 0x01, 0x00}, "Switch"),
+			wantVersion: "Switch",
+			wantBanner:  "sysDescr=Switch",
+		},
+		{
+			name:  "SNMP 错误响应不含 sysDescr",
+			parse: parseSNMPSysDescr,
+			resp:  []byte{0x30, 0x03, 0x02, 0x01, 0x01},
+		},
+		{
+			name:  "SNMP 值不是字符串",
+			parse: parseSNMPSysDescr,
+			resp:  snmpResponse([]byte{0x05, 0x00}, ""),
+		},
+		{
+			name:        "SSDP Server 头",
+			parse:       parseSSDP,
+			resp:        []byte("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=1800\r\nSERVER: Linux/3.10 UPnP/1.0 miniupnpd/2.1\r\n\r\n"),
+			wantVersion: "Linux/3.10 UPnP/1.0 miniupnpd/2.1",
+		},
+		{
+			name:  "mDNS 服务列表",
+			parse: parseMDNSServices,
+			resp: dnsResponse(t, dnsmessage.RCodeSuccess, servicesQ, func(b *dnsmessage.Builder) {
+				for _, s := range []string{"_http._tcp.local.", "_ipp._tcp.local."} {
+					b.PTRResource(dnsmessage.ResourceHeader{Name: servicesQ.Name, Class: dnsmessage.ClassINET}, dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(s)})
+				}
+			}),
+			wantBanner: "services=_http._tcp.local,_ipp._tcp.local",
+		},
+		{
+			name:  "mDNS 没有服务",
+			parse: parseMDNSServices,
+			resp:  dnsResponse(t, dnsmessage.RCodeSuccess, servicesQ, nil),
+		},
+		{
+			name:       "CoAP 带资源列表",
+			parse:      parseCoAP,
+			resp:       append([]byte{0x60, 0x45, 0x4e, 0x57, 0xc1, 0x28, 0xff}, "</sensors/temp>;rt=\"temperature\""...),
+			wantBanner: "code=2.05 </sensors/temp>;rt=\"temperature\"",
+		},
+		{
+			name:       "CoAP 无负载",
+			parse:      parseCoAP,
+			resp:       []byte{0x60, 0x84, 0x4e, 0x57},
+			wantBanner: "code=4.04",
+		},
+		{
+			name:  "CoAP 版本不符",
+			parse: parseCoAP,
+			resp:  []byte{0xa0, 0x45, 0x4e, 0x57},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, banner := tt.parse(tt.resp)
+			if version != tt.wantVersion || banner != tt.wantBanner {
+				t.Fatalf("解析结果 (%q, %q)，期望 (%q, %q)", version, banner, tt.wantVersion, tt.wantBanner)
+			}
+		})
+	}
+}
+
+// 回环地址上没有监听的 UDP 端口返回 ICMP 端口不可达，应判定为 closed 而不是 open|filtered
+func TestScanUDPPortClosed(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	pc.Close()
+
+	info := scanUDPPort(context.Background(), "127.0.0.1", port, time.Second)
+	if info.Status != PortStatusClosed {
+		t.Fatalf("未监听的端口状态为 %s，期望 %s", info.Status, PortStatusClosed)
+	}
+}
@@ -1,6 +1,7 @@
 package toolkit
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"fmt"
@@ -48,6 +49,12 @@ func WebSpeedTest(rawURL string, count int, timeout time.Duration, downloadBytes
 // - GET：会读取 downloadBytes（默认 64KB）
 // - HEAD：不读取 body，只测 DNS/TCP/TLS/TTFB/Total（更贴近“打开网页速度”）
 func WebSpeedTestWithOptions(rawURL string, method string, count int, timeout time.Duration, downloadBytes int64) (*WebSpeedResult, error) {
+	return WebSpeedTestContext(context.Background(), rawURL, method, count, timeout, downloadBytes, nil)
+}
+
+// WebSpeedTestContext 同 WebSpeedTestWithOptions，ctx 取消后不再发起新的请求；
+// onAttempt 非空时每完成一次请求回调一次
+func WebSpeedTestContext(ctx context.Context, rawURL string, method string, count int, timeout time.Duration, downloadBytes int64, onAttempt func(i int, a WebSpeedAttempt)) (*WebSpeedResult, error) {
 	u, err := normalizeWebURL(rawURL)
 	if err != nil {
 		return nil, err
@@ -83,9 +90,12 @@ func WebSpeedTestWithOptions(rawURL string, method string, count int, timeout ti
 		TestTime:      time.Now().Format(time.RFC3339),
 	}
 
-	for i := 0; i < count; i++ {
-		a := webSpeedOnce(u, method, timeout, downloadBytes)
+	for i := 0; i < count && ctx.Err() == nil; i++ {
+		a := webSpeedOnce(ctx, u, method, timeout, downloadBytes)
 		res.Attempts = append(res.Attempts, a)
+		if onAttempt != nil {
+			onAttempt(i, a)
+		}
 	}
 
 	// summary（简单平均/成功率）
@@ -139,7 +149,30 @@ func normalizeWebURL(raw string) (*url.URL, error) {
 	return u, nil
 }
 
-func webSpeedOnce(u *url.URL, method string, timeout time.Duration, downloadBytes int64) WebSpeedAttempt {
+// WebProbe 单次 GET 请求（不带 Range），返回各阶段耗时、状态码和最多 maxBody 字节的响应体；
+// 状态码 >=400 时 OK 为 false，但仍读取响应体，由调用方判断是否符合预期（用于定时检查）
+func WebProbe(ctx context.Context, rawURL string, timeout time.Duration, maxBody int64) (*WebSpeedAttempt, []byte, error) {
+	u, err := normalizeWebURL(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if timeout <= 0 {
+		timeout = 8 * time.Second
+	}
+	if maxBody <= 0 {
+		maxBody = 64 * 1024
+	}
+	var body bytes.Buffer
+	a := webRequestOnce(ctx, u, "GET", timeout, maxBody, &body)
+	return &a, body.Bytes(), nil
+}
+
+func webSpeedOnce(ctx context.Context, u *url.URL, method string, timeout time.Duration, downloadBytes int64) WebSpeedAttempt {
+	return webRequestOnce(ctx, u, method, timeout, downloadBytes, nil)
+}
+
+// webRequestOnce capture 非空时不发 Range，把响应体（最多 downloadBytes 字节）写入 capture
+func webRequestOnce(ctx context.Context, u *url.URL, method string, timeout time.Duration, downloadBytes int64, capture io.Writer) WebSpeedAttempt {
 	a := WebSpeedAttempt{}
 	start := time.Now()
 
@@ -179,13 +212,13 @@ func webSpeedOnce(u *url.URL, method string, timeout time.Duration, downloadByte
 		},
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), method, u.String(), nil)
 	req.Header.Set("User-Agent", "nwct-client/1.0")
 	// 优先用 Range 控制体积；不支持 Range 时，我们也会 LimitReader。
-	if method == "GET" && downloadBytes > 0 {
+	if method == "GET" && downloadBytes > 0 && capture == nil {
 		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", downloadBytes-1))
 	}
 
@@ -201,6 +234,12 @@ func webSpeedOnce(u *url.URL, method string, timeout time.Duration, downloadByte
 	defer resp.Body.Close()
 
 	a.StatusCode = resp.StatusCode
+	if capture != nil && method == "GET" {
+		dlStart := time.Now()
+		n, _ := io.Copy(capture, io.LimitReader(resp.Body, downloadBytes))
+		a.BytesRead = n
+		a.DownloadMs = int(time.Since(dlStart).Milliseconds())
+	}
 	if resp.StatusCode >= 400 {
 		a.OK = false
 		a.Error = resp.Status
@@ -210,7 +249,7 @@ func webSpeedOnce(u *url.URL, method string, timeout time.Duration, downloadByte
 	}
 
 	// 读下载窗口（HEAD 不读 body）
-	if method == "GET" && downloadBytes > 0 {
+	if method == "GET" && downloadBytes > 0 && capture == nil {
 		dlStart := time.Now()
 		n, _ := io.Copy(io.Discard, io.LimitReader(resp.Body, downloadBytes))
 		a.BytesRead = n
@@ -15,6 +15,7 @@ import (
 	"nwct/client-nps/config"
 	"nwct/client-nps/internal/api"
 	"nwct/client-nps/internal/database"
+	"nwct/client-nps/internal/jobs"
 	"nwct/client-nps/internal/logger"
 	"nwct/client-nps/internal/mqtt"
 	"nwct/client-nps/internal/network"
@@ -100,6 +101,13 @@ func autoConnectWiFi(cfg *config.Config, netManager network.Manager) {
 	}
 }
 
+// scanSubnetResolver 定时扫描使用的目标：所有已启动的物理接口所在网段
+func scanSubnetResolver(netManager network.Manager) scanner.SubnetResolver {
+	return func() ([]scanner.ScanTarget, error) {
+		return scanner.ResolveTargets(netManager, nil, nil)
+	}
+}
+
 func main() {
 	// 初始化日志
 	if err := logger.InitLogger(); err != nil {
@@ -124,6 +132,8 @@ func main() {
 			logger.Error("关闭数据库失败: %v", err)
 		}
 	}()
+	// 后台任务管理器：把上次运行遗留的未结束任务标记为中断
+	jobs.Default()
 
 	// 初始化网络管理器
 	netManager := network.NewManager()
@@ -187,6 +197,14 @@ func main() {
 		Timeout:  1 * time.Second,
 	})
 
+	// 定时自动扫描（scanner.auto_scan / scan_interval，配置修改后实时生效）
+	scanner.StartScheduler(probeCtx, cfg, scanner.NewScanner(db), scanSubnetResolver(netManager))
+	// 被动发现（scanner.passive 开启时监听 ARP/DHCP/mDNS）
+	scanner.StartPassiveListener(probeCtx, cfg, db, scanSubnetResolver(netManager))
+	// 定时检查（ping/tcp/http/dns/tls），状态变化同时上报 MQTT 事件
+	probe.DefaultChecks().SetNotifier(mqtt.PublishEvent)
+	probe.DefaultChecks().Start(probeCtx)
+
 	// 初始化NPS客户端
 	npsClient := nps.NewClient(&cfg.NPSServer)
 
@@ -199,6 +217,7 @@ func main() {
 
 	// 初始化HTTP API服务器
 	apiServer := api.NewServer(cfg, db, netManager, npsClient, mqttClient)
+	mqtt.SetGlobalToolSubmitter(apiServer.SubmitTool)
 
 	// 创建HTTP服务器，设置内存优化参数
 	httpServer := &http.Server{
@@ -219,6 +238,23 @@ func main() {
 		}
 	}()
 
+	// 测速服务端独立端口（可选）：不设读写超时，便于长时间测速或单独映射隧道
+	if st := cfg.SpeedTestSettings(); !st.Disabled && st.Port > 0 {
+		speedServer := &http.Server{
+			Addr:              fmt.Sprintf(":%d", st.Port),
+			Handler:           apiServer.SpeedTestRouter(),
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			MaxHeaderBytes:    1 << 12,
+		}
+		go func() {
+			logger.Info("测速服务端启动在端口 %d", st.Port)
+			if err := speedServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("测速服务端启动失败: %v", err)
+			}
+		}()
+	}
+
 	// 如果已初始化，启动服务
 	if cfg.Initialized {
 		// 连接MQTT（可通过 mqtt.auto_connect 控制，保证 UI “断开”后不会被启动逻辑自动拉起）
@@ -36,6 +36,13 @@ async function request<T>(
   path: string,
   options: RequestInit & { skipAuth?: boolean } = {}
 ): Promise<T> {
+  return (await requestWithStatus<T>(path, options)).data;
+}
+
+async function requestWithStatus<T>(
+  path: string,
+  options: RequestInit & { skipAuth?: boolean } = {}
+): Promise<{ status: number; data: T }> {
   const url = `${API_BASE}${path.startsWith("/") ? "" : "/"}${path}`;
   const headers: Record<string, string> = {
     "Content-Type": "application/json",
@@ -61,7 +68,42 @@ async function request<T>(
     const msg = json?.message || `HTTP ${res.status}`;
     throw new Error(msg);
   }
-  return (json as ApiEnvelope<T>).data;
+  return { status: res.status, data: (json as ApiEnvelope<T>).data };
+}
+
+export type Job = {
+  id: string;
+  kind: string;
+  status: "queued" | "running" | "completed" | "failed" | "cancelled";
+  progress: number;
+  output?: any[];
+  result?: any;
+  error?: string;
+};
+
+const JOB_POLL_INTERVAL = 1000;
+
+// 工具箱接口：耗时较长的工具（测速/端口扫描/Traceroute/MTR）后端默认作为后台任务执行并返回 202 + 任务，
+// 这里轮询 /api/v1/jobs/:id 直到结束，调用方仍然拿到最终结果
+async function runTool<T = any>(path: string, req: any, onProgress?: (job: Job) => void): Promise<T> {
+  const { status, data } = await requestWithStatus<any>(path, { method: "POST", body: JSON.stringify(req || {}) });
+  if (status !== 202) return data as T;
+
+  let job = data as Job;
+  for (;;) {
+    onProgress?.(job);
+    switch (job.status) {
+      case "completed":
+        return job.result as T;
+      case "failed":
+        throw new Error(job.error || "任务失败");
+      case "cancelled":
+        if (job.result) return job.result as T;
+        throw new Error("任务已取消");
+    }
+    await new Promise((resolve) => setTimeout(resolve, JOB_POLL_INTERVAL));
+    job = await request<Job>(`/api/v1/jobs/${encodeURIComponent(job.id)}`);
+  }
 }
 
 export const api = {
@@ -183,9 +225,9 @@ export const api = {
     }),
 
   toolsPing: (req: { target: string; count?: number; timeout?: number }) =>
-    request<any>("/api/v1/tools/ping", { method: "POST", body: JSON.stringify(req) }),
-  toolsTraceroute: (req: { target?: string; max_hops?: number; timeout?: number }) =>
-    request<any>("/api/v1/tools/traceroute", { method: "POST", body: JSON.stringify(req || {}) }),
+    runTool("/api/v1/tools/ping", req),
+  toolsTraceroute: (req: { target?: string; max_hops?: number; timeout?: number }, onProgress?: (job: Job) => void) =>
+    runTool("/api/v1/tools/traceroute", req, onProgress),
   toolsSpeedtest: (req?: {
     mode?: "web" | "download";
     url?: string;
@@ -195,11 +237,16 @@ export const api = {
     download_bytes?: number;
     server?: string;
     test_type?: string;
-  }) => request<any>("/api/v1/tools/speedtest", { method: "POST", body: JSON.stringify(req || {}) }),
-  toolsPortscan: (req: { target: string; ports?: any; timeout?: number; scan_type?: string }) =>
-    request<any>("/api/v1/tools/portscan", { method: "POST", body: JSON.stringify(req) }),
+  }, onProgress?: (job: Job) => void) => runTool("/api/v1/tools/speedtest", req, onProgress),
+  toolsPortscan: (
+    req: { target: string; ports?: any; timeout?: number; scan_type?: string },
+    onProgress?: (job: Job) => void
+  ) => runTool("/api/v1/tools/portscan", req, onProgress),
   toolsDNS: (req: { query: string; type?: string; server?: string }) =>
-    request<any>("/api/v1/tools/dns", { method: "POST", body: JSON.stringify(req) }),
+    runTool("/api/v1/tools/dns", req),
+  job: (id: string) => request<Job>(`/api/v1/jobs/${encodeURIComponent(id)}`),
+  jobCancel: (id: string) =>
+    request<Job>(`/api/v1/jobs/${encodeURIComponent(id)}/cancel`, { method: "POST", body: "{}" }),
 };
 
 
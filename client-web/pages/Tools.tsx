@@ -154,7 +154,10 @@ export const Tools: React.FC = () => {
       // port scan
       if (!tg) throw new Error('请输入目标 IP 或域名');
       setOutput(['Starting port scan...']);
-      const r = await api.toolsPortscan({ target: tg, ports: '1-1024', timeout: 1, scan_type: 'tcp' });
+      // 后端以后台任务执行，轮询期间显示进度
+      const r = await api.toolsPortscan({ target: tg, ports: '1-1024', timeout: 1, scan_type: 'tcp' }, (job) => {
+        setOutput(['Starting port scan...', `progress: ${job.progress ?? 0}%`]);
+      });
       const lines: string[] = [];
       lines.push(`target: ${r?.target || tg}`);
       lines.push(`scanned_ports: ${r?.scanned_ports ?? 0}`);